@@ -4,17 +4,29 @@ import (
 	"flag"
 	"fmt"
 	"grits/benchmarks"
+	"grits/lsp"
 	"grits/parser"
 	"grits/process"
 	"grits/webserver"
 	"log"
+	"os"
 	"runtime"
+	"strings"
 	"time"
 )
 
 /*
 Usage of ./grits:
 
+	grits [flags] <file>
+	      typecheck and execute a program
+	grits lsp
+	      start a language server (LSP), communicating over stdin/stdout
+	grits fmt [--check] [--write] <files>
+	      reformat programs (printed to stdout by default)
+	grits synth [--depth n] [--max n] <file>
+	      search for the forms filling each hole (?) of a program, up to a depth (default 5)
+
 	--benchmark
 	      run benchmarks for current program
 	--sample-benchmarks
@@ -31,8 +43,29 @@ Usage of ./grits:
 	      skip typechecker (equivalent to -typecheck=false)
 	--repeat uint
 	      number of repetitions do when benchmarking (default 1)
+	--monitor-types
+	      check each message against the session types of the channels while executing (useful with --notypecheck)
 	--verbosity int
 	      verbosity level (1 = least, 3 = most) (default 1)
+	-W option
+	      control warnings: all, none, error, <kind> or no-<kind>, where kind is one of unused-function,
+	      unused-type, unreachable-branch, implicit-drop, unused-assumption, unfinished-type, unusable-type or
+	      non-termination (can be repeated)
+	--termination
+	      check whether each function is terminating or productive (non-recursive functions are terminating)
+	--gradual
+	      allow missing type annotations, which are taken to be the dynamic type ? (checked at runtime)
+	--infer
+	      infer missing type annotations (of function signatures, processes and new names) from their usage, and print them
+	--shifts
+	      insert the shifts and casts needed to move between modes, according to the declared types
+	--elaborate
+	      print the program as elaborated by the typechecker (i.e. with the implicit drops, splits and shifts inserted)
+	      instead of executing
+	--derivation string
+	      print the typing derivation of a function (or process, e.g. prc[a]) instead of executing
+	--derivation-format string
+	      format of the derivation: json or latex (default "json")
 	--webserver
 	      start webserver
 	--addr string
@@ -41,12 +74,28 @@ Usage of ./grits:
 
 // Entry point to run via CLI
 func Cli() {
+	// Subcommands are handled separately from the flags
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lsp":
+			if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "fmt":
+			os.Exit(formatFiles(os.Args[2:]))
+		case "synth":
+			os.Exit(synthesizeHoles(os.Args[2:]))
+		}
+	}
+
 	// Execution Flags
 	typecheck := flag.Bool("typecheck", true, "run typechecker")
 	noTypecheck := flag.Bool("notypecheck", false, "skip typechecker (equivalent to -typecheck=false)")
 	execute := flag.Bool("execute", true, "execute processes")
 	noExecute := flag.Bool("noexecute", false, "do not execute processes (equivalent to -execute=false)")
 	logLevel := flag.Int("verbosity", 1, "verbosity level (1 = least, 3 = most)")
+	monitorTypes := flag.Bool("monitor-types", false, "check each message against the session types of the channels while executing")
 
 	// Execution Flags
 	syncSemantics := flag.Bool("sync", false, "execute using synchronous version (non-polarized) (default set to --async)")
@@ -61,6 +110,21 @@ func Cli() {
 	// Webserver
 	startWebserver := flag.Bool("webserver", false, "start webserver")
 
+	// Elaboration
+	elaborate := flag.Bool("elaborate", false, "print the program as elaborated by the typechecker (i.e. with the implicit drops, splits and shifts inserted) instead of executing")
+	shifts := flag.Bool("shifts", false, "insert the shifts and casts needed to move between modes, according to the declared types")
+
+	// Typing derivations
+	derivation := flag.String("derivation", "", "print the typing derivation of a function (or process, e.g. prc[a]) instead of executing")
+	derivationFormat := flag.String("derivation-format", "json", "format of the derivation: json or latex")
+
+	// Warnings
+	termination := flag.Bool("termination", false, "check whether each function is terminating or productive")
+	gradual := flag.Bool("gradual", false, "allow missing type annotations, which are taken to be the dynamic type ?")
+	infer := flag.Bool("infer", false, "infer missing type annotations from their usage, and print them")
+	var warningOptions stringList
+	flag.Var(&warningOptions, "W", "control warnings: all, none, error, <kind> or no-<kind> (can be repeated)")
+
 	// todo: add option to choose which execution to use (synchronous vs asynchronous with polarities)
 
 	flag.Parse()
@@ -142,12 +206,57 @@ func Cli() {
 
 	globalEnv.LogLevels = generateLogLevel(*logLevel)
 
+	globalEnv.CheckTermination = *termination
+	globalEnv.Gradual = *gradual
+	globalEnv.InferTypes = *infer
+	globalEnv.InsertShifts = *shifts
+	globalEnv.WarningOptions, err = process.ParseWarningOptions(warningOptions)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if *elaborate {
+		if err := printElaborated(args[0], globalEnv); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *derivation != "" {
+		if err := printDerivation(processes, assumedFreeNames, globalEnv, *derivation, *derivationFormat); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if typecheckRes {
 		err = process.Typecheck(processes, assumedFreeNames, globalEnv)
 		if err != nil {
 			log.Fatal(err)
 			return
 		}
+
+		for _, warning := range globalEnv.Warnings {
+			fmt.Fprintln(os.Stderr, warning.String())
+		}
+
+		for _, result := range globalEnv.TerminationResults {
+			fmt.Println(result.String())
+		}
+
+		for _, annotation := range globalEnv.InferredAnnotations {
+			fmt.Println(annotation.String())
+		}
+
+		for _, hole := range globalEnv.Holes {
+			fmt.Println(hole.String())
+		}
+
+		if executeRes && len(globalEnv.Holes) > 0 {
+			log.Fatalf("cannot execute the program, since %d hole(s) have not been filled in", len(globalEnv.Holes))
+			return
+		}
 	}
 
 	if executeRes {
@@ -164,19 +273,55 @@ func Cli() {
 		}
 
 		re := &process.RuntimeEnvironment{
-			GlobalEnvironment: globalEnv,
-			UseMonitor:        false,
-			Color:             true,
-			ExecutionVersion:  executionVersion,
-			Typechecked:       typecheckRes,
-			Delay:             0 * time.Millisecond,
-			Quiet:             false,
+			GlobalEnvironment:   globalEnv,
+			UseMonitor:          false,
+			Color:               true,
+			ExecutionVersion:    executionVersion,
+			Typechecked:         typecheckRes,
+			MonitorSessionTypes: *monitorTypes,
+			Delay:               0 * time.Millisecond,
+			Quiet:               false,
 		}
 
 		process.InitializeProcesses(processes, nil, nil, re)
 	}
 }
 
+// Prints the elaborated program, typechecked using the same settings as the given global environment
+func printElaborated(fileName string, globalEnv *process.GlobalEnvironment) error {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	elaborated, err := parser.FormatElaborated(string(content), func(g *process.GlobalEnvironment) {
+		g.LogLevels = globalEnv.LogLevels
+		g.CheckTermination = globalEnv.CheckTermination
+		g.Gradual = globalEnv.Gradual
+		g.InferTypes = globalEnv.InferTypes
+		g.InsertShifts = globalEnv.InsertShifts
+		g.WarningOptions = globalEnv.WarningOptions
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(elaborated)
+	return nil
+}
+
+// Flag which can be passed multiple times, e.g. -W all -W no-unused-type
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 // Generate log levels: 1 = least verbose, 3 = most verbose
 // todo maybe add level 0 for quiet
 func generateLogLevel(logLevel int) []process.LogLevel {
@@ -204,3 +349,127 @@ func generateLogLevel(logLevel int) []process.LogLevel {
 		}
 	}
 }
+
+// Typechecks the program while recording derivations, and prints the one requested
+func printDerivation(processes []*process.Process, assumedFreeNames []process.Name, globalEnv *process.GlobalEnvironment, name, format string) error {
+	// Only the derivation is printed, so that the output can be used as is
+	globalEnv.LogLevels = nil
+	globalEnv.RecordDerivations = true
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		return err
+	}
+
+	derivation, ok := globalEnv.Derivations[name]
+	if !ok {
+		return fmt.Errorf("no function or process named '%s' was found", name)
+	}
+
+	switch format {
+	case "json":
+		content, err := derivation.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(content))
+	case "latex":
+		fmt.Print(derivation.LaTeX())
+	default:
+		return fmt.Errorf("unknown derivation format '%s' (expected json or latex)", format)
+	}
+
+	return nil
+}
+
+// Runs 'grits fmt' and returns the exit code
+func formatFiles(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "list files whose formatting differs, and exit with status 1 if any (for CI)")
+	write := flags.Bool("write", false, "write the result to the source files instead of stdout")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: grits fmt [--check] [--write] <files>")
+		return 2
+	}
+
+	exitCode := 0
+	for _, fileName := range flags.Args() {
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 2
+			continue
+		}
+
+		formatted, err := parser.Format(string(content))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", fileName, err)
+			exitCode = 2
+			continue
+		}
+
+		switch {
+		case *check:
+			if formatted != string(content) {
+				fmt.Println(fileName)
+				exitCode = max(exitCode, 1)
+			}
+		case *write:
+			if formatted != string(content) {
+				if err := os.WriteFile(fileName, []byte(formatted), 0644); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					exitCode = 2
+				}
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+
+	return exitCode
+}
+
+func synthesizeHoles(args []string) int {
+	flags := flag.NewFlagSet("synth", flag.ExitOnError)
+	depth := flags.Int("depth", 5, "maximum depth (number of steps) of the forms filling a hole")
+	maxForms := flags.Int("max", 3, "maximum number of forms printed for each hole")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: grits synth [--depth n] [--max n] <file>")
+		return 2
+	}
+
+	content, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	results, err := parser.Synthesize(string(content), *depth, *maxForms)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flags.Arg(0), err)
+		return 2
+	}
+
+	if len(results) == 0 {
+		fmt.Println("no holes (?) found")
+	}
+
+	exitCode := 0
+	for _, result := range results {
+		hole := result.Hole
+		fmt.Printf("(%s) %s : %s\n", hole.Position.String(), hole.Provider, hole.ProviderType.String())
+
+		if len(result.Forms) == 0 {
+			fmt.Printf("    no forms found up to depth %d\n", *depth)
+			exitCode = 1
+		}
+
+		for _, form := range result.Forms {
+			fmt.Println("    " + process.FormatSynthesized(form, 4))
+		}
+	}
+
+	return exitCode
+}
@@ -5,6 +5,7 @@ import (
 	"grits/parser"
 	"grits/process"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -892,3 +893,234 @@ func initProcesses(processes []*process.Process, globalEnv *process.GlobalEnviro
 		return []process.Process{}, []process.MonitorRulesLog{}, re.ProcessCount(), re.DeadProcessCount()
 	}
 }
+
+func TestSessionTypeMonitor(t *testing.T) {
+	nat := "type nat = +{zero : 1, succ : nat}\n"
+
+	cases := []struct {
+		program  string
+		expected string
+	}{
+		// Follows the declared types
+		{nat + `prc[a] : nat = t : 1 <- new close self; self.zero<t>
+		 prc[b] : 1 = case a (zero<x> => wait x; close self | succ<x> => wait x; close self)`, ""},
+		// Label not found in the choices
+		{nat + `prc[a] : nat = self.zreo<b>
+		 prc[b] : 1 = close self
+		 prc[c] : 1 = case a (zero<x> => wait x; close self)`, "the label 'zreo' was selected on 'a', but its type 'nat' expected one of the labels zero, succ; did you mean 'zero'?"},
+		// Wrong rule for the type
+		{`prc[a] : 1 * 1 = close self`, "the type '1 * 1' of 'a' only allows send on the provider (SND), but found close (CLS)"},
+		// Both sides waiting for each other
+		{`prc[a] : &{l : 1} = case self (l<x> => close x)
+		 prc[b] : 1 = <x, y> <- recv a; close self`, "the type '&{l : 1}' of 'a' only allows select from a client (BRA), but the process expects send on the provider (SND)"},
+		// Use after close
+		{`prc[a] : 1 = close self
+		 prc[b] : 1 = wait a; wait a; close self`, "'a' is used (close (CLS)) after being closed"},
+		// Types are propagated to the names being sent
+		{`prc[a] : (1 * 1) * 1 = send self<b, c>
+		 prc[c] = close self
+		 prc[d] : 1 = <x, y> <- recv a; <u, v> <- recv y; close self`, "the type '1' of 'c' only allows close (CLS), but the process expects send on the provider (SND)"},
+	}
+
+	for _, execVersion := range []process.Execution_Version{process.NORMAL_ASYNC, process.NON_POLARIZED_SYNC} {
+		for i, c := range cases {
+			err := runWithSessionTypeMonitor(t, c.program, execVersion, false)
+
+			if c.expected == "" && err != nil {
+				t.Errorf("case #%d: expected no violations, but found %s", i, err)
+			} else if c.expected != "" && (err == nil || !strings.Contains(err.Error(), c.expected)) {
+				t.Errorf("case #%d: expected a violation containing %q, but found %v", i, c.expected, err)
+			}
+		}
+	}
+}
+
+func TestGradualCasts(t *testing.T) {
+	nat := "type nat = +{zero : 1, succ : nat}\n"
+
+	cases := []struct {
+		program  string
+		expected string
+	}{
+		// The forward on a name of type ? takes its polarity from the channel
+		{nat + `let id(x : ?) : ? = fwd self x
+		 prc[a] : nat = t : 1 <- new close self; self.zero<t>
+		 prc[b] : ? = id(a)
+		 prc[c] : 1 = case b (zero<t> => wait t; close self | succ<q> => drop q; close self)`, ""},
+		// Cast when receiving from a process of type ?
+		{nat + `prc[b] : ? = t : 1 <- new close self; self.suc<t>
+		 prc[c] : 1 = case b (zero<t> => wait t; close self | succ<q> => drop q; close self)`,
+			"cast failure blaming (Line 2) prc[b]: the cast of 'b' from '?' to '+{zero : ?, succ : ?}' failed, since the label 'suc' was selected; expected one of the labels zero, succ; did you mean 'succ'?"},
+		{nat + `prc[b] : ? = close self
+		 prc[c] : 1 = case b (zero<t> => wait t; close self | succ<q> => drop q; close self)`,
+			"cast failure blaming (Line 2) prc[b]: the cast of 'b' from '?' to '+{zero : ?, succ : ?}' failed, since the type only allows select by the provider (SEL), but the message was a close (CLS)"},
+		// Cast when passing a name of type ? to a function
+		{nat + `let f(x : nat) : 1 = case x (zero<t> => wait t; close self | succ<q> => drop q; close self)
+		 prc[a] : ? = t : 1 <- new close self; self.one<t>
+		 prc[b] : 1 = f(a)`,
+			"cast failure blaming (Line 3) prc[a]: the cast of 'a' from '?' to 'nat' failed, since the label 'one' was selected"},
+	}
+
+	for _, execVersion := range []process.Execution_Version{process.NORMAL_ASYNC, process.NON_POLARIZED_SYNC} {
+		for i, c := range cases {
+			err := runWithSessionTypeMonitor(t, c.program, execVersion, true)
+
+			if c.expected == "" && err != nil {
+				t.Errorf("case #%d: expected no cast failures, but found %s", i, err)
+			} else if c.expected != "" && (err == nil || !strings.Contains(err.Error(), c.expected)) {
+				t.Errorf("case #%d: expected a cast failure containing %q, but found %v", i, c.expected, err)
+			}
+		}
+	}
+}
+
+func TestInput(t *testing.T) {
+	program := `type answer = +{yes : 1, no : 1}
+		prc[a] : answer = input self
+		prc[b] : 1 = case a (yes<u> => wait u; close self | no<u> => v : 1 <- new close self; wait v; wait u; close self)`
+
+	cases := []struct {
+		input    string
+		expected steps
+	}{
+		{"yes\n", steps{{"a", process.CUT}, {"b", process.SEL}, {"b", process.CLS}}},
+		// Unknown labels are read again
+		{"maybe\nno\n", steps{{"a", process.CUT}, {"b", process.SEL}, {"b", process.CUT}, {"b", process.CLS}, {"b", process.CLS}}},
+	}
+
+	execVersions := []process.Execution_Version{
+		process.NORMAL_ASYNC,
+		process.NORMAL_SYNC,
+		process.NON_POLARIZED_SYNC,
+	}
+
+	for _, c := range cases {
+		sort.Sort(c.expected)
+
+		for _, execVersion := range execVersions {
+			got, _ := runInteractively(t, program, c.input, execVersion)
+			sort.Sort(got)
+
+			if !compareSteps(t, got, c.expected) {
+				t.Errorf("input %q: expected trace %s, but found %s", c.input, stingifySteps(c.expected), stingifySteps(got))
+			}
+		}
+	}
+}
+
+func TestPrintValue(t *testing.T) {
+	definitions := `type nat = +{zero : 1, succ : nat}
+		type list = +{nil : 1, cons : nat * list}
+		type bool = +{true : 1, false : 1}
+		let zero() : nat = self.zero; close self
+		let one() : nat = self.succ; zero()
+		let nil() : list = self.nil; close self
+		let cons(h : nat, t : list) : list = self.cons; send self<h, t>
+		let yes() : bool = self.true; close self
+		let no() : bool = self.false; close self
+		`
+
+	cases := []struct {
+		program  string
+		expected string
+	}{
+		// Natural numbers are printed as numbers
+		{definitions + `prc[a] : nat = self.succ; one()
+			prc[b] : 1 = print(a); close self`, "> 2\n"},
+		{definitions + `prc[a] : list = h : nat <- new one(); z : nat <- new zero(); n : list <- new nil(); t : list <- new cons(z, n); cons(h, t)
+			prc[b] : 1 = print(a); close self`, "> cons(1, cons(0, nil))\n"},
+		{definitions + `prc[a] : bool * 1 * bool = t : bool <- new yes(); u : 1 <- new close self; f : bool <- new no(); send self<t, u, f>
+			prc[b] : 1 = print(a); print done; close self`, "> (true, (), false)\n> done\n"},
+		// Labels carrying other labels
+		{`prc[a] : +{some : +{a : 1, b : 1}, none : 1} = self.some; self.b; close self
+			prc[b] : 1 = print(a); close self`, "> some(b)\n"},
+	}
+
+	for i, c := range cases {
+		for _, execVersion := range []process.Execution_Version{process.NORMAL_ASYNC, process.NON_POLARIZED_SYNC} {
+			_, output := runInteractively(t, c.program, "", execVersion)
+
+			if output != c.expected {
+				t.Errorf("case #%d: expected the output %q, but found %q", i, c.expected, output)
+			}
+		}
+	}
+}
+
+// Runs a typechecked program reading the given input, returning its trace and output
+func runInteractively(t *testing.T, program string, input string, execVersion process.Execution_Version) (steps, string) {
+	processes, assumedFreeNames, globalEnv, err := parser.ParseString(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	re, _, cancel := process.NewRuntimeEnvironment()
+	defer cancel()
+
+	globalEnv.LogLevels = []process.LogLevel{}
+	re.GlobalEnvironment = globalEnv
+	re.UseMonitor = true
+	re.ExecutionVersion = execVersion
+	re.Typechecked = true
+	re.Input = strings.NewReader(input)
+	var output bytes.Buffer
+	re.Output = &output
+
+	channels := re.CreateChannelForEachProcess(processes)
+	re.SubstituteNameInitialization(processes, channels)
+
+	startedWg := new(sync.WaitGroup)
+	startedWg.Add(1)
+	re.InitializeGivenMonitor(startedWg, process.NewMonitor(re, nil), nil)
+	startedWg.Wait()
+
+	go re.HeartbeatReceiver(timeout, cancel)
+
+	re.StartTransitions(processes)
+
+	<-re.Ctx().Done()
+	_, rulesLog := re.StopMonitor()
+	return convertRulesLog(rulesLog), output.String()
+}
+
+// When typechecked, the monitor is only enabled by the use of ?
+func runWithSessionTypeMonitor(t *testing.T, input string, execVersion process.Execution_Version, typecheck bool) error {
+	processes, assumedFreeNames, globalEnv, err := parser.ParseString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if typecheck {
+		if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	re, _, cancel := process.NewRuntimeEnvironment()
+	defer cancel()
+
+	globalEnv.LogLevels = []process.LogLevel{}
+	re.GlobalEnvironment = globalEnv
+	re.ExecutionVersion = execVersion
+	re.Typechecked = typecheck
+	re.MonitorSessionTypes = !typecheck
+	re.Quiet = true
+
+	channels := re.CreateChannelForEachProcess(processes)
+	re.SubstituteNameInitialization(processes, channels)
+
+	go re.HeartbeatReceiver(timeout, cancel)
+
+	re.StartTransitions(processes)
+
+	select {
+	case <-re.Ctx().Done():
+		return nil
+	case err := <-re.ErrorChan():
+		return err
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"grits/parser"
 	"grits/process"
+	"strings"
 	"testing"
 )
 
@@ -82,7 +83,7 @@ func TestTypecheckIncorrectSendReceive(t *testing.T) {
 		"prc[a] : A = close self",
 		"let f() : 1 -* A = close self",
 		// MulL (extra non used names)
-		"let f(c : 1, a : 1, b : 1) : 1 * 1 = send self<a, b>",
+		"let f(c : lin 1, a : lin 1, b : lin 1) : lin 1 * 1 = send self<a, b>",
 		// MulL (missing names)
 		"let f(b : 1) : 1 * 1 = send self<a, b>",
 		// MulL (incorrect self type)
@@ -143,11 +144,11 @@ func TestTypecheckIncorrectUnit(t *testing.T) {
 	cases := []string{
 		// EndR
 		"let f1(u : 1) : 1 = close u",
-		"let f1(u : 1 * 1) : 1 = close self",
+		"let f1(u : lin 1 * 1) : lin 1 = close self",
 		"let f1() : 1 * 1 = close self",
 		// EndL
 		"let f1() : 1 = wait self; close self",
-		"let f1(g : 1 * 1, x : 1) : 1 = wait x; close self",
+		"let f1(g : lin 1 * 1, x : lin 1) : lin 1 = wait x; close self",
 		// Assuming a
 		`type A = 1
 		 assuming a : A
@@ -181,7 +182,7 @@ func TestTypecheckIncorrectForward(t *testing.T) {
 		"let f1(x : 1 * 1) : 1 -* 1 = fwd self x",
 		"let f1(x : &{hello : 1}) : 1 = fwd self x",
 		"let f1(x : 1 * 1) : 1 * 1 = fwd x self",
-		"let f1(x : 1 * 1, y : 1) : 1 * 1 = fwd self x",
+		"let f1(x : lin 1 * 1, y : lin 1) : lin 1 * 1 = fwd self x",
 		"let f1(g : (+{a : 1})) : 1 -* (&{a : 1}) = <x, y> <- recv self; wait x; fwd y g",
 		"let f1(x : 1 * 1, y : 1 * 1) : 1 * 1 = fwd x y",
 	}
@@ -217,8 +218,9 @@ func TestTypecheckIncorrectDrop(t *testing.T) {
 		// Drop and use later
 		"let f1() : 1 -* 1 = <x, y> <- recv self; drop x; wait x; close y",
 		"let f1() : 1 -* 1 = drop x; <x, y> <- recv self;  wait x; close y",
+		"let f1(x : 1) : 1 = wait x; drop x; wait x; close self",
 		// Missed drop
-		"let f1(x : 1 * 1, g : &{a : 1}) : 1 * 1 = fwd self x",
+		"let f1(x : lin 1 * 1, g : lin &{a : 1}) : lin 1 * 1 = fwd self x",
 		// Cannot drop a non weakenable name
 		`assuming a : linear 1
 		prc[b] : 1 = drop a; close self`,
@@ -345,8 +347,8 @@ func TestTypecheckIncorrectBranch(t *testing.T) {
 							wait yy;
 							close self
 			)`,
-		`type bin = &{label1 : 1, label2 : 1}
-		 let f(a : 1) : bin = 
+		`type bin = lin &{label1 : 1, label2 : 1}
+		 let f(a : lin 1) : bin = 
 				case self ( label1<c> => wait a; close c
 							| label2<c> => close c)`,
 	}
@@ -450,7 +452,7 @@ func TestTypecheckCorrectProcessDefinitionsIncorrect(t *testing.T) {
 		 prc[x] = send self<a, b>`,
 		`assuming b : 1
 		 prc[x] = send self<a, b>`,
-		`assuming a : 1, b : 1, c : 1
+		`assuming a : 1, b : 1, c : lin 1
 		 prc[x] : 1 * 1 = send self<a, b>`,
 		`assuming c : 1
 		 prc[x] : 1 * 1 = send self<a, b>`,
@@ -562,6 +564,16 @@ func TestTypecheckCorrectSplit(t *testing.T) {
 						 close self
 	     assuming pid3 : 1, pid4 : 1
 		 prc[pid2] : A = send self<pid3, pid4>`,
+		// Implicit splits
+		`prc[pid0] : 1 = wait x; wait x; close self
+		 prc[x] : 1 = close self`,
+		`prc[pid0] : 1 = wait x; wait x; wait x; close self
+		 prc[x] : multicast 1 = close self`,
+		`let f(x : 1, y : 1) : 1 = wait x; wait y; close self
+		 let g(x : 1) : 1 = y <- new f(x, x); wait y; f(self, x, x)`,
+		`type nat = +{zero : 1, succ : nat}
+		 let f(n : nat, x : 1) : 1 * 1 = case n (zero<u> => wait u; send self<x, x> | succ<m> => drop m; wait x; send self<x, x>)`,
+		`let f(x : 1 * 1) : 1 = <a, b> <- recv x; wait a; wait a; fwd self b`,
 	}
 
 	runThroughTypechecker(t, cases, true)
@@ -575,11 +587,18 @@ func TestTypecheckIncorrectSplit(t *testing.T) {
 		 prc[pid0] : 1 = <u, u> <- split x; wait u; close self
 		 prc[x] : 1 = close self`,
 		`let f(x: 1, y : 1) : 1 = <u, x> <- split y; wait x; close self`,
-		`let f(x: 1, y : 1) : 1 = <u1, u2> <- split y; wait x; close self`,
+		`let f(x: mul 1, y : mul 1) : mul 1 = <u1, u2> <- split y; wait x; close self`,
 		`prc[pid0] : 1 = <u, v> <- split x; wait u; wait v; close self
 		prc[x] : linear 1 = close self`,
 		`prc[pid0] : 1 = <u, v> <- split x; wait u; wait v; close self
 		prc[x] : affine 1 = close self`,
+		// Implicit splits of names which cannot be contracted
+		`prc[pid0] : 1 = wait x; wait x; close self
+		 prc[x] : linear 1 = close self`,
+		`prc[pid0] : 1 = wait x; wait x; close self
+		 prc[x] : affine 1 = close self`,
+		`let f(x : lin 1, y : lin 1) : lin 1 = wait x; wait y; close self
+		 let g(x : lin 1) : lin 1 = f(self, x, x)`,
 	}
 
 	runThroughTypechecker(t, cases, false)
@@ -700,8 +719,8 @@ func TestPreliminaryProcessesChecksIncorrect(t *testing.T) {
 		 prc[c] : 1 = wait a; wait x; close self
 		 prc[d] : 1 = wait b; close self
 		 prc[e] : 1 = wait b; close self`,
-		// remaining unused assumed names
-		`assuming x : 1, y : 1
+		// remaining unused assumed names (which are linear)
+		`assuming x : 1, y : lin 1
 		 prc[a, b] : 1 = close self
 		 prc[c] : 1 = wait a; wait x; close self
 		 prc[d] : 1 = wait b; close self`,
@@ -971,6 +990,512 @@ func TestTypecheckIncorrectShifting(t *testing.T) {
 	runThroughTypechecker(t, cases, false)
 }
 
+// With shift insertion, the shifts and casts are added according to the declared types
+func TestShiftInsertion(t *testing.T) {
+	correct := []string{
+		// Upshifts: the provider is shifted and the client is cast
+		`prc[a] : linear /\ affine 1 = close self`,
+		`let m(f : linear /\ replicable (1 * 1)) : linear 1 =
+			<x, y> <- recv f;
+			wait x;
+			fwd self y`,
+		`type transaction = lin +{start : +{finish : 1}}
+		 let auth() : lin /\ aff transaction =
+		     u : lin 1 <- new close self;
+		     v : lin +{finish : 1} <- new self.finish<u>;
+		     self.start<v>
+		 prc[b] : lin /\ aff transaction = auth()
+		 prc[c] : lin 1 = case b (start<t> => case t (finish<t'> => wait t'; close self))`,
+		// Downshifts: the provider is cast and the client is shifted
+		`assuming x : affine 1
+		 prc[a] : affine \/ linear 1 = fwd self x
+		 prc[b] : affine 1 = fwd self a`,
+		`prc[a] : affine \/ linear 1 = close self
+		 prc[b] : linear 1 = wait a; close self`,
+		// An argument is cast to the downshift expected by the function
+		`let f(x : affine \/ linear 1) : linear 1 = wait x; close self
+		 assuming u : affine 1
+		 prc[a] : linear 1 = f(u)`,
+		// Explicit shifts are left as they are
+		`prc[a] : linear /\ affine 1 = y <- shift self; close y`,
+	}
+
+	incorrect := []string{
+		// The modes still have to allow the shifts
+		`prc[a] : affine /\ linear 1 = close self`,
+		`let f(x : affine \/ linear 1) : linear 1 = wait x; close self
+		 assuming u : linear 1
+		 prc[a] : linear 1 = f(u)`,
+	}
+
+	for i, c := range append(correct, incorrect...) {
+		processes, assumedFreeNames, globalEnv, err := parser.ParseString(c)
+		if err != nil {
+			t.Fatalf("compilation error in case #%d: %s", i, err)
+		}
+
+		globalEnv.InsertShifts = true
+		err = process.Typecheck(processes, assumedFreeNames, globalEnv)
+		if i < len(correct) && err != nil {
+			t.Errorf("expected no type errors in case #%d, but found %s", i, err)
+		} else if i >= len(correct) && err == nil {
+			t.Errorf("expected type error in case #%d, but didn't find any", i)
+		}
+	}
+
+	// Without shift insertion, the missing shifts are reported
+	runThroughTypechecker(t, correct[:len(correct)-1], false)
+}
+
+func TestTypecheckCorrectMacros(t *testing.T) {
+	cases := []string{
+		// On a client, which carries on as the same name
+		"let f(a : 1 -* 1, b : 1) : 1 = send a<b>; wait a; close self",
+		"let f(a : &{l : 1, r : 1 * 1}) : 1 = a.l; wait a; close self",
+		"let f(a : 1 * 1) : 1 = x <- recv a; wait x; wait a; close self",
+		// On the provider, which spawns the rest of the process
+		"let f(b : 1) : 1 * 1 = send self<b>; close self",
+		"let f() : +{l : 1, r : 1 * 1} = self.l; close self",
+		"let f() : 1 -* 1 = x <- recv self; wait x; close self",
+		"let f[w : 1 -* (1 * 1)] = x <- recv w; send w<x>; close w",
+		// Nested macros
+		`type nat = +{zero : 1, succ : nat}
+		 let two() : nat = self.succ; self.succ; self.zero; close self`,
+		`let f(a : 1 -* (1 -* 1), b : 1, c : 1) : 1 * +{l : 1} =
+			send a<b>; send a<c>; wait a;
+			u : 1 <- new close self;
+			send self<u>; self.l; close self`,
+		// On a name standing for the provider
+		"let f(b : 1) : 1 -* (1 * 1) = <x, y> <- recv self; wait x; send y<b>; close y",
+		// Processes
+		`prc[a] : 1 -* 1 = x <- recv self; wait x; close self
+		 prc[b] : 1 = u : 1 <- new close self; send a<u>; wait a; close self`,
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckIncorrectMacros(t *testing.T) {
+	cases := []string{
+		// Wrong types
+		"let f(a : 1 * 1, b : 1) : 1 = send a<b>; wait a; close self",
+		"let f(a : 1 -* 1, b : &{l : 1}) : 1 = send a<b>; wait a; close self",
+		"let f(a : &{l : 1}) : 1 = a.r; wait a; close self",
+		"let f(b : 1) : 1 -* 1 = send self<b>; close self",
+		"let f() : +{l : 1} = self.r; close self",
+		"let f() : 1 * 1 = x <- recv self; wait x; close self",
+		// The name is no longer of the type it had before the macro
+		"let f(a : 1 -* 1, b : 1) : 1 = send a<b>; send a<b>; close self",
+		// The rest of the process cannot use the names sent
+		"let f(b : lin 1) : lin 1 * 1 = send self<b>; wait b; close self",
+		"let f(b : lin 1, c : lin 1) : lin 1 * 1 = send self<b>; close self",
+	}
+
+	runThroughTypechecker(t, cases, false)
+}
+
+func TestExecMacros(t *testing.T) {
+	cases := []string{
+		`type nat = +{zero : 1, succ : nat}
+
+		let two() : nat = self.succ; self.succ; self.zero; close self
+
+		let count(n : nat) : 1 =
+			case n (
+				zero<n> => wait n; close self
+			  | succ<n> => print succ; count(n))
+
+		let main() : 1 = n : nat <- new two(); count(n)
+
+		exec main()`,
+		`let pair() : 1 -* (1 * 1) = x <- recv self; u : 1 <- new close self; send self<u>; wait x; close self
+
+		let main() : 1 =
+			p : 1 -* (1 * 1) <- new pair();
+			u : 1 <- new close self;
+			send p<u>;
+			x <- recv p;
+			wait x;
+			wait p;
+			close self
+
+		exec main()`,
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckCorrectLocalDefinitions(t *testing.T) {
+	cases := []string{
+		"let f() : 1 = let let g() : 1 = close self in g() end",
+		// Local functions may call themselves and each other
+		`type nat = +{zero : 1, succ : nat}
+		 let double(x : nat) : nat =
+			let
+				let twice(y : nat) : nat =
+					case y (
+						zero<y> => self.zero<y>
+					  | succ<y> => self.succ; self.succ; twice(y))
+			in
+				twice(x)
+			end`,
+		`let f() : 1 =
+			let
+				let g() : 1 = h()
+				let h() : 1 = close self
+			in
+				g()
+			end`,
+		// Closures over the names available where the function is defined
+		`type nat = +{zero : 1, succ : nat}
+		 let add(x : nat, y : nat) : nat =
+			let
+				let go(n : nat) : nat =
+					case n (
+						zero<n> => wait n; fwd self y
+					  | succ<n> => self.succ; go(n))
+			in
+				go(x)
+			end`,
+		"let f(x : 1) : 1 = let let g() : 1 = wait x; close self in g() end",
+		// Replicable names can be captured by more than one call
+		`let f(x : rep 1) : 1 =
+			let
+				let g() : 1 = wait x; close self
+			in
+				a : 1 <- new g();
+				b : 1 <- new g();
+				wait a; wait b; close self
+			end`,
+		// Local types
+		`let f() : +{yes : 1, no : 1} =
+			let
+				type answer = +{yes : 1, no : 1}
+				let say() : answer = self.yes; close self
+			in
+				say()
+			end`,
+		// Local definitions shadow the global ones
+		`type t = 1
+		 let g() : t = close self
+		 let f() : 1 * 1 =
+			let
+				type t = 1 * 1
+				let g() : t = u : 1 <- new close self; send self<u>; close self
+			in
+				g()
+			end`,
+		// Nested blocks
+		`let f(x : 1) : 1 =
+			let
+				let g() : 1 = let let h() : 1 = wait x; close self in h() end
+			in
+				g()
+			end`,
+		// Processes
+		`prc[a] : 1 = close self
+		 prc[b] : 1 = let let g() : 1 = wait a; close self in g() end`,
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckIncorrectLocalDefinitions(t *testing.T) {
+	cases := []string{
+		"let f() : 1 = let let g() : 1 * 1 = close self in g() end",
+		"let f() : 1 = let let g(x : 1) : 1 = wait x; close self in g() end",
+		// Linear names cannot be captured by more than one call
+		`let f(x : lin 1) : lin 1 =
+			let
+				let g() : lin 1 = wait x; close self
+			in
+				a : lin 1 <- new g();
+				b : lin 1 <- new g();
+				wait a; wait b; close self
+			end`,
+		// Nor used once captured
+		"let f(x : lin 1) : lin 1 = let let g() : lin 1 = wait x; close self in wait x; g() end",
+		// The captured names must have a stronger mode than the local function (declaration of independence)
+		"let f(x : aff 1) : aff 1 = let let g() : rep 1 = drop x; close self in g() end",
+		// Within the block, t refers to the local type
+		`type t = 1
+		 let f() : t = let type t = 1 * 1 let g() : t = close self in g() end`,
+		// Definitions are only visible within their block
+		`let f() : 1 = let let g() : 1 = close self in g() end
+		 let h() : 1 = g()`,
+	}
+
+	runThroughTypechecker(t, cases, false)
+
+	// Captured names cannot be bound again before a call (e.g. the outer x has been consumed by the case). This is
+	// found while lifting the local definitions, i.e. when parsing.
+	rebound := []string{
+		`type nat = +{zero : 1, succ : nat}
+		 let f(x : nat) : nat = let let g() : nat = fwd self x in case x (zero<k> => self.zero<k> | succ<x> => g()) end`,
+		`type nat = +{zero : 1, succ : nat}
+		 let f(x : nat) : nat =
+			let
+				let g() : nat = case x (zero<k> => self.zero<k> | succ<x> => g())
+			in
+				g()
+			end`,
+		// Nor hidden by the parameters of another local function
+		`let f(x : 1) : 1 =
+			let
+				let g() : 1 = wait x; close self
+				let h(x : 1) : 1 = g()
+			in
+				h(x)
+			end`,
+	}
+
+	for i, c := range rebound {
+		if _, _, _, err := parser.ParseString(c); err == nil || !strings.Contains(err.Error(), "captures x, which is bound again before the call g()") {
+			t.Errorf("expected an error for the rebound name in case #%d, but found %v", i, err)
+		}
+	}
+}
+
+func TestExecLocalDefinitions(t *testing.T) {
+	cases := []string{
+		`type nat = +{zero : 1, succ : nat}
+
+		let two() : nat = self.succ; self.succ; self.zero; close self
+
+		let main() : 1 =
+			let
+				let count(n : nat) : 1 =
+					case n (
+						zero<n> => wait n; close self
+					  | succ<n> => print succ; count(n))
+			in
+				n : nat <- new two(); count(n)
+			end
+
+		exec main()`,
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckCorrectTuples(t *testing.T) {
+	cases := []string{
+		// On the provider
+		"let f(a : 1, b : 1, c : 1) : 1 * 1 * 1 = send self<a, b, c>",
+		"let f() : 1 -* 1 -* 1 -* 1 = <a, b, c, k> <- recv self; wait a; wait b; wait c; close k",
+		"let f[p : 1 * (1 * 1), a : 1, b : 1, c : 1] = send p<a, b, c>",
+		// On a client
+		"let f(x : 1 -* 1 -* 1 -* 1, a : 1, b : 1, c : 1) : 1 = send x<a, b, c, self>",
+		"let f(x : 1 * 1 * 1) : 1 = <a, b, k> <- recv x; wait a; wait b; wait k; close self",
+		// Mixed with the other macros
+		`type nat = +{zero : 1, succ : nat}
+		 let f(x : nat * nat * 1, y : 1) : nat * nat * 1 = <a, b, k> <- recv x; wait k; send self<b, a, y>`,
+		"let f(x : 1 -* 1 -* 1, a : 1, b : 1) : 1 = send x<a>; send x<b, self>",
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckIncorrectTuples(t *testing.T) {
+	cases := []string{
+		// Too many or too few names
+		"let f(a : 1, b : 1, c : 1) : 1 * 1 = send self<a, b, c>",
+		"let f(a : 1, b : 1, c : 1, d : 1) : 1 * 1 * 1 * 1 = send self<a, b, c>",
+		"let f(x : 1 * 1) : 1 = <a, b, k> <- recv x; wait a; wait b; wait k; close self",
+		// Wrong types
+		"let f(a : 1, b : 1 -* 1, c : 1) : 1 * 1 * 1 = send self<a, b, c>",
+		"let f(x : 1 -* 1 -* 1) : 1 = <a, b, k> <- recv x; wait a; wait b; wait k; close self",
+		// The names sent cannot be used afterwards
+		"let f(a : lin 1, k : lin 1) : lin 1 * 1 * 1 = send self<a, a, k>",
+	}
+
+	runThroughTypechecker(t, cases, false)
+}
+
+func TestExecTuples(t *testing.T) {
+	cases := []string{
+		`let triple(a : 1, b : 1, c : 1) : 1 * 1 * 1 * 1 = k : 1 <- new close self; send self<a, b, c, k>
+
+		let serve() : 1 -* 1 -* 1 -* 1 = <a, b, c, k> <- recv self; wait a; wait b; wait c; close k
+
+		let main() : 1 =
+			a : 1 <- new close self;
+			b : 1 <- new close self;
+			c : 1 <- new close self;
+			t : 1 * 1 * 1 * 1 <- new triple(a, b, c);
+			<x, y, z, k> <- recv t;
+			s : 1 -* 1 -* 1 -* 1 <- new serve();
+			send s<x, y, z, self>
+
+		exec main()`,
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckCorrectPatterns(t *testing.T) {
+	cases := []string{
+		// Nested labels
+		`type nat = +{zero : 1, succ : nat}
+		 let half(n : nat) : nat = case n (zero<z> => self.zero<z> | succ<zero<z>> => self.zero<z> | succ<succ<m>> => self.succ; half(m))`,
+		`type nat = +{zero : 1, succ : nat}
+		 let f(n : nat) : 1 = case n (succ<succ<succ<m>>> => drop m; close self | succ<succ<zero<z>>> => wait z; close self | succ<zero<z>> => wait z; close self | zero<z> => wait z; close self)`,
+		// Tuples
+		`type nat = +{zero : 1, succ : nat}
+		 type list = +{nil : 1, cons : nat * list}
+		 let length(l : list) : nat = case l (nil<u> => self.zero<u> | cons<<h, t>> => drop h; self.succ; length(t))`,
+		"let f(x : +{a : 1 * 1 * 1}) : 1 = case x (a<<u, v, w>> => wait u; wait v; wait w; close self)",
+		`type list = +{nil : 1, cons : 1 * list}
+		 let f(l : list) : 1 = case l (nil<u> => wait u; close self | cons<<h, t>> => wait h; case t (nil<u> => wait u; close self | cons<<h, t>> => wait h; drop t; close self))`,
+		// Patterns within tuples
+		`type list = +{nil : 1, cons : 1 * list}
+		 let f(l : list) : 1 = case l (cons<<h, nil<u>>> => wait h; wait u; close self | cons<<h, cons<<h2, t>>>> => wait h; wait h2; drop t; close self | nil<u> => wait u; close self)`,
+		`type nat = +{zero : 1, succ : nat}
+		 type list = +{nil : 1, cons : nat * list}
+		 let f(l : list) : 1 = case l (cons<<zero<z>, t>> => wait z; drop t; close self | nil<u> => wait u; close self | cons<<succ<m>, t>> => drop m; drop t; close self)`,
+		"let f(x : +{a : +{b : 1} * +{c : 1}}) : 1 = case x (a<<b<u>, c<v>>> => wait u; wait v; close self)",
+		// On the provider
+		"let f() : &{a : &{b : 1, c : 1}, d : 1} = case self (a<b<x>> => close x | a<c<y>> => close y | d<z> => close z)",
+		"let f(u : 1) : &{a : 1 -* 1} = case self (a<<x, k>> => wait x; fwd k u)",
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckIncorrectPatterns(t *testing.T) {
+	cases := []string{
+		// Missing patterns
+		`type nat = +{zero : 1, succ : nat}
+		 let f(n : nat) : 1 = case n (zero<z> => wait z; close self | succ<succ<m>> => drop m; close self)`,
+		"let f() : &{a : &{b : 1, c : 1}, d : 1} = case self (a<b<x>> => close x | d<z> => close z)",
+		// Overlapping patterns
+		`type nat = +{zero : 1, succ : nat}
+		 let f(n : nat) : 1 = case n (zero<z> => wait z; close self | succ<zero<z>> => wait z; close self | succ<zero<y>> => wait y; close self | succ<succ<m>> => drop m; close self)`,
+		// Labels or tuples not matching the type
+		`type nat = +{zero : 1, succ : nat}
+		 let f(n : nat) : 1 = case n (zero<z> => wait z; close self | succ<one<z>> => wait z; close self)`,
+		"let f(x : +{a : 1}) : 1 = case x (a<<u, v>> => wait u; wait v; close self)",
+		// Missing patterns within tuples
+		`type list = +{nil : 1, cons : 1 * list}
+		 let f(l : list) : 1 = case l (cons<<h, nil<u>>> => wait h; wait u; close self | nil<u> => wait u; close self)`,
+	}
+
+	runThroughTypechecker(t, cases, false)
+
+	// Patterns which cannot be merged are rejected when parsing
+	unmerged := []struct {
+		program  string
+		expected string
+	}{
+		{`type list = +{nil : 1, cons : 1 * list}
+		  let f(l : list) : 1 = case l (cons<<h, nil<u>>> => wait h; wait u; close self | cons<<k, cons<t>>> => wait k; drop t; close self | nil<u> => wait u; close self)`,
+			"the patterns cons<<h, nil<u>>> and cons<<k, cons<t>>> have to use the same names for the elements which are not matched further"},
+		{`type list = +{nil : 1, cons : list * list}
+		  let f(l : list) : 1 = case l (cons<<nil<a>, nil<u>>> => close self | cons<<cons<a>, cons<t>>> => close self | nil<u> => wait u; close self)`,
+			"only one element of the tuples can be matched further"},
+		{"let f(x : +{a : 1 * (1 * 1)}) : 1 = case x (a<<h, <u, v>>> => close self)",
+			"the element <u, v> of the pattern a<<h, <u, v>>> cannot be a tuple"},
+		{"let f(x : +{a : 1 * 1}) : 1 = case x (a<<u, v>> => close self | a<<u, v, w>> => close self)",
+			"the patterns a<<u, v>> and a<<u, v, w>> match tuples of different sizes"},
+		{`type nat = +{zero : 1, succ : nat}
+		  let f(n : nat) : 1 = case n (zero<z> => wait z; close self | succ<m> => drop m; close self | succ<zero<z>> => wait z; close self)`,
+			"the patterns succ<m> and succ<zero<z>> match the label succ both with a name and with a nested pattern"},
+		{`type nat = +{zero : 1, succ : nat}
+		  let f(x : nat) : 1 = case x (succ<succ<y>> => drop y; close self | succ<z> => drop z; close self | zero<u> => wait u; close self)`,
+			"the patterns succ<succ<y>> and succ<z> match the label succ both with a name and with a nested pattern"},
+		{`type nat = +{zero : 1, succ : nat}
+		  let f(x : nat) : 1 = case x (succ<succ<succ<y>>> => drop y; close self | succ<succ<z>> => drop z; close self | succ<zero<u>> => wait u; close self | zero<u> => wait u; close self)`,
+			"the patterns succ<succ<succ<y>>> and succ<succ<z>> match the label succ both with a name and with a nested pattern"},
+	}
+
+	for i, c := range unmerged {
+		if _, _, _, err := parser.ParseString(c.program); err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("case #%d: expected the error %q, but found %v", i, c.expected, err)
+		}
+	}
+}
+
+func TestExecPatterns(t *testing.T) {
+	cases := []string{
+		`type nat = +{zero : 1, succ : nat}
+
+		let three() : nat = self.succ; self.succ; self.succ; self.zero; close self
+
+		let half(n : nat) : nat = case n (zero<z> => self.zero<z> | succ<zero<z>> => self.zero<z> | succ<succ<m>> => self.succ; half(m))
+
+		let main() : 1 =
+			n : nat <- new three();
+			h : nat <- new half(n);
+			case h (
+				zero<z> => print zero; wait z; close self
+			  | succ<zero<z>> => print one; wait z; close self
+			  | succ<succ<m>> => print more; drop m; close self)
+
+		exec main()`,
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckCorrectInput(t *testing.T) {
+	cases := []string{
+		"prc[a] : +{yes : 1, no : 1} = input self",
+		"prc[a] : 1 = input self",
+		// Recursive types
+		`type commands = +{deposit : commands, withdraw : commands, eof : 1}
+		 prc[a] : commands = input self`,
+		`type answer = +{yes : 1, no : 1}
+		 let ask() : answer = input self
+		 prc[a] : 1 = x : answer <- new ask(); case x (yes<u> => wait u; close self | no<u> => wait u; close self)`,
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckIncorrectInput(t *testing.T) {
+	cases := []string{
+		// Only the provider reads the input
+		"let f(x : +{yes : 1}) : 1 = input x",
+		// Types which are not made of choices and 1
+		"prc[a] : 1 * 1 = input self",
+		"prc[a] : &{yes : 1, no : 1} = input self",
+		"prc[a] : +{yes : 1, no : &{l : 1}} = input self",
+		"prc[a] : +{} = input self",
+	}
+
+	runThroughTypechecker(t, cases, false)
+}
+
+func TestTypecheckCorrectPrintValue(t *testing.T) {
+	cases := []string{
+		"let f(x : 1) : 1 = print(x); close self",
+		"let f(x : +{a : 1, b : 1 * 1}) : 1 = print(x); close self",
+		`type nat = +{zero : 1, succ : nat}
+		 type list = +{nil : 1, cons : nat * list}
+		 let f(x : nat, l : list) : 1 = print(x); print done; print(l); close self`,
+		// Printing consumes the name
+		"let f(x : aff 1) : aff 1 = print(x); close self",
+	}
+
+	runThroughTypechecker(t, cases, true)
+}
+
+func TestTypecheckIncorrectPrintValue(t *testing.T) {
+	cases := []string{
+		// Only clients are printed
+		"let f() : 1 = print(self); close self",
+		// Names are consumed
+		"let f(x : lin 1) : lin 1 = print(x); wait x; close self",
+		"let f() : 1 = print(x); close self",
+		// Types which are not made of choices, pairs and 1
+		"let f(x : 1 -* 1) : 1 = print(x); close self",
+		"let f(x : +{a : &{b : 1}}) : 1 = print(x); close self",
+		"let f(x : ?) : 1 = print(x); close self",
+	}
+
+	runThroughTypechecker(t, cases, false)
+}
+
 func TestFunctionDefinitionModes(t *testing.T) {
 
 	inputProgram :=
@@ -1127,3 +1652,609 @@ func TestTypecheckIncorrectModalityShifts(t *testing.T) {
 
 	runThroughTypechecker(t, cases, false)
 }
+
+// Derivations recorded while typechecking
+func TestTypecheckDerivations(t *testing.T) {
+	program := `type nat = +{zero : 1, succ : nat}
+	let f(x : nat) : nat = case x ( zero<x'> => self.zero<x'> | succ<x'> => self.succ<x'> )
+	prc[a] : 1 = close self`
+
+	processes, assumedFreeNames, globalEnv, err := parser.ParseString(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	globalEnv.RecordDerivations = true
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	derivation := globalEnv.Derivations["f"]
+	if derivation == nil {
+		t.Fatal("expected a derivation for f")
+	}
+
+	if derivation.Rule != "⊕L (IChoiceL)" || derivation.Context != "x : nat" || derivation.ProviderType != "nat" || len(derivation.Premises) != 2 {
+		t.Errorf("unexpected derivation for f: %+v", derivation)
+	}
+
+	if premise := derivation.Premises[1]; premise.Rule != "⊕R (IChoiceR)" || premise.Context != "x' : nat" || len(premise.Premises) != 0 {
+		t.Errorf("unexpected premise: %+v", premise)
+	}
+
+	latex := derivation.LaTeX()
+	if !strings.Contains(latex, "\\BinaryInfC{\\texttt{x : nat} $\\vdash$") || strings.Count(latex, "\\AxiomC{}") != 2 {
+		t.Errorf("unexpected LaTeX output: %s", latex)
+	}
+
+	content, err := globalEnv.Derivations["prc[a]"].JSON()
+	if err != nil || !strings.Contains(string(content), `"rule": "1R (EndR)"`) {
+		t.Errorf("unexpected JSON output: %s (%v)", content, err)
+	}
+
+	// Nothing is recorded by default
+	processes, assumedFreeNames, globalEnv, _ = parser.ParseString(program)
+	process.Typecheck(processes, assumedFreeNames, globalEnv)
+	if globalEnv.Derivations != nil {
+		t.Errorf("expected no derivations, but found %v", globalEnv.Derivations)
+	}
+}
+
+func TestTypecheckWarnings(t *testing.T) {
+	cases := []struct {
+		program  string
+		options  []string
+		expected []string
+	}{
+		{`type nat = +{zero : 1, succ : nat}
+		  prc[a] : nat = t : 1 <- new close self; self.zero<t>`, []string{"all"}, nil},
+		// Recursive uses do not count
+		{`type unused = +{done : 1, more : unused}
+		  let f() : 1 = f()
+		  prc[a] : 1 = close self`, []string{"all"}, []string{
+			"(Line 1) warning: type 'unused' is never used [-W unused-type]",
+			"(Line 2) warning: function 'f' is never used [-W unused-function]"}},
+		// Types used in new annotations and function signatures
+		{`type A = 1
+		  type B = 1
+		  let f(x : B) : 1 = wait x; close self
+		  prc[a] : 1 = x : A <- new close self; wait x; close self`, []string{"all"}, []string{"(Line 3) warning: function 'f' is never used [-W unused-function]"}},
+		{`type maybe = +{none : 1, some : +{}}
+		  let f(x : maybe) : 1 = case x (none<y> => fwd self y | some<y> => case y ())`, nil, []string{"(Line 2) warning: the branch 'some' in the case on x is unreachable, since the type '+{}' has no values [-W unreachable-branch]"}},
+		{`assuming x : 1, y : aff 1
+		  prc[a] : 1 = wait x; close self`, nil, []string{
+			"warning: the assumed name y is never used [-W unused-assumption]"}},
+		// Data types without finite values (streams using &{...} are expected to be infinite)
+		{`type s = +{more : s}
+		  type e = +{}
+		  type stream = &{next : stream}`, nil, []string{
+			"(Line 1) warning: type 's' has no finite values, so a process providing it can never terminate [-W unfinished-type]",
+			"(Line 2) warning: type 'e' has no values [-W unfinished-type]"}},
+		// Infinite types which cannot be dropped
+		{`type s = lin &{next : s}
+		  type t = aff &{next : t}
+		  type u = lin +{more : u}`, nil, []string{
+			"(Line 1) warning: type 's' has no finite values and its mode (linear) does not allow dropping it, so its clients can never be done with it [-W unusable-type]",
+			"(Line 3) warning: type 'u' has no finite values and its mode (linear) does not allow dropping it, so its clients can never be done with it [-W unusable-type]"}},
+		// Weakenable names left unused are dropped implicitly
+		{`let f(x : 1 * 1, g : &{a : 1}) : 1 * 1 = fwd self x`, nil, []string{
+			"(Line 1) warning: name 'g' is left unused, so it is dropped before fwd self x [-W implicit-drop]"}},
+		// Options
+		{`let f() : 1 = close self
+		  type A = 1`, []string{"none", "unused-type"}, []string{"(Line 2) warning: type 'A' is never used [-W unused-type]"}},
+		{`let f() : 1 = close self
+		  type A = 1`, []string{"all", "no-unused-function"}, []string{"(Line 2) warning: type 'A' is never used [-W unused-type]"}},
+		// Unused definitions are not reported by default
+		{`let f() : 1 = close self
+		  type A = 1`, nil, nil},
+	}
+
+	// Each kind of warning accepted by -W has to be produced (non-termination is checked in TestTypecheckTermination)
+	produced := map[process.WarningKind]bool{process.NON_TERMINATION: true}
+
+	for i, c := range cases {
+		processes, assumedFreeNames, globalEnv, err := parser.ParseString(c.program)
+		if err != nil {
+			t.Fatalf("compilation error in case #%d: %s", i, err)
+		}
+
+		globalEnv.WarningOptions, err = process.ParseWarningOptions(c.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+			t.Fatalf("expected no type errors in case #%d, but found %s", i, err)
+		}
+
+		var warnings []string
+		for _, warning := range globalEnv.Warnings {
+			warnings = append(warnings, warning.String())
+			produced[warning.Kind] = true
+		}
+
+		if strings.Join(warnings, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("case #%d: expected warnings %q, but found %q", i, c.expected, warnings)
+		}
+	}
+
+	for _, kind := range process.AllWarningKinds {
+		if !produced[kind] {
+			t.Errorf("the warning %s is never produced", kind)
+		}
+	}
+
+	// Warnings treated as errors
+	processes, assumedFreeNames, globalEnv, _ := parser.ParseString("let f() : 1 = close self")
+	globalEnv.WarningOptions, _ = process.ParseWarningOptions([]string{"unused-function", "error"})
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err == nil || !strings.Contains(err.Error(), "function 'f' is never used") {
+		t.Errorf("expected the warning to be reported as an error, but found %v", err)
+	}
+
+	if _, err := process.ParseWarningOptions([]string{"no-such-warning"}); err == nil {
+		t.Errorf("expected an error for an unknown warning option")
+	}
+}
+
+// Errors containing hints, such as suggestions for misspelt names or explanations of type mismatches
+func TestTypecheckErrorHints(t *testing.T) {
+	nat := "type nat = +{zero : 1, succ : nat}\n"
+
+	cases := []struct {
+		program  string
+		expected string
+	}{
+		{nat + "let f(x : nat) : nat = case x (zero<y> => self.zero<y> | succ<y> => self.sucx<y>)",
+			"expected one of the labels zero, succ; did you mean 'succ'?"},
+		{nat + "let f(x : nat) : nat = case x (zeo<y> => self.zero<y> | succ<y> => self.succ<y>)",
+			"expected one of the labels zero, succ; did you mean 'zero'?"},
+		{nat + "let f(x : nat) : nat = f(x)\n prc[a] : nat = t : 1 <- new close self; y : nat <- new self.zero<t>; ff(y)",
+			"function 'ff(y)' is undefined; did you mean 'f'?"},
+		{nat + "let double(x : nat) : nat = double(x)\n prc[a] : nat = doubel()",
+			"did you mean 'double' (which takes 1 parameter)?"},
+		{nat + "prc[a] : nta = close self", "type 'nta' is undefined; did you mean 'nat'?"},
+		{nat + "let z() : nat = t : 1 <- new close self; self.zero<t>\n prc[a] : 1 = x <- new z(); case x (one<y> => wait y; close self)",
+			"expected one of the labels zero, succ"},
+		{"type C = D\n type D = E\n type E = C", "C → D → E → C"},
+		// Type mismatches explain where the types differ
+		{"let f(x : rep \\/ aff 1) : rep \\/ lin 1 = fwd self x",
+			"difference (found vs expected): mode 'aff' vs 'lin'"},
+		{nat + "let f(x : nat) : +{zero : 1, succ : +{zero : 1}} = fwd self x",
+			"difference (found vs expected): branch 'succ' → unfold 'nat' → label 'succ' vs no such label"},
+	}
+
+	for i, c := range cases {
+		processes, assumedFreeNames, globalEnv, err := parser.ParseString(c.program)
+		if err != nil {
+			t.Fatalf("compilation error in case #%d: %s", i, err)
+		}
+
+		err = process.Typecheck(processes, assumedFreeNames, globalEnv)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("case #%d: expected an error containing %q, but found %v", i, c.expected, err)
+		}
+	}
+}
+
+func TestTypecheckTermination(t *testing.T) {
+	program := `type nat = +{zero : 1, succ : nat}
+	type bits = +{b0 : bits, b1 : bits}
+	let double(x : nat) : nat = case x (zero<x'> => self.zero<x'> | succ<x'> => h <- new double(x'); d : nat <- new self.succ<h>; self.succ<d>)
+	let loop(x : nat) : nat = loop(x)
+	let spin(x : nat) : nat = y <- new spin(x); fwd self y
+	let ones(x : 1) : bits = y <- new ones(x); self.b1<y>
+	let onesTwice(x : 1) : bits = y <- new ones(x); self.b0<y>
+	let useLoop(x : nat) : nat = loop(x)
+	let split2(x : nat) : nat = <a, b> <- split x; drop a; double(b)
+	let eat(x : bits) : 1 = case x (b0<y> => eat(y) | b1<y> => eat(y))
+	let s(x : nat) : nat = self.succ<x>
+	let f(x : nat) : 1 = case x (zero<u> => wait u; close self | succ<x'> => g(x'))
+	let g(y : nat) : 1 = z : nat <- new s(y); z2 : nat <- new s(z); f(z2)
+	let even(x : nat) : 1 = case x (zero<u> => wait u; close self | succ<x'> => odd(x'))
+	let odd(x : nat) : 1 = case x (zero<u> => wait u; close self | succ<x'> => even(x'))
+	let p(x : nat) : nat = y <- new q(x); self.succ<y>
+	let q(x : nat) : nat = p(x)`
+
+	expected := []string{
+		"double: terminating (structural recursion on x)",
+		"loop: unknown (the recursive call loop(x) is neither on a smaller argument nor guarded by a send on self)",
+		"spin: unknown (the recursive call spin(x) is neither on a smaller argument nor guarded by a send on self)",
+		"ones: productive (guarded recursion)",
+		"onesTwice: productive (calls ones, which is productive)",
+		"useLoop: unknown (calls loop, whose termination is unknown)",
+		"split2: terminating (no recursive calls)",
+		"eat: unknown (structural recursion on x, but its type 'bits' has no finite values, so it can keep getting smaller forever)",
+		"s: terminating (no recursive calls)",
+		"f: unknown (the mutually recursive call g(x') is not guarded by a send on self)",
+		"g: unknown (the mutually recursive call f(z2) is not guarded by a send on self)",
+		"even: unknown (the mutually recursive call odd(x') is not guarded by a send on self)",
+		"odd: unknown (the mutually recursive call even(x') is not guarded by a send on self)",
+		"p: unknown (calls q, whose termination is unknown)",
+		"q: unknown (the mutually recursive call p(x) is not guarded by a send on self)",
+	}
+
+	processes, assumedFreeNames, globalEnv, err := parser.ParseString(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	globalEnv.CheckTermination = true
+	globalEnv.WarningOptions, _ = process.ParseWarningOptions([]string{"none", "non-termination"})
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	var results []string
+	for _, result := range globalEnv.TerminationResults {
+		results = append(results, result.String())
+	}
+
+	if strings.Join(results, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected:\n%s\nbut found:\n%s", strings.Join(expected, "\n"), strings.Join(results, "\n"))
+	}
+
+	if len(globalEnv.Warnings) != 10 || globalEnv.Warnings[0].Kind != process.NON_TERMINATION {
+		t.Errorf("expected ten termination warnings, but found %v", globalEnv.Warnings)
+	}
+}
+
+func TestDeadlockFreedom(t *testing.T) {
+	correct := []string{
+		`prc[a] : 1 = close self
+		 prc[b] : 1 = wait a; close self
+		 prc[c] : 1 = wait b; close self`,
+		`assuming x : 1
+		 prc[a, b] : 1 = close self
+		 prc[c] : 1 = wait a; wait b; wait x; close self`,
+	}
+
+	runThroughTypechecker(t, correct, true)
+
+	incorrect := []struct {
+		program  string
+		expected string
+	}{
+		{`prc[a] : 1 = wait b; close self
+		  prc[b] : 1 = wait a; close self`,
+			"(Line 1) prc[a] waits on b, (Line 2) prc[b] waits on a"},
+		{`assuming x : 1
+		  prc[a] : 1 = wait x; wait c; close self
+		  prc[b] : 1 = wait a; close self
+		  prc[c, d] : 1 = wait b; close self
+		  prc[e] : 1 = wait d; close self`,
+			"prc[a] waits on c, (Line 4) prc[c, d] waits on b, (Line 3) prc[b] waits on a"},
+	}
+
+	for i, c := range incorrect {
+		processes, assumedFreeNames, globalEnv, err := parser.ParseString(c.program)
+		if err != nil {
+			t.Fatalf("compilation error in case #%d: %s", i, err)
+		}
+
+		err = process.Typecheck(processes, assumedFreeNames, globalEnv)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("case #%d: expected an error containing %q, but found %v", i, c.expected, err)
+		}
+	}
+}
+
+func TestGradualTyping(t *testing.T) {
+	nat := "type nat = +{zero : 1, succ : nat}\n"
+
+	// Names of type ? can be used in any way, as long as their static counterparts agree
+	correct := []string{
+		nat + `let id(x : ?) : ? = fwd self x
+		 prc[a] : nat = t : 1 <- new close self; self.zero<t>
+		 prc[b] : nat = id(a)
+		 prc[c] : 1 = case b (zero<t> => wait t; close self | succ<q> => drop q; close self)`,
+		`prc[a] : ? = <x, y> <- recv self; wait x; close y
+		 prc[b] : 1 * ? = send self<c, d>
+		 prc[c] : 1 = close self
+		 prc[d] : ? = close self`,
+		nat + `prc[a] : ? = t : ? <- new close self; self.zero<t>
+		 prc[b] : ? = case a (zero<t> => wait t; close self | other<q> => fwd self q)`,
+	}
+
+	runThroughTypechecker(t, correct, true)
+
+	incorrect := []string{
+		// The static parts still have to match
+		nat + `prc[a] : ? * 1 = <x, y> <- recv self; close self`,
+		nat + `prc[a] : nat = t : 1 <- new close self; self.zero<t>
+		 prc[b] : ? * nat = send self<a, c>
+		 prc[c] : 1 = close self`,
+		// Missing annotations are only allowed with gradual typing
+		`prc[a] = close self`,
+	}
+
+	runThroughTypechecker(t, incorrect, false)
+
+	// With gradual typing, missing annotations stand for ?
+	gradual := []string{
+		nat + `let double(x) : nat =
+		    case x (
+		        zero<x'> => self.zero<x'>
+		      | succ<x'> => y <- new double(x');
+		                    y' <- new self.succ<y>;
+		                    self.succ<y'>)
+		 prc[a] : nat = t <- new close self; self.zero<t>
+		 prc[b] = q <- new self.succ<a>; self.succ<q>
+		 prc[c] : nat = double(b)`,
+		`prc[a] = close self
+		 prc[b] : 1 = wait a; close self`,
+	}
+
+	for i, c := range gradual {
+		processes, assumedFreeNames, globalEnv, err := parser.ParseString(c)
+		if err != nil {
+			t.Fatalf("compilation error in case #%d: %s", i, err)
+		}
+
+		globalEnv.Gradual = true
+		if err = process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+			t.Errorf("expected no type errors in case #%d, but found %s", i, err)
+		}
+	}
+}
+
+func TestGlobalTypes(t *testing.T) {
+	trade := `type item = 1
+		global trade = buyer -> seller {
+		    request(item) : seller -> buyer {
+		        quote : buyer -> seller {
+		            ok : seller -> shipper {ship : end},
+		            no : seller -> shipper {cancel : end}
+		        }
+		    }
+		}
+		let decide(s : trade_seller_shipper) : &{ok : 1, no : 1} =
+		    case self (
+		          ok<c> => t : 1 <- new s.ship<self>; wait t; close c
+		        | no<c> => t : 1 <- new s.cancel<self>; wait t; close c)
+		prc[shipper] : trade_seller_shipper = case self (ship<c> => close c | cancel<c> => close c)
+		`
+
+	// The processes implement the projections of the global type
+	correct := []string{
+		trade + `prc[seller] : trade_buyer_seller =
+		    case self (request<r> =>
+		        <i, y> <- recv self;
+		        wait i;
+		        p : &{ok : 1, no : 1} <- new decide(shipper);
+		        y.quote<p>)
+		prc[buyer] : 1 =
+		    it : item <- new close self;
+		    r : item -* +{quote : &{ok : 1, no : 1}} <- new seller.request<self>;
+		    q : +{quote : &{ok : 1, no : 1}} <- new send r<it, self>;
+		    case q (quote<o> => d : 1 <- new o.no<self>; wait d; close self)`,
+	}
+
+	runThroughTypechecker(t, correct, true)
+
+	incorrect := []string{
+		// The seller has to send a quote before hearing back from the buyer
+		trade + `prc[seller] : trade_buyer_seller =
+		    case self (request<r> =>
+		        <i, y> <- recv self;
+		        wait i;
+		        decide(shipper))`,
+		// The shipper cannot choose what to ship
+		`global trade = seller -> shipper {ship : end, cancel : end}
+		 prc[shipper] : trade_seller_shipper = t : 1 <- new close self; self.ship<t>`,
+	}
+
+	runThroughTypechecker(t, incorrect, false)
+}
+
+func TestTypecheckSubtyping(t *testing.T) {
+	types := `type nat = +{zero : 1, succ : nat}
+		type bit = +{zero : 1}
+		type counter = &{inc : counter, done : 1}
+		type resettable = &{inc : resettable, reset : resettable, done : 1}
+		`
+
+	correct := []string{
+		// Selecting from fewer labels (forward)
+		types + `let f(x : bit) : nat = fwd self x`,
+		// Offering more branches (forward)
+		types + `let f(x : resettable) : counter = fwd self x`,
+		// Function calls, both for parameters and providers
+		types + `let f(x : nat) : 1 = drop x; close self
+		 let g(x : bit) : 1 = f(x)
+		 let h() : counter = case self (inc<c> => h() | done<c> => close self)
+		 let i() : resettable = case self (inc<c> => i() | reset<c> => i() | done<c> => close self)
+		 let j() : counter = i()`,
+		// New names take the type of the function, and are then forwarded
+		types + `let i() : resettable = case self (inc<c> => i() | reset<c> => i() | done<c> => close self)
+		 prc[a] : counter = x : resettable <- new i(); fwd self x`,
+		types + `let i() : resettable = case self (inc<c> => i() | reset<c> => i() | done<c> => close self)
+		 prc[a] : 1 = x : counter <- new i(); y : 1 <- new x.done<self>; wait y; close self`,
+		// Receiving is contravariant
+		types + `let f(x : nat -* 1) : bit -* 1 = fwd self x`,
+		// Stronger modes can be used in place of weaker ones
+		`let f(x : rep 1) : lin 1 = fwd self x`,
+	}
+
+	runThroughTypechecker(t, correct, true)
+
+	incorrect := []string{
+		types + `let f(x : nat) : bit = fwd self x`,
+		types + `let f(x : counter) : resettable = fwd self x`,
+		types + `let f(x : bit) : 1 = drop x; close self
+		 let g(x : nat) : 1 = f(x)`,
+		types + `let h() : counter = case self (inc<c> => h() | done<c> => close self)
+		 let j() : resettable = h()`,
+		types + `let f(x : bit -* 1) : nat -* 1 = fwd self x`,
+		// The body of new still has to provide the declared type
+		types + `let h() : counter = case self (inc<c> => h() | done<c> => close self)
+		 prc[a] : 1 = x : resettable <- new h(); y : 1 <- new x.done<self>; wait y; close self`,
+	}
+
+	runThroughTypechecker(t, incorrect, false)
+}
+
+func TestTypeInference(t *testing.T) {
+	nat := "type nat = +{zero : 1, succ : nat}\n"
+
+	cases := []struct {
+		program  string
+		expected []string
+	}{
+		{
+			nat + `let double(x) =
+			    case x (
+			        zero<x'> => self.zero<x'>
+			      | succ<x'> => h <- new double(x');
+			                    d <- new self.succ<h>;
+			                    self.succ<d>)
+			 prc[a] = t : 1 <- new close self; self.zero<t>
+			 prc[b] : nat = double(a)`,
+			[]string{
+				"let double(x : nat) : nat // Line 2",
+				"h : nat <- new double(x') // Line 5",
+				"d : nat <- new self.succ<h> // Line 6",
+				"prc[a] : nat // Line 8",
+			},
+		},
+		// Types which are not defined are generated, whereas modes follow from the parameters
+		{
+			`let ones() = case self (next<r> => t : 1 <- new close self; rest <- new ones(); send r<t, rest> | stop<r> => close r)
+			 let f(x : lin 1) = wait x; close self
+			 let g(x : aff 1, y : mul 1) = wait x; wait y; close self
+			 let h(x) = case x (a<r> => f(r) | b<r> => f(r))`,
+			[]string{
+				"type t1 = &{next : 1 * t1, stop : 1}",
+				"let ones() : t1 // Line 1",
+				"rest : t1 <- new ones() // Line 1",
+				"let f(x : lin 1) : lin 1 // Line 2",
+				"let g(x : aff 1, y : mul 1) : lin 1 // Line 3",
+				"let h(x : lin +{a : 1, b : 1}) : lin 1 // Line 4",
+			},
+		},
+	}
+
+	for i, c := range cases {
+		processes, assumedFreeNames, globalEnv, err := parser.ParseString(c.program)
+		if err != nil {
+			t.Fatalf("compilation error in case #%d: %s", i, err)
+		}
+
+		globalEnv.InferTypes = true
+		globalEnv.WarningOptions, _ = process.ParseWarningOptions([]string{"none"})
+		if err = process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+			t.Errorf("expected no type errors in case #%d, but found %s", i, err)
+			continue
+		}
+
+		var annotations []string
+		for _, annotation := range globalEnv.InferredAnnotations {
+			annotations = append(annotations, annotation.String())
+		}
+
+		if strings.Join(annotations, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("case #%d: expected:\n%s\nbut found:\n%s", i, strings.Join(c.expected, "\n"), strings.Join(annotations, "\n"))
+		}
+	}
+
+	incorrect := []struct {
+		program string
+		err     string
+	}{
+		{nat + `let f(x : nat) = case x (zero<u> => wait u; close self | succ<y> => y.zero<self>)`, "in 'y.zero<self>', expected a branch type (&{...}), but found a select type (+{...})"},
+		{`let f(x) = fwd self x`, "unable to infer the type of the result of function f(x); add a type annotation"},
+		{nat + `let f(x) = case x (zero<u> => self.zero<u> | succ<y> => r <- new f(y); self.succ<r>)`, "which is only known to be 't1', where type t1 = +{zero : ?, succ : t1}"},
+		{`let f(x : lin 1) : lin 1 = wait x; close self
+		 let g(y) : rep 1 = f(y)`, "in 'f(y)', expected mode lin, but found mode rep"},
+	}
+
+	for i, c := range incorrect {
+		processes, assumedFreeNames, globalEnv, err := parser.ParseString(c.program)
+		if err != nil {
+			t.Fatalf("compilation error in case #%d: %s", i, err)
+		}
+
+		globalEnv.InferTypes = true
+		err = process.Typecheck(processes, assumedFreeNames, globalEnv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expected error containing %q in case #%d, but found %v", c.err, i, err)
+		}
+	}
+}
+
+func TestTypedHoles(t *testing.T) {
+	program := `type A = +{a : 1, b : 1}
+	let f(x : 1) : A = ?
+	let g(y : A) : 1 = case y (a<z> => ? | b<z> => wait z; close self)
+	prc[a] : A = x : 1 <- new close self; f(self, x)`
+
+	processes, assumedFreeNames, globalEnv, err := parser.ParseString(program)
+	if err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+
+	// Holes are reported rather than failing, and the rest of the program is still checked
+	if err = process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		t.Fatalf("expected no type errors, but found %s", err)
+	}
+
+	expected := []string{
+		`(Line 2) hole: expected self : A (+ve)
+  context: x : 1
+  candidates:
+    ⊕R (IChoiceR)    self.a<...>
+    ⊕R (IChoiceR)    self.b<...>
+    1L (EndL)        wait x; ...
+    DROP             drop x; ...
+    SPLIT            <x1, x2> <- split x; ...
+    CALL             f(self, x)`,
+		`(Line 3) hole: expected self : 1 (+ve)
+  context: z : 1
+  candidates:
+    1R (EndR)        close self
+    1L (EndL)        wait z; ...
+    ID/FWD           fwd self z
+    DROP             drop z; ...
+    SPLIT            <z1, z2> <- split z; ...
+    CALL             g(self, y)`,
+	}
+
+	if len(globalEnv.Holes) != len(expected) {
+		t.Fatalf("expected %d holes, but found %d", len(expected), len(globalEnv.Holes))
+	}
+
+	for i, hole := range globalEnv.Holes {
+		if hole.String() != expected[i] {
+			t.Errorf("hole #%d: expected:\n%s\nbut found:\n%s", i, expected[i], hole.String())
+		}
+	}
+
+	// Names of a labelled type can be forwarded
+	processes, assumedFreeNames, globalEnv, err = parser.ParseString("type A = +{a : 1}\nlet id(x : A) : A = ?")
+	if err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+
+	if err = process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil || len(globalEnv.Holes) != 1 || !strings.Contains(globalEnv.Holes[0].String(), "fwd self x") {
+		t.Errorf("expected a hole which can be filled by forwarding x, but found %v (%v)", globalEnv.Holes, err)
+	}
+
+	// Errors elsewhere in the program are still reported
+	incorrect := strings.Replace(program, "wait z; close self", "wait z; wait undefined; close self", 1)
+	processes, assumedFreeNames, globalEnv, err = parser.ParseString(incorrect)
+	if err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+
+	if err = process.Typecheck(processes, assumedFreeNames, globalEnv); err == nil {
+		t.Errorf("expected a type error after the hole, but found none")
+	}
+}
+
+// Panics while typechecking are reported as errors, rather than stopping the program (e.g. the language server)
+func TestTypecheckInternalErrors(t *testing.T) {
+	// The environment is left incomplete, without any types
+	err := process.Typecheck(nil, nil, &process.GlobalEnvironment{})
+	if err == nil || !strings.Contains(err.Error(), "internal error while typechecking") {
+		t.Errorf("expected an internal error, but found %v", err)
+	}
+}
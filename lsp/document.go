@@ -0,0 +1,648 @@
+package lsp
+
+import (
+	"fmt"
+	"grits/parser"
+	"grits/process"
+	"grits/types"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A document holds the latest version of an open file, along with the outcome of parsing and typechecking it
+type document struct {
+	uri        string
+	text       string
+	lines      []string
+	tokens     []parser.Token
+	statements []statement
+
+	// Only set if the document was parsed successfully
+	processes []*process.Process
+	globalEnv *process.GlobalEnvironment
+
+	diagnostics []Diagnostic
+}
+
+// Top level statement (e.g. type A = ...), located using the tokens
+type statement struct {
+	keyword string // type, let, prc, sprc, assuming, exec or global
+	name    string // e.g. 'nat', 'double' or 'prc[a, b]'
+	// Token indexes
+	first     int
+	nameToken int
+	last      int
+}
+
+var statementKeywords = map[string]bool{
+	"type":     true,
+	"let":      true,
+	"prc":      true,
+	"sprc":     true,
+	"assuming": true,
+	"exec":     true,
+	"global":   true,
+}
+
+// Parses and typechecks the text, producing the diagnostics
+func newDocument(uri, text string) *document {
+	d := &document{
+		uri:    uri,
+		text:   text,
+		lines:  strings.Split(text, "\n"),
+		tokens: parser.Tokenize(strings.NewReader(text)),
+	}
+
+	d.statements = splitStatements(d.tokens)
+
+	if err := d.check(); err != nil {
+		d.diagnostics = append(d.diagnostics, d.errorToDiagnostic(err))
+	} else {
+		for _, warning := range d.globalEnv.Warnings {
+			d.diagnostics = append(d.diagnostics, d.warningToDiagnostic(warning))
+		}
+
+		for _, hole := range d.globalEnv.Holes {
+			d.diagnostics = append(d.diagnostics, d.holeToDiagnostic(hole))
+		}
+	}
+
+	return d
+}
+
+func (d *document) parsed() bool {
+	return d.globalEnv != nil
+}
+
+// Runs the parser and typechecker
+func (d *document) check() error {
+	processes, assumedFreeNames, globalEnv, err := parser.ParseString(d.text)
+	if err != nil {
+		return err
+	}
+
+	d.processes = processes
+	d.globalEnv = globalEnv
+
+	// Unlike the command line, editors also point out unused definitions
+	globalEnv.WarningOptions, _ = process.ParseWarningOptions([]string{"all"})
+
+	return process.Typecheck(processes, assumedFreeNames, globalEnv)
+}
+
+// Typechecking errors are prefixed by the line of the offending statement, e.g. '(Line 4) ...'
+var errorLineRegex = regexp.MustCompile(`^\(Line (\d+)\)`)
+
+func (d *document) errorToDiagnostic(err error) Diagnostic {
+	diagnostic := Diagnostic{Severity: SeverityError, Source: "grits", Message: err.Error()}
+
+	if parseErr, ok := err.(*parser.ParseError); ok {
+		line := len(parseErr.Pos.Lines)
+		char := parseErr.Pos.Char - 1
+		if char < 0 {
+			char = 0
+		}
+		diagnostic.Range = Range{Start: Position{line, char}, End: Position{line, char + 1}}
+		return diagnostic
+	}
+
+	line := 0
+	if match := errorLineRegex.FindStringSubmatch(err.Error()); match != nil {
+		line, _ = strconv.Atoi(match[1])
+		line--
+	}
+
+	diagnostic.Range = d.lineRange(line)
+	return diagnostic
+}
+
+// Warnings without a position (e.g. unused assumed names) are shown on the first line
+func (d *document) warningToDiagnostic(warning process.Warning) Diagnostic {
+	line := max(warning.Position.StartLine-1, 0)
+	message := fmt.Sprintf("%s [-W %s]", warning.Message, warning.Kind)
+
+	return Diagnostic{Range: d.lineRange(line), Severity: SeverityWarning, Source: "grits", Message: message}
+}
+
+// Holes are reported with what is expected in their place, on the line of the hole
+func (d *document) holeToDiagnostic(hole process.Hole) Diagnostic {
+	line := max(hole.Position.StartLine-1, 0)
+
+	return Diagnostic{Range: d.lineRange(line), Severity: SeverityInformation, Source: "grits", Message: hole.Description()}
+}
+
+// Range covering a whole line (zero-based)
+func (d *document) lineRange(line int) Range {
+	length := 0
+	if line >= 0 && line < len(d.lines) {
+		length = len([]rune(d.lines[line]))
+	}
+
+	return Range{Start: Position{line, 0}, End: Position{line, length}}
+}
+
+func splitStatements(tokens []parser.Token) []statement {
+	var statements []statement
+
+	depths := parser.LetBlockDepths(tokens)
+	for i, t := range tokens {
+		if t.Kind != parser.KEYWORD_TOKEN || !statementKeywords[t.Value] || depths[i] > 0 {
+			continue
+		}
+
+		if len(statements) > 0 {
+			statements[len(statements)-1].last = i - 1
+		}
+
+		s := statement{keyword: t.Value, first: i, nameToken: -1, last: len(tokens) - 1}
+
+		if t.Value == "prc" || t.Value == "sprc" {
+			// The name is made up of the providers, e.g. prc[a, b]
+			var providers []string
+			j := i + 1
+			if j < len(tokens) && tokens[j].Value == "[" {
+				for j++; j < len(tokens) && tokens[j].Value != "]" && !statementKeywords[tokens[j].Value]; j++ {
+					if tokens[j].Kind == parser.LABEL_TOKEN {
+						if s.nameToken == -1 {
+							s.nameToken = j
+						}
+						providers = append(providers, tokens[j].Value)
+					}
+				}
+			}
+			s.name = fmt.Sprintf("%s[%s]", t.Value, strings.Join(providers, ", "))
+		} else if t.Value == "assuming" {
+			s.name = t.Value
+		} else if i+1 < len(tokens) && tokens[i+1].Kind == parser.LABEL_TOKEN {
+			s.nameToken = i + 1
+			s.name = tokens[i+1].Value
+		}
+
+		statements = append(statements, s)
+	}
+
+	return statements
+}
+
+// Returns the statement enclosing the given position (or nil if the position is outside every statement)
+func (d *document) statementAt(pos Position) *statement {
+	for i := range d.statements {
+		s := &d.statements[i]
+		if !positionBefore(pos, tokenStart(d.tokens[s.first])) && !positionBefore(tokenEnd(d.tokens[s.last]), pos) {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// Index of the token found at the given position, or -1 if there is none
+func (d *document) tokenAt(pos Position) int {
+	for i, t := range d.tokens {
+		if !positionBefore(pos, tokenStart(t)) && positionBefore(pos, tokenEnd(t)) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Index of the last token ending at or before the given position, or -1 if there is none
+func (d *document) tokenBefore(pos Position) int {
+	index := -1
+	for i, t := range d.tokens {
+		if positionBefore(pos, tokenEnd(t)) {
+			break
+		}
+		index = i
+	}
+
+	return index
+}
+
+/////////////////////////////////////////////////////
+////////////////// Document symbols /////////////////
+/////////////////////////////////////////////////////
+
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	for _, s := range d.statements {
+		var kind SymbolKind
+		switch s.keyword {
+		case "type", "global":
+			kind = SymbolInterface
+		case "let":
+			kind = SymbolFunction
+		case "prc", "sprc":
+			kind = SymbolObject
+		default:
+			continue
+		}
+
+		symbolRange := Range{Start: tokenStart(d.tokens[s.first]), End: tokenEnd(d.tokens[s.last])}
+		selectionRange := Range{Start: tokenStart(d.tokens[s.first]), End: tokenEnd(d.tokens[s.first])}
+		if s.nameToken != -1 {
+			selectionRange = Range{Start: tokenStart(d.tokens[s.nameToken]), End: tokenEnd(d.tokens[s.nameToken])}
+		}
+
+		symbols = append(symbols, DocumentSymbol{
+			Name:           s.name,
+			Detail:         d.statementDetail(s),
+			Kind:           kind,
+			Range:          symbolRange,
+			SelectionRange: selectionRange,
+		})
+	}
+
+	return symbols
+}
+
+// Describes the type of a statement (only available if the program parses)
+func (d *document) statementDetail(s statement) string {
+	if !d.parsed() {
+		return ""
+	}
+
+	line := d.tokens[s.first].Line
+
+	switch s.keyword {
+	case "type":
+		for _, t := range *d.globalEnv.Types {
+			if t.Position.StartLine == line && t.Name == s.name {
+				return t.SessionType.String()
+			}
+		}
+	case "global":
+		// Lists the binary types projected from the global type
+		var projections []string
+		for _, t := range *d.globalEnv.Types {
+			if t.ProjectedFrom == s.name {
+				projections = append(projections, t.Name)
+			}
+		}
+		return strings.Join(projections, ", ")
+	case "let":
+		for _, f := range *d.globalEnv.FunctionDefinitions {
+			if f.Position.StartLine == line && f.FunctionName == s.name {
+				return functionSignature(f)
+			}
+		}
+	case "prc", "sprc":
+		for _, p := range d.processes {
+			if p.Position.StartLine == line && p.Type != nil {
+				return p.Type.String()
+			}
+		}
+	}
+
+	return ""
+}
+
+// E.g. double(x : nat) : nat
+func functionSignature(f process.FunctionDefinition) string {
+	var params []string
+	for _, p := range f.Parameters {
+		if p.Type != nil {
+			params = append(params, fmt.Sprintf("%s : %s", p.Ident, p.Type.String()))
+		} else {
+			params = append(params, p.Ident)
+		}
+	}
+
+	signature := fmt.Sprintf("%s(%s)", f.FunctionName, strings.Join(params, ", "))
+	if f.Type != nil {
+		signature += " : " + f.Type.String()
+	}
+
+	return signature
+}
+
+/////////////////////////////////////////////////////
+/////////////////////// Hover ///////////////////////
+/////////////////////////////////////////////////////
+
+// Shows the session type of a name (as assigned by the typechecker), or the signature of a function or type
+func (d *document) hover(pos Position) *Hover {
+	index := d.tokenAt(pos)
+	if index == -1 || !d.parsed() {
+		return nil
+	}
+
+	token := d.tokens[index]
+	tokenRange := Range{Start: tokenStart(token), End: tokenEnd(token)}
+
+	var content string
+
+	if name := d.nameAt(token); name != nil {
+		if name.Type == nil {
+			return nil
+		}
+		// The name is shown as written, since the uses of names which are split implicitly are renamed
+		content = fmt.Sprintf("%s : %s", token.Value, name.Type.StringWithOuterModality())
+	} else if f := d.functionDefinition(token.Value); f != nil && token.Kind == parser.LABEL_TOKEN {
+		content = "let " + functionSignature(*f)
+	} else if t := d.typeDefinition(token.Value); t != nil && token.Kind == parser.LABEL_TOKEN {
+		content = fmt.Sprintf("type %s = %s", t.Name, t.SessionType.String())
+	} else {
+		return nil
+	}
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```grits\n" + content + "\n```"},
+		Range:    &tokenRange,
+	}
+}
+
+// Finds the name (from the AST) written at the same position as the token
+func (d *document) nameAt(token parser.Token) *process.Name {
+	for _, name := range d.allNames() {
+		if name.Position.StartLine == token.Line && name.Position.StartPos == token.Char {
+			return name
+		}
+	}
+
+	return nil
+}
+
+// All names found in the program, including the function parameters and process providers
+func (d *document) allNames() []*process.Name {
+	var names []*process.Name
+
+	for i := range *d.globalEnv.FunctionDefinitions {
+		f := &(*d.globalEnv.FunctionDefinitions)[i]
+		if f.UsesExplicitProvider {
+			provider := f.ExplicitProvider
+			provider.Type = f.Type
+			names = append(names, &provider)
+		}
+		for j := range f.Parameters {
+			names = append(names, &f.Parameters[j])
+		}
+		names = append(names, process.AllNames(f.Body)...)
+	}
+
+	for _, p := range d.processes {
+		for j := range p.Providers {
+			provider := p.Providers[j]
+			provider.Type = p.Type
+			names = append(names, &provider)
+		}
+		names = append(names, process.AllNames(p.Body)...)
+	}
+
+	return names
+}
+
+func (d *document) functionDefinition(functionName string) *process.FunctionDefinition {
+	if !d.parsed() {
+		return nil
+	}
+
+	for i, f := range *d.globalEnv.FunctionDefinitions {
+		if f.FunctionName == functionName {
+			return &(*d.globalEnv.FunctionDefinitions)[i]
+		}
+	}
+
+	return nil
+}
+
+func (d *document) typeDefinition(typeName string) *types.SessionTypeDefinition {
+	if !d.parsed() {
+		return nil
+	}
+
+	for i, t := range *d.globalEnv.Types {
+		if t.Name == typeName {
+			return &(*d.globalEnv.Types)[i]
+		}
+	}
+
+	return nil
+}
+
+/////////////////////////////////////////////////////
+///////////////// Go to definition //////////////////
+/////////////////////////////////////////////////////
+
+// Locates the definition of a function (let f(...) = ...) or a labelled type (type A = ...).
+// Only the tokens are used, so this works even when the program does not parse.
+func (d *document) definition(pos Position) *Location {
+	index := d.tokenAt(pos)
+	if index == -1 || d.tokens[index].Kind != parser.LABEL_TOKEN {
+		return nil
+	}
+
+	label := d.tokens[index].Value
+
+	// Function names are followed by their parameters, e.g. f(x, y)
+	isCall := index+1 < len(d.tokens) && d.tokens[index+1].Value == "("
+	lookupOrder := []string{"type", "let"}
+	if isCall {
+		lookupOrder = []string{"let", "type"}
+	}
+
+	for _, keyword := range lookupOrder {
+		for _, s := range d.statements {
+			if s.keyword == keyword && s.name == label && s.nameToken != -1 {
+				nameToken := d.tokens[s.nameToken]
+				return &Location{URI: d.uri, Range: Range{Start: tokenStart(nameToken), End: tokenEnd(nameToken)}}
+			}
+		}
+	}
+
+	return nil
+}
+
+/////////////////////////////////////////////////////
+///////////////////// Completion ////////////////////
+/////////////////////////////////////////////////////
+
+// Suggests the labels that can be used after 'x.' or within the branches of 'case x ( ... )'.
+// The labels are taken from the choice type (+{...} or &{...}) of x, as found by the typechecker.
+// Since incomplete programs do not parse, the types are taken from the last version of the document that parsed successfully (i.e. snapshot).
+func (d *document) completion(pos Position, snapshot *document) []CompletionItem {
+	items := []CompletionItem{}
+
+	if snapshot == nil || !snapshot.parsed() {
+		return items
+	}
+
+	before := d.tokenBefore(pos)
+
+	// Ignore the label being currently typed
+	if before >= 0 && d.tokens[before].Kind == parser.LABEL_TOKEN && !positionBefore(tokenEnd(d.tokens[before]), pos) {
+		before--
+	}
+
+	if before < 0 {
+		return items
+	}
+
+	nameIndex := -1
+	if d.tokens[before].Value == "." && before > 0 {
+		// x.label<...>
+		nameIndex = before - 1
+	} else if d.tokens[before].Value == "(" || d.tokens[before].Value == "|" {
+		// case x ( label<...> => ... | label<...> => ...)
+		nameIndex = d.enclosingCaseName(before)
+	}
+
+	if nameIndex == -1 {
+		return items
+	}
+
+	nameToken := d.tokens[nameIndex]
+	s := d.statementAt(tokenStart(nameToken))
+	if s == nil {
+		return items
+	}
+
+	sessionType := snapshot.nameType(*s, nameToken)
+
+	var branches []types.Option
+	switch t := sessionType.(type) {
+	case *types.SelectLabelType:
+		branches = t.Branches
+	case *types.BranchCaseType:
+		branches = t.Branches
+	}
+
+	for _, b := range branches {
+		items = append(items, CompletionItem{Label: b.Label, Kind: CompletionEnumMember, Detail: b.SessionType.String()})
+	}
+
+	return items
+}
+
+// Given the '(' or '|' token of a case construct, returns the index of the name being cased on (or -1)
+func (d *document) enclosingCaseName(index int) int {
+	depth := 0
+	for i := index; i >= 0; i-- {
+		switch d.tokens[i].Value {
+		case ")":
+			depth++
+		case "(":
+			if depth == 0 {
+				if i >= 2 && d.tokens[i-2].Value == "case" {
+					return i - 1
+				}
+				return -1
+			}
+			depth--
+		}
+
+		if d.tokens[i].Kind == parser.KEYWORD_TOKEN && statementKeywords[d.tokens[i].Value] {
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// Looks up the (unfolded) type of a name within a statement. The statement is matched by its name, since the snapshot
+// may be an older version of the document. The occurrence closest to (and preceding) the token is preferred.
+func (d *document) nameType(s statement, token parser.Token) types.SessionType {
+	var current *statement
+	for i := range d.statements {
+		if d.statements[i].keyword == s.keyword && d.statements[i].name == s.name {
+			current = &d.statements[i]
+			break
+		}
+	}
+
+	if current == nil {
+		return nil
+	}
+
+	isSelf := token.Value == "self"
+	first := tokenStart(d.tokens[current.first])
+	last := tokenEnd(d.tokens[current.last])
+
+	var found types.SessionType
+	for _, name := range d.allNames() {
+		namePosition := Position{name.Position.StartLine - 1, name.Position.StartPos - 1}
+		if positionBefore(namePosition, first) || positionBefore(last, namePosition) {
+			continue
+		}
+
+		if name.Type == nil || (isSelf && !name.IsSelf) || (!isSelf && name.Ident != token.Value) {
+			continue
+		}
+
+		unfolded := d.unfold(name.Type)
+		if !isChoiceType(unfolded) {
+			continue
+		}
+
+		if found == nil || !positionBefore(tokenStart(token), namePosition) {
+			found = unfolded
+		}
+	}
+
+	if found == nil && isSelf {
+		// Fallback to the type of the provider
+		line := d.tokens[current.first].Line
+		for _, f := range *d.globalEnv.FunctionDefinitions {
+			if f.Position.StartLine == line && f.Type != nil {
+				found = d.unfold(f.Type)
+			}
+		}
+		for _, p := range d.processes {
+			if p.Position.StartLine == line && p.Type != nil {
+				found = d.unfold(p.Type)
+			}
+		}
+	}
+
+	return found
+}
+
+// Similar to types.Unfold, but safe to use on non-contractive types
+func (d *document) unfold(sessionType types.SessionType) types.SessionType {
+	labelledTypesEnv := types.ProduceLabelledSessionTypeEnvironment(*d.globalEnv.Types)
+
+	for i := 0; i <= len(labelledTypesEnv); i++ {
+		label, isLabel := sessionType.(*types.LabelType)
+		if !isLabel {
+			return sessionType
+		}
+
+		labelledType, exists := labelledTypesEnv[label.Label]
+		if !exists {
+			return nil
+		}
+		sessionType = labelledType.Type
+	}
+
+	return nil
+}
+
+func isChoiceType(sessionType types.SessionType) bool {
+	switch sessionType.(type) {
+	case *types.SelectLabelType, *types.BranchCaseType:
+		return true
+	}
+
+	return false
+}
+
+/////////////////////////////////////////////////////
+////////////////////// Positions ////////////////////
+/////////////////////////////////////////////////////
+
+// Tokens positions are 1-based, whereas LSP positions are 0-based
+func tokenStart(t parser.Token) Position {
+	return Position{Line: t.Line - 1, Character: t.Char - 1}
+}
+
+func tokenEnd(t parser.Token) Position {
+	return Position{Line: t.Line - 1, Character: t.Char - 1 + len([]rune(t.Value))}
+}
+
+// Strict ordering between positions
+func positionBefore(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
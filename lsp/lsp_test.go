@@ -0,0 +1,309 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const natProgram = `type nat = +{zero : 1, succ : nat}
+
+let double(x : nat) : nat =
+    case x (
+          zero<x'> => self.zero<x'>
+        | succ<x'> => h <- new double(x');
+                      d : nat <- new self.succ<h>;
+                      self.succ<d>
+    )
+
+prc[d0] : nat =
+    t : 1 <- new close self;
+    self.zero<t>
+
+prc[d1] : nat = double(d0)
+`
+
+func TestDocumentDiagnostics(t *testing.T) {
+	d := newDocument("file:///nat.grits", natProgram)
+	if len(d.diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, but found %v", d.diagnostics)
+	}
+
+	// Type error in the function on line 3
+	incorrect := strings.Replace(natProgram, "self.zero<x'>", "self.one<x'>", 1)
+	d = newDocument("file:///nat.grits", incorrect)
+	if len(d.diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, but found %v", d.diagnostics)
+	}
+	if d.diagnostics[0].Range.Start.Line != 2 {
+		t.Errorf("expected diagnostic on line 2, but found %v", d.diagnostics[0].Range)
+	}
+
+	// Parse error
+	d = newDocument("file:///nat.grits", "type nat = +{zero : 1, succ : nat\n\nlet f() : nat = ")
+	if len(d.diagnostics) != 1 || d.parsed() {
+		t.Fatalf("expected one parse error, but found %v", d.diagnostics)
+	}
+
+	// Warnings, e.g. an unused function on line 3
+	unused := strings.Replace(natProgram, "double(d0)", "fwd self d0", 1)
+	d = newDocument("file:///nat.grits", unused)
+	if len(d.diagnostics) != 1 || d.diagnostics[0].Severity != SeverityWarning || d.diagnostics[0].Range.Start.Line != 2 {
+		t.Fatalf("expected one warning on line 2, but found %v", d.diagnostics)
+	}
+
+	// Holes are reported as information, listing what is expected in their place
+	hole := strings.Replace(natProgram, "double(d0)", "?", 1)
+	d = newDocument("file:///nat.grits", hole)
+	if len(d.diagnostics) != 2 || d.diagnostics[1].Severity != SeverityInformation || !strings.HasPrefix(d.diagnostics[1].Message, "hole: expected self : nat") {
+		t.Fatalf("expected a warning and a hole, but found %v", d.diagnostics)
+	}
+
+	// Unterminated comments are tolerated
+	d = newDocument("file:///nat.grits", natProgram+"/* unterminated")
+	if len(d.diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, but found %v", d.diagnostics)
+	}
+}
+
+func TestDocumentHover(t *testing.T) {
+	d := newDocument("file:///nat.grits", natProgram)
+
+	cases := []struct {
+		pos      Position
+		expected string
+	}{
+		// Parameter x
+		{Position{2, 11}, "x : nat"},
+		// The name h (after the call to double)
+		{Position{5, 22}, "h : +{zero : 1, succ : nat}"},
+		// The name d, with an explicit type
+		{Position{6, 22}, "d : +{zero : 1, succ : nat}"},
+		// Function name
+		{Position{5, 35}, "let double(x : nat) : nat"},
+		// Type name
+		{Position{0, 6}, "type nat = +{zero : 1, succ : nat}"},
+		// Process provider
+		{Position{10, 5}, "d0 : nat"},
+	}
+
+	for _, c := range cases {
+		hover := d.hover(c.pos)
+		if hover == nil {
+			t.Errorf("expected hover at %v, but found none", c.pos)
+			continue
+		}
+
+		if !strings.Contains(hover.Contents.Value, c.expected) {
+			t.Errorf("expected hover at %v to contain '%s', but found '%s'", c.pos, c.expected, hover.Contents.Value)
+		}
+	}
+
+	// Keywords have no hover information
+	if hover := d.hover(Position{3, 5}); hover != nil {
+		t.Errorf("expected no hover on keyword, but found %v", hover.Contents.Value)
+	}
+
+	// Names which are split implicitly are shown as written
+	d = newDocument("file:///split.grits", "let f(x : 1) : 1 * 1 = send self<x, x>")
+	if hover := d.hover(Position{0, 33}); hover == nil || !strings.Contains(hover.Contents.Value, "x : 1") {
+		t.Errorf("expected hover on x, but found %v", hover)
+	}
+}
+
+func TestDocumentDefinition(t *testing.T) {
+	d := newDocument("file:///nat.grits", natProgram)
+
+	// Call to double
+	location := d.definition(Position{5, 35})
+	if location == nil || location.Range.Start != (Position{2, 4}) {
+		t.Errorf("expected definition of 'double' at 2:4, but found %v", location)
+	}
+
+	// Type nat used in the new construct
+	location = d.definition(Position{6, 27})
+	if location == nil || location.Range.Start != (Position{0, 5}) {
+		t.Errorf("expected definition of 'nat' at 0:5, but found %v", location)
+	}
+
+	// Names have no definition
+	if location = d.definition(Position{3, 9}); location != nil {
+		t.Errorf("expected no definition, but found %v", location)
+	}
+}
+
+func TestDocumentCompletion(t *testing.T) {
+	snapshot := newDocument("file:///nat.grits", natProgram)
+
+	// Incomplete program, being edited
+	edited := strings.Replace(natProgram, "self.zero<t>", "self.", 1)
+	d := newDocument("file:///nat.grits", edited)
+
+	items := d.completion(Position{12, 9}, snapshot)
+	if labels := completionLabels(items); labels != "zero, succ" {
+		t.Errorf("expected labels 'zero, succ', but found '%s'", labels)
+	}
+
+	// Within a case construct
+	edited = strings.Replace(natProgram, "| succ<x'>", "| ", 1)
+	d = newDocument("file:///nat.grits", edited)
+
+	items = d.completion(Position{5, 10}, snapshot)
+	if labels := completionLabels(items); labels != "zero, succ" {
+		t.Errorf("expected labels 'zero, succ', but found '%s'", labels)
+	}
+
+	// No labels outside of select/case
+	items = d.completion(Position{11, 4}, snapshot)
+	if len(items) != 0 {
+		t.Errorf("expected no completions, but found '%s'", completionLabels(items))
+	}
+}
+
+func completionLabels(items []CompletionItem) string {
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	return strings.Join(labels, ", ")
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	d := newDocument("file:///nat.grits", natProgram)
+
+	symbols := d.symbols()
+	expected := []struct {
+		name string
+		kind SymbolKind
+	}{
+		{"nat", SymbolInterface},
+		{"double", SymbolFunction},
+		{"prc[d0]", SymbolObject},
+		{"prc[d1]", SymbolObject},
+	}
+
+	if len(symbols) != len(expected) {
+		t.Fatalf("expected %d symbols, but found %v", len(expected), symbols)
+	}
+
+	for i := range expected {
+		if symbols[i].Name != expected[i].name || symbols[i].Kind != expected[i].kind {
+			t.Errorf("expected symbol %s (kind %d), but found %s (kind %d)", expected[i].name, expected[i].kind, symbols[i].Name, symbols[i].Kind)
+		}
+	}
+
+	if symbols[1].Detail != "double(x : nat) : nat" {
+		t.Errorf("unexpected detail for double: %s", symbols[1].Detail)
+	}
+
+	if symbols[1].Range.End.Line != 8 {
+		t.Errorf("expected double to end on line 8, but found %v", symbols[1].Range)
+	}
+
+	// Global types are listed along with their projections
+	symbols = newDocument("file:///ping.grits", "global ping = a -> b {ping : b -> a {pong : end}}\n").symbols()
+	if len(symbols) != 1 || symbols[0].Name != "ping" || symbols[0].Kind != SymbolInterface || symbols[0].Detail != "ping_a_b" {
+		t.Errorf("unexpected symbols for a global type: %v", symbols)
+	}
+
+	// Local definitions are part of the statement containing them
+	symbols = newDocument("file:///let.grits", "let f() : 1 =\n    let\n        let g() : 1 = close self\n    in\n        g()\n    end\n\nlet h() : 1 = f()\n").symbols()
+	if len(symbols) != 2 || symbols[0].Name != "f" || symbols[1].Name != "h" || symbols[0].Range.End.Line != 5 {
+		t.Errorf("unexpected symbols for local definitions: %v", symbols)
+	}
+}
+
+// Runs a whole session through the server
+func TestServerSession(t *testing.T) {
+	var input bytes.Buffer
+	writeRequest(&input, 1, "initialize", map[string]interface{}{})
+	writeRequest(&input, nil, "initialized", map[string]interface{}{})
+	writeRequest(&input, nil, "textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: "file:///a.grits", Text: natProgram}})
+	writeRequest(&input, 2, "textDocument/hover", TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: "file:///a.grits"}, Position: Position{2, 11}})
+	writeRequest(&input, nil, "textDocument/didChange", DidChangeTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: "file:///a.grits"}, ContentChanges: []TextDocumentContentChangeEvent{{Text: "prc[a] : 1 = close b"}}})
+	writeRequest(&input, 3, "unknown/method", map[string]interface{}{})
+	writeRequest(&input, 4, "shutdown", nil)
+	writeRequest(&input, nil, "exit", nil)
+
+	var output bytes.Buffer
+	if err := NewServer(&input, &output).Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := readMessages(t, &output)
+	if len(messages) != 6 {
+		t.Fatalf("expected 6 messages, but found %d", len(messages))
+	}
+
+	// 1: initialize
+	if !strings.Contains(string(messages[0]), `"hoverProvider":true`) {
+		t.Errorf("unexpected initialize reply: %s", messages[0])
+	}
+
+	// 2: no diagnostics after opening
+	if !strings.Contains(string(messages[1]), `"diagnostics":[]`) {
+		t.Errorf("expected empty diagnostics, but found: %s", messages[1])
+	}
+
+	// 3: hover
+	if !strings.Contains(string(messages[2]), "x : nat") {
+		t.Errorf("unexpected hover reply: %s", messages[2])
+	}
+
+	// 4: diagnostics after the change
+	if !strings.Contains(string(messages[3]), `"severity":1`) {
+		t.Errorf("expected an error diagnostic, but found: %s", messages[3])
+	}
+
+	// 5: unknown method
+	if !strings.Contains(string(messages[4]), fmt.Sprint(methodNotFoundCode)) {
+		t.Errorf("expected method not found error, but found: %s", messages[4])
+	}
+
+	// 6: shutdown
+	if !strings.Contains(string(messages[5]), `"result":null`) {
+		t.Errorf("unexpected shutdown reply: %s", messages[5])
+	}
+}
+
+func writeRequest(w io.Writer, id interface{}, method string, params interface{}) {
+	request := map[string]interface{}{"jsonrpc": "2.0", "method": method}
+	if id != nil {
+		request["id"] = id
+	}
+	if params != nil {
+		request["params"] = params
+	}
+
+	content, _ := json.Marshal(request)
+	fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func readMessages(t *testing.T, r io.Reader) [][]byte {
+	var messages [][]byte
+
+	reader := bufio.NewReader(r)
+	for {
+		header, err := textproto.NewReader(reader).ReadMIMEHeader()
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		content := make([]byte, length)
+		if _, err := io.ReadFull(reader, content); err != nil {
+			t.Fatal(err)
+		}
+
+		messages = append(messages, content)
+	}
+}
@@ -0,0 +1,236 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"strconv"
+)
+
+// Server implements a language server for Grits programs, communicating using JSON-RPC (e.g. over stdio).
+// Requests are handled sequentially, in the order in which they are received.
+type Server struct {
+	reader *bufio.Reader
+	writer io.Writer
+
+	// Open documents, indexed by their URI
+	documents map[string]*document
+	// Latest version of each document that parsed successfully (used for completion while the user is typing)
+	snapshots map[string]*document
+}
+
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{
+		reader:    bufio.NewReader(r),
+		writer:    w,
+		documents: make(map[string]*document),
+		snapshots: make(map[string]*document),
+	}
+}
+
+// Entry point used by 'grits lsp'
+func Serve(r io.Reader, w io.Writer) error {
+	return NewServer(r, w).Run()
+}
+
+// Run processes messages until the client sends 'exit' or closes the input
+func (s *Server) Run() error {
+	for {
+		msg, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if msg == nil {
+			// Malformed message, already reported to the client
+			continue
+		}
+
+		if msg.Method == "exit" {
+			return nil
+		}
+
+		s.handle(msg)
+	}
+}
+
+// Each message has a header (containing the Content-Length) followed by the JSON content
+func (s *Server) readMessage() (*message, error) {
+	header, err := textproto.NewReader(s.reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header: %v", err)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(s.reader, content); err != nil {
+		return nil, err
+	}
+
+	msg := &message{}
+	if err := json.Unmarshal(content, msg); err != nil {
+		s.write(&message{JSONRPC: "2.0", Error: &responseError{Code: parseErrorCode, Message: err.Error()}})
+		return nil, nil
+	}
+
+	return msg, nil
+}
+
+func (s *Server) write(msg *message) {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("lsp: unable to encode message: %v", err)
+		return
+	}
+
+	fmt.Fprintf(s.writer, "Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}) {
+	if result == nil {
+		// A null result still needs to be sent explicitly
+		result = json.RawMessage("null")
+	}
+
+	s.write(&message{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, errorMessage string) {
+	s.write(&message{JSONRPC: "2.0", ID: id, Error: &responseError{Code: code, Message: errorMessage}})
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	content, err := json.Marshal(params)
+	if err != nil {
+		log.Printf("lsp: unable to encode notification: %v", err)
+		return
+	}
+
+	s.write(&message{JSONRPC: "2.0", Method: method, Params: content})
+}
+
+func (s *Server) handle(msg *message) {
+	isRequest := msg.ID != nil
+
+	switch msg.Method {
+	case "initialize":
+		s.reply(msg.ID, InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync:       fullDocumentSync,
+				HoverProvider:          true,
+				DefinitionProvider:     true,
+				DocumentSymbolProvider: true,
+				CompletionProvider:     CompletionOptions{TriggerCharacters: []string{".", "(", "|"}},
+			},
+			ServerInfo: ServerInfo{Name: "grits"},
+		})
+	case "initialized":
+		// Nothing to do
+	case "shutdown":
+		// Documents are not persisted, so there is nothing to clean up before 'exit'
+		s.reply(msg.ID, nil)
+	case "textDocument/didOpen":
+		params := DidOpenTextDocumentParams{}
+		if s.decode(msg, &params) {
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		params := DidChangeTextDocumentParams{}
+		if s.decode(msg, &params) && len(params.ContentChanges) > 0 {
+			// Full synchronisation: the last change contains the whole document
+			s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+	case "textDocument/didClose":
+		params := DidCloseTextDocumentParams{}
+		if s.decode(msg, &params) {
+			delete(s.documents, params.TextDocument.URI)
+			delete(s.snapshots, params.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		}
+	case "textDocument/hover":
+		params := TextDocumentPositionParams{}
+		if s.decode(msg, &params) {
+			if d, ok := s.documents[params.TextDocument.URI]; ok {
+				if hover := d.hover(params.Position); hover != nil {
+					s.reply(msg.ID, hover)
+					return
+				}
+			}
+			s.reply(msg.ID, nil)
+		}
+	case "textDocument/definition":
+		params := TextDocumentPositionParams{}
+		if s.decode(msg, &params) {
+			if d, ok := s.documents[params.TextDocument.URI]; ok {
+				if location := d.definition(params.Position); location != nil {
+					s.reply(msg.ID, location)
+					return
+				}
+			}
+			s.reply(msg.ID, nil)
+		}
+	case "textDocument/completion":
+		params := TextDocumentPositionParams{}
+		if s.decode(msg, &params) {
+			items := []CompletionItem{}
+			if d, ok := s.documents[params.TextDocument.URI]; ok {
+				items = d.completion(params.Position, s.snapshots[params.TextDocument.URI])
+			}
+			s.reply(msg.ID, items)
+		}
+	case "textDocument/documentSymbol":
+		params := DocumentSymbolParams{}
+		if s.decode(msg, &params) {
+			symbols := []DocumentSymbol{}
+			if d, ok := s.documents[params.TextDocument.URI]; ok {
+				symbols = d.symbols()
+			}
+			s.reply(msg.ID, symbols)
+		}
+	default:
+		// Unknown notifications are ignored
+		if isRequest {
+			s.replyError(msg.ID, methodNotFoundCode, fmt.Sprintf("method '%s' is not supported", msg.Method))
+		}
+	}
+}
+
+// Decodes the parameters of a message. On failure, an error is sent back (for requests).
+func (s *Server) decode(msg *message, params interface{}) bool {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		if msg.ID != nil {
+			s.replyError(msg.ID, invalidParamsCode, err.Error())
+		}
+		return false
+	}
+
+	return true
+}
+
+// Reanalyses a document and publishes its diagnostics
+func (s *Server) update(uri, text string) {
+	d := newDocument(uri, text)
+
+	s.documents[uri] = d
+	if d.parsed() {
+		s.snapshots[uri] = d
+	}
+
+	diagnostics := d.diagnostics
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
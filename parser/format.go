@@ -0,0 +1,512 @@
+package parser
+
+import (
+	"fmt"
+	"grits/process"
+	"grits/types"
+	"strings"
+)
+
+// Format reprints a program using a consistent layout (used by 'grits fmt').
+// Comments are kept next to the code they precede (or follow, if on the same line), and blank lines separating
+// code are preserved (although consecutive ones are collapsed). The formatted program is parsed again to ensure
+// that it is equivalent to the original one, otherwise an error is returned instead.
+func Format(program string) (string, error) {
+	environment, err := Parse(strings.NewReader(program))
+	if err != nil {
+		return "", err
+	}
+
+	tokens, comments := TokenizeWithComments(strings.NewReader(program))
+	lines := formatStatements(environment, tokens)
+	formatted := placeComments(lines, comments, tokens, strings.Split(program, "\n"))
+
+	reparsed, err := Parse(strings.NewReader(formatted))
+	if err != nil {
+		return "", fmt.Errorf("formatted program does not parse: %v", err)
+	}
+
+	if err := compareStatements(environment, reparsed); err != nil {
+		return "", fmt.Errorf("formatted program differs from the original one: %v", err)
+	}
+
+	return formatted, nil
+}
+
+// FormatElaborated typechecks a program and prints it (using the layout of Format) as elaborated by the typechecker,
+// i.e. with the implicit drops, splits and shifts made explicit. The global environment can be configured (e.g. to use
+// gradual typing or to insert shifts) before typechecking.
+func FormatElaborated(program string, configure func(*process.GlobalEnvironment)) (string, error) {
+	// The program is parsed twice, since typechecking fills in the types (e.g. the modes) of the one being elaborated
+	environment, err := Parse(strings.NewReader(program))
+	if err != nil {
+		return "", err
+	}
+
+	elaborated, err := Parse(strings.NewReader(program))
+	if err != nil {
+		return "", err
+	}
+
+	processes, assumedFreeNames, globalEnv, err := expandProcesses(elaborated)
+	if err != nil {
+		return "", err
+	}
+
+	configure(globalEnv)
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		return "", err
+	}
+
+	functions := *globalEnv.FunctionDefinitions
+	functionIndex, processIndex := 0, 0
+	for i := range environment.procsAndFuns {
+		s := &environment.procsAndFuns[i]
+		switch s.kind {
+		case FUNCTION_DEF:
+			var providers []process.Name
+			if s.function.UsesExplicitProvider {
+				providers = []process.Name{s.function.ExplicitProvider}
+			}
+			// The macros and splits are expanded in the same way as in the typechecked copy, leaving only the drops and
+			// shifts to be copied
+			body := process.ElaborateSplits(process.ExpandMacros(s.function.Body, providers), providers)
+			s.function.Body = process.CopyInsertedForms(body, functions[functionIndex].Body)
+			functionIndex++
+		case PROCESS_DEF:
+			body := process.ElaborateSplits(process.ExpandMacros(s.proc.Body, nil), s.proc.Providers)
+			s.proc.Body = process.CopyInsertedForms(body, processes[processIndex].Body)
+			processIndex++
+		}
+	}
+
+	tokens, comments := TokenizeWithComments(strings.NewReader(program))
+	lines := formatStatements(environment, tokens)
+
+	return placeComments(lines, comments, tokens, strings.Split(program, "\n")), nil
+}
+
+var statementKeywords = map[string]bool{"type": true, "let": true, "prc": true, "assuming": true, "exec": true, "global": true}
+
+func formatStatements(environment allEnvironment, tokens []Token) []process.FormattedLine {
+	// Each statement starts with a keyword, which is used to find the source lines spanned by its header
+	var starts []int
+	depths := LetBlockDepths(tokens)
+	for i, t := range tokens {
+		if t.Kind == KEYWORD_TOKEN && statementKeywords[t.Value] && depths[i] == 0 {
+			starts = append(starts, i)
+		}
+	}
+
+	if len(starts) != len(environment.procsAndFuns) {
+		// A program consisting of a single expression
+		var lines []process.FormattedLine
+		for _, s := range environment.procsAndFuns {
+			lines = append(lines, process.FormatForm(s.proc.Body, 0)...)
+		}
+		return lines
+	}
+
+	var lines []process.FormattedLine
+	for i, s := range environment.procsAndFuns {
+		end := len(tokens)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		statementTokens := tokens[starts[i]:end]
+
+		first, last := statementTokens[0], statementTokens[len(statementTokens)-1]
+		header := process.FormattedLine{FirstLine: first.Line, FirstChar: first.Char, LastLine: last.Line, LastChar: last.Char}
+
+		var body process.Form
+		switch s.kind {
+		case TYPE_DEF:
+			header.Text = fmt.Sprintf("type %s = %s", s.session_type.Name, types.FormatType(s.session_type.SessionType))
+		case ASSUMING_DEF:
+			header.Text = "assuming " + formatNamesWithTypes(s.assumedFreeNameTypes)
+		case EXEC_DEF:
+			header.Text = "exec " + process.FormatForm(s.proc.Body, 0)[0].Text
+		case GLOBAL_DEF:
+			globalLines := formatGlobalType(s.global_type.GlobalType, 0)
+			anchorGlobalBranches(globalLines[1:], statementTokens)
+			header.Text = fmt.Sprintf("global %s = %s", s.global_type.Name, globalLines[0].Text)
+			if len(globalLines) > 1 {
+				header.LastLine, header.LastChar = first.Line, first.Char
+				lines = append(append(lines, header), globalLines[1:]...)
+				continue
+			}
+		case FUNCTION_DEF:
+			header.Text = process.FormatFunctionHeader(s.function)
+			header.LastLine, header.LastChar = headerEnd(statementTokens)
+			body = s.function.Body
+		case PROCESS_DEF:
+			header.Text = "prc[" + process.FormatNames(s.proc.Providers) + "]"
+			if s.proc.Type != nil {
+				header.Text += " : " + types.FormatType(s.proc.Type)
+			}
+			header.Text += " ="
+			header.LastLine, header.LastChar = headerEnd(statementTokens)
+			body = s.proc.Body
+		}
+
+		if body == nil {
+			lines = append(lines, header)
+			continue
+		}
+
+		bodyLines := process.FormatForm(body, 4)
+		anchorCalls(bodyLines, statementTokens, header.LastLine)
+
+		if len(bodyLines) == 1 {
+			// Short bodies remain on the same line, e.g. prc[a] : 1 = close self
+			header.Text += " " + bodyLines[0].Text
+			header.AddSourcePosition(bodyLines[0].LastLine, bodyLines[0].LastChar)
+			lines = append(lines, header)
+		} else {
+			// Otherwise, bodies start on a new line, indented by 4 spaces
+			bodyLines[0].Text = "    " + bodyLines[0].Text
+			lines = append(append(lines, header), bodyLines...)
+		}
+	}
+
+	return lines
+}
+
+// Interactions whose branches all end (or refer to another global type) fit on a single line, e.g.
+// seller -> shipper {ship : end}. Otherwise, each branch is placed on its own line, indented by 4 spaces.
+func formatGlobalType(g types.GlobalType, indent int) []process.FormattedLine {
+	interaction, ok := g.(*types.GlobalInteraction)
+	if !ok || !hasNestedInteraction(interaction) {
+		return []process.FormattedLine{{Text: g.String()}}
+	}
+
+	prefix := strings.Repeat(" ", indent+4)
+	lines := []process.FormattedLine{{Text: fmt.Sprintf("%s -> %s {", interaction.From, interaction.To)}}
+	for i, branch := range interaction.Branches {
+		branchLines := formatGlobalType(branch.Continuation, indent+4)
+		branchLines[0].Text = prefix + branch.Header() + " : " + branchLines[0].Text
+		if i+1 < len(interaction.Branches) {
+			branchLines[len(branchLines)-1].Text += ","
+		}
+		lines = append(lines, branchLines...)
+	}
+
+	return append(lines, process.FormattedLine{Text: strings.Repeat(" ", indent) + "}"})
+}
+
+func hasNestedInteraction(interaction *types.GlobalInteraction) bool {
+	for _, branch := range interaction.Branches {
+		if _, ok := branch.Continuation.(*types.GlobalInteraction); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Each branch of a global type starts with its label, followed by ':' (or by '(' if it carries a payload)
+func anchorGlobalBranches(lines []process.FormattedLine, statementTokens []Token) {
+	next := 0
+	for i := range lines {
+		text := strings.TrimSpace(lines[i].Text)
+		if strings.HasPrefix(text, "}") {
+			continue
+		}
+
+		label := text[:strings.IndexAny(text, " (")]
+		for j := next; j+1 < len(statementTokens); j++ {
+			t := statementTokens[j]
+			if t.Kind == LABEL_TOKEN && t.Value == label && (statementTokens[j+1].Value == ":" || statementTokens[j+1].Value == "(") {
+				lines[i].AddSourcePosition(t.Line, t.Char)
+				next = j + 1
+				break
+			}
+		}
+	}
+}
+
+// Calls without any parameters contain no names, so their source line is found by looking for the function name
+func anchorCalls(lines []process.FormattedLine, statementTokens []Token, previousLine int) {
+	for i := range lines {
+		if lines[i].FirstLine > 0 {
+			previousLine = lines[i].LastLine
+			continue
+		}
+
+		text := strings.TrimSpace(lines[i].Text)
+		if !strings.HasSuffix(text, "()") {
+			continue
+		}
+
+		functionName := strings.TrimSuffix(text, "()")
+		for j, t := range statementTokens {
+			if t.Kind == LABEL_TOKEN && t.Value == functionName && t.Line >= previousLine && j+1 < len(statementTokens) && statementTokens[j+1].Value == "(" {
+				lines[i].AddSourcePosition(t.Line, t.Char)
+				previousLine = t.Line
+				break
+			}
+		}
+	}
+}
+
+func formatNamesWithTypes(names []process.Name) string {
+	formatted := make([]string, len(names))
+	for i := range names {
+		formatted[i] = process.FormatNameWithType(names[i])
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// The header of functions and processes ends with the first '=' (types never contain one)
+func headerEnd(statementTokens []Token) (line, char int) {
+	for _, t := range statementTokens {
+		if t.Value == "=" {
+			return t.Line, t.Char
+		}
+	}
+
+	return statementTokens[0].Line, statementTokens[0].Char
+}
+
+// A line of the final output: code (possibly followed by a comment) or a comment on its own
+type outputLine struct {
+	code      string
+	comment   string
+	firstLine int
+	lastLine  int
+}
+
+// Comments are placed by their position with respect to the code: those followed by some code (on their own line, or
+// before some code on the same line, e.g. wait u; /* done */ close self) are placed before the line containing that
+// code, whereas trailing comments remain at the end of the line containing the code preceding them (and are aligned
+// with those on neighbouring lines). Blank lines are kept wherever the source had some between two lines.
+func placeComments(lines []process.FormattedLine, comments []Token, tokens []Token, source []string) string {
+	before := make([][]Token, len(lines)+1)
+	trailing := make([][]Token, len(lines))
+
+	for _, c := range comments {
+		if isTrailingComment(c, source) && !precedesCode(c, tokens) {
+			next := nextCodeLine(lines, c, false)
+			if next > 0 {
+				trailing[next-1] = append(trailing[next-1], c)
+				continue
+			}
+		}
+
+		next := nextCodeLine(lines, c, true)
+		// Closing brackets aside, unknown lines are assumed to follow the comment
+		for next > 0 && next < len(lines) && lines[next-1].FirstLine == 0 && !isClosingLine(lines[next-1]) {
+			next--
+		}
+		before[next] = append(before[next], c)
+	}
+
+	var output []outputLine
+	for i := 0; i <= len(lines); i++ {
+		indent := ""
+		if i < len(lines) {
+			indent = leadingSpaces(lines[i].Text)
+		}
+
+		for _, c := range before[i] {
+			output = append(output, commentLine(indent, c))
+		}
+
+		if i == len(lines) {
+			break
+		}
+
+		line := outputLine{code: lines[i].Text, firstLine: lines[i].FirstLine, lastLine: lines[i].LastLine}
+		if len(trailing[i]) > 0 {
+			line.comment = commentText(trailing[i][0])
+			line.lastLine = max(line.lastLine, commentLastLine(trailing[i][0]))
+		}
+		output = append(output, line)
+
+		// Only one comment fits at the end of a line
+		for _, c := range trailing[i][min(1, len(trailing[i])):] {
+			output = append(output, commentLine(indent, c))
+		}
+	}
+
+	// Blank lines separate groups of aligned comments
+	blankBefore := make([]bool, len(output))
+	previousLine := 0
+	for i, line := range output {
+		blankBefore[i] = i > 0 && line.firstLine > 0 && previousLine > 0 && hasBlankLine(source, previousLine, line.firstLine)
+		if line.lastLine > 0 {
+			previousLine = line.lastLine
+		}
+	}
+
+	var builder strings.Builder
+	for start := 0; start < len(output); {
+		end := start + 1
+		if output[start].code != "" && output[start].comment != "" {
+			for end < len(output) && !blankBefore[end] && output[end].code != "" && output[end].comment != "" {
+				end++
+			}
+		}
+
+		width := 0
+		for _, line := range output[start:end] {
+			width = max(width, len(line.code))
+		}
+
+		for i, line := range output[start:end] {
+			if blankBefore[start+i] {
+				builder.WriteString("\n")
+			}
+
+			if line.code == "" || line.comment == "" {
+				builder.WriteString(line.code + line.comment)
+			} else {
+				builder.WriteString(line.code + strings.Repeat(" ", width-len(line.code)+1) + line.comment)
+			}
+			builder.WriteString("\n")
+		}
+
+		start = end
+	}
+
+	return builder.String()
+}
+
+// Returns the index of the first line of code starting after the comment (or containing some code after it, i.e.
+// ending after it)
+func nextCodeLine(lines []process.FormattedLine, c Token, containing bool) int {
+	for i, l := range lines {
+		line, char := l.FirstLine, l.FirstChar
+		if containing {
+			line, char = l.LastLine, l.LastChar
+		}
+
+		if line > c.Line || (line == c.Line && char > c.Char) {
+			return i
+		}
+	}
+
+	return len(lines)
+}
+
+// A comment is trailing if it follows some code on the same line
+func isTrailingComment(c Token, source []string) bool {
+	if c.Line > len(source) {
+		return false
+	}
+
+	line := []rune(source[c.Line-1])
+	if c.Char-1 > len(line) {
+		return false
+	}
+
+	return strings.TrimSpace(string(line[:c.Char-1])) != ""
+}
+
+// A comment precedes some code if the code follows it on the same line (where the comment ends)
+func precedesCode(c Token, tokens []Token) bool {
+	lastLine := commentLastLine(c)
+	for _, t := range tokens {
+		if t.Line == lastLine && (t.Line > c.Line || t.Char > c.Char) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isClosingLine(line process.FormattedLine) bool {
+	text := strings.TrimSpace(line.Text)
+	return strings.HasPrefix(text, ")") || strings.HasPrefix(text, "}")
+}
+
+func commentLine(indent string, c Token) outputLine {
+	return outputLine{comment: indent + commentText(c), firstLine: c.Line, lastLine: commentLastLine(c)}
+}
+
+func commentText(c Token) string {
+	return strings.TrimRight(c.Value, " \t\r")
+}
+
+func commentLastLine(c Token) int {
+	return c.Line + strings.Count(c.Value, "\n")
+}
+
+// Checks for blank lines strictly between the two (1-based) source lines
+func hasBlankLine(source []string, from, to int) bool {
+	for line := from + 1; line < to && line <= len(source); line++ {
+		if strings.TrimSpace(source[line-1]) == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func leadingSpaces(text string) string {
+	return text[:len(text)-len(strings.TrimLeft(text, " "))]
+}
+
+func compareStatements(original, formatted allEnvironment) error {
+	if len(original.procsAndFuns) != len(formatted.procsAndFuns) {
+		return fmt.Errorf("expected %d statements, but found %d", len(original.procsAndFuns), len(formatted.procsAndFuns))
+	}
+
+	for i := range original.procsAndFuns {
+		s1, s2 := original.procsAndFuns[i], formatted.procsAndFuns[i]
+		if s1.kind != s2.kind {
+			return fmt.Errorf("statement %d changed kind", i+1)
+		}
+
+		var equal bool
+		switch s1.kind {
+		case TYPE_DEF:
+			equal = s1.session_type.Name == s2.session_type.Name && equalTypes(s1.session_type.SessionType, s2.session_type.SessionType)
+		case GLOBAL_DEF:
+			equal = s1.global_type.Name == s2.global_type.Name && s1.global_type.GlobalType.String() == s2.global_type.GlobalType.String()
+		case ASSUMING_DEF:
+			equal = equalNames(s1.assumedFreeNameTypes, s2.assumedFreeNameTypes)
+		case FUNCTION_DEF:
+			f1, f2 := s1.function, s2.function
+			equal = f1.FunctionName == f2.FunctionName &&
+				f1.UsesExplicitProvider == f2.UsesExplicitProvider &&
+				equalNames([]process.Name{f1.ExplicitProvider}, []process.Name{f2.ExplicitProvider}) &&
+				equalNames(f1.Parameters, f2.Parameters) &&
+				equalTypes(f1.Type, f2.Type) &&
+				process.EqualForm(f1.Body, f2.Body)
+		case PROCESS_DEF, EXEC_DEF:
+			equal = equalNames(s1.proc.Providers, s2.proc.Providers) &&
+				equalTypes(s1.proc.Type, s2.proc.Type) &&
+				process.EqualForm(s1.proc.Body, s2.proc.Body)
+		}
+
+		if !equal {
+			return fmt.Errorf("statement %d is not equivalent", i+1)
+		}
+	}
+
+	return nil
+}
+
+// Types are compared structurally, including their modalities
+func equalTypes(t1, t2 types.SessionType) bool {
+	if t1 == nil || t2 == nil {
+		return t1 == nil && t2 == nil
+	}
+
+	return types.FormatType(t1) == types.FormatType(t2) && t1.StringWithModality() == t2.StringWithModality()
+}
+
+func equalNames(names1, names2 []process.Name) bool {
+	if len(names1) != len(names2) {
+		return false
+	}
+
+	for i := range names1 {
+		if process.FormatName(names1[i]) != process.FormatName(names2[i]) || !equalTypes(names1[i].Type, names2[i].Type) {
+			return false
+		}
+	}
+
+	return true
+}
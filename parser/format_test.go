@@ -0,0 +1,470 @@
+package parser
+
+import (
+	"grits/process"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatLayout(t *testing.T) {
+	input := `/* Natural numbers */
+type nat = +{zero : 1, succ : nat}   // unary
+type f = lin /\ rep   ((nat*nat) -* nat)
+
+let double(x : nat) : nat = case x ( zero<x'> => self.zero<x'> | succ<x'> => h <- new double(x'); d : nat <- new self.succ<h>;
+    self.succ<d> )
+
+// Entry point
+prc[d0] : nat = t : 1 <- new close self;  self.zero<t>  // zero
+`
+
+	expected := `/* Natural numbers */
+type nat = +{zero : 1, succ : nat} // unary
+type f = lin /\ rep ((nat * nat) -* nat)
+
+let double(x : nat) : nat =
+    case x (
+          zero<x'> => self.zero<x'>
+        | succ<x'> => h       <- new double(x');
+                      d : nat <- new self.succ<h>;
+                      self.succ<d>
+    )
+
+// Entry point
+prc[d0] : nat =
+    t : 1 <- new close self;
+    self.zero<t> // zero
+`
+
+	output, err := Format(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", output, expected)
+	}
+}
+
+func TestFormatBrackets(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		// Bodies with a continuation are bracketed within new
+		{"prc[a] : 1 = x <- new (wait y; close self); close self", "prc[a] : 1 =\n    x <- new (wait y;\n              close self);\n    close self\n"},
+		// Left operands are bracketed
+		{"type A = (1 * 1) * (1 -* 1)", "type A = (1 * 1) * 1 -* 1\n"},
+		{"type A = (aff /\\ lin 1) -* 1", "type A = (aff /\\ lin 1) -* 1\n"},
+		// Explicit providers and polarities
+		{"let f[p : 1, x : 1] = +x <- shift -p; fwd self x", "let f[p : 1, x : 1] =\n    +x <- shift -p;\n    fwd self x\n"},
+		// Empty case and multiple trailing comments
+		{"let f() = case x () // a\n/* b */", "let f() = case x () // a\n/* b */\n"},
+		// Comments placed by their position within a line: before the code following them, or after the case
+		{"type nat = +{zero : 1, succ : nat}\nlet f(x : nat) : 1 = case x (zero<u> => wait u; /* done */ close self | succ<n> => drop n; close self) // one-liner",
+			"type nat = +{zero : 1, succ : nat}\nlet f(x : nat) : 1 =\n    case x (\n          zero<u> => wait u;\n                     /* done */\n                     close self\n        | succ<n> => drop n;\n                     close self\n    ) // one-liner\n"},
+		{"prc[a] : 1 = wait x; /* then */ close self // end", "prc[a] : 1 =\n    wait x;\n    /* then */\n    close self // end\n"},
+		// Input
+		{"prc[a] : +{yes : 1, no : 1} =  input  self", "prc[a] : +{yes : 1, no : 1} = input self\n"},
+		// Printing a value
+		{"let f(x : 1) : 1 = print( x ); close self", "let f(x : 1) : 1 =\n    print(x);\n    close self\n"},
+	}
+
+	for _, c := range cases {
+		output, err := Format(c.input)
+		if err != nil {
+			t.Errorf("unable to format %q: %v", c.input, err)
+			continue
+		}
+
+		if output != c.expected {
+			t.Errorf("formatting %q: got %q, expected %q", c.input, output, c.expected)
+		}
+	}
+}
+
+func TestFormatElaborated(t *testing.T) {
+	input := `type nat = +{zero : 1, succ : nat}
+
+// y is dropped before both ends
+let f(x : nat, y : aff 1) : aff 1 = case x (zero<u> => wait u; close self | succ<x'> => close self)
+
+// x is split before each of its uses but the last one
+let g(b : nat, x : 1) : 1 * 1 = case b (zero<u> => wait u; wait x; send self<x, x> | succ<b'> => drop b'; send self<x, x>)
+`
+
+	expected := `type nat = +{zero : 1, succ : nat}
+
+// y is dropped before both ends
+let f(x : nat, y : aff 1) : aff 1 =
+    case x (
+          zero<u>  => wait u;
+                      drop y;
+                      close self
+        | succ<x'> => drop x';
+                      drop y;
+                      close self
+    )
+
+// x is split before each of its uses but the last one
+let g(b : nat, x : 1) : 1 * 1 =
+    case b (
+          zero<u>  => wait u;
+                      <x1, x2> <- split x;
+                      wait x1;
+                      <x3, x4> <- split x2;
+                      send self<x3, x4>
+        | succ<b'> => drop b';
+                      <x1, x2> <- split x;
+                      send self<x1, x2>
+    )
+`
+
+	output, err := FormatElaborated(input, func(globalEnv *process.GlobalEnvironment) {})
+	if err != nil {
+		t.Fatalf("unable to elaborate: %v", err)
+	}
+
+	if output != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", output, expected)
+	}
+
+	// The elaborated program typechecks without any names left unused or used more than once
+	processes, assumedFreeNames, globalEnv, err := ParseString(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, warning := range globalEnv.Warnings {
+		if warning.Kind == process.IMPLICIT_DROP {
+			t.Errorf("unexpected warning: %s", warning.String())
+		}
+	}
+
+	// Nothing is left to elaborate
+	if again, err := FormatElaborated(output, func(globalEnv *process.GlobalEnvironment) {}); err != nil || again != output {
+		t.Errorf("elaborating again changed the program:\n%s (%v)", again, err)
+	}
+}
+
+func TestFormatElaboratedShifts(t *testing.T) {
+	input := `type A = lin +{a : 1}
+
+let f(x : aff \/ lin 1) : lin 1 = wait x; close self
+
+let g() : aff \/ lin 1 = close self
+
+let h() : lin /\ aff A = u : lin 1 <- new close self; self.a<u>
+
+assuming u : aff 1
+prc[a] : lin 1 = f(u)
+prc[b] : lin /\ aff A = h()
+prc[c] : lin 1 = case b (a<t> => wait t; close self)
+`
+
+	expected := `type A = lin +{a : 1}
+
+let f(x : aff \/ lin 1) : lin 1 =
+    x1 <- shift x;
+    wait x1;
+    close self
+
+let g() : aff \/ lin 1 =
+    s1 : aff 1 <- new close self;
+    cast self<s1>
+
+let h() : lin /\ aff A =
+    s1 <- shift self;
+    u : lin 1 <- new close self;
+    self.a<u>
+
+assuming u : aff 1
+prc[a] : lin 1 =
+    u1 : aff \/ lin 1 <- new cast self<u>;
+    f(u1)
+prc[b] : lin /\ aff A = h()
+prc[c] : lin 1 =
+    b1 : A <- new cast b<self>;
+    case b1 (
+          a<t> => wait t;
+                  close self
+    )
+`
+
+	output, err := FormatElaborated(input, func(globalEnv *process.GlobalEnvironment) {
+		globalEnv.InsertShifts = true
+	})
+	if err != nil {
+		t.Fatalf("unable to elaborate: %v", err)
+	}
+
+	if output != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", output, expected)
+	}
+
+	// The inserted shifts typecheck without having to insert them again
+	processes, assumedFreeNames, globalEnv, err := ParseString(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFormatMacros(t *testing.T) {
+	input := `type nat = +{zero : 1, succ : nat}
+let two() : nat = self.succ;   self.succ; self.zero; close self
+let pair(a : 1 -* 1 -* 1, b : 1) : 1 = send a<b>; send a<b>; wait a; close self
+let swap() : 1 -* (1 * 1) = x <- recv self; u : 1 <- new close self; send self<u>; wait x; close self
+`
+
+	expected := `type nat = +{zero : 1, succ : nat}
+let two() : nat =
+    self.succ;
+    self.succ;
+    self.zero;
+    close self
+let pair(a : 1 -* 1 -* 1, b : 1) : 1 =
+    send a<b>;
+    send a<b>;
+    wait a;
+    close self
+let swap() : 1 -* 1 * 1 =
+    x <- recv self;
+    u : 1 <- new close self;
+    send self<u>;
+    wait x;
+    close self
+`
+
+	output, err := Format(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", output, expected)
+	}
+
+	// The macros are kept once expanded, except for the implicit splits
+	elaborated, err := FormatElaborated(input, func(globalEnv *process.GlobalEnvironment) {})
+	if err != nil {
+		t.Fatalf("unable to elaborate: %v", err)
+	}
+
+	for _, line := range []string{"self.succ;", "x <- recv self;", "send self<u>;", "send a<b1>;", "send a<b2>;"} {
+		if !strings.Contains(elaborated, line) {
+			t.Errorf("expected the elaborated program to contain %q:\n%s", line, elaborated)
+		}
+	}
+}
+
+func TestFormatTuples(t *testing.T) {
+	input := `let f(a : 1, b : 1, k : 1) : 1 * 1 * 1 = send self<a,b,k>
+let g(x : 1 * 1 * 1) : 1 = <a,b,k> <- recv x; wait a; wait b; wait k; close self
+let h(x : 1 -* 1 -* 1, a : 1) : 1 = send x<a, a, self>
+`
+
+	expected := `let f(a : 1, b : 1, k : 1) : 1 * 1 * 1 = send self<a, b, k>
+let g(x : 1 * 1 * 1) : 1 =
+    <a, b, k> <- recv x;
+    wait a;
+    wait b;
+    wait k;
+    close self
+let h(x : 1 -* 1 -* 1, a : 1) : 1 = send x<a, a, self>
+`
+
+	output, err := Format(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", output, expected)
+	}
+
+	// The tuples are kept once the names sent more than once are split
+	elaborated, err := FormatElaborated(input, func(globalEnv *process.GlobalEnvironment) {})
+	if err != nil {
+		t.Fatalf("unable to elaborate: %v", err)
+	}
+
+	if !strings.Contains(elaborated, "send x<a1, a2, self>") {
+		t.Errorf("expected the elaborated program to contain the tuple:\n%s", elaborated)
+	}
+}
+
+func TestFormatPatterns(t *testing.T) {
+	input := `type nat = +{zero : 1, succ : nat}
+let f(n : nat, y : aff 1) : aff 1 = case n (zero<z> => wait z; close self | succ<zero<z>> => close self | succ<succ<m>> => drop m; close self)
+let g(x : +{a : 1 * 1}) : 1 = case x (a<<u,v>> => wait u; wait v; close self)
+type list = +{nil : 1, cons : 1 * list}
+let h(l : list) : 1 = case l (cons<<x, nil<u>>> => wait x; wait u; close self | cons<<x, cons<<y, t>>>> => wait x; wait y; drop t; close self | nil<u> => wait u; close self)
+`
+
+	expected := `type nat = +{zero : 1, succ : nat}
+let f(n : nat, y : aff 1) : aff 1 =
+    case n (
+          zero<z>       => wait z;
+                           close self
+        | succ<zero<z>> => close self
+        | succ<succ<m>> => drop m;
+                           close self
+    )
+let g(x : +{a : 1 * 1}) : 1 =
+    case x (
+          a<<u, v>> => wait u;
+                       wait v;
+                       close self
+    )
+type list = +{nil : 1, cons : 1 * list}
+let h(l : list) : 1 =
+    case l (
+          cons<<x, nil<u>>>       => wait x;
+                                     wait u;
+                                     close self
+        | cons<<x, cons<<y, t>>>> => wait x;
+                                     wait y;
+                                     drop t;
+                                     close self
+        | nil<u>                  => wait u;
+                                     close self
+    )
+`
+
+	output, err := Format(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", output, expected)
+	}
+
+	// The drops are inserted within the nested patterns, which are kept as written
+	elaborated, err := FormatElaborated(input, func(globalEnv *process.GlobalEnvironment) {})
+	if err != nil {
+		t.Fatalf("unable to elaborate: %v", err)
+	}
+
+	for _, line := range []string{"| succ<zero<z>> => drop y;", "drop z;"} {
+		if !strings.Contains(elaborated, line) {
+			t.Errorf("expected the elaborated program to contain %q:\n%s", line, elaborated)
+		}
+	}
+}
+
+func TestFormatLet(t *testing.T) {
+	input := `type nat = +{zero : 1, succ : nat}
+let add(x : nat, y : nat) : nat = let
+  // adds y
+  let go(n : nat) : nat = case n (zero<n> => wait n; fwd self y | succ<n> => self.succ; go(n))
+in go(x) // call
+end
+let f(x : aff 1) : aff 1 = let type t = aff 1 let g(y : t) : t = close self in u : t <- new close self; g(u) end
+`
+
+	expected := `type nat = +{zero : 1, succ : nat}
+let add(x : nat, y : nat) : nat =
+    let
+        // adds y
+        let go(n : nat) : nat =
+            case n (
+                  zero<n> => wait n;
+                             fwd self y
+                | succ<n> => self.succ;
+                             go(n)
+            )
+    in
+        go(x) // call
+    end
+let f(x : aff 1) : aff 1 =
+    let
+        type t = aff 1
+        let g(y : t) : t = close self
+    in
+        u : t <- new close self;
+        g(u)
+    end
+`
+
+	output, err := Format(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", output, expected)
+	}
+
+	// The drops are inserted into the local functions as well
+	elaborated, err := FormatElaborated(input, func(globalEnv *process.GlobalEnvironment) {})
+	if err != nil {
+		t.Fatalf("unable to elaborate: %v", err)
+	}
+
+	for _, line := range []string{"let g(y : t) : t =", "drop y;", "drop x;", "go(x)"} {
+		if !strings.Contains(elaborated, line) {
+			t.Errorf("expected the elaborated program to contain %q:\n%s", line, elaborated)
+		}
+	}
+}
+
+// Every example should remain equivalent after formatting, and formatting should be idempotent
+func TestFormatExamples(t *testing.T) {
+	files, _ := filepath.Glob("../examples/*.grits")
+	others, _ := filepath.Glob("../examples/others/*.grits")
+	files = append(files, others...)
+
+	if len(files) == 0 {
+		t.Fatal("no examples found")
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		formatted, err := Format(string(content))
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+
+		if again, err := Format(formatted); err != nil || again != formatted {
+			t.Errorf("%s: formatting is not idempotent (%v)", file, err)
+		}
+
+		if strings.Count(formatted, "//")+strings.Count(formatted, "/*") < strings.Count(string(content), "//")+strings.Count(string(content), "/*") {
+			t.Errorf("%s: comments were lost", file)
+		}
+
+		original, _, _, err1 := ParseString(string(content))
+		reformatted, _, _, err2 := ParseString(formatted)
+		if err1 != nil || err2 != nil {
+			t.Errorf("%s: unable to parse (%v, %v)", file, err1, err2)
+			continue
+		}
+
+		if len(original) != len(reformatted) {
+			t.Errorf("%s: expected %d processes, but found %d", file, len(original), len(reformatted))
+			continue
+		}
+
+		for i := range original {
+			if !process.EqualForm(original[i].Body, reformatted[i].Body) {
+				t.Errorf("%s: process %d differs after formatting", file, i)
+			}
+		}
+	}
+}
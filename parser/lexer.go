@@ -38,7 +38,11 @@ func (l *lexer) Lex(yylval *gritsSymType) int {
 
 // Error handles error.
 func (l *lexer) Error(err string) {
-	l.Errors <- &ParseError{Err: err, Pos: l.scanner.pos}
+	// Only the first error is kept (errors found while building the forms do not stop the parser)
+	select {
+	case l.Errors <- &ParseError{Err: err, Pos: l.scanner.pos}:
+	default:
+	}
 }
 
 func LexAndPrintTokens(file io.Reader) {
@@ -62,3 +66,77 @@ func LexAndPrintTokens(file io.Reader) {
 		fmt.Printf("\t%d\t%s\n", tok, val.strval)
 	}
 }
+
+// Token is a lexical token along with its starting position.
+// Tokens are exposed for editor tooling (e.g. the language server), which needs to relate the source text to the AST.
+type Token struct {
+	Kind  TokenKind
+	Value string
+	Line  int // Starting line (1-based)
+	Char  int // Starting character within the line (1-based)
+}
+
+type TokenKind int
+
+const (
+	LABEL_TOKEN   TokenKind = iota // channel names, function names, type names and labels
+	KEYWORD_TOKEN                  // e.g. send, case, let, self
+	SYMBOL_TOKEN                   // e.g. <-, =>, +, 1
+	COMMENT_TOKEN                  // both // and /* */ comments, including their delimiters
+)
+
+// Tokenize splits the program into tokens (comments are skipped). Illegal characters are kept as symbols.
+func Tokenize(r io.Reader) []Token {
+	tokens, _ := tokenize(r, false)
+	return tokens
+}
+
+// TokenizeWithComments is similar to Tokenize, but also returns the comments (in order of appearance)
+func TokenizeWithComments(r io.Reader) (tokens []Token, comments []Token) {
+	return tokenize(r, true)
+}
+
+// LetBlockDepths gives the number of let blocks (let <definitions> in P end) enclosing each token, counting the let and
+// end delimiting a block as part of it. The keywords within a block (e.g. the let of a local function) do not start
+// a new statement.
+func LetBlockDepths(tokens []Token) []int {
+	depths := make([]int, len(tokens))
+	depth := 0
+	for i, t := range tokens {
+		if t.Kind == KEYWORD_TOKEN && t.Value == "let" && i+1 < len(tokens) && (tokens[i+1].Value == "let" || tokens[i+1].Value == "type") {
+			depth++
+		}
+
+		depths[i] = depth
+
+		if t.Kind == KEYWORD_TOKEN && t.Value == "end" && depth > 0 {
+			depth--
+		}
+	}
+
+	return depths
+}
+
+func tokenize(r io.Reader, keepComments bool) ([]Token, []Token) {
+	var tokens []Token
+
+	s := newScanner(r)
+	s.keepComments = keepComments
+	for {
+		token, value, startPos, _ := s.Scan()
+		if token == EOF && value == "" {
+			break
+		}
+
+		kind := SYMBOL_TOKEN
+		if token == LABEL {
+			kind = LABEL_TOKEN
+		} else if len(value) > 0 && (('a' <= value[0] && value[0] <= 'z') || ('A' <= value[0] && value[0] <= 'Z')) {
+			kind = KEYWORD_TOKEN
+		}
+
+		tokens = append(tokens, Token{Kind: kind, Value: value, Line: len(startPos.Lines) + 1, Char: startPos.Char})
+	}
+
+	return tokens, s.comments
+}
@@ -60,7 +60,10 @@ func TestSimpleToken(t *testing.T) {
 		{"cast shift accept acc acquire acq detach det//comment", []int{CAST, SHIFT, ACCEPT, ACCEPT, ACQUIRE, ACQUIRE, DETACH, DETACH}},
 		{"release rel drop/*comment*/split push new exec", []int{RELEASE, RELEASE, DROP, SPLIT, PUSH, NEW, EXEC}},
 		{"/*comment*/snew forward fwd let in end sprc prc self assuming", []int{SNEW, FORWARD, FORWARD, LET, IN, END, SPRC, PRC, SELF, ASSUMING}},
-		{"print", []int{PRINT}},
+		{"print input", []int{PRINT, INPUT}},
+		// global is only a keyword at the start of a global type definition
+		{"global g = end", []int{GLOBAL, LABEL, EQUALS, END}},
+		{"global(x) global<y> self.global<z>", []int{LABEL, LPAREN, LABEL, RPAREN, LABEL, LANGLE, LABEL, RANGLE, SELF, DOT, LABEL, LANGLE, LABEL, RANGLE}},
 		{`+-1 1a{},()/\ \/`, []int{PLUS, MINUS, UNIT, LABEL, LCBRACK, RCBRACK, COMMA, LPAREN, RPAREN, UP_ARROW, DOWN_ARROW}},
 		{`cast+/\\/`, []int{CAST, PLUS, UP_ARROW, DOWN_ARROW}},
 		{`cast+/*comment*//\/*comment*/\//*comment*/`, []int{CAST, PLUS, UP_ARROW, DOWN_ARROW}},
@@ -107,3 +110,38 @@ func TestCommentToken(t *testing.T) {
 		compareOutput(t, tokens, c.expected)
 	}
 }
+
+func TestTokenizePositions(t *testing.T) {
+	input := "let f(x : nat) =\n  /* comment */ fwd self x // end"
+	expected := []Token{
+		{KEYWORD_TOKEN, "let", 1, 1},
+		{LABEL_TOKEN, "f", 1, 5},
+		{SYMBOL_TOKEN, "(", 1, 6},
+		{LABEL_TOKEN, "x", 1, 7},
+		{SYMBOL_TOKEN, ":", 1, 9},
+		{LABEL_TOKEN, "nat", 1, 11},
+		{SYMBOL_TOKEN, ")", 1, 14},
+		{SYMBOL_TOKEN, "=", 1, 16},
+		{KEYWORD_TOKEN, "fwd", 2, 17},
+		{KEYWORD_TOKEN, "self", 2, 21},
+		{LABEL_TOKEN, "x", 2, 26},
+	}
+
+	tokens := Tokenize(strings.NewReader(input))
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, but found %v", len(expected), tokens)
+	}
+
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("expected token %v, but found %v", expected[i], tokens[i])
+		}
+	}
+}
+
+func TestUnterminatedComment(t *testing.T) {
+	tokens := Tokenize(strings.NewReader("close self /* a * b / c"))
+	if len(tokens) != 2 {
+		t.Errorf("expected 2 tokens, but found %v", tokens)
+	}
+}
@@ -21,6 +21,7 @@ type unexpandedProcessOrFunction struct {
 	proc                 incompleteProcess
 	function             process.FunctionDefinition
 	session_type         types.SessionTypeDefinition
+	global_type          types.GlobalTypeDefinition
 	assumedFreeNameTypes []process.Name
 	position             position.Position
 }
@@ -34,6 +35,7 @@ const (
 	TYPE_DEF
 	ASSUMING_DEF
 	EXEC_DEF
+	GLOBAL_DEF
 )
 
 // Process that is currently being parsed and yet to become a process.Process
@@ -78,6 +80,25 @@ func ParseReader(r io.Reader) ([]*process.Process, []process.Name, *process.Glob
 	return expandedProcesses, assumedFreeNames, globalEnv, nil
 }
 
+// let <definitions> in P end
+func newLet(definitions []unexpandedProcessOrFunction, body process.Form, letPosition, inPosition, endPosition position.Position) process.Form {
+	var functions []process.FunctionDefinition
+	var typeDefs []types.SessionTypeDefinition
+	for _, d := range definitions {
+		if d.kind == FUNCTION_DEF {
+			d.function.Position = d.position
+			functions = append(functions, d.function)
+		} else if d.kind == TYPE_DEF {
+			d.session_type.Position = d.position
+			typeDefs = append(typeDefs, d.session_type)
+		}
+	}
+
+	let := process.NewLet(functions, typeDefs, body)
+	let.Position, let.InPosition, let.EndPosition = letPosition, inPosition, endPosition
+	return let
+}
+
 // Splits all of the processes, function definitions, processes, type definitions and assumed names into separate structures
 func expandProcesses(u allEnvironment) ([]*process.Process, []process.Name, *process.GlobalEnvironment, error) {
 
@@ -85,16 +106,22 @@ func expandProcesses(u allEnvironment) ([]*process.Process, []process.Name, *pro
 	var assumedFreeNames []process.Name
 	var functions []process.FunctionDefinition
 	var typeDefs []types.SessionTypeDefinition
+	var globalTypes []types.GlobalTypeDefinition
 
 	// Collect all functions, types, processes and assumed names
 	for _, p := range u.procsAndFuns {
 		if p.kind == FUNCTION_DEF {
 
+			var providers []process.Name
 			if p.function.UsesExplicitProvider {
 				// Substitute any reference to the explicit provider, with the new version which contains IsSelf = true
 				p.function.Body.Substitute(p.function.ExplicitProvider, p.function.ExplicitProvider)
+				providers = []process.Name{p.function.ExplicitProvider}
 			}
 
+			// Macros on the provider can only be told apart once the providers are known
+			p.function.Body = process.ExpandMacros(p.function.Body, providers)
+
 			// Set line position
 			p.function.Position = p.position
 
@@ -104,6 +131,11 @@ func expandProcesses(u allEnvironment) ([]*process.Process, []process.Name, *pro
 			p.session_type.Position = p.position
 
 			typeDefs = append(typeDefs, p.session_type)
+		} else if p.kind == GLOBAL_DEF {
+			// Set line position
+			p.global_type.Position = p.position
+
+			globalTypes = append(globalTypes, p.global_type)
 		} else if p.kind == PROCESS_DEF {
 			// Processes may have multiple provider names:
 			// 		e.g. prc[a, b, c, d]: send self<...>
@@ -124,6 +156,9 @@ func expandProcesses(u allEnvironment) ([]*process.Process, []process.Name, *pro
 				}
 			}
 
+			// The names of a process are not references to self, so only the macros on self act on the provider
+			new_p.Body = process.ExpandMacros(new_p.Body, nil)
+
 			// Package all processes along with the types of the free names
 			processes = append(processes, new_p)
 		} else if p.kind == ASSUMING_DEF {
@@ -132,6 +167,19 @@ func expandProcesses(u allEnvironment) ([]*process.Process, []process.Name, *pro
 		}
 	}
 
+	// The functions and types defined within let blocks become global ones (with unique names)
+	globalFunctions := len(functions)
+	for i := 0; i < globalFunctions; i++ {
+		if err := process.LiftLocalDefinitions(functions[i].Body, functions[i].FunctionName, &functions, &typeDefs); err != nil {
+			return nil, nil, nil, fmt.Errorf("(%s) in function %s, %s", functions[i].Position.String(), functions[i].FunctionName, err)
+		}
+	}
+	for _, p := range processes {
+		if err := process.LiftLocalDefinitions(p.Body, p.Providers[0].Ident, &functions, &typeDefs); err != nil {
+			return nil, nil, nil, fmt.Errorf("(%s) in process %s, %s", p.Position.String(), p.OutlineString(), err)
+		}
+	}
+
 	// Process defined using the 'exec' keyword
 	execCount := 0
 	for _, p := range u.procsAndFuns {
@@ -143,13 +191,20 @@ func expandProcesses(u allEnvironment) ([]*process.Process, []process.Name, *pro
 
 			function := process.GetFunctionByNameArity(functions, functionName, 0)
 			if function == nil {
-				return nil, nil, nil, fmt.Errorf("invalid calling exec on %s()", functionName)
+				return nil, nil, nil, fmt.Errorf("invalid calling exec on %s()%s", functionName, process.SuggestFunction(functionName, 0, functions))
 			}
 			new_p := process.NewProcess(p.proc.Body, []process.Name{{Ident: fmt.Sprintf("exec%d", execCount), IsSelf: true}}, function.Type, process.LINEAR, p.position)
 			processes = append(processes, new_p)
 		}
 	}
 
+	// Each global type contributes the binary session types projected onto its pairs of roles
+	projections, err := types.ProjectGlobalTypes(globalTypes, typeDefs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	typeDefs = append(typeDefs, projections...)
+
 	// Fixes the modalities for each labelled type
 	types.SetModalityTypeDef(typeDefs)
 
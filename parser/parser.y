@@ -20,21 +20,27 @@ import (
 	name 			      process.Name
 	names 			      []process.Name
 	form 			      process.Form
-	branches 		      []*process.BranchForm
+	branches 		      []process.PatternBranch
+	pattern 		      *process.Pattern
+	patterns 		      []*process.Pattern
 	sessionType 	      types.SessionType
 	sessionTypeInitial 	  types.SessionTypeInitial
 	sessionTypeAltInitial []types.OptionInitial
 	polarity 		      types.Polarity
+	globalType 		      types.GlobalType
+	globalBranches 	      []types.GlobalBranch
 }
 
-%token LABEL LEFT_ARROW RIGHT_ARROW UP_ARROW DOWN_ARROW  EQUALS DOT SEQUENCE COLON COMMA LPAREN RPAREN LSBRACK RSBRACK LANGLE RANGLE PIPE SEND RECEIVE CASE CLOSE WAIT CAST SHIFT ACCEPT ACQUIRE DETACH RELEASE DROP SPLIT PUSH NEW SNEW TYPE LET IN END SPRC PRC FORWARD SELF PRINT PLUS MINUS TIMES AMPERSAND UNIT LCBRACK RCBRACK LOLLI PERCENTAGE ASSUMING EXEC
+%token LABEL LEFT_ARROW RIGHT_ARROW UP_ARROW DOWN_ARROW  EQUALS DOT SEQUENCE COLON COMMA LPAREN RPAREN LSBRACK RSBRACK LANGLE RANGLE PIPE SEND RECEIVE CASE CLOSE WAIT CAST SHIFT ACCEPT ACQUIRE DETACH RELEASE DROP SPLIT PUSH NEW SNEW TYPE LET IN END SPRC PRC FORWARD SELF PRINT INPUT PLUS MINUS TIMES AMPERSAND UNIT LCBRACK RCBRACK LOLLI PERCENTAGE ASSUMING EXEC QUESTION GLOBAL MESSAGE_ARROW
 %type <strval> LABEL
 %type <statements> statements 
+%type <statements> local_definitions
 %type <common_type> process_def
 %type <common_type> function_def
 %type <common_type> type_def
 %type <common_type> assuming_def
 %type <common_type> exec_def
+%type <common_type> global_def
 %type <form> expression 
 %type <name> name
 %type <name> name_with_type_ann
@@ -45,10 +51,14 @@ import (
 %type <names> names_with_type_ann
 %type <strval> modality
 %type <branches> branches
+%type <pattern> pattern
+%type <patterns> patterns
 %type <sessionType> session_type
 %type <sessionTypeAltInitial> session_type_options_init
 %type <sessionTypeInitial> session_type_init
 %type <polarity> polarity
+%type <globalType> global_type
+%type <globalBranches> global_branches
 
 %left SEQUENCE RANGLE
 %right TIMES LOLLI UP_ARROW DOWN_ARROW
@@ -68,7 +78,6 @@ program :
 		{ 
 			gritslex.(*lexer).processesOrFunctionsRes = $1
 		};
-/*	 | LET functions IN processes END { }; */
 
 /* A program may consist a combination of processes, function definitions and types */
 statements : process_def             { $$ = []unexpandedProcessOrFunction{$1} }
@@ -80,7 +89,15 @@ statements : process_def             { $$ = []unexpandedProcessOrFunction{$1} }
 		   | assuming_def 			 { $$ = []unexpandedProcessOrFunction{$1} }
 		   | assuming_def statements { $$ = append([]unexpandedProcessOrFunction{$1}, $2...) }
 		   | exec_def 			 	 { $$ = []unexpandedProcessOrFunction{$1} }
-		   | exec_def statements 	 { $$ = append([]unexpandedProcessOrFunction{$1}, $2...) };
+		   | exec_def statements 	 { $$ = append([]unexpandedProcessOrFunction{$1}, $2...) }
+		   | global_def 			 { $$ = []unexpandedProcessOrFunction{$1} }
+		   | global_def statements 	 { $$ = append([]unexpandedProcessOrFunction{$1}, $2...) };
+
+/* Functions and types defined within a let block */
+local_definitions : function_def                   { $$ = []unexpandedProcessOrFunction{$1} }
+				  | function_def local_definitions { $$ = append([]unexpandedProcessOrFunction{$1}, $2...) }
+				  | type_def                       { $$ = []unexpandedProcessOrFunction{$1} }
+				  | type_def local_definitions     { $$ = append([]unexpandedProcessOrFunction{$1}, $2...) };
 
 /* A process is defined using the prc keyword */
 process_def : 
@@ -95,18 +112,30 @@ process_def :
 /* Expressions form the core part of a program  */
 expression : /* Send */ SEND name LANGLE name COMMA name RANGLE  
 					{ $$ = process.NewSend($2, $4, $6) }
-		   /* Send Macro */ /* SEND name LANGLE name COMMA name RANGLE SEQUENCE expression
-			{ $$ = NewSendMacro($2, $4, $6, $9) }*/
+		   | /* Send Macro */ SEND name LANGLE name RANGLE SEQUENCE expression
+					{ $$ = process.NewSendMacro($2, $4, $7) }
+		   | /* N-ary Send */ SEND name LANGLE name COMMA name COMMA names RANGLE
+					{ $$ = process.NewSendTuple($2, append([]process.Name{$4, $6}, $8...)) }
 		   | /* Receive */ LANGLE name COMMA name RANGLE LEFT_ARROW RECEIVE name SEQUENCE expression 
 		   			{ $$ = process.NewReceive($2, $4, $8, $10) }
+		   | /* N-ary Receive */ LANGLE name COMMA name COMMA names RANGLE LEFT_ARROW RECEIVE name SEQUENCE expression
+		   			{ $$ = process.NewReceiveTuple(append([]process.Name{$2, $4}, $6...), $10, $12) }
+		   | /* Receive Macro */ name LEFT_ARROW RECEIVE name SEQUENCE expression
+		   			{ $$ = process.NewReceiveMacro($1, $4, $6) }
 		   | /* Select */ name DOT LABEL LANGLE name RANGLE 
-		   			{ $$ = process.NewSelect($1, process.Label{L: $3}, $5) }
+		   			{ $$ = process.NewSelect($1, process.Label{L: $3, Position: $<currPosition>3}, $5) }
+		   | /* Select Macro */ name DOT LABEL SEQUENCE expression
+		   			{ $$ = process.NewSelectMacro($1, process.Label{L: $3, Position: $<currPosition>3}, $5) }
 		   | /* Case */ CASE name LPAREN branches RPAREN 
-		   			{ $$ = process.NewCase($2, $4) }
+		   			{ p, err := process.NewPatternCase($2, $4)
+		   			  if err != nil {
+		   			      gritslex.Error(err.Error())
+		   			  }
+		   			  $$ = p }
 		   | /* New */ name LEFT_ARROW NEW expression SEQUENCE expression 
 					{ $$ = process.NewNew($1, $4, $6) } 
 		   | /* New */ LABEL COLON session_type LEFT_ARROW NEW expression SEQUENCE expression 
-					{ $$ = process.NewNew(process.Name{Ident: $1, Type: $3, IsSelf: false}, $6, $8) } 		   
+					{ $$ = process.NewNew(process.Name{Ident: $1, Type: $3, IsSelf: false, Position: $<currPosition>1}, $6, $8) } 		   
 		   | /* Call */ LABEL LPAREN optional_names RPAREN
 		   			{ $$ = process.NewCall($1, $3) }
 		   | /* Close */ CLOSE name
@@ -126,13 +155,29 @@ expression : /* Send */ SEND name LANGLE name COMMA name RANGLE
 		   | /* Brackets */ LPAREN expression RPAREN
 					{ $$ = $2 }
 		   | /* Print - for output */ PRINT LABEL SEQUENCE expression
-		   			{ $$ = process.NewPrint(process.Label{L: $2}, $4) };
+		   			{ $$ = process.NewPrint(process.Label{L: $2, Position: $<currPosition>2}, $4) }
+		   | /* Print value - consumes the name */ PRINT LPAREN name RPAREN SEQUENCE expression
+		   			{ $$ = process.NewPrintValue($3, $6) }
+		   | /* Input - read from stdin */ INPUT name
+		   			{ $$ = process.NewInput($2) }
+		   | /* Hole - to be filled in */ QUESTION
+		   			{ $$ = process.NewHole($<currPosition>1) }
+		   | /* Local definitions */ LET local_definitions IN expression END
+		   			{ $$ = newLet($2, $4, $<currPosition>1, $<currPosition>3, $<currPosition>5) };
 /* remaining expressions - used for shared processes
 	SNew, Acquire, Accept, Push, Detach, Release*/
  
-branches :   /* empty */         										 { $$ = nil }
-         |               LABEL LANGLE name RANGLE RIGHT_ARROW expression { $$ = []*process.BranchForm{process.NewBranch(process.Label{L: $1}, $3, $6)} }
-         | branches PIPE LABEL LANGLE name RANGLE RIGHT_ARROW expression { $$ = append($1, process.NewBranch(process.Label{L: $3}, $5, $8)) };
+branches :   /* empty */         										 	{ $$ = nil }
+         |               LABEL LANGLE pattern RANGLE RIGHT_ARROW expression { $$ = []process.PatternBranch{{Label: process.Label{L: $1, Position: $<currPosition>1}, Pattern: $3, Continuation: $6}} }
+         | branches PIPE LABEL LANGLE pattern RANGLE RIGHT_ARROW expression { $$ = append($1, process.PatternBranch{Label: process.Label{L: $3, Position: $<currPosition>3}, Pattern: $5, Continuation: $8}) };
+
+/* Patterns matched by a branch, e.g. succ<succ<x>> or cons<<h, t>> */
+pattern : name 								{ $$ = process.NamePattern($1) }
+		| LANGLE pattern COMMA patterns RANGLE 	{ $$ = process.TuplePattern(append([]*process.Pattern{$2}, $4...)) }
+		| LABEL LANGLE pattern RANGLE 		{ $$ = process.LabelPattern(process.Label{L: $1, Position: $<currPosition>1}, $3) };
+
+patterns : pattern 					{ $$ = []*process.Pattern{$1} }
+		 | pattern COMMA patterns 	{ $$ = append([]*process.Pattern{$1}, $3...) };
 
 names : name { $$ = []process.Name{$1} }
  	  | name COMMA names { $$ = append([]process.Name{$1}, $3...) };
@@ -158,18 +203,18 @@ names_with_type_ann :
 name_with_type_ann : 
 			/* without type - todo remove option to force types */
 			LABEL
-					{ $$ = process.Name{Ident: $1, IsSelf: false} }
+					{ $$ = process.Name{Ident: $1, IsSelf: false, Position: $<currPosition>1} }
 			| LABEL COLON session_type 
-			 		{ $$ = process.Name{Ident: $1, Type: $3, IsSelf: false} };
+			 		{ $$ = process.Name{Ident: $1, Type: $3, IsSelf: false, Position: $<currPosition>1} };
 
-name : SELF { $$ = process.Name{IsSelf: true} }
+name : SELF { $$ = process.Name{IsSelf: true, Position: $<currPosition>1} }
 	 | polarity SELF  
 		{ pol := $1
-		  $$ = process.Name{IsSelf: true, ExplicitPolarity: &pol} }
-	 | LABEL { $$ = process.Name{Ident: $1, IsSelf: false} }
+		  $$ = process.Name{IsSelf: true, ExplicitPolarity: &pol, Position: $<currPosition>2} }
+	 | LABEL { $$ = process.Name{Ident: $1, IsSelf: false, Position: $<currPosition>1} }
 	 | polarity LABEL
 		{ pol := $1
-		  $$ = process.Name{Ident: $2, IsSelf: false, ExplicitPolarity: &pol} };
+		  $$ = process.Name{Ident: $2, IsSelf: false, ExplicitPolarity: &pol, Position: $<currPosition>2} };
 
 assuming_def : ASSUMING names_with_type_ann
 			{ $$ = unexpandedProcessOrFunction{kind: ASSUMING_DEF, assumedFreeNameTypes: $2, position: gritsVAL.currPosition} };
@@ -188,7 +233,7 @@ function_def :
 								Parameters: $5, 
 								Body: $8, 
 								UsesExplicitProvider: true, 
-								ExplicitProvider: process.Name{Ident: $4, IsSelf: true}, 
+								ExplicitProvider: process.Name{Ident: $4, IsSelf: true, Position: $<currPosition>4}, 
 								// Type: $6,
 								}, position: gritsVAL.currPosition} }
 			| /* explicit provider name :  with type annotation */
@@ -199,7 +244,7 @@ function_def :
 								Parameters: $7, 
 								Body: $10, 
 								UsesExplicitProvider: true, 
-								ExplicitProvider: process.Name{Ident: $4, IsSelf: true}, 
+								ExplicitProvider: process.Name{Ident: $4, IsSelf: true, Position: $<currPosition>4}, 
 								Type: $6}, position: gritsVAL.currPosition} };
 
 type_def : TYPE LABEL EQUALS session_type
@@ -208,6 +253,32 @@ type_def : TYPE LABEL EQUALS session_type
 						session_type: types.SessionTypeDefinition{Name: $2, SessionType: $4},
 						position: gritsVAL.currPosition} };
 
+/* A global type describes the interaction between multiple roles */
+global_def : GLOBAL LABEL EQUALS global_type
+			{ $$ = unexpandedProcessOrFunction{
+						kind: GLOBAL_DEF, 
+						global_type: types.GlobalTypeDefinition{Name: $2, GlobalType: $4},
+						position: gritsVAL.currPosition} };
+
+global_type : /* interaction a -> b { } */ LABEL MESSAGE_ARROW LABEL LCBRACK global_branches RCBRACK
+				{ $$ = types.NewGlobalInteraction($1, $3, $5) }
+			| /* end */ END
+				{ $$ = types.NewGlobalEnd() }
+			| /* reference to a global type */ LABEL
+				{ $$ = types.NewGlobalReference($1) }
+			| /* brackets (G) */ LPAREN global_type RPAREN
+				{ $$ = $2 };
+
+global_branches : 
+			/* label : G */ LABEL COLON global_type
+				{ $$ = []types.GlobalBranch{*types.NewGlobalBranch($1, nil, $3)} }
+		  | /* label(A) : G */ LABEL LPAREN session_type RPAREN COLON global_type
+				{ $$ = []types.GlobalBranch{*types.NewGlobalBranch($1, $3, $6)} }
+		  | LABEL COLON global_type COMMA global_branches
+				{ $$ = append([]types.GlobalBranch{*types.NewGlobalBranch($1, nil, $3)}, $5...) }
+		  | LABEL LPAREN session_type RPAREN COLON global_type COMMA global_branches
+				{ $$ = append([]types.GlobalBranch{*types.NewGlobalBranch($1, $3, $6)}, $8...) };
+
 /* Returns a SessionType struct */
 session_type : /* no explicit mode */ session_type_init
 					{ $$ = types.ConvertSessionTypeInitialToSessionType($1)}
@@ -221,10 +292,16 @@ session_type_init :
 				{ $$ = types.NewLabelTypeInitial($1) }
 		   | /* unit */ UNIT
 		   		{ $$ = types.NewUnitTypeInitial() }
+		   | /* dynamic ? (gradual typing) */ QUESTION
+		   		{ $$ = types.NewDynamicTypeInitial() }
 		   | /* select +{ } */ PLUS LCBRACK session_type_options_init RCBRACK  
 		   		{ $$ = types.NewSelectLabelTypeInitial($3) }
 		   | /* branch &{ } */ AMPERSAND LCBRACK session_type_options_init RCBRACK  
 		   		{ $$ = types.NewBranchCaseTypeInitial($3) }
+		   | /* empty select +{} */ PLUS LCBRACK RCBRACK
+		   		{ $$ = types.NewSelectLabelTypeInitial(nil) }
+		   | /* empty branch &{} */ AMPERSAND LCBRACK RCBRACK
+		   		{ $$ = types.NewBranchCaseTypeInitial(nil) }
 		   | /* send A * B */ session_type_init TIMES session_type_init
 		   		{ $$ = types.NewSendTypeInitial($1, $3) }
 		   | /* receive A -o B */ session_type_init LOLLI session_type_init
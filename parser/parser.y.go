@@ -27,11 +27,15 @@ type gritsSymType struct {
 	name                  process.Name
 	names                 []process.Name
 	form                  process.Form
-	branches              []*process.BranchForm
+	branches              []process.PatternBranch
+	pattern               *process.Pattern
+	patterns              []*process.Pattern
 	sessionType           types.SessionType
 	sessionTypeInitial    types.SessionTypeInitial
 	sessionTypeAltInitial []types.OptionInitial
 	polarity              types.Polarity
+	globalType            types.GlobalType
+	globalBranches        []types.GlobalBranch
 }
 
 const LABEL = 57346
@@ -76,17 +80,21 @@ const PRC = 57384
 const FORWARD = 57385
 const SELF = 57386
 const PRINT = 57387
-const PLUS = 57388
-const MINUS = 57389
-const TIMES = 57390
-const AMPERSAND = 57391
-const UNIT = 57392
-const LCBRACK = 57393
-const RCBRACK = 57394
-const LOLLI = 57395
-const PERCENTAGE = 57396
-const ASSUMING = 57397
-const EXEC = 57398
+const INPUT = 57388
+const PLUS = 57389
+const MINUS = 57390
+const TIMES = 57391
+const AMPERSAND = 57392
+const UNIT = 57393
+const LCBRACK = 57394
+const RCBRACK = 57395
+const LOLLI = 57396
+const PERCENTAGE = 57397
+const ASSUMING = 57398
+const EXEC = 57399
+const QUESTION = 57400
+const GLOBAL = 57401
+const MESSAGE_ARROW = 57402
 
 var gritsToknames = [...]string{
 	"$end",
@@ -134,6 +142,7 @@ var gritsToknames = [...]string{
 	"FORWARD",
 	"SELF",
 	"PRINT",
+	"INPUT",
 	"PLUS",
 	"MINUS",
 	"TIMES",
@@ -145,6 +154,9 @@ var gritsToknames = [...]string{
 	"PERCENTAGE",
 	"ASSUMING",
 	"EXEC",
+	"QUESTION",
+	"GLOBAL",
+	"MESSAGE_ARROW",
 }
 
 var gritsStatenames = [...]string{}
@@ -153,7 +165,7 @@ const gritsEofCode = 1
 const gritsErrCode = 2
 const gritsInitialStackSize = 16
 
-//line parser/parser.y:261
+//line parser/parser.y:338
 
 // Parse is the entry point to the parser.
 func Parse(r io.Reader) (allEnvironment, error) {
@@ -175,149 +187,189 @@ var gritsExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
-	-1, 69,
-	4, 72,
-	7, 72,
-	8, 72,
-	14, 72,
-	46, 72,
-	49, 72,
-	50, 72,
-	-2, 61,
+	-1, 83,
+	4, 104,
+	7, 104,
+	8, 104,
+	14, 104,
+	47, 104,
+	50, 104,
+	51, 104,
+	58, 104,
+	-2, 90,
 }
 
 const gritsPrivate = 57344
 
-const gritsLast = 270
-
-var gritsAct = [...]uint8{
-	3, 136, 147, 57, 98, 67, 115, 82, 66, 99,
-	162, 103, 160, 9, 105, 56, 44, 104, 64, 52,
-	130, 195, 175, 15, 171, 188, 63, 6, 139, 152,
-	5, 7, 8, 10, 12, 13, 174, 31, 33, 172,
-	36, 14, 39, 40, 41, 42, 43, 32, 141, 68,
-	173, 123, 11, 22, 16, 29, 30, 26, 25, 51,
-	98, 98, 24, 127, 93, 99, 99, 129, 128, 92,
-	75, 78, 76, 69, 100, 27, 28, 60, 109, 106,
-	111, 192, 112, 73, 168, 83, 53, 22, 116, 29,
-	30, 113, 90, 91, 145, 118, 94, 120, 68, 84,
-	68, 85, 121, 119, 131, 132, 107, 138, 80, 37,
-	110, 38, 89, 133, 135, 71, 140, 65, 72, 70,
-	148, 149, 144, 149, 124, 165, 153, 154, 166, 4,
-	146, 157, 142, 122, 114, 143, 199, 108, 87, 158,
-	83, 159, 61, 163, 161, 88, 83, 46, 47, 48,
-	49, 50, 164, 116, 150, 68, 170, 169, 194, 193,
-	68, 156, 167, 176, 126, 125, 179, 177, 81, 79,
-	77, 35, 182, 190, 181, 180, 34, 187, 68, 189,
-	178, 86, 191, 101, 102, 202, 186, 196, 151, 97,
-	197, 198, 137, 58, 200, 201, 155, 9, 134, 117,
-	203, 96, 62, 204, 183, 184, 185, 15, 59, 55,
-	54, 6, 45, 2, 5, 1, 8, 10, 12, 13,
-	23, 95, 74, 69, 21, 14, 101, 102, 20, 19,
-	26, 25, 18, 73, 17, 24, 11, 22, 16, 29,
-	30, 0, 0, 0, 0, 0, 0, 0, 27, 28,
-	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 71, 0, 0, 72, 70,
+const gritsLast = 356
+
+var gritsAct = [...]int16{
+	7, 250, 146, 3, 258, 168, 35, 37, 68, 40,
+	186, 43, 44, 45, 46, 47, 80, 180, 52, 48,
+	137, 259, 103, 82, 245, 69, 264, 206, 81, 53,
+	120, 29, 57, 204, 235, 121, 28, 127, 126, 177,
+	98, 65, 162, 90, 255, 91, 170, 221, 173, 9,
+	30, 31, 97, 32, 29, 57, 170, 271, 222, 15,
+	120, 248, 260, 6, 227, 121, 5, 104, 8, 10,
+	12, 13, 256, 252, 110, 111, 112, 14, 114, 22,
+	113, 64, 120, 49, 21, 101, 102, 121, 11, 26,
+	16, 17, 33, 34, 132, 172, 131, 225, 133, 56,
+	134, 219, 136, 18, 55, 169, 125, 218, 75, 159,
+	223, 122, 125, 77, 160, 143, 157, 128, 195, 175,
+	158, 76, 142, 116, 144, 138, 153, 83, 224, 56,
+	115, 104, 152, 171, 55, 56, 56, 88, 161, 93,
+	55, 55, 104, 73, 125, 125, 147, 165, 167, 163,
+	164, 188, 187, 174, 104, 211, 149, 150, 192, 193,
+	194, 66, 198, 151, 185, 140, 201, 99, 108, 100,
+	86, 200, 54, 87, 84, 280, 191, 265, 217, 266,
+	207, 85, 148, 197, 178, 199, 145, 214, 210, 125,
+	135, 125, 129, 220, 202, 95, 203, 36, 212, 50,
+	198, 215, 41, 213, 42, 29, 57, 285, 138, 51,
+	79, 26, 230, 279, 33, 34, 181, 182, 272, 242,
+	104, 226, 237, 238, 182, 198, 231, 179, 198, 125,
+	233, 141, 244, 130, 229, 106, 247, 26, 249, 4,
+	33, 34, 236, 198, 208, 257, 240, 209, 283, 243,
+	262, 261, 183, 74, 205, 184, 269, 267, 268, 107,
+	58, 59, 60, 61, 62, 63, 276, 273, 274, 254,
+	253, 228, 189, 198, 277, 9, 176, 278, 156, 155,
+	281, 282, 270, 275, 154, 15, 284, 286, 96, 6,
+	94, 92, 5, 38, 8, 10, 12, 13, 39, 263,
+	246, 83, 234, 14, 123, 124, 241, 232, 29, 19,
+	109, 88, 105, 28, 11, 26, 16, 17, 33, 34,
+	123, 124, 239, 190, 119, 251, 170, 30, 31, 18,
+	32, 216, 70, 196, 166, 139, 118, 54, 78, 72,
+	71, 67, 2, 1, 86, 27, 117, 87, 84, 89,
+	25, 24, 23, 20, 0, 85,
 }
 
 var gritsPact = [...]int16{
-	193, -1000, -1000, -1000, -1000, 43, 43, 166, 43, 97,
-	43, 43, 43, 43, 43, 9, 208, 20, 20, 20,
-	20, 20, -1000, 15, 70, 206, 205, 189, 204, -1000,
-	-1000, 59, -1000, 129, 198, -9, 103, 69, 43, -1000,
-	43, 159, 53, 158, 93, 157, -1000, -1000, -1000, -1000,
-	-1000, -1000, -1000, 43, 85, 172, -1000, 125, 133, 98,
-	43, 43, 51, 9, 43, 197, 184, -44, 219, -1000,
-	-1000, -34, -37, 69, 91, 124, -1000, 9, 43, 9,
-	-1000, 9, 74, 121, 189, 195, 69, 189, 69, 87,
-	120, 32, 43, 154, 153, 48, 49, -15, 69, 69,
-	-44, 194, 194, 176, 188, 188, 13, -1000, 43, -1000,
-	29, -1000, -1000, 123, 43, 79, 117, 108, -1000, -1000,
-	-1000, -1000, 43, 183, 10, 9, 9, -1000, 192, 43,
-	9, -44, -44, 69, -1000, 69, -40, 132, -42, -1000,
-	-1000, -1000, 9, 69, -1000, 116, 189, 67, 69, 189,
-	5, 17, -1000, -1000, -1000, 18, 3, 152, -44, -44,
-	-1000, 69, -1000, -1000, 171, 9, 69, -1000, 165, 110,
-	-1000, -1000, 43, 43, 43, 180, 9, 12, 9, -1000,
-	164, 9, 64, 148, 147, 2, 9, -1000, 188, -1000,
-	9, -1000, 127, 9, 9, 179, -1000, -1000, -1000, 9,
-	-1000, -1000, 9, -1000, -1000,
+	271, -1000, -1000, -1000, -1000, 193, 193, 288, 193, 190,
+	193, 193, 193, 193, 193, 45, 195, 193, -1000, 168,
+	-6, -6, -6, -6, -6, -6, -1000, 37, 145, 337,
+	328, 336, 335, -1000, -1000, 125, -1000, 240, 86, 334,
+	196, 123, 193, -1000, 193, 280, 121, 279, 180, 17,
+	277, 193, -1000, 1, 153, 17, 17, 333, -1000, -1000,
+	-1000, -1000, -1000, -1000, -1000, -1000, 193, 303, -1000, 222,
+	247, 154, 301, 193, 193, 193, 45, 193, 112, 332,
+	319, -19, 297, -1000, -1000, -1000, -14, -15, 123, 177,
+	220, -1000, 45, 193, 45, -1000, 45, 175, 45, 328,
+	331, -1000, -1000, 148, 218, 123, 328, 123, 171, 142,
+	144, 113, 273, 268, 267, 193, 45, 94, 120, 7,
+	123, 123, -19, 330, 330, 313, 52, 42, 33, -1000,
+	193, -1000, 100, -1000, -1000, 265, -1, 169, 214, 204,
+	243, 193, -1000, -1000, -1000, -1000, -1000, -50, -1000, 142,
+	193, 261, 318, 193, 45, 45, 45, 99, -1000, -1000,
+	329, 167, 45, -19, -19, 123, -1000, 123, -20, -1000,
+	242, -26, -1000, -1000, -1000, -1000, 45, -1000, 235, 328,
+	138, 123, 328, 45, 123, -1000, 327, 163, 88, 45,
+	25, 91, -1000, -1000, -1000, -1000, 110, 78, -1000, 167,
+	46, 260, -19, -19, -1000, 123, -1000, -1000, 45, 123,
+	-1000, 298, 211, -1000, -1000, 293, -18, -1000, -1000, 193,
+	-1000, 193, 193, 317, 167, 300, 206, 167, 45, 11,
+	-1000, 291, 45, 44, 45, 321, 54, 259, 258, 22,
+	53, 45, 167, 43, -1000, 322, 45, -1000, 290, -1000,
+	-27, 165, -1000, 45, 45, 193, 276, -1000, 38, 205,
+	-1000, -1000, -1000, 45, -1000, 142, 123, -1000, -1000, 255,
+	45, -1000, 167, -1000, 200, 160, 45, -1000, -1000, 321,
+	236, -1000, -1000, 142, 194, 321, -1000,
 }
 
-var gritsPgo = [...]uint8{
-	0, 129, 234, 232, 229, 228, 224, 0, 31, 3,
-	7, 222, 6, 2, 15, 11, 221, 8, 1, 5,
-	220, 215, 213,
+var gritsPgo = [...]int16{
+	0, 239, 29, 353, 84, 79, 352, 351, 350, 3,
+	0, 25, 22, 349, 20, 17, 8, 23, 346, 21,
+	4, 16, 5, 28, 345, 2, 1, 343, 342,
 }
 
 var gritsR1 = [...]int8{
-	0, 21, 22, 22, 1, 1, 1, 1, 1, 1,
-	1, 1, 1, 1, 2, 2, 7, 7, 7, 7,
-	7, 7, 7, 7, 7, 7, 7, 7, 7, 7,
-	7, 7, 16, 16, 16, 10, 10, 11, 11, 11,
-	12, 12, 12, 13, 13, 14, 14, 9, 9, 8,
-	8, 8, 8, 5, 3, 3, 3, 3, 4, 17,
-	17, 19, 19, 19, 19, 19, 19, 19, 19, 19,
-	18, 18, 15, 20, 20, 6,
+	0, 27, 28, 28, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 2, 2, 2, 2,
+	3, 3, 9, 9, 9, 9, 9, 9, 9, 9,
+	9, 9, 9, 9, 9, 9, 9, 9, 9, 9,
+	9, 9, 9, 9, 9, 9, 9, 18, 18, 18,
+	19, 19, 19, 20, 20, 12, 12, 13, 13, 13,
+	14, 14, 14, 15, 15, 16, 16, 11, 11, 10,
+	10, 10, 10, 6, 4, 4, 4, 4, 5, 8,
+	25, 25, 25, 25, 26, 26, 26, 26, 21, 21,
+	23, 23, 23, 23, 23, 23, 23, 23, 23, 23,
+	23, 23, 22, 22, 17, 24, 24, 7,
 }
 
 var gritsR2 = [...]int8{
 	0, 1, 1, 1, 1, 2, 1, 2, 1, 2,
-	1, 2, 1, 2, 6, 8, 7, 10, 6, 5,
-	6, 8, 4, 2, 3, 10, 4, 5, 6, 4,
-	3, 4, 0, 6, 8, 1, 3, 0, 1, 3,
+	1, 2, 1, 2, 1, 2, 1, 2, 1, 2,
+	6, 8, 7, 7, 9, 10, 12, 6, 6, 5,
+	5, 6, 8, 4, 2, 3, 10, 4, 5, 6,
+	4, 3, 4, 6, 2, 1, 5, 0, 6, 8,
+	1, 5, 4, 1, 3, 1, 3, 0, 1, 3,
 	0, 1, 3, 0, 2, 1, 3, 1, 3, 1,
-	2, 1, 2, 2, 7, 9, 8, 10, 4, 1,
-	2, 1, 1, 4, 4, 3, 3, 3, 4, 4,
-	3, 5, 1, 1, 1, 4,
+	2, 1, 2, 2, 7, 9, 8, 10, 4, 4,
+	6, 1, 1, 3, 3, 6, 5, 8, 1, 2,
+	1, 1, 1, 4, 4, 3, 3, 3, 3, 3,
+	4, 4, 3, 5, 1, 1, 1, 4,
 }
 
 var gritsChk = [...]int16{
-	-1000, -21, -22, -7, -1, 21, 18, -8, 23, 4,
-	24, 43, 25, 26, 32, 14, 45, -2, -3, -4,
-	-5, -6, 44, -20, 42, 38, 37, 55, 56, 46,
-	47, -8, 4, -8, 10, 5, -8, 12, 14, -8,
-	-8, -8, -8, -8, -7, 4, -1, -1, -1, -1,
-	-1, 44, 4, 16, 4, 4, -14, -9, 4, 4,
-	18, 13, 4, 35, 27, 14, -17, -19, -15, 4,
-	50, 46, 49, 14, -11, -8, -8, 11, 18, 11,
-	15, 11, -10, -8, 14, 16, 9, 13, 12, 14,
-	-8, -8, 18, -7, -8, -16, 4, 5, 48, 53,
-	-19, 7, 8, -15, 51, 51, -19, 15, 13, -7,
-	-8, -7, -7, 17, 13, -12, -9, 4, -17, -14,
-	-17, 15, 13, 19, -8, 11, 11, 15, 20, 18,
-	35, -19, -19, -15, 4, -15, -18, 4, -18, 15,
-	-10, 19, 9, 12, -10, 15, 13, -13, 12, 13,
-	-8, 5, 19, -7, -7, 4, -8, -7, -19, -19,
-	52, 12, 52, -7, -17, 9, 12, -14, 17, -17,
-	-12, 19, 22, 33, 18, 19, 11, -19, 9, -7,
-	-17, 9, -13, -8, -8, -8, 6, -7, 13, -7,
-	9, -7, 17, 11, 11, 19, -7, -18, -7, 9,
-	-7, -7, 6, -7, -7,
+	-1000, -27, -28, -9, -1, 21, 18, -10, 23, 4,
+	24, 43, 25, 26, 32, 14, 45, 46, 58, 38,
+	-3, -4, -5, -6, -7, -8, 44, -24, 42, 37,
+	56, 57, 59, 47, 48, -10, 4, -10, 5, 10,
+	-10, 12, 14, -10, -10, -10, -10, -10, -9, 38,
+	4, 14, -10, -2, 4, -4, -5, 38, -1, -1,
+	-1, -1, -1, -1, 44, 4, 16, 4, -16, -11,
+	4, 4, 4, 18, 13, 22, 35, 27, 4, 14,
+	-21, -23, -17, 4, 51, 58, 47, 50, 14, -13,
+	-10, -10, 11, 18, 11, 15, 11, -10, 39, 14,
+	16, -2, -2, -12, -10, 9, 13, 12, 14, 9,
+	-10, -10, -10, -9, -10, 18, 11, -18, 4, 5,
+	49, 54, -23, 7, 8, -17, 52, 52, -23, 15,
+	13, -9, -10, -9, -9, 15, -9, -14, -11, 4,
+	17, 13, -21, -16, -21, 15, -25, 4, 40, 14,
+	13, 19, 19, 13, 11, 11, 11, -10, -9, 15,
+	20, 18, 35, -23, -23, -17, 4, -17, -22, 53,
+	4, -22, 53, 15, -12, 19, 11, 40, 15, 13,
+	-15, 12, 13, 9, 12, -12, 60, -25, -10, 11,
+	5, -12, -9, -9, -9, 19, 4, -19, -10, 18,
+	4, -9, -23, -23, 53, 12, 53, -9, 9, 12,
+	-16, 17, -21, -14, -9, -21, 4, 15, 19, 13,
+	-9, 22, 33, 19, 18, 19, -19, 18, 11, -23,
+	-9, -21, 9, -15, 9, 52, -12, -10, -10, 5,
+	-19, 6, 13, -19, -9, 13, 9, -9, 17, -9,
+	-26, 4, 19, 11, 11, 22, 19, -9, -20, -19,
+	19, -22, -9, 9, 53, 12, 14, -9, -9, -10,
+	6, 19, 13, -9, -25, -21, 11, -9, -20, 13,
+	15, -9, -26, 12, -25, 13, -26,
 }
 
 var gritsDef = [...]int8{
-	0, -2, 1, 2, 3, 0, 0, 0, 0, 51,
-	0, 0, 0, 0, 0, 0, 0, 4, 6, 8,
-	10, 12, 49, 0, 0, 0, 0, 0, 0, 73,
-	74, 0, 51, 0, 0, 0, 0, 0, 37, 23,
-	0, 0, 0, 0, 0, 0, 5, 7, 9, 11,
-	13, 50, 52, 0, 0, 0, 53, 45, 47, 0,
-	0, 0, 0, 0, 0, 32, 0, 59, 0, -2,
-	62, 0, 0, 0, 0, 38, 24, 0, 0, 0,
-	30, 0, 0, 35, 40, 0, 0, 0, 0, 0,
+	0, -2, 1, 2, 3, 0, 0, 0, 0, 71,
+	0, 0, 0, 0, 0, 0, 0, 0, 45, 0,
+	4, 6, 8, 10, 12, 14, 69, 0, 0, 0,
+	0, 0, 0, 105, 106, 0, 71, 0, 0, 0,
+	0, 0, 57, 34, 0, 0, 0, 0, 0, 0,
+	0, 0, 44, 0, 0, 16, 18, 0, 5, 7,
+	9, 11, 13, 15, 70, 72, 0, 0, 73, 65,
+	67, 0, 0, 0, 0, 0, 0, 0, 0, 47,
+	0, 88, 0, -2, 91, 92, 0, 0, 0, 0,
+	58, 35, 0, 0, 0, 41, 0, 0, 0, 60,
+	0, 17, 19, 0, 55, 0, 0, 0, 0, 0,
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	60, 0, 0, 0, 0, 0, 0, 22, 0, 26,
-	0, 29, 31, 0, 0, 0, 41, 43, 58, 46,
-	48, 75, 0, 0, 0, 0, 0, 19, 0, 0,
-	0, 65, 66, 0, 72, 0, 0, 0, 0, 67,
-	39, 27, 0, 0, 36, 0, 0, 0, 0, 40,
-	0, 0, 18, 20, 28, 0, 0, 0, 68, 69,
-	63, 0, 64, 14, 0, 0, 0, 42, 0, 43,
-	44, 16, 0, 0, 0, 0, 0, 70, 0, 54,
-	0, 0, 0, 0, 0, 0, 0, 21, 0, 15,
-	0, 56, 0, 0, 0, 0, 33, 71, 55, 0,
-	17, 25, 0, 57, 34,
+	0, 0, 89, 0, 0, 0, 0, 0, 0, 33,
+	0, 37, 0, 40, 42, 0, 0, 0, 61, 63,
+	0, 0, 78, 66, 68, 107, 79, 82, 81, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 29, 30,
+	0, 0, 0, 97, 98, 0, 104, 0, 0, 95,
+	0, 0, 96, 99, 59, 38, 0, 46, 0, 0,
+	0, 0, 60, 0, 0, 56, 0, 0, 0, 0,
+	0, 0, 27, 31, 39, 28, 0, 0, 50, 0,
+	71, 0, 100, 101, 93, 0, 94, 43, 0, 0,
+	62, 0, 63, 64, 20, 0, 0, 83, 22, 0,
+	23, 0, 0, 0, 0, 0, 0, 0, 0, 102,
+	74, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 32, 0, 0, 76, 0, 21,
+	0, 0, 24, 0, 0, 0, 0, 48, 0, 53,
+	52, 103, 75, 0, 80, 0, 0, 25, 36, 0,
+	0, 51, 0, 77, 84, 0, 0, 49, 54, 0,
+	0, 26, 86, 0, 85, 0, 87,
 }
 
 var gritsTok1 = [...]int8{
@@ -330,7 +382,7 @@ var gritsTok2 = [...]int8{
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
 	32, 33, 34, 35, 36, 37, 38, 39, 40, 41,
 	42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
-	52, 53, 54, 55, 56,
+	52, 53, 54, 55, 56, 57, 58, 59, 60,
 }
 
 var gritsTok3 = [...]int8{
@@ -676,473 +728,672 @@ gritsdefault:
 
 	case 1:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:58
+//line parser/parser.y:68
 		{
 		}
 	case 2:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:64
+//line parser/parser.y:74
 		{
 			gritslex.(*lexer).processesOrFunctionsRes = append(gritslex.(*lexer).processesOrFunctionsRes, unexpandedProcessOrFunction{kind: PROCESS_DEF, proc: incompleteProcess{Body: gritsDollar[1].form, Providers: []process.Name{{Ident: "root", IsSelf: false}}}, position: gritsVAL.currPosition})
 		}
 	case 3:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:68
+//line parser/parser.y:78
 		{
 			gritslex.(*lexer).processesOrFunctionsRes = gritsDollar[1].statements
 		}
 	case 4:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:74
+//line parser/parser.y:83
 		{
 			gritsVAL.statements = []unexpandedProcessOrFunction{gritsDollar[1].common_type}
 		}
 	case 5:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:75
+//line parser/parser.y:84
 		{
 			gritsVAL.statements = append([]unexpandedProcessOrFunction{gritsDollar[1].common_type}, gritsDollar[2].statements...)
 		}
 	case 6:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:76
+//line parser/parser.y:85
 		{
 			gritsVAL.statements = []unexpandedProcessOrFunction{gritsDollar[1].common_type}
 		}
 	case 7:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:77
+//line parser/parser.y:86
 		{
 			gritsVAL.statements = append([]unexpandedProcessOrFunction{gritsDollar[1].common_type}, gritsDollar[2].statements...)
 		}
 	case 8:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:78
+//line parser/parser.y:87
 		{
 			gritsVAL.statements = []unexpandedProcessOrFunction{gritsDollar[1].common_type}
 		}
 	case 9:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:79
+//line parser/parser.y:88
 		{
 			gritsVAL.statements = append([]unexpandedProcessOrFunction{gritsDollar[1].common_type}, gritsDollar[2].statements...)
 		}
 	case 10:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:80
+//line parser/parser.y:89
 		{
 			gritsVAL.statements = []unexpandedProcessOrFunction{gritsDollar[1].common_type}
 		}
 	case 11:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:81
+//line parser/parser.y:90
 		{
 			gritsVAL.statements = append([]unexpandedProcessOrFunction{gritsDollar[1].common_type}, gritsDollar[2].statements...)
 		}
 	case 12:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:82
+//line parser/parser.y:91
 		{
 			gritsVAL.statements = []unexpandedProcessOrFunction{gritsDollar[1].common_type}
 		}
 	case 13:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:83
+//line parser/parser.y:92
 		{
 			gritsVAL.statements = append([]unexpandedProcessOrFunction{gritsDollar[1].common_type}, gritsDollar[2].statements...)
 		}
 	case 14:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:93
+		{
+			gritsVAL.statements = []unexpandedProcessOrFunction{gritsDollar[1].common_type}
+		}
+	case 15:
+		gritsDollar = gritsS[gritspt-2 : gritspt+1]
+//line parser/parser.y:94
+		{
+			gritsVAL.statements = append([]unexpandedProcessOrFunction{gritsDollar[1].common_type}, gritsDollar[2].statements...)
+		}
+	case 16:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:97
+		{
+			gritsVAL.statements = []unexpandedProcessOrFunction{gritsDollar[1].common_type}
+		}
+	case 17:
+		gritsDollar = gritsS[gritspt-2 : gritspt+1]
+//line parser/parser.y:98
+		{
+			gritsVAL.statements = append([]unexpandedProcessOrFunction{gritsDollar[1].common_type}, gritsDollar[2].statements...)
+		}
+	case 18:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:99
+		{
+			gritsVAL.statements = []unexpandedProcessOrFunction{gritsDollar[1].common_type}
+		}
+	case 19:
+		gritsDollar = gritsS[gritspt-2 : gritspt+1]
+//line parser/parser.y:100
+		{
+			gritsVAL.statements = append([]unexpandedProcessOrFunction{gritsDollar[1].common_type}, gritsDollar[2].statements...)
+		}
+	case 20:
 		gritsDollar = gritsS[gritspt-6 : gritspt+1]
-//line parser/parser.y:89
+//line parser/parser.y:106
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{kind: PROCESS_DEF, proc: incompleteProcess{Body: gritsDollar[6].form, Providers: gritsDollar[3].names}, position: gritsVAL.currPosition}
 		}
-	case 15:
+	case 21:
 		gritsDollar = gritsS[gritspt-8 : gritspt+1]
-//line parser/parser.y:91
+//line parser/parser.y:108
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{kind: PROCESS_DEF, proc: incompleteProcess{Body: gritsDollar[8].form, Type: gritsDollar[6].sessionType, Providers: gritsDollar[3].names}, position: gritsVAL.currPosition}
 		}
-	case 16:
+	case 22:
 		gritsDollar = gritsS[gritspt-7 : gritspt+1]
-//line parser/parser.y:97
+//line parser/parser.y:114
 		{
 			gritsVAL.form = process.NewSend(gritsDollar[2].name, gritsDollar[4].name, gritsDollar[6].name)
 		}
-	case 17:
+	case 23:
+		gritsDollar = gritsS[gritspt-7 : gritspt+1]
+//line parser/parser.y:116
+		{
+			gritsVAL.form = process.NewSendMacro(gritsDollar[2].name, gritsDollar[4].name, gritsDollar[7].form)
+		}
+	case 24:
+		gritsDollar = gritsS[gritspt-9 : gritspt+1]
+//line parser/parser.y:118
+		{
+			gritsVAL.form = process.NewSendTuple(gritsDollar[2].name, append([]process.Name{gritsDollar[4].name, gritsDollar[6].name}, gritsDollar[8].names...))
+		}
+	case 25:
 		gritsDollar = gritsS[gritspt-10 : gritspt+1]
-//line parser/parser.y:101
+//line parser/parser.y:120
 		{
 			gritsVAL.form = process.NewReceive(gritsDollar[2].name, gritsDollar[4].name, gritsDollar[8].name, gritsDollar[10].form)
 		}
-	case 18:
+	case 26:
+		gritsDollar = gritsS[gritspt-12 : gritspt+1]
+//line parser/parser.y:122
+		{
+			gritsVAL.form = process.NewReceiveTuple(append([]process.Name{gritsDollar[2].name, gritsDollar[4].name}, gritsDollar[6].names...), gritsDollar[10].name, gritsDollar[12].form)
+		}
+	case 27:
 		gritsDollar = gritsS[gritspt-6 : gritspt+1]
-//line parser/parser.y:103
+//line parser/parser.y:124
 		{
-			gritsVAL.form = process.NewSelect(gritsDollar[1].name, process.Label{L: gritsDollar[3].strval}, gritsDollar[5].name)
+			gritsVAL.form = process.NewReceiveMacro(gritsDollar[1].name, gritsDollar[4].name, gritsDollar[6].form)
 		}
-	case 19:
+	case 28:
+		gritsDollar = gritsS[gritspt-6 : gritspt+1]
+//line parser/parser.y:126
+		{
+			gritsVAL.form = process.NewSelect(gritsDollar[1].name, process.Label{L: gritsDollar[3].strval, Position: gritsDollar[3].currPosition}, gritsDollar[5].name)
+		}
+	case 29:
 		gritsDollar = gritsS[gritspt-5 : gritspt+1]
-//line parser/parser.y:105
+//line parser/parser.y:128
 		{
-			gritsVAL.form = process.NewCase(gritsDollar[2].name, gritsDollar[4].branches)
+			gritsVAL.form = process.NewSelectMacro(gritsDollar[1].name, process.Label{L: gritsDollar[3].strval, Position: gritsDollar[3].currPosition}, gritsDollar[5].form)
 		}
-	case 20:
+	case 30:
+		gritsDollar = gritsS[gritspt-5 : gritspt+1]
+//line parser/parser.y:130
+		{
+			p, err := process.NewPatternCase(gritsDollar[2].name, gritsDollar[4].branches)
+			if err != nil {
+				gritslex.Error(err.Error())
+			}
+			gritsVAL.form = p
+		}
+	case 31:
 		gritsDollar = gritsS[gritspt-6 : gritspt+1]
-//line parser/parser.y:107
+//line parser/parser.y:136
 		{
 			gritsVAL.form = process.NewNew(gritsDollar[1].name, gritsDollar[4].form, gritsDollar[6].form)
 		}
-	case 21:
+	case 32:
 		gritsDollar = gritsS[gritspt-8 : gritspt+1]
-//line parser/parser.y:109
+//line parser/parser.y:138
 		{
-			gritsVAL.form = process.NewNew(process.Name{Ident: gritsDollar[1].strval, Type: gritsDollar[3].sessionType, IsSelf: false}, gritsDollar[6].form, gritsDollar[8].form)
+			gritsVAL.form = process.NewNew(process.Name{Ident: gritsDollar[1].strval, Type: gritsDollar[3].sessionType, IsSelf: false, Position: gritsDollar[1].currPosition}, gritsDollar[6].form, gritsDollar[8].form)
 		}
-	case 22:
+	case 33:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:111
+//line parser/parser.y:140
 		{
 			gritsVAL.form = process.NewCall(gritsDollar[1].strval, gritsDollar[3].names)
 		}
-	case 23:
+	case 34:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:113
+//line parser/parser.y:142
 		{
 			gritsVAL.form = process.NewClose(gritsDollar[2].name)
 		}
-	case 24:
+	case 35:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:115
+//line parser/parser.y:144
 		{
 			gritsVAL.form = process.NewForward(gritsDollar[2].name, gritsDollar[3].name)
 		}
-	case 25:
+	case 36:
 		gritsDollar = gritsS[gritspt-10 : gritspt+1]
-//line parser/parser.y:117
+//line parser/parser.y:146
 		{
 			gritsVAL.form = process.NewSplit(gritsDollar[2].name, gritsDollar[4].name, gritsDollar[8].name, gritsDollar[10].form)
 		}
-	case 26:
+	case 37:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:119
+//line parser/parser.y:148
 		{
 			gritsVAL.form = process.NewWait(gritsDollar[2].name, gritsDollar[4].form)
 		}
-	case 27:
+	case 38:
 		gritsDollar = gritsS[gritspt-5 : gritspt+1]
-//line parser/parser.y:121
+//line parser/parser.y:150
 		{
 			gritsVAL.form = process.NewCast(gritsDollar[2].name, gritsDollar[4].name)
 		}
-	case 28:
+	case 39:
 		gritsDollar = gritsS[gritspt-6 : gritspt+1]
-//line parser/parser.y:123
+//line parser/parser.y:152
 		{
 			gritsVAL.form = process.NewShift(gritsDollar[1].name, gritsDollar[4].name, gritsDollar[6].form)
 		}
-	case 29:
+	case 40:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:125
+//line parser/parser.y:154
 		{
 			gritsVAL.form = process.NewDrop(gritsDollar[2].name, gritsDollar[4].form)
 		}
-	case 30:
+	case 41:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:127
+//line parser/parser.y:156
 		{
 			gritsVAL.form = gritsDollar[2].form
 		}
-	case 31:
+	case 42:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:129
+//line parser/parser.y:158
 		{
-			gritsVAL.form = process.NewPrint(process.Label{L: gritsDollar[2].strval}, gritsDollar[4].form)
+			gritsVAL.form = process.NewPrint(process.Label{L: gritsDollar[2].strval, Position: gritsDollar[2].currPosition}, gritsDollar[4].form)
 		}
-	case 32:
+	case 43:
+		gritsDollar = gritsS[gritspt-6 : gritspt+1]
+//line parser/parser.y:160
+		{
+			gritsVAL.form = process.NewPrintValue(gritsDollar[3].name, gritsDollar[6].form)
+		}
+	case 44:
+		gritsDollar = gritsS[gritspt-2 : gritspt+1]
+//line parser/parser.y:162
+		{
+			gritsVAL.form = process.NewInput(gritsDollar[2].name)
+		}
+	case 45:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:164
+		{
+			gritsVAL.form = process.NewHole(gritsDollar[1].currPosition)
+		}
+	case 46:
+		gritsDollar = gritsS[gritspt-5 : gritspt+1]
+//line parser/parser.y:166
+		{
+			gritsVAL.form = newLet(gritsDollar[2].statements, gritsDollar[4].form, gritsDollar[1].currPosition, gritsDollar[3].currPosition, gritsDollar[5].currPosition)
+		}
+	case 47:
 		gritsDollar = gritsS[gritspt-0 : gritspt+1]
-//line parser/parser.y:133
+//line parser/parser.y:170
 		{
 			gritsVAL.branches = nil
 		}
-	case 33:
+	case 48:
 		gritsDollar = gritsS[gritspt-6 : gritspt+1]
-//line parser/parser.y:134
+//line parser/parser.y:171
 		{
-			gritsVAL.branches = []*process.BranchForm{process.NewBranch(process.Label{L: gritsDollar[1].strval}, gritsDollar[3].name, gritsDollar[6].form)}
+			gritsVAL.branches = []process.PatternBranch{{Label: process.Label{L: gritsDollar[1].strval, Position: gritsDollar[1].currPosition}, Pattern: gritsDollar[3].pattern, Continuation: gritsDollar[6].form}}
 		}
-	case 34:
+	case 49:
 		gritsDollar = gritsS[gritspt-8 : gritspt+1]
-//line parser/parser.y:135
+//line parser/parser.y:172
 		{
-			gritsVAL.branches = append(gritsDollar[1].branches, process.NewBranch(process.Label{L: gritsDollar[3].strval}, gritsDollar[5].name, gritsDollar[8].form))
+			gritsVAL.branches = append(gritsDollar[1].branches, process.PatternBranch{Label: process.Label{L: gritsDollar[3].strval, Position: gritsDollar[3].currPosition}, Pattern: gritsDollar[5].pattern, Continuation: gritsDollar[8].form})
 		}
-	case 35:
+	case 50:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:175
+		{
+			gritsVAL.pattern = process.NamePattern(gritsDollar[1].name)
+		}
+	case 51:
+		gritsDollar = gritsS[gritspt-5 : gritspt+1]
+//line parser/parser.y:176
+		{
+			gritsVAL.pattern = process.TuplePattern(append([]*process.Pattern{gritsDollar[2].pattern}, gritsDollar[4].patterns...))
+		}
+	case 52:
+		gritsDollar = gritsS[gritspt-4 : gritspt+1]
+//line parser/parser.y:177
+		{
+			gritsVAL.pattern = process.LabelPattern(process.Label{L: gritsDollar[1].strval, Position: gritsDollar[1].currPosition}, gritsDollar[3].pattern)
+		}
+	case 53:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:137
+//line parser/parser.y:179
+		{
+			gritsVAL.patterns = []*process.Pattern{gritsDollar[1].pattern}
+		}
+	case 54:
+		gritsDollar = gritsS[gritspt-3 : gritspt+1]
+//line parser/parser.y:180
+		{
+			gritsVAL.patterns = append([]*process.Pattern{gritsDollar[1].pattern}, gritsDollar[3].patterns...)
+		}
+	case 55:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:182
 		{
 			gritsVAL.names = []process.Name{gritsDollar[1].name}
 		}
-	case 36:
+	case 56:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:138
+//line parser/parser.y:183
 		{
 			gritsVAL.names = append([]process.Name{gritsDollar[1].name}, gritsDollar[3].names...)
 		}
-	case 37:
+	case 57:
 		gritsDollar = gritsS[gritspt-0 : gritspt+1]
-//line parser/parser.y:140
+//line parser/parser.y:185
 		{
 			gritsVAL.names = nil
 		}
-	case 38:
+	case 58:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:141
+//line parser/parser.y:186
 		{
 			gritsVAL.names = []process.Name{gritsDollar[1].name}
 		}
-	case 39:
+	case 59:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:142
+//line parser/parser.y:187
 		{
 			gritsVAL.names = append([]process.Name{gritsDollar[1].name}, gritsDollar[3].names...)
 		}
-	case 40:
+	case 60:
 		gritsDollar = gritsS[gritspt-0 : gritspt+1]
-//line parser/parser.y:145
+//line parser/parser.y:190
 		{
 			gritsVAL.names = nil
 		}
-	case 41:
+	case 61:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:146
+//line parser/parser.y:191
 		{
 			gritsVAL.names = []process.Name{gritsDollar[1].name}
 		}
-	case 42:
+	case 62:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:147
+//line parser/parser.y:192
 		{
 			gritsVAL.names = append([]process.Name{gritsDollar[1].name}, gritsDollar[3].names...)
 		}
-	case 43:
+	case 63:
 		gritsDollar = gritsS[gritspt-0 : gritspt+1]
-//line parser/parser.y:150
+//line parser/parser.y:195
 		{
 			gritsVAL.names = nil
 		}
-	case 44:
+	case 64:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:151
+//line parser/parser.y:196
 		{
 			gritsVAL.names = gritsDollar[2].names
 		}
-	case 45:
+	case 65:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:155
+//line parser/parser.y:200
 		{
 			gritsVAL.names = []process.Name{gritsDollar[1].name}
 		}
-	case 46:
+	case 66:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:156
+//line parser/parser.y:201
 		{
 			gritsVAL.names = append([]process.Name{gritsDollar[1].name}, gritsDollar[3].names...)
 		}
-	case 47:
+	case 67:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:161
+//line parser/parser.y:206
 		{
-			gritsVAL.name = process.Name{Ident: gritsDollar[1].strval, IsSelf: false}
+			gritsVAL.name = process.Name{Ident: gritsDollar[1].strval, IsSelf: false, Position: gritsDollar[1].currPosition}
 		}
-	case 48:
+	case 68:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:163
+//line parser/parser.y:208
 		{
-			gritsVAL.name = process.Name{Ident: gritsDollar[1].strval, Type: gritsDollar[3].sessionType, IsSelf: false}
+			gritsVAL.name = process.Name{Ident: gritsDollar[1].strval, Type: gritsDollar[3].sessionType, IsSelf: false, Position: gritsDollar[1].currPosition}
 		}
-	case 49:
+	case 69:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:165
+//line parser/parser.y:210
 		{
-			gritsVAL.name = process.Name{IsSelf: true}
+			gritsVAL.name = process.Name{IsSelf: true, Position: gritsDollar[1].currPosition}
 		}
-	case 50:
+	case 70:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:167
+//line parser/parser.y:212
 		{
 			pol := gritsDollar[1].polarity
-			gritsVAL.name = process.Name{IsSelf: true, ExplicitPolarity: &pol}
+			gritsVAL.name = process.Name{IsSelf: true, ExplicitPolarity: &pol, Position: gritsDollar[2].currPosition}
 		}
-	case 51:
+	case 71:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:169
+//line parser/parser.y:214
 		{
-			gritsVAL.name = process.Name{Ident: gritsDollar[1].strval, IsSelf: false}
+			gritsVAL.name = process.Name{Ident: gritsDollar[1].strval, IsSelf: false, Position: gritsDollar[1].currPosition}
 		}
-	case 52:
+	case 72:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:171
+//line parser/parser.y:216
 		{
 			pol := gritsDollar[1].polarity
-			gritsVAL.name = process.Name{Ident: gritsDollar[2].strval, IsSelf: false, ExplicitPolarity: &pol}
+			gritsVAL.name = process.Name{Ident: gritsDollar[2].strval, IsSelf: false, ExplicitPolarity: &pol, Position: gritsDollar[2].currPosition}
 		}
-	case 53:
+	case 73:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:175
+//line parser/parser.y:220
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{kind: ASSUMING_DEF, assumedFreeNameTypes: gritsDollar[2].names, position: gritsVAL.currPosition}
 		}
-	case 54:
+	case 74:
 		gritsDollar = gritsS[gritspt-7 : gritspt+1]
-//line parser/parser.y:180
+//line parser/parser.y:225
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{kind: FUNCTION_DEF, function: process.FunctionDefinition{FunctionName: gritsDollar[2].strval, Parameters: gritsDollar[4].names, Body: gritsDollar[7].form, UsesExplicitProvider: false}, position: gritsVAL.currPosition}
 		}
-	case 55:
+	case 75:
 		gritsDollar = gritsS[gritspt-9 : gritspt+1]
-//line parser/parser.y:182
+//line parser/parser.y:227
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{kind: FUNCTION_DEF, function: process.FunctionDefinition{FunctionName: gritsDollar[2].strval, Parameters: gritsDollar[4].names, Body: gritsDollar[9].form, Type: gritsDollar[7].sessionType, UsesExplicitProvider: false}, position: gritsVAL.currPosition}
 		}
-	case 56:
+	case 76:
 		gritsDollar = gritsS[gritspt-8 : gritspt+1]
-//line parser/parser.y:185
+//line parser/parser.y:230
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{kind: FUNCTION_DEF, function: process.FunctionDefinition{
 				FunctionName:         gritsDollar[2].strval,
 				Parameters:           gritsDollar[5].names,
 				Body:                 gritsDollar[8].form,
 				UsesExplicitProvider: true,
-				ExplicitProvider:     process.Name{Ident: gritsDollar[4].strval, IsSelf: true},
+				ExplicitProvider:     process.Name{Ident: gritsDollar[4].strval, IsSelf: true, Position: gritsDollar[4].currPosition},
 				// Type: $6,
 			}, position: gritsVAL.currPosition}
 		}
-	case 57:
+	case 77:
 		gritsDollar = gritsS[gritspt-10 : gritspt+1]
-//line parser/parser.y:196
+//line parser/parser.y:241
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{kind: FUNCTION_DEF, function: process.FunctionDefinition{
 				FunctionName:         gritsDollar[2].strval,
 				Parameters:           gritsDollar[7].names,
 				Body:                 gritsDollar[10].form,
 				UsesExplicitProvider: true,
-				ExplicitProvider:     process.Name{Ident: gritsDollar[4].strval, IsSelf: true},
+				ExplicitProvider:     process.Name{Ident: gritsDollar[4].strval, IsSelf: true, Position: gritsDollar[4].currPosition},
 				Type:                 gritsDollar[6].sessionType}, position: gritsVAL.currPosition}
 		}
-	case 58:
+	case 78:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:206
+//line parser/parser.y:251
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{
 				kind:         TYPE_DEF,
 				session_type: types.SessionTypeDefinition{Name: gritsDollar[2].strval, SessionType: gritsDollar[4].sessionType},
 				position:     gritsVAL.currPosition}
 		}
-	case 59:
+	case 79:
+		gritsDollar = gritsS[gritspt-4 : gritspt+1]
+//line parser/parser.y:258
+		{
+			gritsVAL.common_type = unexpandedProcessOrFunction{
+				kind:        GLOBAL_DEF,
+				global_type: types.GlobalTypeDefinition{Name: gritsDollar[2].strval, GlobalType: gritsDollar[4].globalType},
+				position:    gritsVAL.currPosition}
+		}
+	case 80:
+		gritsDollar = gritsS[gritspt-6 : gritspt+1]
+//line parser/parser.y:264
+		{
+			gritsVAL.globalType = types.NewGlobalInteraction(gritsDollar[1].strval, gritsDollar[3].strval, gritsDollar[5].globalBranches)
+		}
+	case 81:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:266
+		{
+			gritsVAL.globalType = types.NewGlobalEnd()
+		}
+	case 82:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:268
+		{
+			gritsVAL.globalType = types.NewGlobalReference(gritsDollar[1].strval)
+		}
+	case 83:
+		gritsDollar = gritsS[gritspt-3 : gritspt+1]
+//line parser/parser.y:270
+		{
+			gritsVAL.globalType = gritsDollar[2].globalType
+		}
+	case 84:
+		gritsDollar = gritsS[gritspt-3 : gritspt+1]
+//line parser/parser.y:274
+		{
+			gritsVAL.globalBranches = []types.GlobalBranch{*types.NewGlobalBranch(gritsDollar[1].strval, nil, gritsDollar[3].globalType)}
+		}
+	case 85:
+		gritsDollar = gritsS[gritspt-6 : gritspt+1]
+//line parser/parser.y:276
+		{
+			gritsVAL.globalBranches = []types.GlobalBranch{*types.NewGlobalBranch(gritsDollar[1].strval, gritsDollar[3].sessionType, gritsDollar[6].globalType)}
+		}
+	case 86:
+		gritsDollar = gritsS[gritspt-5 : gritspt+1]
+//line parser/parser.y:278
+		{
+			gritsVAL.globalBranches = append([]types.GlobalBranch{*types.NewGlobalBranch(gritsDollar[1].strval, nil, gritsDollar[3].globalType)}, gritsDollar[5].globalBranches...)
+		}
+	case 87:
+		gritsDollar = gritsS[gritspt-8 : gritspt+1]
+//line parser/parser.y:280
+		{
+			gritsVAL.globalBranches = append([]types.GlobalBranch{*types.NewGlobalBranch(gritsDollar[1].strval, gritsDollar[3].sessionType, gritsDollar[6].globalType)}, gritsDollar[8].globalBranches...)
+		}
+	case 88:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:213
+//line parser/parser.y:284
 		{
 			gritsVAL.sessionType = types.ConvertSessionTypeInitialToSessionType(gritsDollar[1].sessionTypeInitial)
 		}
-	case 60:
+	case 89:
 		gritsDollar = gritsS[gritspt-2 : gritspt+1]
-//line parser/parser.y:215
+//line parser/parser.y:286
 		{
 			mode := types.StringToMode(gritsDollar[1].strval)
 			gritsVAL.sessionType = types.ConvertSessionTypeInitialToSessionType(types.NewExplicitModeTypeInitial(mode, gritsDollar[2].sessionTypeInitial))
 		}
-	case 61:
+	case 90:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:221
+//line parser/parser.y:292
 		{
 			gritsVAL.sessionTypeInitial = types.NewLabelTypeInitial(gritsDollar[1].strval)
 		}
-	case 62:
+	case 91:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:223
+//line parser/parser.y:294
 		{
 			gritsVAL.sessionTypeInitial = types.NewUnitTypeInitial()
 		}
-	case 63:
+	case 92:
+		gritsDollar = gritsS[gritspt-1 : gritspt+1]
+//line parser/parser.y:296
+		{
+			gritsVAL.sessionTypeInitial = types.NewDynamicTypeInitial()
+		}
+	case 93:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:225
+//line parser/parser.y:298
 		{
 			gritsVAL.sessionTypeInitial = types.NewSelectLabelTypeInitial(gritsDollar[3].sessionTypeAltInitial)
 		}
-	case 64:
+	case 94:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:227
+//line parser/parser.y:300
 		{
 			gritsVAL.sessionTypeInitial = types.NewBranchCaseTypeInitial(gritsDollar[3].sessionTypeAltInitial)
 		}
-	case 65:
+	case 95:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:229
+//line parser/parser.y:302
+		{
+			gritsVAL.sessionTypeInitial = types.NewSelectLabelTypeInitial(nil)
+		}
+	case 96:
+		gritsDollar = gritsS[gritspt-3 : gritspt+1]
+//line parser/parser.y:304
+		{
+			gritsVAL.sessionTypeInitial = types.NewBranchCaseTypeInitial(nil)
+		}
+	case 97:
+		gritsDollar = gritsS[gritspt-3 : gritspt+1]
+//line parser/parser.y:306
 		{
 			gritsVAL.sessionTypeInitial = types.NewSendTypeInitial(gritsDollar[1].sessionTypeInitial, gritsDollar[3].sessionTypeInitial)
 		}
-	case 66:
+	case 98:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:231
+//line parser/parser.y:308
 		{
 			gritsVAL.sessionTypeInitial = types.NewReceiveTypeInitial(gritsDollar[1].sessionTypeInitial, gritsDollar[3].sessionTypeInitial)
 		}
-	case 67:
+	case 99:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:233
+//line parser/parser.y:310
 		{
 			gritsVAL.sessionTypeInitial = gritsDollar[2].sessionTypeInitial
 		}
-	case 68:
+	case 100:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:235
+//line parser/parser.y:312
 		{
 			modeFrom := types.StringToMode(gritsDollar[1].strval)
 			modeTo := types.StringToMode(gritsDollar[3].strval)
 			gritsVAL.sessionTypeInitial = types.NewUpTypeInitial(modeFrom, modeTo, gritsDollar[4].sessionTypeInitial)
 		}
-	case 69:
+	case 101:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:239
+//line parser/parser.y:316
 		{
 			modeFrom := types.StringToMode(gritsDollar[1].strval)
 			modeTo := types.StringToMode(gritsDollar[3].strval)
 			gritsVAL.sessionTypeInitial = types.NewDownTypeInitial(modeFrom, modeTo, gritsDollar[4].sessionTypeInitial)
 		}
-	case 70:
+	case 102:
 		gritsDollar = gritsS[gritspt-3 : gritspt+1]
-//line parser/parser.y:245
+//line parser/parser.y:322
 		{
 			gritsVAL.sessionTypeAltInitial = []types.OptionInitial{*types.NewOptionInitial(gritsDollar[1].strval, gritsDollar[3].sessionTypeInitial)}
 		}
-	case 71:
+	case 103:
 		gritsDollar = gritsS[gritspt-5 : gritspt+1]
-//line parser/parser.y:247
+//line parser/parser.y:324
 		{
 			gritsVAL.sessionTypeAltInitial = append([]types.OptionInitial{*types.NewOptionInitial(gritsDollar[1].strval, gritsDollar[3].sessionTypeInitial)}, gritsDollar[5].sessionTypeAltInitial...)
 		}
-	case 72:
+	case 104:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:249
+//line parser/parser.y:326
 		{
 			gritsVAL.strval = gritsDollar[1].strval
 		}
-	case 73:
+	case 105:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:251
+//line parser/parser.y:328
 		{
 			gritsVAL.polarity = types.POSITIVE
 		}
-	case 74:
+	case 106:
 		gritsDollar = gritsS[gritspt-1 : gritspt+1]
-//line parser/parser.y:252
+//line parser/parser.y:329
 		{
 			gritsVAL.polarity = types.NEGATIVE
 		}
-	case 75:
+	case 107:
 		gritsDollar = gritsS[gritspt-4 : gritspt+1]
-//line parser/parser.y:256
+//line parser/parser.y:333
 		{
 			gritsVAL.common_type = unexpandedProcessOrFunction{
 				kind:     EXEC_DEF,
@@ -3,6 +3,7 @@ package parser
 import (
 	"grits/process"
 	"grits/types"
+	"strings"
 	"testing"
 )
 
@@ -414,3 +415,91 @@ func TestTypeDefinitionModes(t *testing.T) {
 		}
 	}
 }
+
+func TestGlobalTypeProjections(t *testing.T) {
+	inputProgram :=
+		`type item = 1
+		 global trade = buyer -> seller {
+		     request(item) : seller -> buyer {
+		         quote : buyer -> seller {
+		             ok : seller -> shipper {ship : end},
+		             no : seller -> shipper {cancel : end}
+		         }
+		     }
+		 }
+		 global loop = a -> b {more(item) : b -> c {next : loop}, stop : b -> c {done : end}}`
+
+	cases := []struct {
+		name        string
+		sessionType string
+	}{
+		{"trade_buyer_seller", "&{request : item -* +{quote : &{ok : 1, no : 1}}}"},
+		{"trade_buyer_shipper", "1"},
+		{"trade_seller_shipper", "&{ship : 1, cancel : 1}"},
+		{"loop_a_b", "&{more : item -* loop_a_b, stop : 1}"},
+		{"loop_a_c", "1"},
+		{"loop_b_c", "&{next : loop_b_c, done : 1}"},
+	}
+
+	sessionTypeDefinitions := *parseGetEnvironment(inputProgram).Types
+
+	if len(sessionTypeDefinitions) != len(cases)+1 {
+		t.Fatalf("expected %d type definitions, but found %d\n", len(cases)+1, len(sessionTypeDefinitions))
+	}
+	for i, c := range cases {
+		def := sessionTypeDefinitions[i+1]
+		if def.Name != c.name || def.SessionType.String() != c.sessionType {
+			t.Errorf("error in case #%d: got %s = %s, but expected %s = %s\n", i, def.Name, def.SessionType.String(), c.name, c.sessionType)
+		}
+	}
+}
+
+// global is only a keyword at the start of a global type definition
+func TestGlobalAsName(t *testing.T) {
+	input := `type scope = +{global : 1, local : 1}
+		 let global(global : 1) : scope = self.global<global>
+		 global g = a -> b {x : end}
+		 prc[a] : scope = u : 1 <- new close self; global(u)`
+
+	processes, assumedFreeNames, globalEnv, err := ParseString(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*globalEnv.FunctionDefinitions) != 1 || len(*globalEnv.Types) != 2 {
+		t.Errorf("expected one function and two types, but found %d and %d", len(*globalEnv.FunctionDefinitions), len(*globalEnv.Types))
+	}
+}
+
+func TestGlobalTypeProjectionErrors(t *testing.T) {
+	cases := []struct {
+		input string
+		err   string
+	}{
+		{"global g = a -> a {x : end}", "role 'a' cannot send a message to itself"},
+		{"global g = a -> b {x : end, x : end}", "the label 'x' is used more than once"},
+		{"global g = a -> b {x : h}", "the global type 'h' is not defined"},
+		{"global g = end", "needs at least two roles"},
+		{"global g = a -> b {x : end}\n global g = a -> b {y : end}", "is defined more than once"},
+		{"type g_a_b = 1\n global g = a -> b {x : end}", "clashes with the type 'g_a_b'"},
+		{"global g = a -> b {x : h}\n global h = b -> a {y : end}", "the roles appear in the opposite order in the global type 'h'"},
+		// c cannot tell which branch was taken
+		{"global g = a -> b {x : b -> c {y : end}, z : a -> c {w : end}}", "cannot be projected onto a and c"},
+		{"global g = a -> b {x : c -> d {y : end}, z : c -> d {w : end}}", "cannot be projected onto c and d"},
+		// a and b keep on going without interacting with each other
+		{"global g = a -> c {x : b -> c {y : h}}\n global h = a -> c {x : b -> c {y : g}}", "since the projection keeps unfolding to other labels"},
+	}
+
+	for i, c := range cases {
+		_, _, _, err := ParseString(c.input)
+		if err == nil {
+			t.Errorf("expected an error in case #%d (%s), but found none", i, c.input)
+		} else if !strings.Contains(err.Error(), c.err) {
+			t.Errorf("expected the error in case #%d to contain '%s', but found: %s", i, c.err, err)
+		}
+	}
+}
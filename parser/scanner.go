@@ -17,6 +17,19 @@ const (
 type scanner struct {
 	r   *bufio.Reader
 	pos TokenPos
+
+	// Comments are only kept when needed (e.g. by the formatter)
+	keepComments bool
+	comments     []Token
+
+	// Tokens already scanned while looking ahead (see startsGlobalDefinition)
+	pending []scannedToken
+}
+
+type scannedToken struct {
+	token            tok
+	value            string
+	startPos, endPos TokenPos
 }
 
 // newScanner returns a new instance of Scanner.
@@ -53,6 +66,32 @@ func (s *scanner) unread() {
 
 // Scan returns the next token and parsed value.
 func (s *scanner) Scan() (token tok, value string, startPos, endPos TokenPos) {
+	if len(s.pending) > 0 {
+		next := s.pending[0]
+		s.pending = s.pending[1:]
+		return next.token, next.value, next.startPos, next.endPos
+	}
+
+	token, value, startPos, endPos = s.scanToken()
+	if token == LABEL && value == "global" && s.startsGlobalDefinition() {
+		token = GLOBAL
+	}
+
+	return token, value, startPos, endPos
+}
+
+// The global keyword is contextual, so that it can still be used as a name (e.g. a function or label called global).
+// It is only a keyword when it starts a global type definition, i.e. global <name> = ...
+func (s *scanner) startsGlobalDefinition() bool {
+	for len(s.pending) < 2 {
+		token, value, startPos, endPos := s.scanToken()
+		s.pending = append(s.pending, scannedToken{token, value, startPos, endPos})
+	}
+
+	return s.pending[0].token == LABEL && s.pending[1].token == EQUALS
+}
+
+func (s *scanner) scanToken() (token tok, value string, startPos, endPos TokenPos) {
 	ch := s.read()
 
 	if isWhitespace(ch) {
@@ -97,12 +136,14 @@ func (s *scanner) Scan() (token tok, value string, startPos, endPos TokenPos) {
 		return TIMES, string(ch), startPos, endPos
 	case '&':
 		return AMPERSAND, string(ch), startPos, endPos
+	case '?':
+		return QUESTION, string(ch), startPos, endPos
 	case '%':
 		return PERCENTAGE, string(ch), startPos, endPos
 	}
 
 	if s.consumeIfComment(ch) {
-		return s.Scan()
+		return s.scanToken()
 	}
 
 	if isSpecialSymbol(ch) {
@@ -204,6 +245,8 @@ func (s *scanner) scanLabel(ch rune) (token tok, value string, startPos, endPos
 	case "print":
 		// Debug keyword
 		return PRINT, buf.String(), startPos, endPos
+	case "input":
+		return INPUT, buf.String(), startPos, endPos
 	}
 	return LABEL, buf.String(), startPos, endPos
 }
@@ -222,11 +265,14 @@ func (s *scanner) skipWhitespace() {
 // Consumes line comments (//...) or multiline comments (/*...*/)
 func (s *scanner) consumeIfComment(ch rune) bool {
 	if ch == '/' {
+		line, char := len(s.pos.Lines)+1, s.pos.Char
 		if ch = s.read(); ch == '/' {
-			s.skipToEOL()
+			text := s.skipToEOL()
+			s.addComment("//"+text, line, char)
 			return true
 		} else if ch == '*' {
-			s.skipToEndOfComment()
+			text := s.skipToEndOfComment()
+			s.addComment("/*"+text, line, char)
 			return true
 		} else {
 			s.unread()
@@ -237,24 +283,45 @@ func (s *scanner) consumeIfComment(ch rune) bool {
 	return false
 }
 
-func (s *scanner) skipToEndOfComment() {
+func (s *scanner) addComment(text string, line, char int) {
+	if s.keepComments {
+		s.comments = append(s.comments, Token{Kind: COMMENT_TOKEN, Value: text, Line: line, Char: char})
+	}
+}
+
+// Stops at the end of the input in case of an unterminated comment
+func (s *scanner) skipToEndOfComment() string {
+	var buf bytes.Buffer
 	for {
-		if ch := s.read(); ch == '*' {
-			for {
-				if ch := s.read(); ch == '/' {
-					return
-				}
+		ch := s.read()
+		if ch == eof {
+			return buf.String()
+		}
+		buf.WriteRune(ch)
+
+		for ch == '*' {
+			if ch = s.read(); ch == eof {
+				return buf.String()
+			}
+			buf.WriteRune(ch)
+			if ch == '/' {
+				return buf.String()
 			}
 		}
 	}
 }
 
-func (s *scanner) skipToEOL() {
+// Returns the skipped text, excluding the new line
+func (s *scanner) skipToEOL() string {
+	var buf bytes.Buffer
 	for {
-		if ch := s.read(); ch == '\n' || ch == eof {
+		ch := s.read()
+		if ch == '\n' || ch == eof {
 			break
 		}
+		buf.WriteRune(ch)
 	}
+	return buf.String()
 }
 
 // Some commands are multi-character. So, they have to be check explicitly
@@ -290,13 +357,16 @@ func (s *scanner) scanSpecialSymbol(ch rune) (token tok, value string, startPos,
 			return LANGLE, "<", startPos, endPos
 		}
 	case '-':
-		// Can be - or -* (or -o)
+		// Can be - or -* (or -o) or ->
 		if ch2 == '*' {
 			// is -o
 			return LOLLI, "-*", startPos, endPos
 		} else if ch2 == 'o' {
 			// is -o
 			return LOLLI, "-o", startPos, endPos
+		} else if ch2 == '>' {
+			// is ->
+			return MESSAGE_ARROW, "->", startPos, endPos
 		} else {
 			// is just -
 			s.unread()
@@ -0,0 +1,93 @@
+package parser
+
+import (
+	"fmt"
+	"grits/process"
+	"strings"
+)
+
+// The forms found for a hole, which fill it without introducing type errors
+type SynthesizedHole struct {
+	Hole  process.Hole
+	Forms []process.Form
+}
+
+// Synthesize searches for the forms filling each hole (?) of a program (used by 'grits synth'), up to the given depth.
+// Each form found by the search is written in place of its hole and the whole program is typechecked again, so only
+// the forms which typecheck (and do not weaken the termination status of any function, e.g. a terminating function
+// cannot become productive) are kept, up to max forms per hole.
+func Synthesize(program string, depth, max int) ([]SynthesizedHole, error) {
+	processes, assumedFreeNames, globalEnv, err := ParseString(program)
+	if err != nil {
+		return nil, err
+	}
+
+	globalEnv.CheckTermination = true
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil {
+		return nil, err
+	}
+
+	holes := globalEnv.Holes
+	termination := terminationStatuses(globalEnv)
+	lines := strings.Split(program, "\n")
+
+	var results []SynthesizedHole
+	for _, hole := range holes {
+		line, char := hole.Position.StartLine-1, hole.Position.StartPos-1
+		if line < 0 || line >= len(lines) || char < 0 || char >= len([]rune(lines[line])) || []rune(lines[line])[char] != '?' {
+			return nil, fmt.Errorf("(%s) unable to locate the hole in the source", hole.Position.String())
+		}
+
+		result := SynthesizedHole{Hole: hole}
+		process.SynthesizeHole(hole, depth, func(form process.Form) bool {
+			if fillsHole(fillHole(lines, line, char, form), len(holes)-1, termination) {
+				result.Forms = append(result.Forms, form)
+			}
+			return len(result.Forms) < max
+		})
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Writes the form (bracketed) in place of the hole at the given line and character
+func fillHole(lines []string, line, char int, form process.Form) string {
+	row := []rune(lines[line])
+	filled := string(row[:char]) + "(" + process.FormatSynthesized(form, 0) + ")" + string(row[char+1:])
+
+	result := append([]string(nil), lines[:line]...)
+	result = append(result, filled)
+	result = append(result, lines[line+1:]...)
+	return strings.Join(result, "\n")
+}
+
+// Whether the program typechecks, with one hole less than before, and without weakening the termination status of any function
+func fillsHole(program string, holes int, termination map[string]process.TerminationStatus) bool {
+	processes, assumedFreeNames, globalEnv, err := ParseString(program)
+	if err != nil {
+		return false
+	}
+
+	globalEnv.CheckTermination = true
+	if err := process.Typecheck(processes, assumedFreeNames, globalEnv); err != nil || len(globalEnv.Holes) != holes {
+		return false
+	}
+
+	for function, status := range terminationStatuses(globalEnv) {
+		if previous, exists := termination[function]; exists && status > previous {
+			return false
+		}
+	}
+
+	return true
+}
+
+func terminationStatuses(globalEnv *process.GlobalEnvironment) map[string]process.TerminationStatus {
+	statuses := make(map[string]process.TerminationStatus)
+	for _, result := range globalEnv.TerminationResults {
+		statuses[result.Function] = result.Status
+	}
+	return statuses
+}
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"grits/process"
+	"strings"
+	"testing"
+)
+
+func TestSynthesize(t *testing.T) {
+	program := `type nat = +{zero : 1, succ : nat}
+let swap(p : nat * nat) : nat * nat = ?
+let loop(x : nat) : nat = case x (zero<u> => self.zero<u> | succ<y> => ?)
+let unit(x : lin 1) : lin 1 = ?`
+
+	results, err := Synthesize(program, 4, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := [][]string{
+		{"fwd self p", "<x1, x2> <- recv p; send self<x1, x2>", "<x1, x2> <- recv p; send self<x2, x1>"},
+		// Calling loop(y) is terminating, unlike loop(x)
+		{"self.succ<y>", "fwd self y", "loop(y)"},
+		{"fwd self x", "wait x; close self"},
+	}
+
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d holes, but found %d", len(expected), len(results))
+	}
+
+	for i, result := range results {
+		var forms []string
+		for _, form := range result.Forms {
+			lines := process.FormatForm(form, 0)
+			texts := make([]string, len(lines))
+			for j := range lines {
+				texts[j] = strings.TrimSpace(lines[j].Text)
+			}
+			forms = append(forms, strings.Join(texts, " "))
+		}
+
+		if strings.Join(forms, "\n") != strings.Join(expected[i], "\n") {
+			t.Errorf("hole #%d: expected:\n%s\nbut found:\n%s", i, strings.Join(expected[i], "\n"), strings.Join(forms, "\n"))
+		}
+	}
+
+	// Parse and type errors are reported as such
+	if _, err := Synthesize("let f() : 1 = wait x; ?", 3, 3); err == nil {
+		t.Errorf("expected a type error, but found none")
+	}
+}
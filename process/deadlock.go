@@ -0,0 +1,103 @@
+package process
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The processes declared using prc[...] are connected through their provider names: a process whose body refers to
+// the name provided by another process is its client, so it may end up waiting on it. Each process is typechecked on
+// its own, so well-typed processes may still refer to each other in a cycle, e.g.
+//
+//	prc[a] : 1 = wait b; close self
+//	prc[b] : 1 = wait a; close self
+//
+// in which case each process waits on the next one forever. The configuration is deadlock free as long as these
+// dependencies form a forest (as in a well-typed tree of processes). Assumed names (assuming x : A) are not provided by
+// any process, so they never take part in a cycle.
+
+// A process refers to the process at index provider using the name via
+type processDependency struct {
+	provider int
+	via      string
+}
+
+// Builds the dependency graph from the free names of each process, and reports any cycles found
+func checkDeadlockFreedom(processes []*Process) error {
+	providers := make(map[string]int)
+	for i := range processes {
+		for _, provider := range processes[i].Providers {
+			providers[provider.Ident] = i
+		}
+	}
+
+	dependencies := make([][]processDependency, len(processes))
+	for i := range processes {
+		freeNames := NamesInFirstListOnly(processes[i].Body.FreeNames(), processes[i].Providers)
+		for _, fn := range freeNames {
+			if j, ok := providers[fn.Ident]; ok {
+				dependencies[i] = append(dependencies[i], processDependency{provider: j, via: fn.Ident})
+			}
+		}
+	}
+
+	var cycles []string
+
+	const (
+		unvisited = iota
+		onStack
+		finished
+	)
+	state := make([]int, len(processes))
+	// The processes currently being explored, each with the dependency leading to the next one
+	var stack []int
+	var stackVia []string
+
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = onStack
+		stack = append(stack, i)
+		stackVia = append(stackVia, "")
+
+		for _, dependency := range dependencies[i] {
+			stackVia[len(stackVia)-1] = dependency.via
+
+			switch state[dependency.provider] {
+			case unvisited:
+				visit(dependency.provider)
+			case onStack:
+				start := 0
+				for stack[start] != dependency.provider {
+					start++
+				}
+				cycles = append(cycles, describeCycle(processes, stack[start:], stackVia[start:]))
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		stackVia = stackVia[:len(stackVia)-1]
+		state[i] = finished
+	}
+
+	for i := range processes {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+
+	if len(cycles) > 0 {
+		return fmt.Errorf("the processes depend on each other in a cycle, so they may wait on each other forever (deadlock): %s", strings.Join(cycles, "; "))
+	}
+
+	return nil
+}
+
+// E.g. (Line 2) prc[a] waits on b, (Line 3) prc[b] waits on a
+func describeCycle(processes []*Process, cycle []int, via []string) string {
+	steps := make([]string, len(cycle))
+	for i, p := range cycle {
+		steps[i] = fmt.Sprintf("(%s) %s waits on %s", processes[p].Position.String(), processes[p].OutlineString(), via[i])
+	}
+
+	return strings.Join(steps, ", ")
+}
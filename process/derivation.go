@@ -0,0 +1,172 @@
+package process
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"grits/types"
+	"strings"
+)
+
+// Derivation is a node in the typing derivation built by the typechecker: a rule applied to the judgement
+// Γ ⊢ P :: (provider : A), along with the derivations of its premises.
+// Derivations are only recorded when GlobalEnvironment.RecordDerivations is set.
+type Derivation struct {
+	Rule         string        `json:"rule"`
+	Context      string        `json:"context"`
+	Process      string        `json:"process"`
+	Provider     string        `json:"provider"`
+	ProviderType string        `json:"providerType"`
+	Premises     []*Derivation `json:"premises,omitempty"`
+}
+
+// Called at the start of each typecheckForm. The returned function closes the derivation, so that the nodes created
+// in between become its premises.
+func (globalEnv *GlobalEnvironment) beginDerivation(form Form, gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType) func() {
+	if !globalEnv.RecordDerivations {
+		return func() {}
+	}
+
+	derivation := &Derivation{
+		Context:  stringifyContext(gammaNameTypesCtx),
+		Process:  form.StringShort(),
+		Provider: "self",
+	}
+
+	if providerShadowName != nil {
+		derivation.Provider = providerShadowName.String()
+	}
+
+	if providerType != nil {
+		derivation.ProviderType = providerType.String()
+	}
+
+	if len(globalEnv.derivationStack) == 0 {
+		if globalEnv.Derivations == nil {
+			globalEnv.Derivations = make(map[string]*Derivation)
+		}
+		globalEnv.Derivations[globalEnv.derivationName] = derivation
+	} else {
+		parent := globalEnv.derivationStack[len(globalEnv.derivationStack)-1]
+		parent.Premises = append(parent.Premises, derivation)
+	}
+
+	globalEnv.derivationStack = append(globalEnv.derivationStack, derivation)
+
+	return func() {
+		globalEnv.derivationStack = globalEnv.derivationStack[:len(globalEnv.derivationStack)-1]
+	}
+}
+
+// Logs the rule being applied, and sets it as the rule of the current derivation
+func (globalEnv *GlobalEnvironment) logRule(rule string) {
+	globalEnv.log(LOGRULEDETAILS, "rule "+rule)
+
+	if globalEnv.RecordDerivations && len(globalEnv.derivationStack) > 0 {
+		globalEnv.derivationStack[len(globalEnv.derivationStack)-1].Rule = rule
+	}
+}
+
+// Sets the function or process (e.g. prc[a]) whose derivation is recorded next
+func (globalEnv *GlobalEnvironment) startDerivation(name string) {
+	globalEnv.derivationName = name
+	globalEnv.derivationStack = nil
+}
+
+// Processes contain symbols such as <- and =>, so HTML escaping is disabled to keep the output readable
+func (d *Derivation) JSON() ([]byte, error) {
+	var buffer bytes.Buffer
+
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(d); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// LaTeX produces a proof tree using the bussproofs package, e.g.
+//
+//	\begin{prooftree}
+//	\AxiomC{}
+//	\RightLabel{\scriptsize 1R (EndR)}
+//	\UnaryInfC{$\cdot$ $\vdash$ \texttt{close self} $::$ (\texttt{self} : \texttt{1})}
+//	\end{prooftree}
+//
+// bussproofs supports at most five premises per rule, so any further premises are elided (as \dots).
+func (d *Derivation) LaTeX() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("\\begin{prooftree}\n")
+	d.writeLaTeX(&buffer)
+	buffer.WriteString("\\end{prooftree}\n")
+	return buffer.String()
+}
+
+var inferenceCommands = []string{"\\UnaryInfC", "\\BinaryInfC", "\\TrinaryInfC", "\\QuaternaryInfC", "\\QuinaryInfC"}
+
+func (d *Derivation) writeLaTeX(buffer *bytes.Buffer) {
+	premises := d.Premises
+	if len(premises) > len(inferenceCommands) {
+		premises = premises[:len(inferenceCommands)-1]
+	}
+
+	if len(d.Premises) == 0 {
+		buffer.WriteString("\\AxiomC{}\n")
+	}
+
+	for _, premise := range premises {
+		premise.writeLaTeX(buffer)
+	}
+
+	premiseCount := len(premises)
+	if len(premises) < len(d.Premises) {
+		buffer.WriteString("\\AxiomC{$\\dots$}\n")
+		premiseCount++
+	}
+
+	command := inferenceCommands[max(premiseCount, 1)-1]
+
+	buffer.WriteString(fmt.Sprintf("\\RightLabel{\\scriptsize %s}\n", latexRule(d.Rule)))
+	buffer.WriteString(fmt.Sprintf("%s{%s $\\vdash$ \\texttt{%s} $::$ (\\texttt{%s} : \\texttt{%s})}\n", command, latexContext(d.Context), latexEscape(d.Process), latexEscape(d.Provider), latexEscape(d.ProviderType)))
+}
+
+func latexContext(context string) string {
+	if context == "" {
+		return "$\\cdot$"
+	}
+
+	return "\\texttt{" + latexEscape(context) + "}"
+}
+
+var latexEscaper = strings.NewReplacer(
+	"\\", "\\textbackslash{}",
+	"{", "\\{",
+	"}", "\\}",
+	"_", "\\_",
+	"&", "\\&",
+	"%", "\\%",
+	"$", "\\$",
+	"#", "\\#",
+	"^", "\\^{}",
+	"~", "\\~{}",
+)
+
+func latexEscape(text string) string {
+	return latexEscaper.Replace(text)
+}
+
+// Rule names contain some unicode symbols, which are replaced by their math mode equivalent
+var latexRuleReplacer = strings.NewReplacer(
+	"⊗", "$\\otimes$",
+	"⊸", "$\\multimap$",
+	"⊕", "$\\oplus$",
+	"↑", "$\\uparrow$",
+	"↓", "$\\downarrow$",
+)
+
+func latexRule(rule string) string {
+	return latexRuleReplacer.Replace(latexEscape(rule))
+}
@@ -0,0 +1,510 @@
+package process
+
+import (
+	"fmt"
+	"grits/position"
+	"sort"
+)
+
+// Elaboration rewrites programs which typecheck into equivalent ones in which some of the implicit steps are made
+// explicit. Affine and replicable names may be left unused: the typechecker records them for each form ending a
+// process (e.g. close self), and a drop is inserted for each of them before that form. Similarly, names used more than
+// once are split before typechecking (see ElaborateSplits), and the shifts between modes can be inserted according to
+// the declared types (see insertShifts). This way, the runtime only has to deal with explicit drops, splits and
+// shifts.
+
+// Records the names (which can all be weakened) left behind by a form ending a process
+func (globalEnv *GlobalEnvironment) recordImplicitDrops(form Form, names []Name) {
+	if len(names) == 0 {
+		return
+	}
+
+	sort.Slice(names, func(i, j int) bool { return names[i].Ident < names[j].Ident })
+
+	if globalEnv.implicitDrops == nil {
+		globalEnv.implicitDrops = make(map[Form][]Name)
+	}
+
+	globalEnv.implicitDrops[form] = names
+}
+
+// Inserts the drops recorded while typechecking into the bodies of the functions and processes
+func elaborateDrops(processes []*Process, globalEnv *GlobalEnvironment) {
+	if len(globalEnv.implicitDrops) == 0 {
+		return
+	}
+
+	functions := *globalEnv.FunctionDefinitions
+	for i := range functions {
+		functions[i].Body = insertDrops(functions[i].Body, functions[i].Position, globalEnv)
+	}
+
+	for _, p := range processes {
+		p.Body = insertDrops(p.Body, p.Position, globalEnv)
+	}
+
+	// The same form is never elaborated twice
+	globalEnv.implicitDrops = nil
+}
+
+// Warnings about names without a position (e.g. names bound by a case) refer to the enclosing definition instead
+func insertDrops(form Form, definition position.Position, globalEnv *GlobalEnvironment) Form {
+	switch p := form.(type) {
+	case *ReceiveForm:
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	case *CaseForm:
+		for _, branch := range p.branches {
+			branch.continuation_e = insertDrops(branch.continuation_e, definition, globalEnv)
+		}
+	case *NewForm:
+		p.body = insertDrops(p.body, definition, globalEnv)
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	case *SplitForm:
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	case *WaitForm:
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	case *ShiftForm:
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	case *DropForm:
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	case *PrintForm:
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	case *PrintValueForm:
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	case *LetForm:
+		p.continuation_e = insertDrops(p.continuation_e, definition, globalEnv)
+	}
+
+	names := globalEnv.implicitDrops[form]
+	for _, name := range names {
+		pos := name.Position
+		if pos.StartLine <= 0 {
+			pos = definition
+		}
+		globalEnv.warnf(IMPLICIT_DROP, pos, "name '%s' is left unused, so it is dropped before %s", name.Ident, form.StringShort())
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		// The inserted drop has no position, since it does not appear in the source
+		form = NewDrop(Name{Ident: names[i].Ident, Type: names[i].Type}, form)
+	}
+
+	return form
+}
+
+// Copies the drops and shifts inserted into elaborated (an elaborated copy of form) over to form. This way, the
+// elaborated program can be printed with its types as written in the source, rather than as filled in by the
+// typechecker.
+func CopyInsertedForms(form, elaborated Form) Form {
+	switch e := elaborated.(type) {
+	case *DropForm:
+		if _, explicit := form.(*DropForm); !explicit {
+			return NewDrop(Name{Ident: e.client_c.Ident}, CopyInsertedForms(form, e.continuation_e))
+		}
+	case *ShiftForm:
+		if e.inserted {
+			if !e.from_c.IsSelf {
+				form.Substitute(Name{Ident: e.from_c.Ident}, Name{Ident: e.continuation_c.Ident})
+			}
+			return NewShift(Name{Ident: e.continuation_c.Ident}, sourceName(e.from_c), CopyInsertedForms(form, e.continuation_e))
+		}
+	case *CastForm:
+		if e.inserted {
+			return NewCast(sourceName(e.to_c), sourceName(e.continuation_c))
+		}
+	case *NewForm:
+		if e.insertedType != nil {
+			newName := Name{Ident: e.new_name_c.Ident, Type: sourceType(e.insertedType)}
+			if cast, ok := e.continuation_e.(*CastForm); ok && cast.inserted {
+				// The form itself was moved to the new process
+				return NewNew(newName, CopyForm(e.body), CopyInsertedForms(form, cast))
+			}
+
+			form.Substitute(Name{Ident: nonSelfName(e.body)}, Name{Ident: e.new_name_c.Ident})
+			return NewNew(newName, CopyForm(e.body), CopyInsertedForms(form, e.continuation_e))
+		}
+	}
+
+	switch p := form.(type) {
+	case *ReceiveForm:
+		if e, ok := elaborated.(*ReceiveForm); ok {
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	case *CaseForm:
+		if e, ok := elaborated.(*CaseForm); ok && len(e.branches) == len(p.branches) {
+			for i, branch := range p.branches {
+				branch.continuation_e = CopyInsertedForms(branch.continuation_e, e.branches[i].continuation_e)
+			}
+		}
+	case *NewForm:
+		if e, ok := elaborated.(*NewForm); ok {
+			p.body = CopyInsertedForms(p.body, e.body)
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	case *SplitForm:
+		if e, ok := elaborated.(*SplitForm); ok {
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	case *WaitForm:
+		if e, ok := elaborated.(*WaitForm); ok {
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	case *ShiftForm:
+		if e, ok := elaborated.(*ShiftForm); ok {
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	case *DropForm:
+		if e, ok := elaborated.(*DropForm); ok {
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	case *PrintForm:
+		if e, ok := elaborated.(*PrintForm); ok {
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	case *PrintValueForm:
+		if e, ok := elaborated.(*PrintValueForm); ok {
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	case *LetForm:
+		if e, ok := elaborated.(*LetForm); ok {
+			// The local functions are elaborated (and typechecked) as lifted functions
+			for i := range p.functions {
+				if i >= len(e.lifted) || e.definitions == nil {
+					break
+				}
+				lifted := GetFunctionByName(*e.definitions, e.lifted[i].name)
+				if lifted == nil {
+					continue
+				}
+
+				var providers []Name
+				if p.functions[i].UsesExplicitProvider {
+					providers = []Name{p.functions[i].ExplicitProvider}
+				}
+				body := ElaborateSplits(p.functions[i].Body, providers)
+				p.functions[i].Body = CopyInsertedForms(body, lifted.Body)
+			}
+			p.continuation_e = CopyInsertedForms(p.continuation_e, e.continuation_e)
+		}
+	}
+
+	return form
+}
+
+// Names without the details filled in by the typechecker
+func sourceName(name Name) Name {
+	return Name{Ident: name.Ident, IsSelf: name.IsSelf}
+}
+
+// Splits the names used more than once in the bodies of the functions and processes
+func elaborateSplits(processes []*Process, globalEnv *GlobalEnvironment) {
+	functions := *globalEnv.FunctionDefinitions
+	for i := range functions {
+		// References to an explicit provider are already marked as self
+		functions[i].Body = ElaborateSplits(functions[i].Body, nil)
+	}
+
+	for _, p := range processes {
+		p.Body = ElaborateSplits(p.Body, p.Providers)
+	}
+}
+
+// ElaborateSplits makes the contraction of names explicit. A name which is used by a step (e.g. wait x) and again
+// later on (or more than once by the same step, e.g. f(x, x)) is split right before that step: the step uses one copy
+// and the rest of the process uses the other. Each branch of a case is considered on its own, so a split is only
+// inserted where it is actually needed. The providers (i.e. self and its other names) are never split, and neither
+// are names at the point where they are dropped explicitly (e.g. drop x; wait x is still an error).
+//
+// E.g.
+//
+//	wait x; wait x; close self
+//
+// becomes
+//
+//	<x1, x2> <- split x; wait x1; wait x2; close self
+//
+// The typechecker then ensures that the names being split can be contracted. The elaboration only depends on the
+// syntax, so it gives the same result on different parses of the same program.
+func ElaborateSplits(form Form, providers []Name) Form {
+	e := splitElaborator{taken: make(map[string]bool), originals: make(map[string]string)}
+	for _, name := range AllNames(form) {
+		e.taken[name.Ident] = true
+	}
+
+	shadows := make(map[string]bool)
+	for _, provider := range providers {
+		shadows[provider.Ident] = true
+	}
+
+	return e.insertSplits(form, shadows)
+}
+
+type splitElaborator struct {
+	// The names which cannot be used for the copies, since they already appear in the process
+	taken map[string]bool
+	// The name (as written) from which each copy originates
+	originals map[string]string
+}
+
+// A use of a name by a step, which can be renamed to one of its copies
+type nameUse struct {
+	ident  string
+	rename func(ident string)
+}
+
+// The rest of the process following a step (i.e. one of its continuations), apart from the names bound by the step
+type splitScope struct {
+	form  Form
+	bound []Name
+}
+
+func (e *splitElaborator) insertSplits(form Form, providers map[string]bool) Form {
+	isProvider := func(name Name) bool {
+		return name.IsSelf || providers[name.Ident]
+	}
+
+	if names, ok := axiomaticNames(form); ok {
+		return e.splitUses(form, e.uses(providers, names...))
+	}
+
+	switch p := form.(type) {
+	case *ReceiveForm:
+		form = e.splitUses(form, e.uses(providers, &p.from_c), splitScope{p.continuation_e, []Name{p.payload_c, p.continuation_c}})
+		if isProvider(p.from_c) {
+			providers = withProvider(providers, p.continuation_c)
+		}
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	case *CaseForm:
+		scopes := make([]splitScope, len(p.branches))
+		for i, branch := range p.branches {
+			scopes[i] = splitScope{branch.continuation_e, []Name{branch.payload_c}}
+		}
+		form = e.splitUses(form, e.uses(providers, &p.from_c), scopes...)
+		for _, branch := range p.branches {
+			branchProviders := providers
+			if isProvider(p.from_c) {
+				branchProviders = withProvider(providers, branch.payload_c)
+			}
+			// The copies made in one branch can be reused by the other ones
+			branchElaborator := splitElaborator{taken: make(map[string]bool), originals: e.originals}
+			for ident := range e.taken {
+				branchElaborator.taken[ident] = true
+			}
+			branch.continuation_e = branchElaborator.insertSplits(branch.continuation_e, branchProviders)
+		}
+	case *NewForm:
+		if names, ok := axiomaticNames(p.body); ok {
+			// The splits needed by a simple body (e.g. a call) are placed before the new, so that it stays simple
+			form = e.splitUses(form, e.uses(providers, names...), splitScope{p.continuation_e, []Name{p.new_name_c}})
+		} else {
+			// Otherwise, the body is a single use of each of its free names, and is elaborated on its own
+			var uses []nameUse
+			for _, name := range p.body.FreeNames() {
+				if !isProvider(name) && !containsUse(uses, name.Ident) {
+					old := name.Ident
+					uses = append(uses, nameUse{old, func(ident string) { p.body.Substitute(Name{Ident: old}, Name{Ident: ident}) }})
+				}
+			}
+			form = e.splitUses(form, uses, splitScope{p.continuation_e, []Name{p.new_name_c}})
+			p.body = e.insertSplits(p.body, make(map[string]bool))
+		}
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	case *WaitForm:
+		form = e.splitUses(form, e.uses(providers, &p.to_c), splitScope{p.continuation_e, nil})
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	case *ShiftForm:
+		form = e.splitUses(form, e.uses(providers, &p.from_c), splitScope{p.continuation_e, []Name{p.continuation_c}})
+		if isProvider(p.from_c) {
+			providers = withProvider(providers, p.continuation_c)
+		}
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	case *DropForm:
+		// A name which is dropped explicitly is not meant to be used again, so it is never split (any later use is
+		// reported by the typechecker)
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	case *SplitForm:
+		form = e.splitUses(form, e.uses(providers, &p.from_c), splitScope{p.continuation_e, []Name{p.channel_one, p.channel_two}})
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	case *PrintForm:
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	case *PrintValueForm:
+		form = e.splitUses(form, e.uses(providers, &p.from_c), splitScope{p.continuation_e, nil})
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	case *LetForm:
+		p.continuation_e = e.insertSplits(p.continuation_e, providers)
+	}
+
+	return form
+}
+
+// The names used by the forms which end a process (e.g. send self<x, y>)
+func axiomaticNames(form Form) ([]*Name, bool) {
+	switch p := form.(type) {
+	case *SendForm:
+		return []*Name{&p.to_c, &p.payload_c, &p.continuation_c}, true
+	case *SelectForm:
+		return []*Name{&p.to_c, &p.continuation_c}, true
+	case *CloseForm:
+		return []*Name{&p.from_c}, true
+	case *InputForm:
+		return []*Name{&p.from_c}, true
+	case *ForwardForm:
+		return []*Name{&p.to_c, &p.from_c}, true
+	case *CastForm:
+		return []*Name{&p.to_c, &p.continuation_c}, true
+	case *CallForm:
+		names := make([]*Name, len(p.parameters))
+		for i := range p.parameters {
+			names[i] = &p.parameters[i]
+		}
+		return names, true
+	}
+
+	return nil, false
+}
+
+// The names used by a step, excluding the providers
+func (e *splitElaborator) uses(providers map[string]bool, names ...*Name) []nameUse {
+	var uses []nameUse
+	for _, name := range names {
+		if name.IsSelf || providers[name.Ident] {
+			continue
+		}
+
+		n := name
+		uses = append(uses, nameUse{n.Ident, func(ident string) { n.Ident = ident }})
+	}
+
+	return uses
+}
+
+// Inserts the splits needed by a step, renaming its uses (and the ones in the rest of the process) to the copies. A
+// name used n times (counting the rest of the process as a single use) is split n-1 times.
+func (e *splitElaborator) splitUses(form Form, uses []nameUse, rest ...splitScope) Form {
+	var splits []*SplitForm
+
+	for i, use := range uses {
+		if containsUse(uses[:i], use.ident) {
+			// Already split
+			continue
+		}
+
+		var occurrences []nameUse
+		for _, other := range uses[i:] {
+			if other.ident == use.ident {
+				occurrences = append(occurrences, other)
+			}
+		}
+
+		usedLater := false
+		for _, scope := range rest {
+			if scope.uses(use.ident) {
+				usedLater = true
+			}
+		}
+
+		count := len(occurrences)
+		if usedLater {
+			count++
+		}
+
+		current := use.ident
+		for j, occurrence := range occurrences {
+			if j == count-1 {
+				occurrence.rename(current)
+				break
+			}
+
+			one, two := e.fresh(use.ident), e.fresh(use.ident)
+			split := NewSplit(Name{Ident: one}, Name{Ident: two}, Name{Ident: current}, nil)
+			split.contracted = e.original(use.ident)
+			splits = append(splits, split)
+			occurrence.rename(one)
+			current = two
+		}
+
+		if usedLater && current != use.ident {
+			for _, scope := range rest {
+				scope.rename(use.ident, current)
+			}
+		}
+	}
+
+	for i := len(splits) - 1; i >= 0; i-- {
+		splits[i].continuation_e = form
+		form = splits[i]
+	}
+
+	return form
+}
+
+func (s splitScope) uses(ident string) bool {
+	if identBound(s.bound, ident) {
+		return false
+	}
+
+	for _, name := range s.form.FreeNames() {
+		if name.Ident == ident {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s splitScope) rename(old, new string) {
+	if !identBound(s.bound, old) {
+		s.form.Substitute(Name{Ident: old}, Name{Ident: new})
+	}
+}
+
+// Numbers the copies of a name, e.g. x1, x2, ... (skipping the names already in use)
+func (e *splitElaborator) fresh(ident string) string {
+	original := e.original(ident)
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s%d", original, i)
+		if !e.taken[name] {
+			e.taken[name] = true
+			e.originals[name] = original
+			return name
+		}
+	}
+}
+
+func (e *splitElaborator) original(ident string) string {
+	if original, ok := e.originals[ident]; ok {
+		return original
+	}
+
+	return ident
+}
+
+func withProvider(providers map[string]bool, provider Name) map[string]bool {
+	extended := make(map[string]bool, len(providers)+1)
+	for ident := range providers {
+		extended[ident] = true
+	}
+	extended[provider.Ident] = true
+
+	return extended
+}
+
+func containsUse(uses []nameUse, ident string) bool {
+	for _, use := range uses {
+		if use.ident == ident {
+			return true
+		}
+	}
+
+	return false
+}
+
+func identBound(bound []Name, ident string) bool {
+	for _, name := range bound {
+		if name.Ident == ident {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,6 +3,7 @@ package process
 import (
 	"bytes"
 	"fmt"
+	"grits/position"
 	"grits/types"
 	"reflect"
 )
@@ -96,6 +97,10 @@ type ReceiveForm struct {
 	continuation_c Name
 	from_c         Name
 	continuation_e Form
+	// Written as payload_c <- recv from_c; P (see NewReceiveMacro)
+	derivedFromMacro bool
+	// Written along with the next receive, e.g. <a, b, k> <- recv x; P (see NewReceiveTuple)
+	tupled bool
 }
 
 func NewReceive(payload_c, continuation_c, from_c Name, continuation_e Form) *ReceiveForm {
@@ -108,6 +113,20 @@ func NewReceive(payload_c, continuation_c, from_c Name, continuation_e Form) *Re
 
 func (p *ReceiveForm) String() string {
 	var buf bytes.Buffer
+	if names, continuation_e, ok := p.tuple(); ok {
+		buf.WriteString(tupleReceiveString(names, p.from_c))
+		buf.WriteString("; ")
+		buf.WriteString(continuation_e.String())
+		return buf.String()
+	}
+	if p.macro() {
+		buf.WriteString(p.payload_c.String())
+		buf.WriteString(" <- recv ")
+		buf.WriteString(p.from_c.String())
+		buf.WriteString("; ")
+		buf.WriteString(p.continuation_e.String())
+		return buf.String()
+	}
 	buf.WriteString("<")
 	buf.WriteString(p.payload_c.String())
 	buf.WriteString(",")
@@ -121,6 +140,18 @@ func (p *ReceiveForm) String() string {
 
 func (p *ReceiveForm) StringShort() string {
 	var buf bytes.Buffer
+	if names, _, ok := p.tuple(); ok {
+		buf.WriteString(tupleReceiveString(names, p.from_c))
+		buf.WriteString("; ...")
+		return buf.String()
+	}
+	if p.macro() {
+		buf.WriteString(p.payload_c.String())
+		buf.WriteString(" <- recv ")
+		buf.WriteString(p.from_c.String())
+		buf.WriteString("; ...")
+		return buf.String()
+	}
 	buf.WriteString("<")
 	buf.WriteString(p.payload_c.String())
 	buf.WriteString(",")
@@ -221,6 +252,8 @@ type BranchForm struct {
 	label          Label
 	payload_c      Name
 	continuation_e Form
+	// Written as a nested pattern, which is matched by the continuation (see NewPatternCase)
+	nested bool
 }
 
 func NewBranch(label Label, payload_c Name, continuation_e Form) *BranchForm {
@@ -231,22 +264,11 @@ func NewBranch(label Label, payload_c Name, continuation_e Form) *BranchForm {
 }
 
 func (p *BranchForm) String() string {
-	var buf bytes.Buffer
-	buf.WriteString(p.label.String())
-	buf.WriteString("<")
-	buf.WriteString(p.payload_c.String())
-	buf.WriteString("> => ")
-	buf.WriteString(p.continuation_e.String())
-	return buf.String()
+	return StringifyBranches([]*BranchForm{p})
 }
 
 func (p *BranchForm) StringShort() string {
-	var buf bytes.Buffer
-	buf.WriteString(p.label.String())
-	buf.WriteString("<")
-	buf.WriteString(p.payload_c.String())
-	buf.WriteString("> => ...")
-	return buf.String()
+	return StringifyBranchesShort([]*BranchForm{p})
 }
 
 func (p *BranchForm) Substitute(old, new Name) {
@@ -259,10 +281,13 @@ func (p *BranchForm) Substitute(old, new Name) {
 func StringifyBranches(branches []*BranchForm) string {
 	var buf bytes.Buffer
 
-	for i, j := range branches {
-		buf.WriteString(j.String())
+	written := writtenBranches(branches, stringName)
+	for i, j := range written {
+		buf.WriteString(j.pattern)
+		buf.WriteString(" => ")
+		buf.WriteString(j.continuation.String())
 
-		if i < len(branches)-1 {
+		if i < len(written)-1 {
 			buf.WriteString(" | ")
 		}
 	}
@@ -272,10 +297,12 @@ func StringifyBranches(branches []*BranchForm) string {
 func StringifyBranchesShort(branches []*BranchForm) string {
 	var buf bytes.Buffer
 
-	for i, j := range branches {
-		buf.WriteString(j.StringShort())
+	written := writtenBranches(branches, stringName)
+	for i, j := range written {
+		buf.WriteString(j.pattern)
+		buf.WriteString(" => ...")
 
-		if i < len(branches)-1 {
+		if i < len(written)-1 {
 			buf.WriteString(" | ")
 		}
 
@@ -304,6 +331,12 @@ func (p *BranchForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironme
 type CaseForm struct {
 	from_c   Name
 	branches []*BranchForm
+	// Matches the continuation of a nested pattern within the case on patternOf, e.g. the labels in place of %s in
+	// succ<%s> (see NewPatternCase)
+	patternOf   Name
+	patternPath string
+	// Matches an element of a tuple pattern, e.g. the case on l#2 for cons<<h, nil<u>>> (see compileTuple)
+	element bool
 }
 
 func NewCase(from_c Name, branches []*BranchForm) *CaseForm {
@@ -376,10 +409,17 @@ func (p *CaseForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironment
 
 // New: new_name_c <- new (body); continuation_e
 type NewForm struct {
-	new_name_c       Name
-	body             Form
-	continuation_e   Form
+	new_name_c     Name
+	body           Form
+	continuation_e Form
+	// Written as a macro, e.g. send x<y>; P (see NewSendMacro). For a macro on the provider, the body is the rest of
+	// the process and continuation_e is the step on the provider.
 	derivedFromMacro bool
+	onProvider       bool
+	// Written along with the next send, e.g. send x<a, b, k> (see NewSendTuple)
+	tupled bool
+	// The declared type of the new name, if the new was inserted by the elaboration (see insertShifts)
+	insertedType types.SessionType
 }
 
 func NewNew(new_name_c Name, body, continuation_e Form) *NewForm {
@@ -392,6 +432,13 @@ func NewNew(new_name_c Name, body, continuation_e Form) *NewForm {
 }
 
 func (p *NewForm) String() string {
+	if to_c, names, ok := p.tuple(); ok {
+		return tupleSendString(to_c, names)
+	}
+	if step, rest, ok := p.macro(); ok {
+		return macroString(step, rest, false)
+	}
+
 	var buf bytes.Buffer
 	buf.WriteString(p.new_name_c.String())
 	buf.WriteString(" <- new (")
@@ -402,6 +449,13 @@ func (p *NewForm) String() string {
 }
 
 func (p *NewForm) StringShort() string {
+	if to_c, names, ok := p.tuple(); ok {
+		return tupleSendString(to_c, names)
+	}
+	if step, rest, ok := p.macro(); ok {
+		return macroString(step, rest, true)
+	}
+
 	var buf bytes.Buffer
 	buf.WriteString(p.new_name_c.String())
 	buf.WriteString(" <- new ...; ...")
@@ -532,6 +586,8 @@ type SplitForm struct {
 	channel_two    Name
 	from_c         Name
 	continuation_e Form
+	// Set to the name used more than once if the split was inserted implicitly (see ElaborateSplits)
+	contracted string
 }
 
 func NewSplit(channel_one, channel_two, from_c Name, continuation_e Form) *SplitForm {
@@ -595,6 +651,12 @@ type CallForm struct {
 	functionName string
 	parameters   []Name
 	ProviderType types.SessionType
+	// Calls to local functions refer to the lifted function, and pass on the names it captures after the ones written
+	// (see LiftLocalDefinitions). They are still printed as written.
+	writtenName string
+	captured    int
+	// Set while the names captured by the local function are being worked out
+	local *localFunction
 }
 
 func NewCall(functionName string, parameters []Name) *CallForm {
@@ -605,14 +667,25 @@ func NewCall(functionName string, parameters []Name) *CallForm {
 }
 
 func (p *CallForm) String() string {
+	functionName, parameters := p.written()
+
 	var buf bytes.Buffer
-	buf.WriteString(p.functionName)
+	buf.WriteString(functionName)
 	buf.WriteString("(")
-	buf.WriteString(NamesToString(p.parameters))
+	buf.WriteString(NamesToString(parameters))
 	buf.WriteString(")")
 	return buf.String()
 }
 
+// The function name and parameters as written in the source
+func (p *CallForm) written() (string, []Name) {
+	if p.writtenName == "" {
+		return p.functionName, p.parameters
+	}
+
+	return p.writtenName, p.parameters[:len(p.parameters)-p.captured]
+}
+
 func (p *CallForm) StringShort() string {
 	return p.String()
 }
@@ -628,6 +701,9 @@ func (p *CallForm) FreeNames() []Name {
 	for i := range p.parameters {
 		fn = appendIfNotSelf(p.parameters[i], fn)
 	}
+	if p.local != nil {
+		fn = append(fn, p.local.captured...)
+	}
 	return fn
 }
 
@@ -706,6 +782,8 @@ func (p *WaitForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironment
 type CastForm struct {
 	to_c           Name
 	continuation_c Name
+	// Inserted by the elaboration (see insertShifts)
+	inserted bool
 }
 
 func NewCast(to_c, continuation_c Name) *CastForm {
@@ -756,6 +834,8 @@ type ShiftForm struct {
 	continuation_c Name
 	from_c         Name
 	continuation_e Form
+	// Inserted by the elaboration (see insertShifts)
+	inserted bool
 }
 
 func NewShift(continuation_c, from_c Name, continuation_e Form) *ShiftForm {
@@ -868,6 +948,8 @@ func (p *DropForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironment
 type PrintForm struct {
 	label          Label
 	continuation_e Form
+	// The value printed instead of the label, once received by print(x)
+	value *printedValue
 }
 
 func NewPrint(label Label, continuation_e Form) *PrintForm {
@@ -907,6 +989,213 @@ func (p *PrintForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironmen
 	return p.continuation_e.Polarity(fromTypes, globalEnvironment)
 }
 
+// Print value: print(x); P
+// Consumes x and prints its value, once received (see nextStep)
+type PrintValueForm struct {
+	from_c         Name
+	continuation_e Form
+	// The type of from_c, as set by the typechecker
+	valueType types.SessionType
+	// While running, the part of the value being received on from_c, and the label it was selected with (if any)
+	value *printedValue
+	label string
+}
+
+func NewPrintValue(from_c Name, continuation_e Form) *PrintValueForm {
+	return &PrintValueForm{
+		from_c:         from_c,
+		continuation_e: continuation_e,
+	}
+}
+
+func (p *PrintValueForm) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("print(")
+	buf.WriteString(p.from_c.String())
+	buf.WriteString("); ")
+	buf.WriteString(p.continuation_e.String())
+	return buf.String()
+}
+
+func (p *PrintValueForm) StringShort() string {
+	var buf bytes.Buffer
+	buf.WriteString("print(")
+	buf.WriteString(p.from_c.String())
+	buf.WriteString("); ...")
+	return buf.String()
+}
+
+func (p *PrintValueForm) Substitute(old, new Name) {
+	p.from_c.Substitute(old, new)
+	p.continuation_e.Substitute(old, new)
+}
+
+func (p *PrintValueForm) FreeNames() []Name {
+	var fn []Name
+	fn = appendIfNotSelf(p.from_c, fn)
+	fn = mergeTwoNamesList(fn, p.continuation_e.FreeNames())
+	return fn
+}
+
+func (p *PrintValueForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironment) types.Polarity {
+	// Lookup polarity from the continuation
+	return p.continuation_e.Polarity(fromTypes, globalEnvironment)
+}
+
+// Let: let <definitions> in P end
+// The functions and types defined locally are only visible within P (and within the definitions themselves). They
+// are lifted to global definitions before typechecking (see LiftLocalDefinitions), so only P is left to run.
+type LetForm struct {
+	functions      []FunctionDefinition
+	types          []types.SessionTypeDefinition
+	continuation_e Form
+	// The lifted functions (in the order of functions), along with the names they capture from this point
+	lifted []liftedFunction
+	// The global functions, to which the local ones are lifted
+	definitions *[]FunctionDefinition
+	// Positions of the let, in and end keywords
+	Position    position.Position
+	InPosition  position.Position
+	EndPosition position.Position
+}
+
+func NewLet(functions []FunctionDefinition, typeDefs []types.SessionTypeDefinition, continuation_e Form) *LetForm {
+	return &LetForm{
+		functions:      functions,
+		types:          typeDefs,
+		continuation_e: continuation_e,
+	}
+}
+
+func (p *LetForm) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("let ")
+	for _, t := range p.types {
+		buf.WriteString("type ")
+		buf.WriteString(t.Name)
+		buf.WriteString(" = ")
+		buf.WriteString(t.SessionType.String())
+		buf.WriteString(" ")
+	}
+	for _, f := range p.functions {
+		buf.WriteString("let ")
+		buf.WriteString(f.String())
+		buf.WriteString(" = ")
+		buf.WriteString(f.Body.String())
+		buf.WriteString(" ")
+	}
+	buf.WriteString("in ")
+	buf.WriteString(p.continuation_e.String())
+	buf.WriteString(" end")
+	return buf.String()
+}
+
+func (p *LetForm) StringShort() string {
+	var buf bytes.Buffer
+	buf.WriteString("let ")
+	for _, t := range p.types {
+		buf.WriteString("type ")
+		buf.WriteString(t.Name)
+		buf.WriteString(" ")
+	}
+	for _, f := range p.functions {
+		buf.WriteString("let ")
+		buf.WriteString(f.String())
+		buf.WriteString(" ")
+	}
+	buf.WriteString("in ... end")
+	return buf.String()
+}
+
+func (p *LetForm) Substitute(old, new Name) {
+	for i := range p.lifted {
+		for j := range p.lifted[i].captured {
+			p.lifted[i].captured[j].Substitute(old, new)
+		}
+	}
+	p.continuation_e.Substitute(old, new)
+}
+
+// The captured names are only used by the calls (which pass them on), rather than by the let block itself
+func (p *LetForm) FreeNames() []Name {
+	return p.continuation_e.FreeNames()
+}
+
+func (p *LetForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironment) types.Polarity {
+	// Lookup polarity from the continuation
+	return p.continuation_e.Polarity(fromTypes, globalEnvironment)
+}
+
+// Hole: ?
+// Stands for a term which is yet to be written. The typechecker reports what is expected in its place.
+type HoleForm struct {
+	Position position.Position
+}
+
+func NewHole(position position.Position) *HoleForm {
+	return &HoleForm{Position: position}
+}
+
+func (p *HoleForm) String() string {
+	return "?"
+}
+
+func (p *HoleForm) StringShort() string {
+	return p.String()
+}
+
+func (p *HoleForm) Substitute(old, new Name) {}
+
+func (p *HoleForm) FreeNames() []Name {
+	return nil
+}
+
+func (p *HoleForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironment) types.Polarity {
+	return types.UNKNOWN
+}
+
+// Input: input self
+// Provides self by reading labels from the standard input (see readInput)
+type InputForm struct {
+	from_c Name
+	// The type provided, as set by the typechecker
+	inputType types.SessionType
+}
+
+func NewInput(from_c Name) *InputForm {
+	return &InputForm{from_c: from_c}
+}
+
+func (p *InputForm) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("input ")
+	buf.WriteString(p.from_c.String())
+	return buf.String()
+}
+
+func (p *InputForm) StringShort() string {
+	return p.String()
+}
+
+func (p *InputForm) Substitute(old, new Name) {
+	p.from_c.Substitute(old, new)
+}
+
+func (p *InputForm) FreeNames() []Name {
+	var fn []Name
+	fn = appendIfNotSelf(p.from_c, fn)
+	return fn
+}
+
+func (p *InputForm) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironment) types.Polarity {
+	if p.from_c.IsSelf {
+		// Selects a label or closes, as read from the input
+		return types.POSITIVE
+	}
+
+	return types.UNKNOWN
+}
+
 // Check equality between different forms
 func EqualForm(form1, form2 Form) bool {
 	a := reflect.TypeOf(form1)
@@ -942,6 +1231,10 @@ func EqualForm(form1, form2 Form) bool {
 		f2, ok2 := form2.(*CaseForm)
 
 		if ok1 && ok2 {
+			if len(f1.branches) != len(f2.branches) {
+				return false
+			}
+
 			for index := range f1.branches {
 				if !EqualForm(f1.branches[index], f2.branches[index]) {
 					return false
@@ -1041,6 +1334,29 @@ func EqualForm(form1, form2 Form) bool {
 		if ok1 && ok2 {
 			return f1.label.Equal(f2.label) && EqualForm(f1.continuation_e, f2.continuation_e)
 		}
+	case *PrintValueForm:
+		f1, ok1 := form1.(*PrintValueForm)
+		f2, ok2 := form2.(*PrintValueForm)
+
+		if ok1 && ok2 {
+			return f1.from_c.Equal(f2.from_c) && EqualForm(f1.continuation_e, f2.continuation_e)
+		}
+	case *LetForm:
+		f1, ok1 := form1.(*LetForm)
+		f2, ok2 := form2.(*LetForm)
+
+		if ok1 && ok2 {
+			return equalLocalDefinitions(f1, f2) && EqualForm(f1.continuation_e, f2.continuation_e)
+		}
+	case *HoleForm:
+		return true
+	case *InputForm:
+		f1, ok1 := form1.(*InputForm)
+		f2, ok2 := form2.(*InputForm)
+
+		if ok1 && ok2 {
+			return f1.from_c.Equal(f2.from_c)
+		}
 	}
 
 	fmt.Printf("todo implement EqualForm for type %s\n", a)
@@ -1060,7 +1376,10 @@ func CopyForm(orig Form) Form {
 		p, ok := orig.(*ReceiveForm)
 		if ok {
 			cont := CopyForm(p.continuation_e)
-			return NewReceive(*p.payload_c.Copy(), *p.continuation_c.Copy(), *p.from_c.Copy(), cont)
+			receive := NewReceive(*p.payload_c.Copy(), *p.continuation_c.Copy(), *p.from_c.Copy(), cont)
+			receive.derivedFromMacro = p.derivedFromMacro
+			receive.tupled = p.tupled
+			return receive
 		}
 	case *SelectForm:
 		p, ok := orig.(*SelectForm)
@@ -1077,14 +1396,20 @@ func CopyForm(orig Form) Form {
 				branches[i] = b
 			}
 
-			return NewCase(*p.from_c.Copy(), branches)
+			caseForm := NewCase(*p.from_c.Copy(), branches)
+			caseForm.patternOf = p.patternOf
+			caseForm.patternPath = p.patternPath
+			caseForm.element = p.element
+			return caseForm
 		}
 
 	case *BranchForm:
 		p, ok := orig.(*BranchForm)
 		if ok {
 			cont := CopyForm(p.continuation_e)
-			return NewBranch(p.label, *p.payload_c.Copy(), cont)
+			branch := NewBranch(p.label, *p.payload_c.Copy(), cont)
+			branch.nested = p.nested
+			return branch
 		}
 	case *CloseForm:
 		p, ok := orig.(*CloseForm)
@@ -1096,7 +1421,12 @@ func CopyForm(orig Form) Form {
 		if ok {
 			body := CopyForm(p.body)
 			cont := CopyForm(p.continuation_e)
-			return NewNew(*p.new_name_c.Copy(), body, cont)
+			newForm := NewNew(*p.new_name_c.Copy(), body, cont)
+			newForm.derivedFromMacro = p.derivedFromMacro
+			newForm.onProvider = p.onProvider
+			newForm.tupled = p.tupled
+			newForm.insertedType = p.insertedType
+			return newForm
 		}
 	case *ForwardForm:
 		p, ok := orig.(*ForwardForm)
@@ -1107,7 +1437,9 @@ func CopyForm(orig Form) Form {
 		p, ok := orig.(*SplitForm)
 		if ok {
 			cont := CopyForm(p.continuation_e)
-			return NewSplit(*p.channel_one.Copy(), *p.channel_two.Copy(), *p.from_c.Copy(), cont)
+			split := NewSplit(*p.channel_one.Copy(), *p.channel_two.Copy(), *p.from_c.Copy(), cont)
+			split.contracted = p.contracted
+			return split
 		}
 	case *CallForm:
 		p, ok := orig.(*CallForm)
@@ -1116,7 +1448,10 @@ func CopyForm(orig Form) Form {
 			for i := 0; i < len(p.parameters); i++ {
 				copiedParameters[i] = *p.parameters[i].Copy()
 			}
-			return NewCall(p.functionName, copiedParameters)
+			call := NewCall(p.functionName, copiedParameters)
+			call.writtenName = p.writtenName
+			call.captured = p.captured
+			return call
 		}
 	case *WaitForm:
 		p, ok := orig.(*WaitForm)
@@ -1127,13 +1462,17 @@ func CopyForm(orig Form) Form {
 	case *CastForm:
 		p, ok := orig.(*CastForm)
 		if ok {
-			return NewCast(*p.to_c.Copy(), p.continuation_c)
+			cast := NewCast(*p.to_c.Copy(), p.continuation_c)
+			cast.inserted = p.inserted
+			return cast
 		}
 	case *ShiftForm:
 		p, ok := orig.(*ShiftForm)
 		if ok {
 			cont := CopyForm(p.continuation_e)
-			return NewShift(*p.continuation_c.Copy(), *p.from_c.Copy(), cont)
+			shift := NewShift(*p.continuation_c.Copy(), *p.from_c.Copy(), cont)
+			shift.inserted = p.inserted
+			return shift
 		}
 	case *DropForm:
 		p, ok := orig.(*DropForm)
@@ -1145,13 +1484,132 @@ func CopyForm(orig Form) Form {
 	case *PrintForm:
 		p, ok := orig.(*PrintForm)
 		if ok {
-			return NewPrint(p.label, CopyForm(p.continuation_e))
+			printForm := NewPrint(p.label, CopyForm(p.continuation_e))
+			printForm.value = p.value
+			return printForm
+		}
+	case *PrintValueForm:
+		p, ok := orig.(*PrintValueForm)
+		if ok {
+			printForm := NewPrintValue(*p.from_c.Copy(), CopyForm(p.continuation_e))
+			printForm.valueType = p.valueType
+			printForm.value, printForm.label = p.value, p.label
+			return printForm
+		}
+	case *LetForm:
+		p, ok := orig.(*LetForm)
+		if ok {
+			let := NewLet(p.functions, p.types, CopyForm(p.continuation_e))
+			let.definitions = p.definitions
+			let.Position, let.InPosition, let.EndPosition = p.Position, p.InPosition, p.EndPosition
+			for _, lifted := range p.lifted {
+				let.lifted = append(let.lifted, liftedFunction{name: lifted.name, captured: copyNames(lifted.captured)})
+			}
+			return let
 		}
+	case *HoleForm:
+		return NewHole(orig.(*HoleForm).Position)
+	case *InputForm:
+		p := orig.(*InputForm)
+		input := NewInput(*p.from_c.Copy())
+		input.inputType = p.inputType
+		return input
 	}
 
 	panic("modify CopyForm to handle new type")
 }
 
+// Returns a reference to each name (both free and bound) found in a form, in the order they are written in the source.
+// After typechecking, these names also carry their session types.
+func AllNames(form Form) []*Name {
+	switch p := form.(type) {
+	case *SendForm:
+		return []*Name{&p.to_c, &p.payload_c, &p.continuation_c}
+	case *ReceiveForm:
+		return append([]*Name{&p.payload_c, &p.continuation_c, &p.from_c}, AllNames(p.continuation_e)...)
+	case *SelectForm:
+		return []*Name{&p.to_c, &p.continuation_c}
+	case *CaseForm:
+		names := []*Name{&p.from_c}
+		for _, b := range p.branches {
+			names = append(names, AllNames(b)...)
+		}
+		return names
+	case *BranchForm:
+		return append([]*Name{&p.payload_c}, AllNames(p.continuation_e)...)
+	case *NewForm:
+		names := []*Name{&p.new_name_c}
+		names = append(names, AllNames(p.body)...)
+		return append(names, AllNames(p.continuation_e)...)
+	case *CloseForm:
+		return []*Name{&p.from_c}
+	case *InputForm:
+		return []*Name{&p.from_c}
+	case *ForwardForm:
+		return []*Name{&p.to_c, &p.from_c}
+	case *SplitForm:
+		return append([]*Name{&p.channel_one, &p.channel_two, &p.from_c}, AllNames(p.continuation_e)...)
+	case *CallForm:
+		names := make([]*Name, len(p.parameters))
+		for i := range p.parameters {
+			names[i] = &p.parameters[i]
+		}
+		return names
+	case *WaitForm:
+		return append([]*Name{&p.to_c}, AllNames(p.continuation_e)...)
+	case *CastForm:
+		return []*Name{&p.to_c, &p.continuation_c}
+	case *ShiftForm:
+		return append([]*Name{&p.continuation_c, &p.from_c}, AllNames(p.continuation_e)...)
+	case *DropForm:
+		return append([]*Name{&p.client_c}, AllNames(p.continuation_e)...)
+	case *PrintForm:
+		return AllNames(p.continuation_e)
+	case *PrintValueForm:
+		return append([]*Name{&p.from_c}, AllNames(p.continuation_e)...)
+	case *LetForm:
+		return AllNames(p.continuation_e)
+	}
+
+	return nil
+}
+
+// Returns the form itself followed by all of its inner forms (i.e. bodies, branches and continuations), in the order
+// they are written in the source
+func AllForms(form Form) []Form {
+	forms := []Form{form}
+
+	switch p := form.(type) {
+	case *ReceiveForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *CaseForm:
+		for _, b := range p.branches {
+			forms = append(forms, AllForms(b)...)
+		}
+	case *BranchForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *NewForm:
+		forms = append(forms, AllForms(p.body)...)
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *SplitForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *WaitForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *ShiftForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *DropForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *PrintForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *PrintValueForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	case *LetForm:
+		forms = append(forms, AllForms(p.continuation_e)...)
+	}
+
+	return forms
+}
+
 // Return true if the given for has continuation expression, or false otherwise (i.e. follows an axiomatic rule)
 func FormHasContinuation(form Form) bool {
 	switch interface{}(form).(type) {
@@ -1167,6 +1625,10 @@ func FormHasContinuation(form Form) bool {
 		return false
 	case *CastForm:
 		return false
+	case *HoleForm:
+		return false
+	case *InputForm:
+		return false
 	default:
 		// These have a continuation:
 		// -> ReceiveForm:
@@ -1178,12 +1640,16 @@ func FormHasContinuation(form Form) bool {
 		// -> ShiftForm:
 		// -> DropForm:
 		// -> PrintForm:
+		// -> PrintValueForm:
+		// -> LetForm:
 		return true
 	}
 }
 
 type Label struct {
 	L string
+	// Where the label occurs in the source (set by the parser)
+	Position position.Position
 }
 
 func (p *Label) String() string {
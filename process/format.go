@@ -0,0 +1,370 @@
+package process
+
+import (
+	"fmt"
+	"grits/position"
+	"grits/types"
+	"strings"
+)
+
+// FormattedLine is a line of code printed by the formatter, along with the range of source positions it originates from.
+// The source positions are needed to place the comments (which are not part of the AST) back in the right place.
+type FormattedLine struct {
+	Text string
+	// Source positions (1-based) of the first and last names and labels printed on this line, or 0 if unknown (e.g.
+	// closing brackets)
+	FirstLine, FirstChar int
+	LastLine, LastChar   int
+}
+
+// AddSourcePosition extends the range of source positions of the line to include the given one
+func (l *FormattedLine) AddSourcePosition(line, char int) {
+	if line <= 0 {
+		return
+	}
+
+	if l.FirstLine == 0 || line < l.FirstLine || (line == l.FirstLine && char < l.FirstChar) {
+		l.FirstLine, l.FirstChar = line, char
+	}
+
+	if line > l.LastLine || (line == l.LastLine && char > l.LastChar) {
+		l.LastLine, l.LastChar = line, char
+	}
+}
+
+func (l *FormattedLine) addSourcePosition(p position.Position) {
+	l.AddSourcePosition(p.StartLine, p.StartPos)
+}
+
+func newFormattedLine(text string, names ...*Name) FormattedLine {
+	line := FormattedLine{Text: text}
+	for _, n := range names {
+		line.addSourcePosition(n.Position)
+	}
+	return line
+}
+
+// FormatForm prints a form using the layout of 'grits fmt': one step per line, with aligned case branches and new chains.
+// The first line is not indented (so that it can follow some prefix), whereas the rest are indented by 'column' spaces.
+func FormatForm(form Form, column int) []FormattedLine {
+	switch p := form.(type) {
+	case *SendForm:
+		return []FormattedLine{newFormattedLine(fmt.Sprintf("send %s<%s, %s>", FormatName(p.to_c), FormatName(p.payload_c), FormatName(p.continuation_c)), &p.to_c, &p.payload_c, &p.continuation_c)}
+	case *ReceiveForm:
+		if names, continuation_e, ok := p.tuple(); ok {
+			line := newFormattedLine(fmt.Sprintf("<%s> <- recv %s;", FormatNames(names), FormatName(p.from_c)), append(namePointers(names), &p.from_c)...)
+			return formatSequence(line, continuation_e, column)
+		}
+		if p.macro() {
+			line := newFormattedLine(fmt.Sprintf("%s <- recv %s;", FormatName(p.payload_c), FormatName(p.from_c)), &p.payload_c, &p.from_c)
+			return formatSequence(line, p.continuation_e, column)
+		}
+		line := newFormattedLine(fmt.Sprintf("<%s, %s> <- recv %s;", FormatName(p.payload_c), FormatName(p.continuation_c), FormatName(p.from_c)), &p.payload_c, &p.continuation_c, &p.from_c)
+		return formatSequence(line, p.continuation_e, column)
+	case *SelectForm:
+		line := newFormattedLine(fmt.Sprintf("%s.%s<%s>", FormatName(p.to_c), p.label.L, FormatName(p.continuation_c)), &p.to_c, &p.continuation_c)
+		line.addSourcePosition(p.label.Position)
+		return []FormattedLine{line}
+	case *CaseForm:
+		return formatCase(p, column)
+	case *NewForm:
+		if to_c, names, ok := p.tuple(); ok {
+			return []FormattedLine{newFormattedLine(fmt.Sprintf("send %s<%s>", FormatName(to_c), FormatNames(names)), append(namePointers(names), &to_c)...)}
+		}
+		if step, rest, ok := p.macro(); ok {
+			line := newFormattedLine(macroStep(step, FormatName)+";", macroNames(step)...)
+			if selectForm, ok := step.(*SelectForm); ok {
+				line.addSourcePosition(selectForm.label.Position)
+			}
+			return formatSequence(line, rest, column)
+		}
+		return formatNewChain(p, column)
+	case *CloseForm:
+		return []FormattedLine{newFormattedLine("close "+FormatName(p.from_c), &p.from_c)}
+	case *InputForm:
+		return []FormattedLine{newFormattedLine("input "+FormatName(p.from_c), &p.from_c)}
+	case *ForwardForm:
+		return []FormattedLine{newFormattedLine(fmt.Sprintf("fwd %s %s", FormatName(p.to_c), FormatName(p.from_c)), &p.to_c, &p.from_c)}
+	case *SplitForm:
+		line := newFormattedLine(fmt.Sprintf("<%s, %s> <- split %s;", FormatName(p.channel_one), FormatName(p.channel_two), FormatName(p.from_c)), &p.channel_one, &p.channel_two, &p.from_c)
+		return formatSequence(line, p.continuation_e, column)
+	case *CallForm:
+		functionName, parameters := p.written()
+		return []FormattedLine{newFormattedLine(fmt.Sprintf("%s(%s)", functionName, FormatNames(parameters)), AllNames(p)...)}
+	case *WaitForm:
+		return formatSequence(newFormattedLine(fmt.Sprintf("wait %s;", FormatName(p.to_c)), &p.to_c), p.continuation_e, column)
+	case *CastForm:
+		return []FormattedLine{newFormattedLine(fmt.Sprintf("cast %s<%s>", FormatName(p.to_c), FormatName(p.continuation_c)), &p.to_c, &p.continuation_c)}
+	case *ShiftForm:
+		line := newFormattedLine(fmt.Sprintf("%s <- shift %s;", FormatName(p.continuation_c), FormatName(p.from_c)), &p.continuation_c, &p.from_c)
+		return formatSequence(line, p.continuation_e, column)
+	case *DropForm:
+		return formatSequence(newFormattedLine(fmt.Sprintf("drop %s;", FormatName(p.client_c)), &p.client_c), p.continuation_e, column)
+	case *PrintForm:
+		line := newFormattedLine(fmt.Sprintf("print %s;", p.label.L))
+		line.addSourcePosition(p.label.Position)
+		return formatSequence(line, p.continuation_e, column)
+	case *PrintValueForm:
+		return formatSequence(newFormattedLine(fmt.Sprintf("print(%s);", FormatName(p.from_c)), &p.from_c), p.continuation_e, column)
+	case *LetForm:
+		return formatLet(p, column)
+	case *HoleForm:
+		line := newFormattedLine("?")
+		line.addSourcePosition(p.Position)
+		return []FormattedLine{line}
+	}
+
+	// Branches are printed as part of their case construct
+	return []FormattedLine{{Text: form.String()}}
+}
+
+// FormatName prints a name as written in the source (unlike String(), which is meant for debugging)
+func FormatName(n Name) string {
+	var prefix string
+	if n.ExplicitPolarity != nil {
+		switch *n.ExplicitPolarity {
+		case types.POSITIVE:
+			prefix = "+"
+		case types.NEGATIVE:
+			prefix = "-"
+		}
+	}
+
+	if n.IsSelf && n.Ident == "" {
+		return prefix + "self"
+	}
+
+	return prefix + n.Ident
+}
+
+func FormatNames(names []Name) string {
+	formatted := make([]string, len(names))
+	for i := range names {
+		formatted[i] = FormatName(names[i])
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func namePointers(names []Name) []*Name {
+	pointers := make([]*Name, len(names))
+	for i := range names {
+		pointers[i] = &names[i]
+	}
+	return pointers
+}
+
+// Name along with its type annotation (if any), e.g. x : nat
+func FormatNameWithType(n Name) string {
+	if n.Type == nil {
+		return FormatName(n)
+	}
+
+	return FormatName(n) + " : " + types.FormatType(n.Type)
+}
+
+// The continuation is placed on the next line, at the same indentation
+func formatSequence(line FormattedLine, continuation Form, column int) []FormattedLine {
+	rest := FormatForm(continuation, column)
+	rest[0].Text = indentation(column) + rest[0].Text
+	return append([]FormattedLine{line}, rest...)
+}
+
+// Branches are aligned on their arrows:
+//
+//	case x (
+//	      zero<x'> => ...
+//	    | succ<x'> => ...
+//	)
+func formatCase(p *CaseForm, column int) []FormattedLine {
+	if len(p.branches) == 0 {
+		return []FormattedLine{newFormattedLine(fmt.Sprintf("case %s ()", FormatName(p.from_c)), &p.from_c)}
+	}
+
+	lines := []FormattedLine{newFormattedLine(fmt.Sprintf("case %s (", FormatName(p.from_c)), &p.from_c)}
+
+	// Nested patterns are printed as written, one branch each
+	branches := writtenBranches(p.branches, FormatName)
+	width := 0
+	for _, b := range branches {
+		width = max(width, len(b.pattern))
+	}
+
+	bodyColumn := column + 6 + width + len(" => ")
+	for i, b := range branches {
+		prefix := indentation(column+4) + "| "
+		if i == 0 {
+			prefix = indentation(column + 6)
+		}
+
+		body := FormatForm(b.continuation, bodyColumn)
+		body[0].Text = prefix + padRight(b.pattern, width) + " => " + body[0].Text
+		for _, label := range b.labels {
+			body[0].addSourcePosition(label.Position)
+		}
+		for _, name := range b.names {
+			body[0].addSourcePosition(name.Position)
+		}
+
+		lines = append(lines, body...)
+	}
+
+	return append(lines, FormattedLine{Text: indentation(column) + ")"})
+}
+
+// The definitions and the body are each indented by 4 spaces:
+//
+//	let
+//	    type t = A
+//	    let f(x : A) : B = ...
+//	in
+//	    ...
+//	end
+func formatLet(p *LetForm, column int) []FormattedLine {
+	start := FormattedLine{Text: "let"}
+	start.addSourcePosition(p.Position)
+	lines := []FormattedLine{start}
+
+	for _, t := range p.types {
+		line := FormattedLine{Text: indentation(column+4) + fmt.Sprintf("type %s = %s", t.Name, types.FormatType(t.SessionType))}
+		line.addSourcePosition(t.Position)
+		lines = append(lines, line)
+	}
+
+	for _, f := range p.functions {
+		header := newFormattedLine(indentation(column+4) + FormatFunctionHeader(f))
+		for i := range f.Parameters {
+			header.addSourcePosition(f.Parameters[i].Position)
+		}
+
+		body := FormatForm(f.Body, column+8)
+		if len(body) == 1 {
+			// Short bodies remain on the same line, as for global functions
+			header.Text += " " + body[0].Text
+			header.AddSourcePosition(body[0].FirstLine, body[0].FirstChar)
+			header.AddSourcePosition(body[0].LastLine, body[0].LastChar)
+			lines = append(lines, header)
+		} else {
+			body[0].Text = indentation(column+8) + body[0].Text
+			lines = append(append(lines, header), body...)
+		}
+	}
+
+	in := FormattedLine{Text: indentation(column) + "in"}
+	in.addSourcePosition(p.InPosition)
+	lines = append(lines, in)
+
+	body := FormatForm(p.continuation_e, column+4)
+	body[0].Text = indentation(column+4) + body[0].Text
+	lines = append(lines, body...)
+
+	end := FormattedLine{Text: indentation(column) + "end"}
+	end.addSourcePosition(p.EndPosition)
+	return append(lines, end)
+}
+
+// FormatFunctionHeader prints the header of a function as written in the source, e.g. let f(x : nat) : nat =
+func FormatFunctionHeader(function FunctionDefinition) string {
+	var header string
+
+	if function.UsesExplicitProvider {
+		provider := function.ExplicitProvider
+		provider.Type = function.Type
+		names := append([]Name{provider}, function.WrittenParameters()...)
+		header = fmt.Sprintf("let %s[%s]", function.FunctionName, formatNamesWithTypes(names))
+	} else {
+		header = fmt.Sprintf("let %s(%s)", function.FunctionName, formatNamesWithTypes(function.WrittenParameters()))
+		if function.Type != nil {
+			header += " : " + types.FormatType(function.Type)
+		}
+	}
+
+	return header + " ="
+}
+
+// Consecutive new constructs are aligned on their arrows, e.g.
+//
+//	a       <- new f();
+//	b : nat <- new g();
+//
+// Only constructs on adjacent source lines are aligned together, so blank lines and comments start a new group.
+func formatNewChain(p *NewForm, column int) []FormattedLine {
+	chain := []*NewForm{p}
+	for {
+		next, ok := chain[len(chain)-1].continuation_e.(*NewForm)
+		if !ok {
+			break
+		}
+		if _, _, isMacro := next.macro(); isMacro {
+			break
+		}
+		chain = append(chain, next)
+	}
+
+	var lines []FormattedLine
+	for start := 0; start < len(chain); {
+		end := start + 1
+		for end < len(chain) && isAdjacentNew(chain[end-1], chain[end]) {
+			end++
+		}
+
+		width := 0
+		for _, n := range chain[start:end] {
+			width = max(width, len(FormatNameWithType(n.new_name_c)))
+		}
+
+		for _, n := range chain[start:end] {
+			prefix := padRight(FormatNameWithType(n.new_name_c), width) + " <- new "
+
+			// Bodies spanning multiple steps are bracketed, since the ';' would otherwise end the new construct
+			bracketed := FormHasContinuation(n.body)
+			bodyColumn := column + len(prefix)
+			if bracketed {
+				bodyColumn++
+			}
+
+			body := FormatForm(n.body, bodyColumn)
+			if bracketed {
+				body[0].Text = "(" + body[0].Text
+				body[len(body)-1].Text += ")"
+			}
+			body[0].Text = prefix + body[0].Text
+			body[0].addSourcePosition(n.new_name_c.Position)
+			body[len(body)-1].Text += ";"
+
+			if len(lines) > 0 {
+				body[0].Text = indentation(column) + body[0].Text
+			}
+
+			lines = append(lines, body...)
+		}
+
+		start = end
+	}
+
+	rest := FormatForm(chain[len(chain)-1].continuation_e, column)
+	rest[0].Text = indentation(column) + rest[0].Text
+	return append(lines, rest...)
+}
+
+// Checks whether the second new construct starts on the line following the first one (or on the same line)
+func isAdjacentNew(first, second *NewForm) bool {
+	lastLine := first.new_name_c.Position.StartLine
+	for _, n := range AllNames(first.body) {
+		lastLine = max(lastLine, n.Position.StartLine)
+	}
+
+	secondLine := second.new_name_c.Position.StartLine
+	if lastLine == 0 || secondLine == 0 {
+		return true
+	}
+
+	return secondLine-lastLine <= 1
+}
+
+func indentation(column int) string {
+	return strings.Repeat(" ", column)
+}
+
+func padRight(text string, width int) string {
+	return text + strings.Repeat(" ", width-len(text))
+}
@@ -19,6 +19,42 @@ type GlobalEnvironment struct {
 
 	// Logging levels
 	LogLevels []LogLevel
+
+	// When set, the typechecker records the derivation of each function and process, indexed by the function name
+	// or the process outline (e.g. prc[a])
+	RecordDerivations bool
+	Derivations       map[string]*Derivation
+
+	// Derivations being built (the last one belongs to the form currently being typechecked)
+	derivationName  string
+	derivationStack []*Derivation
+
+	// Warnings found by the latest run of the typechecker, and the options controlling which ones are reported
+	Warnings       []Warning
+	WarningOptions WarningOptions
+
+	// When set, the typechecker also checks whether each function is terminating or productive
+	CheckTermination   bool
+	TerminationResults []TerminationResult
+
+	// When set, missing type annotations (e.g. of processes, function parameters or new names) are taken to be the
+	// dynamic type ?, rather than being reported as errors
+	Gradual bool
+
+	// When set, missing type annotations (of function signatures, processes and new names) are inferred from the way
+	// names are used. The annotations filled in by the latest run of the typechecker are listed in InferredAnnotations.
+	InferTypes          bool
+	InferredAnnotations []InferredAnnotation
+
+	// When set, the shifts and casts needed to move between modes are inserted according to the declared types (see
+	// insertShifts), e.g. a name of type lin /\ aff A can be used directly as an A
+	InsertShifts bool
+
+	// Holes (?) found by the latest run of the typechecker, each listing what is expected in its place
+	Holes []Hole
+
+	// Weakenable names left behind by each form ending a process, which are dropped once typechecking succeeds
+	implicitDrops map[Form][]Name
 }
 
 /////////////////////////////////////////////////////
@@ -0,0 +1,186 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"grits/position"
+	"grits/types"
+	"sort"
+	"strings"
+)
+
+// A hole (?) stands for a term which has not been written yet. Instead of failing, the typechecker records what is
+// expected in its place (see HoleForm) and continues checking the rest of the program.
+type Hole struct {
+	Position position.Position
+	// The name being provided (self, or its explicit name) and the type expected from it
+	Provider         string
+	ProviderType     types.SessionType
+	ProviderPolarity types.Polarity
+	// The names available at the hole, as x : A; y : B
+	Context    string
+	Candidates []HoleCandidate
+
+	// The typing context at the hole, from which the forms filling it are synthesized (see SynthesizeHole)
+	gamma            NamesTypesCtx
+	labelledTypesEnv types.LabelledTypesEnv
+	sigma            FunctionTypesEnv
+}
+
+// A rule which can be applied at a hole, together with an outline of the form it corresponds to
+type HoleCandidate struct {
+	Rule string
+	Form string
+}
+
+// E.g.
+//
+//	(Line 4) hole: expected self : +{a : 1, b : 1} (+ve)
+//	  context: x : 1
+//	  candidates:
+//	    ⊕R (IChoiceR)    self.a<...>
+func (h Hole) String() string {
+	if h.Position.StartLine > 0 {
+		return fmt.Sprintf("(%s) %s", h.Position.String(), h.Description())
+	}
+
+	return h.Description()
+}
+
+// The report of the hole, without its position
+func (h Hole) Description() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(fmt.Sprintf("hole: expected %s : %s (%s)\n", h.Provider, h.ProviderType.String(), types.PolarityMap[h.ProviderPolarity]))
+
+	if h.Context == "" {
+		buffer.WriteString("  context: (empty)\n")
+	} else {
+		buffer.WriteString(fmt.Sprintf("  context: %s\n", h.Context))
+	}
+
+	buffer.WriteString("  candidates:")
+	if len(h.Candidates) == 0 {
+		buffer.WriteString(" (none)")
+	}
+
+	for _, candidate := range h.Candidates {
+		buffer.WriteString(fmt.Sprintf("\n    %-16s %s", candidate.Rule, candidate.Form))
+	}
+
+	return buffer.String()
+}
+
+// Lists the rules which apply at a hole. The rules on the provider come first, followed by the ones on each of the
+// names in the context (sorted by name), and finally the forward, drop, split and call rules.
+func holeCandidates(gammaNameTypesCtx NamesTypesCtx, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv) []HoleCandidate {
+	var candidates []HoleCandidate
+
+	add := func(rule, form string, args ...interface{}) {
+		candidates = append(candidates, HoleCandidate{Rule: rule, Form: fmt.Sprintf(form, args...)})
+	}
+
+	provider := types.Unfold(providerType, labelledTypesEnv)
+
+	// Rules on the provider, i.e. right rules
+	switch t := provider.(type) {
+	case *types.SendType:
+		add("⊗R (MulR)", "send self<x, y>")
+	case *types.ReceiveType:
+		add("⊸R (ImpR)", "<x, y> <- recv self; ...")
+	case *types.SelectLabelType:
+		for _, option := range t.Branches {
+			add("⊕R (IChoiceR)", "self.%s<...>", option.Label)
+		}
+	case *types.BranchCaseType:
+		add("& (EChoiceR)", "case self (%s)", caseBranches(t.Branches))
+	case *types.UnitType:
+		add("1R (EndR)", "close self")
+	case *types.DownType:
+		add("↓R (DnSR, Cast)", "cast self<x>")
+	case *types.UpType:
+		add("↑R (UpSR, Shift)", "x <- shift self; ...")
+	case *types.DynamicType:
+		add("?", "any form (the type of self is only known at runtime)")
+	}
+
+	names := make([]string, 0, len(gammaNameTypesCtx))
+	for name, nameType := range gammaNameTypesCtx {
+		if nameType.Type != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Rules on each client, i.e. left rules
+	for _, x := range names {
+		switch t := types.Unfold(gammaNameTypesCtx[x].Type, labelledTypesEnv).(type) {
+		case *types.SendType:
+			add("⊗L (MulL)", "<y, z> <- recv %s; ...", x)
+		case *types.ReceiveType:
+			add("⊸L (ImpL)", "%s' <- new send %s<y, self>; ...", x, x)
+		case *types.SelectLabelType:
+			add("⊕L (IChoiceL)", "case %s (%s)", x, caseBranches(t.Branches))
+		case *types.BranchCaseType:
+			for _, option := range t.Branches {
+				add("& (EChoiceL)", "%s' <- new %s.%s<self>; ...", x, x, option.Label)
+			}
+		case *types.UnitType:
+			add("1L (EndL)", "wait %s; ...", x)
+		case *types.UpType:
+			add("↑L (UpSL, Cast)", "%s' <- new cast %s<self>; ...", x, x)
+		case *types.DownType:
+			add("↓L (DnSL, Shift)", "y <- shift %s; ...", x)
+		}
+	}
+
+	// Names which can be forwarded, dropped or split
+	for _, x := range names {
+		xType := types.Unfold(gammaNameTypesCtx[x].Type, labelledTypesEnv)
+		if types.ConsistentSubtype(xType, providerType, labelledTypesEnv) && (types.IsDynamic(xType) || types.IsDynamic(provider) || xType.Polarity() == provider.Polarity()) {
+			add("ID/FWD", "fwd self %s", x)
+		}
+	}
+
+	for _, x := range names {
+		if types.IsWeakenable(gammaNameTypesCtx[x].Type) {
+			add("DROP", "drop %s; ...", x)
+		}
+	}
+
+	for _, x := range names {
+		if types.IsContractable(gammaNameTypesCtx[x].Type) {
+			add("SPLIT", "<%s1, %s2> <- split %s; ...", x, x, x)
+		}
+	}
+
+	// Functions providing (a subtype of) the expected type
+	functionNames := make([]string, 0, len(sigma))
+	for name := range sigma {
+		functionNames = append(functionNames, name)
+	}
+	sort.Strings(functionNames)
+
+	for _, name := range functionNames {
+		function := sigma[name]
+		if function.Type != nil && types.ConsistentSubtype(function.Type, providerType, labelledTypesEnv) {
+			parameters := []string{"self"}
+			for _, parameter := range function.Parameters {
+				parameters = append(parameters, parameter.Ident)
+			}
+			add("CALL", "%s(%s)", name, strings.Join(parameters, ", "))
+		}
+	}
+
+	return candidates
+}
+
+// E.g. a<x> => ... | b<x> => ...
+func caseBranches(options []types.Option) string {
+	branches := make([]string, len(options))
+	for i, option := range options {
+		branches[i] = fmt.Sprintf("%s<x> => ...", option.Label)
+	}
+
+	return strings.Join(branches, " | ")
+}
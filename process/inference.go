@@ -0,0 +1,1100 @@
+package process
+
+import (
+	"fmt"
+	"grits/position"
+	"grits/types"
+	"sort"
+	"strings"
+)
+
+// Type inference (enabled by GlobalEnvironment.InferTypes) fills in the type annotations which are missing from
+// function signatures, processes and new names, e.g.
+// >    let double(x) = case x ( zero<x'> => self.zero<x'> | succ<x'> => ... )
+// The way each name is used generates constraints on its type (e.g. 'case x (...)' means that x has a select type),
+// which are solved by unification as they are found. Each function has a single signature, shared by all its calls.
+// Modes are inferred in the same way; the ones which are never constrained are set to the default mode, except for
+// the result of functions, which takes the strongest mode allowed by the declaration of independence.
+// Recursive types are replaced by a matching type definition, or by a new one (named t1, t2, ...).
+// The inferred annotations are written back, so the usual typechecker verifies them afterwards.
+
+// An annotation filled in by type inference, printed as it would be written in the source
+type InferredAnnotation struct {
+	Position position.Position
+	Source   string
+}
+
+func (a InferredAnnotation) String() string {
+	if a.Position.StartLine > 0 {
+		return fmt.Sprintf("%s // %s", a.Source, a.Position.String())
+	}
+
+	return a.Source
+}
+
+type inferKind int
+
+const (
+	INFER_UNKNOWN inferKind = iota
+	INFER_DYNAMIC
+	INFER_UNIT
+	INFER_SEND
+	INFER_RECEIVE
+	INFER_SELECT
+	INFER_BRANCH
+	INFER_UP
+	INFER_DOWN
+)
+
+var inferKindMap = map[inferKind]string{
+	INFER_DYNAMIC: "the dynamic type (?)",
+	INFER_UNIT:    "the unit type (1)",
+	INFER_SEND:    "a send type (A * B)",
+	INFER_RECEIVE: "a receive type (A -* B)",
+	INFER_SELECT:  "a select type (+{...})",
+	INFER_BRANCH:  "a branch type (&{...})",
+	INFER_UP:      "an upshift type (/\\)",
+	INFER_DOWN:    "a downshift type (\\/)",
+}
+
+// A mode variable, which is unknown until mode is set
+type inferMode struct {
+	parent *inferMode
+	mode   types.Modality
+}
+
+func (m *inferMode) find() *inferMode {
+	if m.parent == nil {
+		return m
+	}
+
+	m.parent = m.parent.find()
+	return m.parent
+}
+
+func unifyModes(mode1, mode2 *inferMode) error {
+	mode1, mode2 = mode1.find(), mode2.find()
+	if mode1 == mode2 {
+		return nil
+	}
+
+	if mode1.mode != nil && mode2.mode != nil && !mode1.mode.Equals(mode2.mode) {
+		return fmt.Errorf("expected mode %s, but found mode %s", mode1.mode.String(), mode2.mode.String())
+	}
+
+	if mode2.mode == nil {
+		mode2.mode = mode1.mode
+	}
+	mode1.parent = mode2
+	return nil
+}
+
+// A type being inferred. Nodes are merged using union-find, so only the representative (i.e. find()) is up to date.
+type inferNode struct {
+	parent *inferNode
+	kind   inferKind
+	// The mode of the type (for shifts, the mode it is shifted to)
+	mode *inferMode
+	// Payload and continuation of send/receive types (only the continuation is used by shifts)
+	left, right *inferNode
+	// The mode a shift starts from
+	from *inferMode
+	// Choices may gain new labels until they are closed (e.g. by a case)
+	labels  []string
+	options map[string]*inferNode
+	closed  bool
+	// Name of the type definition this node stands for (if any)
+	label     string
+	recursive bool
+}
+
+func (n *inferNode) find() *inferNode {
+	if n.parent == nil {
+		return n
+	}
+
+	n.parent = n.parent.find()
+	return n.parent
+}
+
+func (n *inferNode) children() []*inferNode {
+	children := []*inferNode{}
+	if n.left != nil {
+		children = append(children, n.left)
+	}
+	if n.right != nil {
+		children = append(children, n.right)
+	}
+	for _, label := range n.labels {
+		children = append(children, n.options[label])
+	}
+	return children
+}
+
+func (n *inferNode) option(label string) (*inferNode, error) {
+	if option, exists := n.options[label]; exists {
+		return option, nil
+	}
+
+	if n.closed {
+		return nil, fmt.Errorf("label '%s' is not one of the available labels (%s)", label, strings.Join(n.labels, ", "))
+	}
+
+	option := newInferNode(INFER_UNKNOWN, n.mode)
+	n.labels = append(n.labels, label)
+	n.options[label] = option
+	return option, nil
+}
+
+// A case has to handle all the labels of the choice, after which no more labels can be added to it. Returns the
+// options which are handled by the case (a branch with some other label can never be taken).
+func (n *inferNode) caseOn(labels []string) (map[string]*inferNode, error) {
+	for _, label := range n.labels {
+		if !stringInList(label, labels) {
+			return nil, fmt.Errorf("label '%s' is not handled", label)
+		}
+	}
+
+	if !n.closed {
+		for _, label := range labels {
+			if _, err := n.option(label); err != nil {
+				return nil, err
+			}
+		}
+		n.closed = true
+	}
+
+	return n.options, nil
+}
+
+func newInferNode(kind inferKind, mode *inferMode) *inferNode {
+	n := &inferNode{kind: kind, mode: mode}
+
+	switch kind {
+	case INFER_SEND, INFER_RECEIVE:
+		n.left = newInferNode(INFER_UNKNOWN, mode)
+		n.right = newInferNode(INFER_UNKNOWN, mode)
+	case INFER_SELECT, INFER_BRANCH:
+		n.options = make(map[string]*inferNode)
+	case INFER_UP, INFER_DOWN:
+		n.from = &inferMode{}
+		n.right = newInferNode(INFER_UNKNOWN, n.from)
+	}
+
+	return n
+}
+
+// Makes sure that the node has the expected kind of type, returning its representative
+func expectKind(n *inferNode, kind inferKind) (*inferNode, error) {
+	n = n.find()
+
+	switch n.kind {
+	case kind:
+		return n, nil
+	case INFER_DYNAMIC:
+		// Anything goes, so the constraints are not kept
+		return newInferNode(kind, &inferMode{}), nil
+	case INFER_UNKNOWN:
+		structure := newInferNode(kind, n.mode)
+		n.parent = structure
+		return structure, nil
+	}
+
+	return nil, fmt.Errorf("expected %s, but found %s", inferKindMap[kind], inferKindMap[n.kind])
+}
+
+func unify(node1, node2 *inferNode) error {
+	node1, node2 = node1.find(), node2.find()
+	if node1 == node2 || node1.kind == INFER_DYNAMIC || node2.kind == INFER_DYNAMIC {
+		return nil
+	}
+
+	if node1.kind == INFER_UNKNOWN {
+		node1, node2 = node2, node1
+	}
+
+	if node2.kind != INFER_UNKNOWN && node1.kind != node2.kind {
+		return fmt.Errorf("expected %s, but found %s", inferKindMap[node1.kind], inferKindMap[node2.kind])
+	}
+
+	// Merge first, so that recursive types are unified only once
+	node2.parent = node1
+	if node1.label == "" {
+		node1.label = node2.label
+	}
+
+	if err := unifyModes(node1.mode, node2.mode); err != nil {
+		return err
+	}
+
+	switch node2.kind {
+	case INFER_SEND, INFER_RECEIVE:
+		if err := unify(node1.left, node2.left); err != nil {
+			return err
+		}
+		return unify(node1.right, node2.right)
+	case INFER_SELECT, INFER_BRANCH:
+		return unifyOptions(node1, node2)
+	case INFER_UP, INFER_DOWN:
+		if err := unifyModes(node1.from, node2.from); err != nil {
+			return err
+		}
+		return unify(node1.right, node2.right)
+	}
+
+	return nil
+}
+
+// The labels of both choices are merged into the first one (i.e. the representative). A closed choice cannot gain
+// any new labels.
+func unifyOptions(node1, node2 *inferNode) error {
+	if node2.closed {
+		for _, label := range node1.labels {
+			if _, exists := node2.options[label]; !exists {
+				return fmt.Errorf("label '%s' is not one of the available labels (%s)", label, strings.Join(node2.labels, ", "))
+			}
+		}
+	}
+
+	var pairs [][2]*inferNode
+	for _, label := range node2.labels {
+		option, err := node1.option(label)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, [2]*inferNode{option, node2.options[label]})
+	}
+	node1.closed = node1.closed || node2.closed
+
+	for _, pair := range pairs {
+		if err := unify(pair[0], pair[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The signature shared by all calls to a function
+type inferredSignature struct {
+	parameters []*inferNode
+	result     *inferNode
+}
+
+// A missing annotation, to be filled in with the inferred type
+type missingAnnotation struct {
+	node        *inferNode
+	target      *types.SessionType
+	description string
+	position    position.Position
+	// Called once the type is written, to record the annotation (set only for the last annotation of a statement)
+	record func() InferredAnnotation
+}
+
+type inferenceState struct {
+	typeDefs         []types.SessionTypeDefinition
+	labelledTypesEnv types.LabelledTypesEnv
+	labelledNodes    map[string]*inferNode
+	signatures       map[string]*inferredSignature
+	missing          []missingAnnotation
+	// Counts the unknown types found while converting a node back to a session type
+	unresolved int
+	// Whether the converted types are replaced by equal type definitions (which needs all labels to be defined)
+	matching bool
+	// Generated type definitions which still contain unknown types
+	partialLabels map[string]bool
+}
+
+// Infers the missing type annotations, writes them back and lists them in globalEnv.InferredAnnotations
+func inferTypes(processes []*Process, assumedFreeNames []Name, globalEnv *GlobalEnvironment) error {
+	state := &inferenceState{
+		typeDefs:         *globalEnv.Types,
+		labelledTypesEnv: types.ProduceLabelledSessionTypeEnvironment(*globalEnv.Types),
+		labelledNodes:    make(map[string]*inferNode),
+		signatures:       make(map[string]*inferredSignature),
+		partialLabels:    make(map[string]bool),
+	}
+
+	functions := *globalEnv.FunctionDefinitions
+	for i := range functions {
+		state.addSignature(&functions[i])
+	}
+
+	for i := range functions {
+		f := &functions[i]
+		signature := state.signatures[f.FunctionName]
+		ctx := make(map[string]*inferNode)
+		for j, p := range f.Parameters {
+			ctx[p.Ident] = signature.parameters[j]
+		}
+
+		if err := state.walk(f.Body, ctx, nil, signature.result, f.FunctionName); err != nil {
+			return fmt.Errorf("(%s) unable to infer the types in function %s; %s", f.Position.String(), f.String(), err)
+		}
+	}
+
+	processNodes := make(map[string]*inferNode)
+	for i := range processes {
+		node := state.fromAnnotation(processes[i].Type)
+		if processes[i].Type == nil {
+			p := processes[i]
+			state.missing = append(state.missing, missingAnnotation{
+				node:        node,
+				target:      &p.Type,
+				description: fmt.Sprintf("process %s", p.OutlineString()),
+				position:    p.Position,
+				record: func() InferredAnnotation {
+					return InferredAnnotation{Position: p.Position, Source: fmt.Sprintf("prc[%s] : %s", FormatNames(p.Providers), types.FormatType(p.Type))}
+				},
+			})
+		}
+
+		for _, provider := range processes[i].Providers {
+			processNodes[provider.Ident] = node
+		}
+	}
+
+	for _, name := range assumedFreeNames {
+		processNodes[name.Ident] = state.fromAnnotation(name.Type)
+	}
+
+	for i := range processes {
+		ctx := make(map[string]*inferNode)
+		for _, fn := range processes[i].Body.FreeNames() {
+			if node, exists := processNodes[fn.Ident]; exists {
+				ctx[fn.Ident] = node
+			}
+		}
+
+		if err := state.walk(processes[i].Body, ctx, nil, processNodes[processes[i].Providers[0].Ident], processes[i].OutlineString()); err != nil {
+			return fmt.Errorf("(%s) unable to infer the types in process %s; %s", processes[i].Position.String(), processes[i].OutlineString(), err)
+		}
+	}
+
+	state.resolveModes(functions)
+
+	generated := state.nameRecursiveTypes()
+	state.labelledTypesEnv = types.ProduceLabelledSessionTypeEnvironment(append(append([]types.SessionTypeDefinition{}, state.typeDefs...), generated...))
+	state.matching = true
+
+	var annotations []InferredAnnotation
+	for _, def := range generated {
+		def.SessionType = sourceType(def.SessionType)
+		def.Modality = nil
+		*globalEnv.Types = append(*globalEnv.Types, def)
+		annotations = append(annotations, InferredAnnotation{Source: fmt.Sprintf("type %s = %s", def.Name, types.FormatType(def.SessionType))})
+	}
+
+	for _, m := range state.missing {
+		before := state.unresolved
+		inferred := sourceType(state.toSessionType(m.node, false))
+
+		if state.unresolved != before && !globalEnv.Gradual {
+			if types.IsDynamic(inferred) {
+				return fmt.Errorf("(%s) unable to infer the type of %s; add a type annotation", m.position.String(), m.description)
+			}
+
+			return fmt.Errorf("(%s) unable to infer the type of %s, which is only known to be '%s'%s; add a type annotation", m.position.String(), m.description, types.FormatType(inferred), partialDefinitions(generated, state.partialLabels))
+		}
+
+		*m.target = inferred
+		if m.record != nil {
+			annotations = append(annotations, m.record())
+		}
+	}
+
+	if len(generated) > 0 {
+		types.SetModalityTypeDef(*globalEnv.Types)
+	}
+
+	// Listed in the order they appear in the source (with the generated types first)
+	sort.SliceStable(annotations, func(i, j int) bool {
+		return annotations[i].Position.StartLine < annotations[j].Position.StartLine
+	})
+
+	globalEnv.InferredAnnotations = annotations
+	return nil
+}
+
+// Lists the generated definitions which are not fully known, e.g. ", where type t1 = +{zero : ?, succ : t1}"
+func partialDefinitions(generated []types.SessionTypeDefinition, partialLabels map[string]bool) string {
+	var defs []string
+	for _, def := range generated {
+		if partialLabels[def.Name] {
+			defs = append(defs, fmt.Sprintf("type %s = %s", def.Name, types.FormatType(sourceType(def.SessionType))))
+		}
+	}
+
+	if len(defs) == 0 {
+		return ""
+	}
+
+	return ", where " + strings.Join(defs, " and ")
+}
+
+func (state *inferenceState) addSignature(f *FunctionDefinition) {
+	if _, exists := state.signatures[f.FunctionName]; exists {
+		// Duplicate functions are reported by the typechecker
+		return
+	}
+
+	signature := &inferredSignature{result: state.fromAnnotation(f.Type)}
+	state.signatures[f.FunctionName] = signature
+
+	var missing []missingAnnotation
+	if f.Type == nil {
+		missing = append(missing, missingAnnotation{node: signature.result, target: &f.Type, description: fmt.Sprintf("the result of function %s", f.String()), position: f.Position})
+	}
+
+	for i := range f.Parameters {
+		parameter := &f.Parameters[i]
+		signature.parameters = append(signature.parameters, state.fromAnnotation(parameter.Type))
+		// The names captured by a local function take their types from its let block
+		if parameter.Type == nil && i < len(f.WrittenParameters()) {
+			missing = append(missing, missingAnnotation{node: signature.parameters[i], target: &parameter.Type, description: fmt.Sprintf("parameter '%s' of function %s", parameter.Ident, f.String()), position: f.Position})
+		}
+	}
+
+	if len(missing) > 0 {
+		missing[len(missing)-1].record = func() InferredAnnotation {
+			return InferredAnnotation{Position: f.Position, Source: functionSignature(f)}
+		}
+		state.missing = append(state.missing, missing...)
+	}
+}
+
+// The header of a function, as written in the source, e.g. let f(x : nat) : nat
+func functionSignature(f *FunctionDefinition) string {
+	if f.UsesExplicitProvider {
+		provider := f.ExplicitProvider
+		provider.Type = f.Type
+		names := append([]Name{provider}, f.WrittenParameters()...)
+		return fmt.Sprintf("let %s[%s]", f.FunctionName, formatNamesWithTypes(names))
+	}
+
+	return fmt.Sprintf("let %s(%s) : %s", f.FunctionName, formatNamesWithTypes(f.WrittenParameters()), types.FormatType(f.Type))
+}
+
+func formatNamesWithTypes(names []Name) string {
+	formatted := make([]string, len(names))
+	for i := range names {
+		formatted[i] = FormatNameWithType(names[i])
+	}
+	return strings.Join(formatted, ", ")
+}
+
+/////////////////////////////////////////////////////
+///////////////// Constraint solving ////////////////
+/////////////////////////////////////////////////////
+
+// Each form adds the constraints on the types of the names it uses. The names in scope are kept in ctx, whereas
+// the provider (i.e. self, or its shadow name) has the type provider.
+func (state *inferenceState) walk(form Form, ctx map[string]*inferNode, shadow *Name, provider *inferNode, owner string) error {
+	lookupClient := func(name Name) *inferNode {
+		if name.IsSelf {
+			return provider
+		}
+
+		node, exists := ctx[name.Ident]
+		if !exists {
+			// Undefined names are reported by the typechecker
+			node = newInferNode(INFER_UNKNOWN, &inferMode{})
+			ctx[name.Ident] = node
+		}
+		return node
+	}
+
+	lookup := func(name Name) *inferNode {
+		if isProvider(name, shadow) {
+			return provider
+		}
+
+		return lookupClient(name)
+	}
+
+	var err error
+	switch p := form.(type) {
+	case *SendForm:
+		kind, to := INFER_SEND, provider
+		if !isProvider(p.to_c, shadow) {
+			kind, to = INFER_RECEIVE, lookup(p.to_c)
+		}
+
+		var sendType *inferNode
+		if sendType, err = expectKind(to, kind); err == nil {
+			if err = unify(sendType.left, lookup(p.payload_c)); err == nil {
+				err = unify(sendType.right, lookup(p.continuation_c))
+			}
+		}
+	case *ReceiveForm:
+		if isProvider(p.from_c, shadow) {
+			var receiveType *inferNode
+			if receiveType, err = expectKind(provider, INFER_RECEIVE); err == nil {
+				ctx[p.payload_c.Ident] = receiveType.left
+				return state.walk(p.continuation_e, ctx, &p.continuation_c, receiveType.right, owner)
+			}
+		} else {
+			var sendType *inferNode
+			if sendType, err = expectKind(lookup(p.from_c), INFER_SEND); err == nil {
+				ctx[p.payload_c.Ident] = sendType.left
+				ctx[p.continuation_c.Ident] = sendType.right
+				return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+			}
+		}
+	case *SelectForm:
+		kind, to := INFER_SELECT, provider
+		if !isProvider(p.to_c, shadow) {
+			kind, to = INFER_BRANCH, lookup(p.to_c)
+		}
+
+		var choiceType, option *inferNode
+		if choiceType, err = expectKind(to, kind); err == nil {
+			if option, err = choiceType.option(p.label.L); err == nil {
+				err = unify(option, lookup(p.continuation_c))
+			}
+		}
+	case *CaseForm:
+		kind, from := INFER_BRANCH, provider
+		if !isProvider(p.from_c, shadow) {
+			kind, from = INFER_SELECT, lookup(p.from_c)
+		}
+
+		var choiceType *inferNode
+		var options map[string]*inferNode
+		if choiceType, err = expectKind(from, kind); err == nil {
+			if options, err = choiceType.caseOn(p.labels()); err == nil {
+				for _, branch := range p.branches {
+					option, exists := options[branch.label.L]
+					if !exists {
+						continue
+					}
+
+					branchCtx := copyInferenceContext(ctx)
+					if kind == INFER_BRANCH {
+						err = state.walk(branch.continuation_e, branchCtx, &branch.payload_c, option, owner)
+					} else {
+						branchCtx[branch.payload_c.Ident] = option
+						err = state.walk(branch.continuation_e, branchCtx, shadow, provider, owner)
+					}
+
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	case *NewForm:
+		node := state.fromAnnotation(p.new_name_c.Type)
+		// The names bound by macros are never annotated, since their types follow from the names they continue
+		if p.new_name_c.Type == nil && !p.derivedFromMacro {
+			state.missing = append(state.missing, missingAnnotation{
+				node:        node,
+				target:      &p.new_name_c.Type,
+				description: fmt.Sprintf("'%s' in %s", p.new_name_c.Ident, owner),
+				position:    p.new_name_c.Position,
+				record: func() InferredAnnotation {
+					return InferredAnnotation{Position: p.new_name_c.Position, Source: fmt.Sprintf("%s <- new %s", FormatNameWithType(p.new_name_c), FormatForm(p.body, 0)[0].Text)}
+				},
+			})
+		}
+
+		// The spawned process refers to its provider as self, so a reassigned name still refers to the client in the body
+		bodyShadow := &p.new_name_c
+		if _, reused := ctx[p.new_name_c.Ident]; reused {
+			bodyShadow = &Name{IsSelf: true}
+		}
+
+		if err := state.walk(p.body, copyInferenceContext(ctx), bodyShadow, node, owner); err != nil {
+			return err
+		}
+
+		ctx[p.new_name_c.Ident] = node
+		return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+	case *CloseForm:
+		_, err = expectKind(lookup(p.from_c), INFER_UNIT)
+	case *WaitForm:
+		if _, err = expectKind(lookup(p.to_c), INFER_UNIT); err == nil {
+			return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+		}
+	case *ForwardForm:
+		err = unify(lookup(p.to_c), lookup(p.from_c))
+	case *SplitForm:
+		from := lookup(p.from_c)
+		ctx[p.channel_one.Ident] = from
+		ctx[p.channel_two.Ident] = from
+		return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+	case *CallForm:
+		err = state.walkCall(p, lookup, lookupClient, provider)
+	case *CastForm:
+		if isProvider(p.to_c, shadow) {
+			var downType *inferNode
+			if downType, err = expectKind(provider, INFER_DOWN); err == nil {
+				err = unify(downType.right, lookup(p.continuation_c))
+			}
+		} else {
+			var upType *inferNode
+			if upType, err = expectKind(lookup(p.to_c), INFER_UP); err == nil {
+				err = unify(upType.right, lookup(p.continuation_c))
+			}
+		}
+	case *ShiftForm:
+		if isProvider(p.from_c, shadow) {
+			var upType *inferNode
+			if upType, err = expectKind(provider, INFER_UP); err == nil {
+				return state.walk(p.continuation_e, ctx, &p.continuation_c, upType.right, owner)
+			}
+		} else {
+			var downType *inferNode
+			if downType, err = expectKind(lookup(p.from_c), INFER_DOWN); err == nil {
+				ctx[p.continuation_c.Ident] = downType.right
+				return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+			}
+		}
+	case *DropForm:
+		return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+	case *PrintForm:
+		return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+	case *PrintValueForm:
+		return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+	case *LetForm:
+		// The names captured by the local functions have the same types as the ones available here
+		for _, lifted := range p.lifted {
+			signature := state.signatures[lifted.name]
+			written := len(signature.parameters) - len(lifted.captured)
+			for j, captured := range lifted.captured {
+				if node, exists := ctx[captured.Ident]; exists {
+					if err = unify(signature.parameters[written+j], node); err != nil {
+						return fmt.Errorf("in '%s', %s", form.StringShort(), err)
+					}
+				}
+			}
+		}
+		return state.walk(p.continuation_e, ctx, shadow, provider, owner)
+	}
+
+	if err != nil {
+		return fmt.Errorf("in '%s', %s", form.StringShort(), err)
+	}
+
+	return nil
+}
+
+// A call relates the types of the parameters (and of the provider) to the signature of the function
+// (the parameters are clients, even if they share the name of the provider being spawned, as in x <- new f(x))
+func (state *inferenceState) walkCall(p *CallForm, lookup, lookupClient func(Name) *inferNode, provider *inferNode) error {
+	signature, exists := state.signatures[p.functionName]
+	if !exists {
+		// Undefined functions are reported by the typechecker
+		return nil
+	}
+
+	parameters := p.parameters
+	if len(signature.parameters)+1 == len(parameters) {
+		// 'self' is passed explicitly as the first parameter
+		provider = lookup(parameters[0])
+		parameters = parameters[1:]
+	} else if len(signature.parameters) != len(parameters) {
+		return nil
+	}
+
+	if err := unify(signature.result, provider); err != nil {
+		return err
+	}
+
+	for i := range parameters {
+		if err := unify(signature.parameters[i], lookupClient(parameters[i])); err != nil {
+			return fmt.Errorf("parameter '%s': %s", parameters[i].String(), err)
+		}
+	}
+
+	return nil
+}
+
+func copyInferenceContext(ctx map[string]*inferNode) map[string]*inferNode {
+	result := make(map[string]*inferNode, len(ctx))
+	for name, node := range ctx {
+		result[name] = node
+	}
+	return result
+}
+
+func stringInList(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////
+////////////// Conversion to/from types /////////////
+/////////////////////////////////////////////////////
+
+// Turns an annotation into a node. Missing annotations produce an unknown node, to be inferred.
+func (state *inferenceState) fromAnnotation(sessionType types.SessionType) *inferNode {
+	if sessionType == nil {
+		return newInferNode(INFER_UNKNOWN, &inferMode{})
+	}
+
+	annotation := types.CopyType(sessionType)
+	types.AddMissingModalities(&annotation, state.labelledTypesEnv)
+	return state.fromSessionType(annotation)
+}
+
+func (state *inferenceState) fromSessionType(sessionType types.SessionType) *inferNode {
+	mode := &inferMode{mode: sessionType.Modality()}
+	if _, unset := mode.mode.(*types.UnsetMode); unset {
+		mode.mode = nil
+	}
+
+	switch q := sessionType.(type) {
+	case *types.LabelType:
+		return state.fromLabel(q.Label)
+	case *types.UnitType:
+		return newInferNode(INFER_UNIT, mode)
+	case *types.SendType:
+		return &inferNode{kind: INFER_SEND, mode: mode, left: state.fromSessionType(q.Left), right: state.fromSessionType(q.Right)}
+	case *types.ReceiveType:
+		return &inferNode{kind: INFER_RECEIVE, mode: mode, left: state.fromSessionType(q.Left), right: state.fromSessionType(q.Right)}
+	case *types.SelectLabelType:
+		return state.fromOptions(INFER_SELECT, mode, q.Branches)
+	case *types.BranchCaseType:
+		return state.fromOptions(INFER_BRANCH, mode, q.Branches)
+	case *types.UpType:
+		return &inferNode{kind: INFER_UP, mode: mode, from: &inferMode{mode: q.From}, right: state.fromSessionType(q.Continuation)}
+	case *types.DownType:
+		return &inferNode{kind: INFER_DOWN, mode: mode, from: &inferMode{mode: q.From}, right: state.fromSessionType(q.Continuation)}
+	}
+
+	return newInferNode(INFER_DYNAMIC, mode)
+}
+
+func (state *inferenceState) fromOptions(kind inferKind, mode *inferMode, branches []types.Option) *inferNode {
+	n := &inferNode{kind: kind, mode: mode, options: make(map[string]*inferNode), closed: true}
+	for _, option := range branches {
+		n.labels = append(n.labels, option.Label)
+		n.options[option.Label] = state.fromSessionType(option.SessionType)
+	}
+	return n
+}
+
+// All references to a type definition share the same node, so recursive types form cycles
+func (state *inferenceState) fromLabel(label string) *inferNode {
+	if n, exists := state.labelledNodes[label]; exists {
+		return n
+	}
+
+	def, exists := state.labelledTypesEnv[label]
+	if !exists {
+		// Undefined labels are reported by the typechecker
+		return newInferNode(INFER_DYNAMIC, &inferMode{})
+	}
+
+	n := newInferNode(INFER_UNKNOWN, &inferMode{mode: def.Mode})
+	state.labelledNodes[label] = n
+
+	if err := unify(n, state.fromSessionType(def.Type)); err != nil {
+		return newInferNode(INFER_DYNAMIC, &inferMode{})
+	}
+
+	if representative := n.find(); representative.label == "" {
+		representative.label = label
+	}
+
+	return n
+}
+
+// Modes which are never constrained are set to the default mode. The result of a function takes the strongest mode
+// which still allows its parameters to be used, i.e. the declaration of independence.
+func (state *inferenceState) resolveModes(functions []FunctionDefinition) {
+	for _, f := range functions {
+		signature := state.signatures[f.FunctionName]
+		result := signature.result.find().mode.find()
+		if result.mode != nil {
+			continue
+		}
+
+		var parameterModes []types.Modality
+		for _, parameter := range signature.parameters {
+			if mode := parameter.find().mode.find().mode; mode != nil {
+				parameterModes = append(parameterModes, mode)
+			}
+		}
+
+		result.mode = weakestCommonMode(parameterModes)
+	}
+}
+
+func weakestCommonMode(modes []types.Modality) types.Modality {
+	for _, candidate := range []types.Modality{types.NewReplicableMode(), types.NewAffineMode(), types.NewMulticastMode()} {
+		allowed := true
+		for _, mode := range modes {
+			allowed = allowed && mode.CanBeDownshiftedTo(candidate)
+		}
+
+		if allowed {
+			return candidate
+		}
+	}
+
+	return types.NewLinearMode()
+}
+
+func resolveMode(mode *inferMode) types.Modality {
+	mode = mode.find()
+	if mode.mode == nil {
+		mode.mode = types.DefaultMode()
+	}
+	return mode.mode
+}
+
+// Inferred types which refer back to themselves need a name. If they match some existing type definition, then that
+// definition is used, otherwise a new one is generated (and returned, with all its modes set).
+func (state *inferenceState) nameRecursiveTypes() []types.SessionTypeDefinition {
+	visiting := make(map[*inferNode]bool)
+	visited := make(map[*inferNode]bool)
+	var recursive []*inferNode
+
+	var visit func(n *inferNode)
+	visit = func(n *inferNode) {
+		n = n.find()
+		if n.label != "" || visited[n] {
+			return
+		}
+
+		if visiting[n] {
+			if !n.recursive {
+				n.recursive = true
+				recursive = append(recursive, n)
+			}
+			return
+		}
+
+		visiting[n] = true
+		for _, child := range n.children() {
+			visit(child)
+		}
+		visiting[n] = false
+		visited[n] = true
+	}
+
+	for _, m := range state.missing {
+		visit(m.node)
+	}
+
+	if len(recursive) == 0 {
+		return nil
+	}
+
+	// Compare the recursive types (using temporary names, which cannot clash with the source) with the existing ones
+	for i, n := range recursive {
+		n.label = fmt.Sprintf("#%d", i)
+	}
+
+	temporaryDefs := append([]types.SessionTypeDefinition{}, state.typeDefs...)
+	for _, n := range recursive {
+		temporaryDefs = append(temporaryDefs, types.SessionTypeDefinition{Name: n.label, SessionType: state.toSessionType(n, true), Modality: resolveMode(n.mode)})
+	}
+	temporaryEnv := types.ProduceLabelledSessionTypeEnvironment(temporaryDefs)
+
+	names := make(map[string]bool)
+	userLabels := make(map[string]bool)
+	for _, def := range state.typeDefs {
+		names[def.Name] = true
+		userLabels[def.Name] = true
+	}
+
+	temporaryNames := make(map[*inferNode]string)
+	var generated []*inferNode
+	for _, n := range recursive {
+		temporaryNames[n] = n.label
+		if match := state.matchDefinition(types.NewLabelType(n.label, resolveMode(n.mode)), temporaryEnv); match != "" {
+			n.label = match
+			continue
+		}
+
+		// Equal recursive types share the same definition
+		for _, previous := range generated {
+			if types.EqualType(types.NewLabelType(temporaryNames[n], resolveMode(n.mode)), types.NewLabelType(temporaryNames[previous], resolveMode(previous.mode)), temporaryEnv) {
+				n.label = previous.label
+				break
+			}
+		}
+
+		if n.label == temporaryNames[n] {
+			n.label = freshTypeName(names)
+			generated = append(generated, n)
+		}
+	}
+
+	var defs []types.SessionTypeDefinition
+	for _, n := range generated {
+		defs = append(defs, types.SessionTypeDefinition{Name: n.label, SessionType: state.toSessionType(n, true), Modality: resolveMode(n.mode)})
+
+		if hasUnknownType(n, userLabels, make(map[*inferNode]bool)) {
+			state.partialLabels[n.label] = true
+		}
+	}
+
+	return defs
+}
+
+// Whether some part of the type is still unknown (the user defined types are known in full)
+func hasUnknownType(n *inferNode, userLabels map[string]bool, visited map[*inferNode]bool) bool {
+	n = n.find()
+	if visited[n] || userLabels[n.label] {
+		return false
+	}
+	visited[n] = true
+
+	if n.kind == INFER_UNKNOWN {
+		return true
+	}
+
+	for _, child := range n.children() {
+		if hasUnknownType(child, userLabels, visited) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func freshTypeName(names map[string]bool) string {
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("t%d", i)
+		if !names[name] {
+			names[name] = true
+			return name
+		}
+	}
+}
+
+// Returns the name of the first (user defined) type definition equal to the given type, if any
+func (state *inferenceState) matchDefinition(sessionType types.SessionType, labelledTypesEnv types.LabelledTypesEnv) string {
+	for _, def := range state.typeDefs {
+		if def.ProjectedFrom != "" {
+			continue
+		}
+
+		if types.EqualType(sessionType, types.NewLabelType(def.Name, def.Modality), labelledTypesEnv) {
+			return def.Name
+		}
+	}
+
+	return ""
+}
+
+// Converts a node to a session type, with all modes set. Types equal to some type definition are replaced by its
+// name. Unknown types are turned into ? (and counted in state.unresolved).
+func (state *inferenceState) toSessionType(n *inferNode, structural bool) types.SessionType {
+	n = n.find()
+	mode := resolveMode(n.mode)
+
+	if n.label != "" && !structural {
+		if state.partialLabels[n.label] {
+			state.unresolved++
+		}
+		return types.NewLabelType(n.label, mode)
+	}
+
+	before := state.unresolved
+	var result types.SessionType
+	switch n.kind {
+	case INFER_UNKNOWN:
+		state.unresolved++
+		return types.NewDynamicType(mode)
+	case INFER_DYNAMIC:
+		return types.NewDynamicType(mode)
+	case INFER_UNIT:
+		return types.NewUnitType(mode)
+	case INFER_SEND:
+		result = types.NewSendType(state.toSessionType(n.left, false), state.toSessionType(n.right, false), mode)
+	case INFER_RECEIVE:
+		result = types.NewReceiveType(state.toSessionType(n.left, false), state.toSessionType(n.right, false), mode)
+	case INFER_SELECT:
+		result = types.NewSelectLabelType(state.toOptions(n), mode)
+	case INFER_BRANCH:
+		result = types.NewBranchCaseType(state.toOptions(n), mode)
+	case INFER_UP:
+		return types.NewUpType(resolveMode(n.from), mode, state.toSessionType(n.right, false))
+	case INFER_DOWN:
+		return types.NewDownType(resolveMode(n.from), mode, state.toSessionType(n.right, false))
+	}
+
+	if state.matching && !structural && state.unresolved == before {
+		if match := state.matchDefinition(result, state.labelledTypesEnv); match != "" {
+			return types.NewLabelType(match, mode)
+		}
+	}
+
+	return result
+}
+
+func (state *inferenceState) toOptions(n *inferNode) []types.Option {
+	options := make([]types.Option, len(n.labels))
+	for i, label := range n.labels {
+		options[i] = *types.NewOption(label, state.toSessionType(n.options[label], false))
+	}
+	return options
+}
+
+// The type as it would be written in the source: only the outer mode is kept (when it is not the default one), since
+// the inner modes follow from it. Shifts keep their modes.
+func sourceType(sessionType types.SessionType) types.SessionType {
+	result := stripModes(sessionType)
+
+	mode := sessionType.Modality()
+	if mode.Equals(types.DefaultMode()) {
+		return result
+	}
+
+	switch q := result.(type) {
+	case *types.UnitType:
+		q.Mode = mode
+	case *types.SendType:
+		q.Mode = mode
+	case *types.ReceiveType:
+		q.Mode = mode
+	case *types.SelectLabelType:
+		q.Mode = mode
+	case *types.BranchCaseType:
+		q.Mode = mode
+	case *types.DynamicType:
+		q.Mode = mode
+	}
+
+	return result
+}
+
+func stripModes(sessionType types.SessionType) types.SessionType {
+	switch q := sessionType.(type) {
+	case *types.LabelType:
+		return types.NewLabelType(q.Label, types.NewUnsetMode())
+	case *types.UnitType:
+		return types.NewUnitType(types.NewUnsetMode())
+	case *types.SendType:
+		return types.NewSendType(stripModes(q.Left), stripModes(q.Right), types.NewUnsetMode())
+	case *types.ReceiveType:
+		return types.NewReceiveType(stripModes(q.Left), stripModes(q.Right), types.NewUnsetMode())
+	case *types.SelectLabelType:
+		return types.NewSelectLabelType(stripOptionModes(q.Branches), types.NewUnsetMode())
+	case *types.BranchCaseType:
+		return types.NewBranchCaseType(stripOptionModes(q.Branches), types.NewUnsetMode())
+	case *types.UpType:
+		return types.NewUpType(q.From, q.To, stripModes(q.Continuation))
+	case *types.DownType:
+		return types.NewDownType(q.From, q.To, stripModes(q.Continuation))
+	}
+
+	return types.NewDynamicType(types.NewUnsetMode())
+}
+
+func stripOptionModes(branches []types.Option) []types.Option {
+	options := make([]types.Option, len(branches))
+	for i, option := range branches {
+		options[i] = *types.NewOption(option.Label, stripModes(option.SessionType))
+	}
+	return options
+}
@@ -0,0 +1,150 @@
+package process
+
+import (
+	"bufio"
+	"fmt"
+	"grits/types"
+	"os"
+	"strings"
+	"time"
+)
+
+// The input is read by a process providing a type made up of choices (+{...}) ending in 1, e.g.
+//
+//	type answer = +{yes : 1, no : 1}
+//	type commands = +{deposit : commands, withdraw : commands, eof : 1}
+//
+//	prc[a] : answer = input self
+//
+// Each choice is resolved by reading a line from the standard input, which has to be one of its labels. The label is
+// selected on self, and the continuation is provided by another input process (or closed, for 1). The end of the
+// input selects the label eof, if there is one.
+
+// Whether a type can be provided by reading the input
+func checkInputType(sessionType types.SessionType, labelledTypesEnv types.LabelledTypesEnv) error {
+	return checkInputTypeVisited(sessionType, labelledTypesEnv, make(map[string]bool))
+}
+
+func checkInputTypeVisited(sessionType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, visited map[string]bool) error {
+	if label, ok := sessionType.(*types.LabelType); ok {
+		if visited[label.Label] {
+			return nil
+		}
+		visited[label.Label] = true
+	}
+
+	switch t := types.Unfold(sessionType, labelledTypesEnv).(type) {
+	case *types.LabelType:
+		return checkInputTypeVisited(t, labelledTypesEnv, visited)
+	case *types.UnitType:
+		return nil
+	case *types.SelectLabelType:
+		if len(t.Branches) == 0 {
+			return fmt.Errorf("the type '%s' has no labels to be read", t.String())
+		}
+
+		for _, branch := range t.Branches {
+			if err := checkInputTypeVisited(branch.SessionType, labelledTypesEnv, visited); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("the type '%s' cannot be read from the input", sessionType.String())
+}
+
+// The step taken by input self: either a close, or a select of the label read from the input, whose continuation is
+// provided by a new input process
+func (f *InputForm) nextStep(re *RuntimeEnvironment) (Form, error) {
+	if f.inputType == nil {
+		return nil, fmt.Errorf("the type of '%s' is unknown, since the program was not typechecked", f.String())
+	}
+
+	sessionType := f.inputType
+	for {
+		label, ok := sessionType.(*types.LabelType)
+		if !ok {
+			break
+		}
+		sessionType = types.UnfoldIfNeeded(label, re.GlobalEnvironment.Types)
+	}
+
+	switch t := sessionType.(type) {
+	case *types.UnitType:
+		return NewClose(f.from_c), nil
+	case *types.SelectLabelType:
+		option, err := re.readInput(t.Branches)
+		if err != nil {
+			return nil, err
+		}
+
+		continuation := Name{Ident: "input#1", Type: option.SessionType}
+		input := NewInput(Name{IsSelf: true})
+		input.inputType = option.SessionType
+		return NewNew(continuation, input, NewSelect(f.from_c, Label{L: option.Label}, continuation)), nil
+	}
+
+	return nil, fmt.Errorf("the type '%s' of '%s' cannot be read from the input", f.inputType.String(), f.String())
+}
+
+// Reads lines from the input (the standard input, unless set otherwise) until one of the labels is entered. Since
+// waiting for the input is not a lack of progress, the heartbeat is kept going in the meantime.
+func (re *RuntimeEnvironment) readInput(branches []types.Option) (*types.Option, error) {
+	re.inputMutex.Lock()
+	defer re.inputMutex.Unlock()
+
+	if re.inputScanner == nil {
+		if re.Input == nil {
+			re.Input = os.Stdin
+		}
+		re.inputScanner = bufio.NewScanner(re.Input)
+	}
+
+	labels := types.BranchLabels(branches)
+	for {
+		if !re.Quiet {
+			fmt.Fprintf(re.output(), "< %s\n", strings.Join(labels, " | "))
+		}
+
+		scanned := make(chan bool, 1)
+		go func() {
+			scanned <- re.inputScanner.Scan()
+		}()
+
+		ticker := time.NewTicker(10 * time.Millisecond)
+		var ok bool
+	waiting:
+		for {
+			select {
+			case ok = <-scanned:
+				break waiting
+			case <-ticker.C:
+				select {
+				case re.heartbeat <- struct{}{}:
+				default:
+				}
+			case <-re.ctx.Done():
+				ticker.Stop()
+				return nil, fmt.Errorf("stopped while waiting for the input")
+			}
+		}
+		ticker.Stop()
+
+		if !ok {
+			if option, found := types.LookupBranchByLabel(branches, "eof"); found {
+				return option, nil
+			}
+			return nil, fmt.Errorf("reached the end of the input, while expecting one of the labels %s", strings.Join(labels, ", "))
+		}
+
+		line := strings.TrimSpace(re.inputScanner.Text())
+		if option, found := types.LookupBranchByLabel(branches, line); found {
+			return option, nil
+		}
+
+		if !re.Quiet {
+			fmt.Fprintf(re.output(), "< unknown label '%s'; %s\n", line, types.ExpectedLabelsHint(line, branches))
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package process
+
+import (
+	"fmt"
+	"grits/types"
+)
+
+// Local definitions (let <definitions> in P end) are only visible within P and within the definitions themselves,
+// where they shadow the global (or outer) ones with the same name. Rather than keeping track of scopes, the local
+// functions and types are lifted to global definitions with unique names (e.g. the function g defined within f becomes
+// f.g), and the calls and type annotations in scope are renamed accordingly. Calls are still printed as written.
+//
+// A local function may refer to the names available where it is defined, i.e. it is a closure:
+//
+//	let f(x : A) : B = let let g() : B = fwd self x in g() end
+//
+// The names captured by a local function become extra parameters of the lifted function, which each call passes on
+// (so f.g(x) is called in place of g()). Calls use the captured names just like the ones written, so a linear name
+// cannot be captured by more than one call. The types of the captured names are taken from the point where the
+// function is defined, where the declaration of independence (captured names ⪰ function) is checked.
+//
+// Since the captured names are passed on by their identifiers, a call is rejected if any of them has been bound again
+// between the definition and the call (e.g. by a receive or a case branch), as it would then refer to a different name:
+//
+//	let f(x : nat) : nat = let let g() : nat = fwd self x in case x (zero<k> => self.zero<k> | succ<x> => g()) end
+
+// A local function while its captured names are being worked out
+type localFunction struct {
+	name     string
+	captured []Name
+	// The number of names bound (see localScope.bound) where the function is defined
+	depth int
+}
+
+// A call to a local function, along with the names bound between the definition of the function and the call
+type localCall struct {
+	call    *CallForm
+	local   *localFunction
+	rebound []string
+}
+
+// A local function, once lifted
+type liftedFunction struct {
+	name     string
+	captured []Name
+}
+
+type localScope struct {
+	functions map[string]*localFunction
+	types     map[string]string
+	// The names bound so far (e.g. by receives and case branches), in order
+	bound []string
+}
+
+func (scope localScope) extend() localScope {
+	inner := localScope{functions: make(map[string]*localFunction), types: make(map[string]string), bound: scope.bound}
+	for name, f := range scope.functions {
+		inner.functions[name] = f
+	}
+	for name, label := range scope.types {
+		inner.types[name] = label
+	}
+	return inner
+}
+
+// The scope of the continuation of a form binding the given names
+func (scope localScope) bind(names ...Name) localScope {
+	bound := make([]string, len(scope.bound), len(scope.bound)+len(names))
+	copy(bound, scope.bound)
+	for _, name := range names {
+		if !name.IsSelf {
+			bound = append(bound, name.Ident)
+		}
+	}
+	scope.bound = bound
+	return scope
+}
+
+type lifter struct {
+	functions *[]FunctionDefinition
+	typeDefs  *[]types.SessionTypeDefinition
+	// The local functions lifted so far, with their index in functions
+	pending []*localFunction
+	indexes []int
+	// The let blocks found so far, with their local functions
+	lets      []*LetForm
+	letLocals [][]*localFunction
+	// The calls to local functions found so far
+	calls []localCall
+}
+
+// LiftLocalDefinitions moves the definitions of the let blocks within form (which belongs to the function or process
+// named owner) to the global functions and types.
+func LiftLocalDefinitions(form Form, owner string, functions *[]FunctionDefinition, typeDefs *[]types.SessionTypeDefinition) error {
+	l := &lifter{functions: functions, typeDefs: typeDefs}
+
+	if err := l.lift(form, owner, localScope{}); err != nil {
+		return err
+	}
+
+	// A function captures the free names of its body, including the ones captured by the local functions it calls
+	for changed := true; changed; {
+		changed = false
+		for i, local := range l.pending {
+			f := &(*functions)[l.indexes[i]]
+			for _, name := range f.Body.FreeNames() {
+				if name.IsSelf || (f.UsesExplicitProvider && name.Ident == f.ExplicitProvider.Ident) ||
+					nameExists(f.Parameters, name) || nameExists(local.captured, name) {
+					continue
+				}
+				local.captured = append(local.captured, Name{Ident: name.Ident})
+				changed = true
+			}
+		}
+	}
+
+	for i, local := range l.pending {
+		f := &(*functions)[l.indexes[i]]
+		f.Parameters = append(f.Parameters, copyNames(local.captured)...)
+		f.Captured = len(local.captured)
+	}
+
+	for i, let := range l.lets {
+		for j, local := range l.letLocals[i] {
+			let.lifted[j].captured = copyNames(local.captured)
+		}
+	}
+
+	// The captured names are passed on by the calls, so they must still refer to the same names
+	for _, c := range l.calls {
+		for _, name := range c.local.captured {
+			for _, rebound := range c.rebound {
+				if rebound == name.Ident {
+					return fmt.Errorf("the local function %s captures %s, which is bound again before the call %s (rename one of them)", c.call.writtenName, name.Ident, c.call.String())
+				}
+			}
+		}
+	}
+
+	// Each call passes on the captured names
+	bodies := []Form{form}
+	for _, index := range l.indexes {
+		bodies = append(bodies, (*functions)[index].Body)
+	}
+	for _, body := range bodies {
+		for _, inner := range AllForms(body) {
+			if call, ok := inner.(*CallForm); ok && call.local != nil {
+				call.parameters = append(call.parameters, copyNames(call.local.captured)...)
+				call.captured = len(call.local.captured)
+				call.local = nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *lifter) lift(form Form, owner string, scope localScope) error {
+	switch p := form.(type) {
+	case *CallForm:
+		if local, ok := scope.functions[p.functionName]; ok {
+			p.writtenName = p.functionName
+			p.functionName = local.name
+			p.local = local
+			l.calls = append(l.calls, localCall{call: p, local: local, rebound: scope.bound[local.depth:]})
+		}
+	case *NewForm:
+		if p.new_name_c.Type != nil && len(scope.types) > 0 {
+			p.new_name_c.Type = types.RenameLabels(p.new_name_c.Type, scope.types)
+		}
+		if err := l.lift(p.body, owner, scope); err != nil {
+			return err
+		}
+		return l.lift(p.continuation_e, owner, scope.bind(p.new_name_c))
+	case *ReceiveForm:
+		return l.lift(p.continuation_e, owner, scope.bind(p.payload_c, p.continuation_c))
+	case *CaseForm:
+		for _, branch := range p.branches {
+			if err := l.lift(branch.continuation_e, owner, scope.bind(branch.payload_c)); err != nil {
+				return err
+			}
+		}
+	case *SplitForm:
+		return l.lift(p.continuation_e, owner, scope.bind(p.channel_one, p.channel_two))
+	case *WaitForm:
+		return l.lift(p.continuation_e, owner, scope)
+	case *ShiftForm:
+		return l.lift(p.continuation_e, owner, scope.bind(p.continuation_c))
+	case *DropForm:
+		return l.lift(p.continuation_e, owner, scope)
+	case *PrintForm:
+		return l.lift(p.continuation_e, owner, scope)
+	case *PrintValueForm:
+		return l.lift(p.continuation_e, owner, scope)
+	case *LetForm:
+		return l.liftLet(p, owner, scope)
+	}
+
+	return nil
+}
+
+func (l *lifter) liftLet(p *LetForm, owner string, scope localScope) error {
+	inner := scope.extend()
+
+	takenTypes := make(map[string]bool)
+	for _, t := range *l.typeDefs {
+		takenTypes[t.Name] = true
+	}
+	definedTypes := make(map[string]bool)
+	for _, t := range p.types {
+		if definedTypes[t.Name] {
+			return fmt.Errorf("type %s is defined more than once in the same let block", t.Name)
+		}
+		definedTypes[t.Name] = true
+		inner.types[t.Name] = uniqueName(owner+"."+t.Name, takenTypes)
+	}
+
+	takenFunctions := make(map[string]bool)
+	for _, f := range *l.functions {
+		takenFunctions[f.FunctionName] = true
+	}
+	definedFunctions := make(map[string]bool)
+	var locals []*localFunction
+	for _, f := range p.functions {
+		if definedFunctions[f.FunctionName] {
+			return fmt.Errorf("function %s is defined more than once in the same let block", f.FunctionName)
+		}
+		definedFunctions[f.FunctionName] = true
+		local := &localFunction{name: uniqueName(owner+"."+f.FunctionName, takenFunctions), depth: len(scope.bound)}
+		inner.functions[f.FunctionName] = local
+		locals = append(locals, local)
+	}
+
+	for _, t := range p.types {
+		*l.typeDefs = append(*l.typeDefs, types.SessionTypeDefinition{
+			Name:        inner.types[t.Name],
+			SessionType: types.RenameLabels(t.SessionType, inner.types),
+			Position:    t.Position,
+		})
+	}
+
+	// The let block keeps the definitions as written (sharing their bodies with the lifted functions)
+	first := len(*l.functions)
+	p.lifted = nil
+	for i, f := range p.functions {
+		lifted := f
+		lifted.FunctionName = locals[i].name
+		lifted.Local = true
+		lifted.Parameters = copyNames(f.Parameters)
+		for j := range lifted.Parameters {
+			if lifted.Parameters[j].Type != nil {
+				lifted.Parameters[j].Type = types.RenameLabels(lifted.Parameters[j].Type, inner.types)
+			}
+		}
+		if lifted.Type != nil {
+			lifted.Type = types.RenameLabels(lifted.Type, inner.types)
+		}
+
+		*l.functions = append(*l.functions, lifted)
+		l.pending = append(l.pending, locals[i])
+		l.indexes = append(l.indexes, first+i)
+		p.lifted = append(p.lifted, liftedFunction{name: locals[i].name})
+	}
+	p.definitions = l.functions
+	l.lets = append(l.lets, p)
+	l.letLocals = append(l.letLocals, locals)
+
+	// The local functions may call each other (and themselves), and their parameters may hide captured names
+	for i, f := range p.functions {
+		if err := l.lift((*l.functions)[first+i].Body, locals[i].name, inner.bind(f.Parameters...)); err != nil {
+			return err
+		}
+	}
+
+	return l.lift(p.continuation_e, owner, inner)
+}
+
+// Numbers name (e.g. f.g#2) if it is already taken, and marks the result as taken
+func uniqueName(name string, taken map[string]bool) string {
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s#%d", name, i)
+	}
+	taken[unique] = true
+
+	return unique
+}
+
+// Unlike Name.Copy, a missing explicit polarity is kept as missing
+func copyNames(names []Name) []Name {
+	var copied []Name
+	for _, name := range names {
+		name.Type = types.CopyType(name.Type)
+		copied = append(copied, name)
+	}
+
+	return copied
+}
+
+func equalLocalDefinitions(let1, let2 *LetForm) bool {
+	if len(let1.types) != len(let2.types) || len(let1.functions) != len(let2.functions) {
+		return false
+	}
+
+	for i := range let1.types {
+		if let1.types[i].Name != let2.types[i].Name {
+			return false
+		}
+	}
+
+	for i := range let1.functions {
+		f1, f2 := let1.functions[i], let2.functions[i]
+		if f1.FunctionName != f2.FunctionName || !AreNamesEqual(f1.Parameters, f2.Parameters) ||
+			!EqualForm(f1.Body, f2.Body) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,350 @@
+package process
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Macros are shorthands (in the style of SAX) for steps which carry on using the same name, rather than naming its
+// continuation explicitly. A macro on a client reuses the name of the client for its continuation:
+//
+//	send x<y>; P      stands for    x <- new send x<y, self>; P
+//	x.label; P        stands for    x <- new x.label<self>; P
+//	y <- recv x; P    stands for    <y, x> <- recv x; P
+//
+// Whereas a macro on the provider spawns the rest of the process, which provides the continuation of its type:
+//
+//	send self<y>; P   stands for    self#1 <- new P; send self<y, self#1>
+//	self.label; P     stands for    self#1 <- new P; self.label<self#1>
+//	y <- recv self; P stands for    <y, self#1> <- recv self; P
+//
+// Sending or receiving several names at once is a chain of such macros, ending with the step naming the continuation:
+//
+//	send x<a, b, k>           stands for    send x<a>; send x<b, k>
+//	<a, b, k> <- recv x; P    stands for    a <- recv x; <b, k> <- recv x; P
+//
+// so that x : A * B * C (i.e. A * (B * C)) is provided by send self<a, b, k> where k : C.
+//
+// The expanded forms are marked as derived from a macro, so they are still printed (and reported by the typechecker)
+// as written. The names generated for the continuation of the provider cannot be written in the source, and the
+// spawned process is named after the provider at runtime.
+
+// The parser only knows whether a name refers to the provider when it is written as self, so macros are first
+// expanded as if acting on a client, and the ones on the provider are rewritten by ExpandMacros.
+
+// send x<y>; P
+func NewSendMacro(to_c, payload_c Name, continuation_e Form) *NewForm {
+	p := NewNew(macroClient(to_c), NewSend(to_c, payload_c, Name{IsSelf: true}), continuation_e)
+	p.derivedFromMacro = true
+	return p
+}
+
+// x.label; P
+func NewSelectMacro(to_c Name, label Label, continuation_e Form) *NewForm {
+	p := NewNew(macroClient(to_c), NewSelect(to_c, label, Name{IsSelf: true}), continuation_e)
+	p.derivedFromMacro = true
+	return p
+}
+
+// y <- recv x; P
+func NewReceiveMacro(payload_c, from_c Name, continuation_e Form) *ReceiveForm {
+	p := NewReceive(payload_c, macroClient(from_c), from_c, continuation_e)
+	p.derivedFromMacro = true
+	return p
+}
+
+// send x<a, b, k>, where the last name is the continuation
+func NewSendTuple(to_c Name, names []Name) Form {
+	last := len(names) - 1
+	var form Form = NewSend(to_c, names[last-1], names[last])
+	for i := last - 2; i >= 0; i-- {
+		p := NewSendMacro(to_c, names[i], form)
+		p.tupled = true
+		form = p
+	}
+	return form
+}
+
+// <a, b, k> <- recv x; P, where the last name is the continuation
+func NewReceiveTuple(names []Name, from_c Name, continuation_e Form) Form {
+	last := len(names) - 1
+	var form Form = NewReceive(names[last-1], names[last], from_c, continuation_e)
+	for i := last - 2; i >= 0; i-- {
+		p := NewReceiveMacro(names[i], from_c, form)
+		p.tupled = true
+		form = p
+	}
+	return form
+}
+
+// The continuation of a client is known by the same name (which is bound again by the macro)
+func macroClient(name Name) Name {
+	return Name{Ident: name.Ident, IsSelf: name.IsSelf, Position: name.Position}
+}
+
+// ExpandMacros rewrites the macros acting on the provider (i.e. on self, on one of the providers, or on a name
+// standing for the continuation of the provider, e.g. y in <x, y> <- recv self) to spawn the rest of the process.
+// References to the provider within the spawned process are marked as self.
+func ExpandMacros(form Form, providers []Name) Form {
+	shadows := make(map[string]bool)
+	for _, provider := range providers {
+		shadows[provider.Ident] = true
+	}
+
+	return expandMacros(form, shadows)
+}
+
+func expandMacros(form Form, providers map[string]bool) Form {
+	isProvider := func(name Name) bool {
+		return name.IsSelf || providers[name.Ident]
+	}
+
+	switch p := form.(type) {
+	case *ReceiveForm:
+		if isProvider(p.from_c) {
+			if p.derivedFromMacro && p.continuation_c.Ident == p.from_c.Ident {
+				p.continuation_e = asProvider(p.continuation_e, p.from_c)
+				p.continuation_c = macroContinuation(p.continuation_e, p.payload_c)
+				p.from_c.IsSelf = true
+			}
+			providers = withProvider(providers, p.continuation_c)
+		}
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	case *CaseForm:
+		for _, branch := range p.branches {
+			branchProviders := providers
+			if isProvider(p.from_c) {
+				branchProviders = withProvider(providers, branch.payload_c)
+			}
+			branch.continuation_e = expandMacros(branch.continuation_e, branchProviders)
+		}
+	case *NewForm:
+		if step, _, ok := p.macro(); ok && !p.onProvider && isProvider(macroSubject(step)) {
+			subject := macroSubject(step)
+			rest := asProvider(expandMacros(p.continuation_e, providers), subject)
+
+			var payload []Name
+			if send, ok := step.(*SendForm); ok {
+				payload = append(payload, send.payload_c)
+			}
+			continuation := macroContinuation(rest, payload...)
+
+			self := Name{Ident: subject.Ident, IsSelf: true, Position: subject.Position}
+			switch s := step.(type) {
+			case *SendForm:
+				step = NewSend(self, s.payload_c, continuation)
+			case *SelectForm:
+				step = NewSelect(self, s.label, continuation)
+			}
+
+			expanded := NewNew(continuation, rest, step)
+			expanded.derivedFromMacro = true
+			expanded.onProvider = true
+			expanded.tupled = p.tupled
+			return expanded
+		}
+
+		// Within the spawned process, the provider is only known as self
+		p.body = expandMacros(p.body, make(map[string]bool))
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	case *WaitForm:
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	case *ShiftForm:
+		if isProvider(p.from_c) {
+			providers = withProvider(providers, p.continuation_c)
+		}
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	case *DropForm:
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	case *SplitForm:
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	case *PrintForm:
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	case *PrintValueForm:
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	case *LetForm:
+		// Each local function has its own provider
+		for i := range p.functions {
+			f := &p.functions[i]
+			var localProviders []Name
+			if f.UsesExplicitProvider {
+				f.Body.Substitute(f.ExplicitProvider, f.ExplicitProvider)
+				localProviders = []Name{f.ExplicitProvider}
+			}
+			f.Body = ExpandMacros(f.Body, localProviders)
+		}
+		p.continuation_e = expandMacros(p.continuation_e, providers)
+	}
+
+	return form
+}
+
+// Marks the references to the provider (known by the name subject) as self, keeping the name as written
+func asProvider(form Form, subject Name) Form {
+	if subject.Ident != "" {
+		form.Substitute(Name{Ident: subject.Ident}, Name{Ident: subject.Ident, IsSelf: true})
+	}
+
+	return form
+}
+
+// A name for the continuation of the provider, numbered to differ from the ones in use (e.g. by nested macros)
+func macroContinuation(form Form, names ...Name) Name {
+	taken := make(map[string]bool)
+	for _, name := range AllNames(form) {
+		taken[name.Ident] = true
+	}
+	for _, name := range names {
+		taken[name.Ident] = true
+	}
+
+	for i := 1; ; i++ {
+		ident := fmt.Sprintf("self#%d", i)
+		if !taken[ident] {
+			return Name{Ident: ident}
+		}
+	}
+}
+
+// The step (i.e. a send or select) and the rest of the process making up a macro. This only holds while the
+// expanded form keeps its shape, which may be changed by the elaboration (e.g. when renaming the client).
+func (p *NewForm) macro() (step, rest Form, ok bool) {
+	if !p.derivedFromMacro {
+		return nil, nil, false
+	}
+
+	if !p.onProvider {
+		// On a client: x <- new send x<y, self>; P
+		switch s := p.body.(type) {
+		case *SendForm:
+			ok = s.to_c.Ident == p.new_name_c.Ident && s.continuation_c.IsSelf
+		case *SelectForm:
+			ok = s.to_c.Ident == p.new_name_c.Ident && s.continuation_c.IsSelf
+		}
+		return p.body, p.continuation_e, ok
+	}
+
+	// On the provider: self#1 <- new P; send self<y, self#1>
+	switch s := p.continuation_e.(type) {
+	case *SendForm:
+		ok = s.to_c.IsSelf && s.continuation_c.Ident == p.new_name_c.Ident
+	case *SelectForm:
+		ok = s.to_c.IsSelf && s.continuation_c.Ident == p.new_name_c.Ident
+	}
+	return p.continuation_e, p.body, ok
+}
+
+// Whether the receive was written as y <- recv x; P (which holds as long as its continuation is named as written)
+func (p *ReceiveForm) macro() bool {
+	return p.derivedFromMacro && (p.from_c.IsSelf || p.continuation_c.Ident == p.from_c.Ident)
+}
+
+// The names of a send written as send x<a, b, k>, starting from its first macro. As for macro(), this only holds while
+// the steps keep their shape.
+func (p *NewForm) tuple() (to_c Name, names []Name, ok bool) {
+	current := p
+	for {
+		step, rest, isMacro := current.macro()
+		send, isSend := step.(*SendForm)
+		if !isMacro || !current.tupled || !isSend {
+			return Name{}, nil, false
+		}
+		if len(names) == 0 {
+			to_c = send.to_c
+		} else if send.to_c.Ident != to_c.Ident {
+			return Name{}, nil, false
+		}
+		names = append(names, send.payload_c)
+
+		switch r := rest.(type) {
+		case *NewForm:
+			current = r
+		case *SendForm:
+			if r.to_c.Ident != to_c.Ident {
+				return Name{}, nil, false
+			}
+			return to_c, append(names, r.payload_c, r.continuation_c), true
+		default:
+			return Name{}, nil, false
+		}
+	}
+}
+
+// The names of a receive written as <a, b, k> <- recv x; P, along with P
+func (p *ReceiveForm) tuple() (names []Name, continuation_e Form, ok bool) {
+	current := p
+	for current.macro() && current.tupled {
+		names = append(names, current.payload_c)
+		next, isReceive := current.continuation_e.(*ReceiveForm)
+		if !isReceive || next.from_c.Ident != current.from_c.Ident {
+			return nil, nil, false
+		}
+		current = next
+	}
+
+	if len(names) == 0 || current.derivedFromMacro {
+		return nil, nil, false
+	}
+
+	return append(names, current.payload_c, current.continuation_c), current.continuation_e, true
+}
+
+func macroSubject(step Form) Name {
+	switch s := step.(type) {
+	case *SendForm:
+		return s.to_c
+	case *SelectForm:
+		return s.to_c
+	}
+
+	return Name{}
+}
+
+// The step of a macro as written, e.g. send x<y> or x.label (names are printed using print)
+func macroStep(step Form, print func(Name) string) string {
+	switch s := step.(type) {
+	case *SendForm:
+		return fmt.Sprintf("send %s<%s>", print(s.to_c), print(s.payload_c))
+	case *SelectForm:
+		return fmt.Sprintf("%s.%s", print(s.to_c), s.label.L)
+	}
+
+	return ""
+}
+
+func stringName(name Name) string {
+	return name.String()
+}
+
+// The names written in the step of a macro
+func macroNames(step Form) []*Name {
+	switch s := step.(type) {
+	case *SendForm:
+		return []*Name{&s.to_c, &s.payload_c}
+	case *SelectForm:
+		return []*Name{&s.to_c}
+	}
+
+	return nil
+}
+
+func macroString(step, rest Form, short bool) string {
+	var buf strings.Builder
+	buf.WriteString(macroStep(step, stringName))
+	buf.WriteString("; ")
+	if short {
+		buf.WriteString("...")
+	} else {
+		buf.WriteString(rest.String())
+	}
+
+	return buf.String()
+}
+
+func tupleSendString(to_c Name, names []Name) string {
+	return fmt.Sprintf("send %s<%s>", to_c.String(), NamesToString(names))
+}
+
+func tupleReceiveString(names []Name, from_c Name) string {
+	return fmt.Sprintf("<%s> <- recv %s", NamesToString(names), from_c.String())
+}
@@ -2,6 +2,7 @@ package process
 
 import (
 	"bytes"
+	"grits/position"
 	"grits/types"
 	"strconv"
 )
@@ -30,6 +31,8 @@ type Name struct {
 	// Channel ID is a unique id for each channel
 	// Used only for debugging, since setting the ChannelID is a slow (& synchronous) operation
 	ChannelID uint64
+	// Line and character where the name occurs in the source (set by the parser, used by editor tooling)
+	Position position.Position
 }
 
 func (n *Name) Initialized() bool {
@@ -95,8 +98,13 @@ func (n *Name) Polarity(fromTypes bool, globalEnvironment *GlobalEnvironment) ty
 	if fromTypes {
 		// unfold if required
 		n.Type = types.UnfoldIfNeeded(n.Type, globalEnvironment.Types)
-		return n.Type.Polarity()
-	} else if n.ExplicitPolarity != nil {
+		if !types.IsDynamic(n.Type) {
+			return n.Type.Polarity()
+		}
+		// The polarity of ? is only known at runtime, unless given explicitly
+	}
+
+	if n.ExplicitPolarity != nil {
 		return *n.ExplicitPolarity
 	}
 
@@ -139,6 +147,7 @@ func (n *Name) Copy() *Name {
 		ControlChannel:   n.ControlChannel,
 		Type:             types.CopyType(n.Type),
 		ExplicitPolarity: &new_pol,
+		Position:         n.Position,
 	}
 }
 
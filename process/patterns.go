@@ -0,0 +1,434 @@
+package process
+
+import (
+	"fmt"
+	"grits/types"
+	"strings"
+)
+
+// Patterns take apart what a branch receives within the branch itself, rather than in a nested case or receive:
+//
+//	case x (succ<succ<y>> => P | succ<zero<z>> => Q | zero<z> => R)
+//	    stands for    case x (succ<x#1> => case x#1 (succ<y> => P | zero<z> => Q) | zero<z> => R)
+//
+//	case l (cons<<h, t>> => P | nil<u> => Q)
+//	    stands for    case l (cons<l#1> => <h, t> <- recv l#1; P | nil<u> => Q)
+//
+//	case l (cons<<h, nil<u>>> => P | cons<<h, cons<t>>> => Q | nil<u> => R)
+//	    stands for    case l (cons<l#1> => <h, l#2> <- recv l#1; case l#2 (nil<u> => P | cons<t> => Q) | nil<u> => R)
+//
+// Branches whose patterns start with the same label are merged into a single branch, so the nested patterns have to
+// cover all the labels of the type (as the branches of a case do). When merging tuples, the elements which are names
+// have to be the same in each branch, and only one of the elements can be matched further. The names standing for the
+// continuations matched by nested patterns are numbered after the name matched on (so they cannot be written in the
+// source, nor shadow any other name), and the merged branches are still printed as written.
+
+// Pattern is what a branch expects along with its label: a name, a tuple of patterns (e.g. <h, t> or <h, nil<u>>), or
+// a nested label along with its own pattern (e.g. succ<y>)
+type Pattern struct {
+	name     Name
+	elements []*Pattern
+	label    *Label
+	inner    *Pattern
+}
+
+func NamePattern(name Name) *Pattern {
+	return &Pattern{name: name}
+}
+
+func TuplePattern(elements []*Pattern) *Pattern {
+	return &Pattern{elements: elements}
+}
+
+func LabelPattern(label Label, inner *Pattern) *Pattern {
+	return &Pattern{label: &label, inner: inner}
+}
+
+// The names bound by a pattern
+func (p *Pattern) boundNames() []Name {
+	switch {
+	case p.label != nil:
+		return p.inner.boundNames()
+	case p.elements != nil:
+		var names []Name
+		for _, element := range p.elements {
+			names = append(names, element.boundNames()...)
+		}
+		return names
+	}
+
+	return []Name{p.name}
+}
+
+func (p *Pattern) isName() bool {
+	return p.label == nil && p.elements == nil
+}
+
+// E.g. cons<<h, nil<u>>>
+func (p *Pattern) String() string {
+	switch {
+	case p.label != nil:
+		return fmt.Sprintf("%s<%s>", p.label.L, p.inner.String())
+	case p.elements != nil:
+		var elements []string
+		for _, element := range p.elements {
+			elements = append(elements, element.String())
+		}
+		return "<" + strings.Join(elements, ", ") + ">"
+	}
+
+	return p.name.String()
+}
+
+// PatternBranch is a branch as written, e.g. succ<succ<y>> => P
+type PatternBranch struct {
+	Label        Label
+	Pattern      *Pattern
+	Continuation Form
+}
+
+// NewPatternCase compiles the patterns of the branches into nested case and receive forms. Tuple patterns which cannot
+// be merged (see compileTuple) are reported as errors.
+func NewPatternCase(from_c Name, branches []PatternBranch) (*CaseForm, error) {
+	taken := map[string]bool{from_c.Ident: true}
+	for _, b := range branches {
+		for _, n := range AllNames(b.Continuation) {
+			taken[n.Ident] = true
+		}
+		for _, n := range b.Pattern.boundNames() {
+			taken[n.Ident] = true
+		}
+	}
+
+	c := &patternCompiler{base: from_c.Ident, taken: taken}
+	return c.compileCase(from_c, branches, from_c, "%s")
+}
+
+type patternCompiler struct {
+	base  string
+	taken map[string]bool
+}
+
+// The labels of the case on from_c (matched within 'path', e.g. succ<%s>, when matching on the continuation of a
+// nested pattern)
+func (c *patternCompiler) compileCase(from_c Name, branches []PatternBranch, matched Name, path string) (*CaseForm, error) {
+	// Branches are grouped by their label, keeping the order in which the labels are first written
+	var labels []string
+	groups := make(map[string][]PatternBranch)
+	for _, b := range branches {
+		if _, ok := groups[b.Label.L]; !ok {
+			labels = append(labels, b.Label.L)
+		}
+		groups[b.Label.L] = append(groups[b.Label.L], b)
+	}
+
+	var compiled []*BranchForm
+	for _, label := range labels {
+		group := groups[label]
+
+		nested, tuples := true, len(group) > 1
+		for _, b := range group {
+			nested = nested && b.Pattern.label != nil
+			tuples = tuples && b.Pattern.elements != nil
+		}
+
+		switch {
+		case nested:
+			continuation := c.fresh(group[0].Label)
+			inner := make([]PatternBranch, len(group))
+			for i, b := range group {
+				inner[i] = PatternBranch{Label: *b.Pattern.label, Pattern: b.Pattern.inner, Continuation: b.Continuation}
+			}
+
+			innerCase, err := c.compileCase(continuation, inner, matched, fmt.Sprintf(path, label+"<%s>"))
+			if err != nil {
+				return nil, err
+			}
+			branch := NewBranch(group[0].Label, continuation, innerCase)
+			branch.nested = true
+			compiled = append(compiled, branch)
+		case tuples:
+			branch, err := c.compileTuple(group, matched, path)
+			if err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, branch)
+		default:
+			// A name cannot be merged with the nested patterns, since the nested case would have to fall back to it
+			// for the labels left over
+			if err := checkMixedGroup(group, path); err != nil {
+				return nil, err
+			}
+
+			// Each one is kept on its own, so labels matched more than once are reported by the typechecker
+			for _, b := range group {
+				branch, err := c.compileBranch(b, matched, path)
+				if err != nil {
+					return nil, err
+				}
+				compiled = append(compiled, branch)
+			}
+		}
+	}
+
+	p := NewCase(from_c, compiled)
+	if path != "%s" {
+		p.patternOf = matched
+		p.patternPath = path
+	}
+	return p, nil
+}
+
+// The branches (sharing the same label) cannot match it both with a name and with a nested (or tuple) pattern
+func checkMixedGroup(group []PatternBranch, path string) error {
+	name, nested := -1, -1
+	for i, b := range group {
+		if b.Pattern.isName() {
+			if name < 0 {
+				name = i
+			}
+		} else if nested < 0 {
+			nested = i
+		}
+	}
+
+	if name < 0 || nested < 0 {
+		return nil
+	}
+
+	written := func(i int) string {
+		return fmt.Sprintf(path, group[i].Label.L+"<"+group[i].Pattern.String()+">")
+	}
+	return fmt.Errorf("the patterns %s and %s match the label %s both with a name and with a nested pattern", written(min(name, nested)), written(max(name, nested)), group[0].Label.L)
+}
+
+// A branch compiled on its own
+func (c *patternCompiler) compileBranch(b PatternBranch, matched Name, path string) (*BranchForm, error) {
+	switch {
+	case b.Pattern.label != nil:
+		p, err := c.compileCase(Name{}, []PatternBranch{b}, matched, path)
+		if err != nil {
+			return nil, err
+		}
+		return p.branches[0], nil
+	case b.Pattern.elements != nil:
+		return c.compileTuple([]PatternBranch{b}, matched, path)
+	}
+
+	return NewBranch(b.Label, b.Pattern.name, b.Continuation), nil
+}
+
+// The branches (sharing the same label) matching tuples are merged into a receive on the continuation of the label,
+// followed by a case on each element matched further, e.g. <h, l#2> <- recv l#1; case l#2 (...). When merging
+// several branches, they have to use the same names for the other elements, and only one element can be matched
+// further (since the cases on the elements are nested).
+func (c *patternCompiler) compileTuple(group []PatternBranch, matched Name, path string) (*BranchForm, error) {
+	label := group[0].Label
+	size := len(group[0].Pattern.elements)
+	continuation := c.fresh(label)
+
+	written := make([]string, len(group))
+	for i, b := range group {
+		written[i] = fmt.Sprintf(path, label.L+"<"+b.Pattern.String()+">")
+		if len(b.Pattern.elements) != size {
+			return nil, fmt.Errorf("the patterns %s and %s match tuples of different sizes", written[0], written[i])
+		}
+	}
+
+	// The name received for each element, and the elements matched further
+	names := make([]Name, size)
+	var matchedElements []int
+	for i := 0; i < size; i++ {
+		first := group[0].Pattern.elements[i]
+		same := true
+		for _, b := range group {
+			element := b.Pattern.elements[i]
+			same = same && element.isName() && element.name.Ident == first.name.Ident
+		}
+
+		if same {
+			names[i] = first.name
+			continue
+		}
+
+		for j, b := range group {
+			element := b.Pattern.elements[i]
+			if element.elements != nil {
+				return nil, fmt.Errorf("the element %s of the pattern %s cannot be a tuple, since tuples are matched using their labels", element.String(), written[j])
+			}
+			if len(group) > 1 && element.label == nil {
+				other := max(j, 1)
+				return nil, fmt.Errorf("the patterns %s and %s have to use the same names for the elements which are not matched further", written[other-1], written[other])
+			}
+		}
+
+		names[i] = c.fresh(label)
+		matchedElements = append(matchedElements, i)
+	}
+
+	if len(group) > 1 && len(matchedElements) > 1 {
+		return nil, fmt.Errorf("only one element of the tuples can be matched further by the patterns %s", strings.Join(written, ", "))
+	}
+
+	// The path of each element within the tuple, e.g. cons<<_, %s>>
+	elementPath := func(i int) string {
+		elements := make([]string, size)
+		for j := range elements {
+			elements[j] = "_"
+		}
+		elements[i] = "%s"
+		return fmt.Sprintf(path, label.L+"<<"+strings.Join(elements, ", ")+">>")
+	}
+
+	var body Form
+	if len(group) > 1 {
+		i := matchedElements[0]
+		inner := make([]PatternBranch, len(group))
+		for j, b := range group {
+			element := b.Pattern.elements[i]
+			inner[j] = PatternBranch{Label: *element.label, Pattern: element.inner, Continuation: b.Continuation}
+		}
+
+		p, err := c.compileCase(names[i], inner, matched, elementPath(i))
+		if err != nil {
+			return nil, err
+		}
+		p.element = true
+		body = p
+	} else {
+		// The elements of a single tuple are matched one after the other
+		body = group[0].Continuation
+		for k := len(matchedElements) - 1; k >= 0; k-- {
+			i := matchedElements[k]
+			element := group[0].Pattern.elements[i]
+			inner := PatternBranch{Label: *element.label, Pattern: element.inner, Continuation: body}
+
+			p, err := c.compileCase(names[i], []PatternBranch{inner}, matched, elementPath(i))
+			if err != nil {
+				return nil, err
+			}
+			p.element = true
+			body = p
+		}
+	}
+
+	var receive Form
+	if size == 2 {
+		receive = NewReceive(names[0], names[1], continuation, body)
+	} else {
+		receive = NewReceiveTuple(names, continuation, body)
+	}
+
+	branch := NewBranch(label, continuation, receive)
+	branch.nested = true
+	return branch, nil
+}
+
+// A name for the continuation matched by a nested pattern, e.g. x#1 when matching on x
+func (c *patternCompiler) fresh(label Label) Name {
+	base := c.base
+	if base == "" {
+		// Matching on self
+		base = label.L
+	}
+
+	for i := 1; ; i++ {
+		ident := fmt.Sprintf("%s#%d", base, i)
+		if !c.taken[ident] {
+			c.taken[ident] = true
+			return Name{Ident: ident, Position: label.Position}
+		}
+	}
+}
+
+// A branch as written, with its nested patterns taken apart again (names are printed using print)
+type writtenBranch struct {
+	pattern      string
+	names        []*Name
+	labels       []Label
+	continuation Form
+}
+
+func writtenBranches(branches []*BranchForm, print func(Name) string) []writtenBranch {
+	var written []writtenBranch
+	for _, b := range branches {
+		for _, w := range writtenPatterns(b, print) {
+			w.pattern = fmt.Sprintf("%s<%s>", b.label.L, w.pattern)
+			w.labels = append([]Label{b.label}, w.labels...)
+			written = append(written, w)
+		}
+	}
+
+	return written
+}
+
+// The patterns matched by what the branch receives. As for macros, this only holds while the nested forms keep
+// their shape; otherwise the name standing for the continuation is printed.
+func writtenPatterns(b *BranchForm, print func(Name) string) []writtenBranch {
+	if b.nested {
+		switch p := b.continuation_e.(type) {
+		case *CaseForm:
+			if p.from_c.Ident == b.payload_c.Ident {
+				return writtenBranches(p.branches, print)
+			}
+		case *ReceiveForm:
+			if p.from_c.Ident == b.payload_c.Ident {
+				names, continuation_e, ok := p.tuple()
+				if !ok && !p.derivedFromMacro {
+					names, continuation_e, ok = []Name{p.payload_c, p.continuation_c}, p.continuation_e, true
+				}
+				if ok {
+					elements := make([]writtenBranch, len(names))
+					for i := range names {
+						elements[i] = writtenBranch{pattern: print(names[i]), names: []*Name{&names[i]}}
+					}
+					return writtenTuple(elements, names, continuation_e, print)
+				}
+			}
+		}
+	}
+
+	return []writtenBranch{{pattern: print(b.payload_c), names: []*Name{&b.payload_c}, continuation: b.continuation_e}}
+}
+
+// The tuples matched by a receive, where the elements matched further (by the cases on the names received) are
+// printed as patterns, e.g. <h, nil<u>>
+func writtenTuple(elements []writtenBranch, received []Name, continuation Form, print func(Name) string) []writtenBranch {
+	if p, ok := continuation.(*CaseForm); ok && p.element {
+		for i, name := range received {
+			if name.Ident != p.from_c.Ident {
+				continue
+			}
+
+			var written []writtenBranch
+			for _, w := range writtenBranches(p.branches, print) {
+				matched := make([]writtenBranch, len(elements))
+				copy(matched, elements)
+				matched[i] = w
+				written = append(written, writtenTuple(matched, received, w.continuation, print)...)
+			}
+			return written
+		}
+	}
+
+	tuple := writtenBranch{continuation: continuation}
+	var patterns []string
+	for _, element := range elements {
+		patterns = append(patterns, element.pattern)
+		tuple.names = append(tuple.names, element.names...)
+		tuple.labels = append(tuple.labels, element.labels...)
+	}
+	tuple.pattern = "<" + strings.Join(patterns, ", ") + ">"
+
+	return []writtenBranch{tuple}
+}
+
+// The patterns left unmatched by the case on the continuation of a nested pattern, e.g. succ<zero<_>>
+func (p *CaseForm) unmatchedPatterns(branches []types.Option, labels map[string]bool) string {
+	var patterns []string
+	for _, label := range strings.Split(extractUnusedLabels(branches, labels), ", ") {
+		patterns = append(patterns, fmt.Sprintf(p.patternPath, label+"<_>"))
+	}
+
+	return strings.Join(patterns, ", ")
+}
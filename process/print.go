@@ -0,0 +1,192 @@
+package process
+
+import (
+	"fmt"
+	"grits/types"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Values are printed by a client consuming a name whose type is made up of choices (+{...}), pairs (*) and 1, e.g.
+//
+//	type nat = +{zero : 1, succ : nat}
+//	type list = +{nil : 1, cons : nat * list}
+//
+//	prc[a] : 1 = print(l); close self
+//
+// The whole value is received before being printed as a tree, with each label followed by what it carries, e.g.
+// cons(2, cons(0, nil)). Values of types shaped as natural numbers (i.e. +{zero : 1, succ : T}, where T is the same
+// type) are printed as numbers.
+
+// Whether a type can be printed
+func checkPrintType(sessionType types.SessionType, labelledTypesEnv types.LabelledTypesEnv) error {
+	return checkPrintTypeVisited(sessionType, labelledTypesEnv, make(map[string]bool))
+}
+
+func checkPrintTypeVisited(sessionType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, visited map[string]bool) error {
+	if label, ok := sessionType.(*types.LabelType); ok {
+		if visited[label.Label] {
+			return nil
+		}
+		visited[label.Label] = true
+	}
+
+	switch t := types.Unfold(sessionType, labelledTypesEnv).(type) {
+	case *types.UnitType:
+		return nil
+	case *types.SendType:
+		if err := checkPrintTypeVisited(t.Left, labelledTypesEnv, visited); err != nil {
+			return err
+		}
+		return checkPrintTypeVisited(t.Right, labelledTypesEnv, visited)
+	case *types.SelectLabelType:
+		for _, branch := range t.Branches {
+			if err := checkPrintTypeVisited(branch.SessionType, labelledTypesEnv, visited); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("the type '%s' cannot be printed", sessionType.String())
+}
+
+// Whether the values of a type are printed as numbers, i.e. +{zero : 1, succ : T}, where T is the type itself
+func isNumberType(sessionType types.SessionType, labelledTypesEnv types.LabelledTypesEnv) bool {
+	choice, ok := types.Unfold(sessionType, labelledTypesEnv).(*types.SelectLabelType)
+	if !ok || len(choice.Branches) != 2 {
+		return false
+	}
+
+	zero, zeroFound := types.LookupBranchByLabel(choice.Branches, "zero")
+	succ, succFound := types.LookupBranchByLabel(choice.Branches, "succ")
+	if !zeroFound || !succFound {
+		return false
+	}
+
+	_, unit := types.Unfold(zero.SessionType, labelledTypesEnv).(*types.UnitType)
+	return unit && types.EqualType(succ.SessionType, sessionType, labelledTypesEnv)
+}
+
+// A value (or part of it) received by print(x)
+type printedValue struct {
+	// The label selected, along with what it carries
+	label   string
+	payload *printedValue
+	// The pair received
+	first, second *printedValue
+	// Printed as a number (see isNumberType)
+	number bool
+}
+
+func (v *printedValue) String() string {
+	if v.number {
+		count := 0
+		for n := v; n.label == "succ"; n = n.payload {
+			count++
+		}
+		return strconv.Itoa(count)
+	}
+
+	switch {
+	case v.label != "":
+		if v.payload.isUnit() {
+			return v.label
+		}
+		if v.payload.first != nil {
+			// The pair is written as the arguments of the label, e.g. cons(h, t)
+			return v.label + v.payload.String()
+		}
+		return v.label + "(" + v.payload.String() + ")"
+	case v.first != nil:
+		return "(" + strings.Join(v.elements(), ", ") + ")"
+	}
+
+	return "()"
+}
+
+func (v *printedValue) isUnit() bool {
+	return v.label == "" && v.first == nil
+}
+
+// The elements of nested pairs, e.g. a, b and c for (a, (b, c))
+func (v *printedValue) elements() []string {
+	if v.second.first != nil {
+		return append([]string{v.first.String()}, v.second.elements()...)
+	}
+
+	return []string{v.first.String(), v.second.String()}
+}
+
+// Where print writes to
+func (re *RuntimeEnvironment) output() io.Writer {
+	if re.Output == nil {
+		return os.Stdout
+	}
+
+	return re.Output
+}
+
+// The text printed by print l, or the value received by print(x)
+func (p *PrintForm) printed() string {
+	if p.value != nil {
+		return p.value.String()
+	}
+
+	return p.label.String()
+}
+
+// The step taken by print(x): receives the next part of the value on x (through a case, receive or wait), and
+// continues printing the parts carried by it. Once the whole value has been received, it is printed.
+func (f *PrintValueForm) nextStep(re *RuntimeEnvironment) (Form, error) {
+	if f.valueType == nil {
+		return nil, fmt.Errorf("the type of '%s' is unknown, since the program was not typechecked", f.from_c.String())
+	}
+
+	if f.value == nil {
+		value := &printedValue{}
+		printed := NewPrint(Label{L: f.from_c.Ident}, f.continuation_e)
+		printed.value = value
+		return f.receive(value, printed, re)
+	}
+
+	if f.label != "" {
+		// Selected on the name carried by the label
+		f.value.label = f.label
+		f.value.payload = &printedValue{}
+		return f.receive(f.value.payload, f.continuation_e, re)
+	}
+
+	return f.receive(f.value, f.continuation_e, re)
+}
+
+// Receives the value of f.from_c into value, then continues with continuation_e
+func (f *PrintValueForm) receive(value *printedValue, continuation_e Form, re *RuntimeEnvironment) (Form, error) {
+	labelledTypesEnv := types.ProduceLabelledSessionTypeEnvironment(*re.GlobalEnvironment.Types)
+	value.number = isNumberType(f.valueType, labelledTypesEnv)
+
+	switch t := types.Unfold(f.valueType, labelledTypesEnv).(type) {
+	case *types.UnitType:
+		return NewWait(f.from_c, continuation_e), nil
+	case *types.SendType:
+		value.first, value.second = &printedValue{}, &printedValue{}
+		first := Name{Ident: "print#1", Type: t.Left}
+		second := Name{Ident: "print#2", Type: t.Right}
+
+		printSecond := &PrintValueForm{from_c: second, valueType: t.Right, value: value.second, continuation_e: continuation_e}
+		printFirst := &PrintValueForm{from_c: first, valueType: t.Left, value: value.first, continuation_e: printSecond}
+		return NewReceive(first, second, f.from_c, printFirst), nil
+	case *types.SelectLabelType:
+		var branches []*BranchForm
+		for _, option := range t.Branches {
+			payload := Name{Ident: "print#1", Type: option.SessionType}
+			printPayload := &PrintValueForm{from_c: payload, valueType: option.SessionType, value: value, label: option.Label, continuation_e: continuation_e}
+			branches = append(branches, NewBranch(Label{L: option.Label}, payload, printPayload))
+		}
+		return NewCase(f.from_c, branches), nil
+	}
+
+	return nil, fmt.Errorf("the type '%s' of '%s' cannot be printed", f.valueType.String(), f.from_c.String())
+}
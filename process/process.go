@@ -2,6 +2,7 @@ package process
 
 import (
 	"bytes"
+	"fmt"
 	"grits/position"
 	"grits/types"
 )
@@ -52,12 +53,19 @@ type FunctionDefinition struct {
 	ExplicitProvider     Name              // Optional name to be used instead of 'self'
 	UsesExplicitProvider bool              // ExplicitProvider set or not
 	Position             position.Position // Line and character position where function is first defined
+	Local                bool              // Defined within a let block, and lifted to a unique name (see LiftLocalDefinitions)
+	Captured             int               // Number of trailing parameters standing for the outer names used by a local function
 }
 
 func (function *FunctionDefinition) Arity() int {
 	return len(function.Parameters)
 }
 
+// The parameters as written, i.e. without the names captured by a local function
+func (function *FunctionDefinition) WrittenParameters() []Name {
+	return function.Parameters[:len(function.Parameters)-function.Captured]
+}
+
 func (function *FunctionDefinition) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString(function.FunctionName)
@@ -80,6 +88,62 @@ func GetFunctionByNameArity(functions []FunctionDefinition, name string, arity i
 	return nil
 }
 
+// Returns a reference to the definition itself (rather than to a copy), so it can be updated
+func GetFunctionByName(functions []FunctionDefinition, name string) *FunctionDefinition {
+	for i := range functions {
+		if functions[i].FunctionName == name {
+			return &functions[i]
+		}
+	}
+
+	return nil
+}
+
+// SuggestFunction produces a hint for an undefined function, e.g. "; did you mean 'double'?", preferring functions
+// which can be called using the given number of parameters. If the function exists, but expects a different number of
+// parameters, then the hint mentions its arity. Returns an empty string if no function has a similar name.
+func SuggestFunction(name string, arity int, functions []FunctionDefinition) string {
+	var names []string
+	for _, f := range functions {
+		if f.Local {
+			// Local functions cannot be called by name outside their let block
+			continue
+		}
+		if f.FunctionName == name && GetFunctionByNameArity(functions, name, arity) == nil {
+			return fmt.Sprintf("; '%s' takes %s", f.FunctionName, parametersCount(f.Arity()))
+		}
+		names = append(names, f.FunctionName)
+	}
+
+	matches := types.ClosestMatches(name, names)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	for _, match := range matches {
+		if GetFunctionByNameArity(functions, match, arity) != nil {
+			return fmt.Sprintf("; did you mean '%s'?", match)
+		}
+	}
+
+	// The closest function expects a different number of parameters
+	for _, f := range functions {
+		if f.FunctionName == matches[0] {
+			return fmt.Sprintf("; did you mean '%s' (which takes %s)?", f.FunctionName, parametersCount(f.Arity()))
+		}
+	}
+
+	return ""
+}
+
+func parametersCount(count int) string {
+	if count == 1 {
+		return "1 parameter"
+	}
+
+	return fmt.Sprintf("%d parameters", count)
+}
+
 type Shape int
 
 const (
@@ -1,10 +1,13 @@
 package process
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -44,12 +47,23 @@ type RuntimeEnvironment struct {
 	ExecutionVersion Execution_Version
 	// Flag to see whether the typechecker was used or not (i.e. if true, then all names have types)
 	Typechecked bool
+	// Check the messages against the session types of the channels (mostly useful when skipping the typechecker).
+	// The monitor is always used for typechecked programs containing the dynamic type ?, since it performs the casts.
+	MonitorSessionTypes bool
+	// Session type monitor info (only set if the monitor is in use)
+	sessionMonitor *SessionTypeMonitor
 
 	// For benchmarking
 	timeTaken time.Duration // Stores the time taken during execution
 
 	Quiet bool // Suppresses 'print' output
-	// might be useful to replace with a buffer for the output
+
+	// Read by 'input' (the standard input, unless set)
+	Input        io.Reader
+	inputScanner *bufio.Scanner
+	inputMutex   sync.Mutex
+	// Written by 'print' (the standard output, unless set)
+	Output io.Writer
 }
 
 type Execution_Version int
@@ -101,6 +115,8 @@ func InitializeProcesses(processes []*Process, globalEnv *GlobalEnvironment, sub
 			ExecutionVersion: NORMAL_ASYNC,
 			Typechecked:      false,
 			Quiet:            false,
+			// There is no one to read the input from (e.g. when running within the webserver)
+			Input: strings.NewReader(""),
 		}
 	}
 
@@ -259,6 +275,10 @@ func (re *RuntimeEnvironment) SubstituteNameInitialization(processes []*Process,
 }
 
 func (re *RuntimeEnvironment) StartTransitions(processes []*Process) {
+	if re.MonitorSessionTypes || (re.Typechecked && usesDynamicTypes(processes, re.GlobalEnvironment)) {
+		re.sessionMonitor = NewSessionTypeMonitor(re.GlobalEnvironment)
+	}
+
 	for _, p := range processes {
 		p_uniq := p
 
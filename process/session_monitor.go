@@ -0,0 +1,464 @@
+package process
+
+import (
+	"errors"
+	"fmt"
+	"grits/types"
+	"sync"
+)
+
+// When the typechecker is skipped (--notypecheck), nothing ensures that processes follow the protocol of their
+// channels, so a mismatch shows up as a panic (or a hang) deep within the transitions. The session type monitor
+// (enabled by RuntimeEnvironment.MonitorSessionTypes) keeps track of the type expected on each channel, and checks:
+// -> each message being sent, e.g. SND is only allowed on A * B, and a selected label has to be one of the choices
+// -> each receive as it starts, e.g. a case on a client expects SEL, so the channel has to be of type +{...}
+// -> that a channel is never used again after being closed
+// Channels take the declared type of the process providing them (e.g. prc[a] : A, x : A <- new ..., or the type of a
+// called function), while the names carried by a message take the corresponding part of the channel's type.
+// Channels whose type is not known are not checked.
+//
+// With gradual typing, the monitor is also where the casts between the dynamic type ? and the static types take place.
+// A channel of type ? is cast to a static type when it is first used at that type, e.g. when passed to a function
+// expecting nat, or when a statically typed process receives on it. From then on, its messages are checked against
+// the static type, and a mismatch is reported as a failed cast, blaming the process which sent the offending message.
+type SessionTypeMonitor struct {
+	mutex            sync.Mutex
+	labelledTypesEnv types.LabelledTypesEnv
+	channels         map[chan Message]*monitoredChannel
+}
+
+type monitoredChannel struct {
+	// Original name of the channel (for error messages)
+	ident       string
+	sessionType types.SessionType
+	closed      bool
+	// Set once a channel of type ? is cast to a static type
+	cast bool
+	// The last process which sent a message on the channel, e.g. (Line 4) prc[a], to assign blame for failed casts
+	lastSender string
+}
+
+// A message which does not follow the static type that a channel of type ? has been cast to. The blamed process is
+// the one which sent the message (or the one reporting the failure, if blame is empty).
+type castFailure struct {
+	blame   string
+	message string
+}
+
+func (c *castFailure) Error() string {
+	return c.message
+}
+
+func NewSessionTypeMonitor(globalEnv *GlobalEnvironment) *SessionTypeMonitor {
+	return &SessionTypeMonitor{
+		labelledTypesEnv: types.ProduceLabelledSessionTypeEnvironment(*globalEnv.Types),
+		channels:         make(map[chan Message]*monitoredChannel),
+	}
+}
+
+// Sets the type expected on a channel, unless it is already known. A known type of ? is cast to a static one.
+func (m *SessionTypeMonitor) attachType(name Name, sessionType types.SessionType) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.attachTypeUnlocked(name, sessionType)
+}
+
+func (m *SessionTypeMonitor) attachTypeUnlocked(name Name, sessionType types.SessionType) {
+	if name.Channel == nil || sessionType == nil {
+		return
+	}
+
+	channel, exists := m.channels[name.Channel]
+	if !exists {
+		m.channels[name.Channel] = &monitoredChannel{ident: name.Ident, sessionType: sessionType}
+	} else if channel.sessionType == nil {
+		channel.sessionType = sessionType
+	} else if m.isDynamic(channel.sessionType) && !m.isDynamic(sessionType) {
+		channel.sessionType = sessionType
+		channel.cast = true
+	}
+}
+
+func (m *SessionTypeMonitor) isDynamic(sessionType types.SessionType) bool {
+	return types.IsDynamic(types.Unfold(sessionType, m.labelledTypesEnv))
+}
+
+// Checks a message about to be sent on a channel, and sets the types of the names it carries
+func (m *SessionTypeMonitor) checkMessage(toChan chan Message, message Message, sender string) error {
+	if _, interactive := ruleDescription[message.Rule]; !interactive {
+		// Control messages (e.g. FWD) do not follow the protocol
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if channel, exists := m.channels[toChan]; exists {
+		channel.lastSender = sender
+	}
+
+	channel, sessionType, err := m.expectRule(toChan, message.Rule, "found")
+	if err != nil || sessionType == nil {
+		return err
+	}
+
+	return m.attachMessageTypes(channel, sessionType, message)
+}
+
+// Sets the types of the names carried by a message, according to the (unfolded) type of the channel
+func (m *SessionTypeMonitor) attachMessageTypes(channel *monitoredChannel, sessionType types.SessionType, message Message) error {
+	switch t := sessionType.(type) {
+	case *types.SendType:
+		m.attachTypeUnlocked(message.Channel1, t.Left)
+		m.attachTypeUnlocked(message.Channel2, t.Right)
+	case *types.ReceiveType:
+		m.attachTypeUnlocked(message.Channel1, t.Left)
+		m.attachTypeUnlocked(message.Channel2, t.Right)
+	case *types.SelectLabelType:
+		return m.attachBranchType(channel, t.Branches, message)
+	case *types.BranchCaseType:
+		return m.attachBranchType(channel, t.Branches, message)
+	case *types.DownType:
+		m.attachTypeUnlocked(message.Channel1, t.Continuation)
+	case *types.UpType:
+		m.attachTypeUnlocked(message.Channel1, t.Continuation)
+	}
+
+	return nil
+}
+
+// The continuation of a selected label takes the type of the corresponding choice
+func (m *SessionTypeMonitor) attachBranchType(channel *monitoredChannel, branches []types.Option, message Message) error {
+	for _, option := range branches {
+		if option.Label == message.Label.L {
+			m.attachTypeUnlocked(message.Channel1, option.SessionType)
+			return nil
+		}
+	}
+
+	if channel.cast {
+		return &castFailure{message: fmt.Sprintf("the cast of '%s' from '?' to '%s' failed, since the label '%s' was selected; %s", channel.ident, channel.sessionType.String(), message.Label.L, types.ExpectedLabelsHint(message.Label.L, branches))}
+	}
+
+	return fmt.Errorf("the label '%s' was selected on '%s', but its type '%s' %s", message.Label.L, channel.ident, channel.sessionType.String(), types.ExpectedLabelsHint(message.Label.L, branches))
+}
+
+// Checks that the kind of message a process is about to receive matches the type of the channel
+func (m *SessionTypeMonitor) checkReceive(fromChan chan Message, expectedRule Rule) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, _, err := m.expectRule(fromChan, expectedRule, "the process expects")
+	return err
+}
+
+// Once a CLS message is received, the channel can no longer be used
+func (m *SessionTypeMonitor) received(fromChan chan Message, message Message) {
+	if message.Rule != CLS {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if channel, exists := m.channels[fromChan]; exists {
+		channel.closed = true
+	}
+}
+
+// A message received on a channel of type ? is only checked once it reaches a process expecting a static type (i.e.
+// the type of the name it receives on, as set by the typechecker). This is the boundary where ? is cast to that type.
+func (m *SessionTypeMonitor) checkReceived(fromChan chan Message, message Message, receivingName Name) error {
+	if _, interactive := ruleDescription[message.Rule]; !interactive || receivingName.Type == nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channel, exists := m.channels[fromChan]
+	if !exists || (!m.isDynamic(channel.sessionType) && !channel.cast) {
+		// Messages on channels with a static type are checked when sent (unless sent before a cast)
+		return nil
+	}
+
+	expectedType := types.Unfold(receivingName.Type, m.labelledTypesEnv)
+	if types.IsDynamic(expectedType) {
+		return nil
+	}
+
+	if expectedRule, ok := protocolRule(expectedType); ok && expectedRule != message.Rule {
+		return &castFailure{
+			blame:   channel.lastSender,
+			message: fmt.Sprintf("the cast of '%s' from '?' to '%s' failed, since the type only allows %s, but the message was a %s", receivingName.Ident, receivingName.Type.String(), ruleDescription[expectedRule], ruleDescription[message.Rule]),
+		}
+	}
+
+	err := m.attachMessageTypes(&monitoredChannel{ident: receivingName.Ident, sessionType: receivingName.Type, cast: true}, expectedType, message)
+	if cast, ok := err.(*castFailure); ok {
+		cast.blame = channel.lastSender
+	}
+
+	return err
+}
+
+// Compares a rule to the one allowed by the (unfolded) type of the channel. The unfolded type is returned, or nil if
+// it is not known.
+func (m *SessionTypeMonitor) expectRule(channelChan chan Message, rule Rule, found string) (*monitoredChannel, types.SessionType, error) {
+	channel, exists := m.channels[channelChan]
+	if !exists {
+		return nil, nil, nil
+	}
+
+	if channel.closed {
+		return channel, nil, fmt.Errorf("'%s' is used (%s) after being closed", channel.ident, ruleDescription[rule])
+	}
+
+	sessionType := types.Unfold(channel.sessionType, m.labelledTypesEnv)
+	if sessionType == nil {
+		return channel, nil, nil
+	}
+
+	expectedRule, ok := protocolRule(sessionType)
+	if ok && expectedRule != rule && channel.cast {
+		return channel, nil, &castFailure{message: fmt.Sprintf("the cast of '%s' from '?' to '%s' failed, since the type only allows %s, but %s %s", channel.ident, channel.sessionType.String(), ruleDescription[expectedRule], found, ruleDescription[rule])}
+	} else if ok && expectedRule != rule {
+		return channel, nil, fmt.Errorf("the type '%s' of '%s' only allows %s, but %s %s", channel.sessionType.String(), channel.ident, ruleDescription[expectedRule], found, ruleDescription[rule])
+	}
+
+	return channel, sessionType, nil
+}
+
+// The only rule allowed by a type, e.g. a provider of A * B sends a SND message
+func protocolRule(sessionType types.SessionType) (Rule, bool) {
+	switch sessionType.(type) {
+	case *types.UnitType:
+		return CLS, true
+	case *types.SendType:
+		return SND, true
+	case *types.ReceiveType:
+		return RCV, true
+	case *types.SelectLabelType:
+		return SEL, true
+	case *types.BranchCaseType:
+		return BRA, true
+	case *types.DownType:
+		return CST, true
+	case *types.UpType:
+		return SHF, true
+	}
+
+	return 0, false
+}
+
+var ruleDescription = map[Rule]string{
+	CLS: "close (CLS)",
+	SND: "send on the provider (SND)",
+	RCV: "send from a client (RCV)",
+	SEL: "select by the provider (SEL)",
+	BRA: "select from a client (BRA)",
+	CST: "cast by the provider (CST)",
+	SHF: "cast from a client (SHF)",
+}
+
+// The rule a receiving form waits for, e.g. a receive on self waits for RCV, while one on a client waits for SND
+func receivingRule(form Form) (Rule, bool) {
+	switch f := form.(type) {
+	case *ReceiveForm:
+		if f.from_c.IsSelf {
+			return RCV, true
+		}
+		return SND, true
+	case *CaseForm:
+		if f.from_c.IsSelf {
+			return BRA, true
+		}
+		return SEL, true
+	case *WaitForm:
+		return CLS, true
+	case *ShiftForm:
+		if f.from_c.IsSelf {
+			return SHF, true
+		}
+		return CST, true
+	}
+
+	return 0, false
+}
+
+// The name on which a receiving form waits, e.g. x in case x (...)
+func receivingName(form Form) (Name, bool) {
+	switch f := form.(type) {
+	case *ReceiveForm:
+		return f.from_c, true
+	case *CaseForm:
+		return f.from_c, true
+	case *WaitForm:
+		return f.to_c, true
+	case *ShiftForm:
+		return f.from_c, true
+	}
+
+	return Name{}, false
+}
+
+// Programs using the dynamic type ? rely on the monitor to check the casts between ? and the static types
+func usesDynamicTypes(processes []*Process, globalEnv *GlobalEnvironment) bool {
+	var sessionTypes []types.SessionType
+	for _, def := range *globalEnv.Types {
+		sessionTypes = append(sessionTypes, def.SessionType)
+	}
+
+	forms := []Form{}
+	for _, p := range processes {
+		sessionTypes = append(sessionTypes, p.Type)
+		forms = append(forms, p.Body)
+	}
+
+	for _, f := range *globalEnv.FunctionDefinitions {
+		sessionTypes = append(sessionTypes, f.Type)
+		for _, param := range f.Parameters {
+			sessionTypes = append(sessionTypes, param.Type)
+		}
+		forms = append(forms, f.Body)
+	}
+
+	for _, form := range forms {
+		for _, f := range AllForms(form) {
+			if newForm, ok := f.(*NewForm); ok {
+				sessionTypes = append(sessionTypes, newForm.new_name_c.Type)
+			}
+		}
+	}
+
+	for _, t := range sessionTypes {
+		if types.ContainsDynamicType(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+///////////////////////////////////////////////////////////
+////////////////// Runtime integration ////////////////////
+///////////////////////////////////////////////////////////
+
+// The following are no-ops unless the session type monitor is enabled. They return false when a violation was found,
+// in which case the process should stop transitioning.
+
+func (re *RuntimeEnvironment) monitorSpawn(process *Process) {
+	if re.sessionMonitor == nil {
+		return
+	}
+
+	for _, provider := range process.Providers {
+		re.sessionMonitor.attachType(provider, process.Type)
+	}
+}
+
+func (re *RuntimeEnvironment) monitorSend(process *Process, toChan chan Message, message Message) bool {
+	if re.sessionMonitor == nil {
+		return true
+	}
+
+	sender := fmt.Sprintf("(%s) %s", process.Position.String(), process.OutlineString())
+	if err := re.sessionMonitor.checkMessage(toChan, message, sender); err != nil {
+		re.reportViolation(process, err)
+		return false
+	}
+
+	return true
+}
+
+func (re *RuntimeEnvironment) monitorReceive(process *Process, fromChan chan Message) bool {
+	if re.sessionMonitor == nil {
+		return true
+	}
+
+	rule, ok := receivingRule(process.Body)
+	if !ok {
+		return true
+	}
+
+	if err := re.sessionMonitor.checkReceive(fromChan, rule); err != nil {
+		re.reportViolation(process, err)
+		return false
+	}
+
+	return true
+}
+
+func (re *RuntimeEnvironment) monitorReceived(process *Process, fromChan chan Message, message Message) bool {
+	if re.sessionMonitor == nil {
+		return true
+	}
+
+	if name, ok := receivingName(process.Body); ok && re.Typechecked {
+		if err := re.sessionMonitor.checkReceived(fromChan, message, name); err != nil {
+			re.reportViolation(process, err)
+			return false
+		}
+	}
+
+	re.sessionMonitor.received(fromChan, message)
+	return true
+}
+
+// A called function may declare the types of its provider and parameters
+func (re *RuntimeEnvironment) monitorCall(process *Process, call *CallForm, function *FunctionDefinition) {
+	if re.sessionMonitor == nil {
+		return
+	}
+
+	if len(process.Providers) == 1 {
+		re.sessionMonitor.attachType(process.Providers[0], function.Type)
+	}
+
+	parameters := call.parameters
+	if len(parameters) == len(function.Parameters)+1 {
+		// The first parameter refers to the provider
+		parameters = parameters[1:]
+	}
+
+	for i := 0; i < len(parameters) && i < len(function.Parameters); i++ {
+		re.sessionMonitor.attachType(parameters[i], function.Parameters[i].Type)
+	}
+}
+
+// The polarity of a name of type ? is taken from the type of the channel it refers to at runtime (if known)
+func (re *RuntimeEnvironment) monitoredPolarity(name Name) types.Polarity {
+	if re.sessionMonitor == nil {
+		return types.UNKNOWN
+	}
+
+	m := re.sessionMonitor
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	channel, exists := m.channels[name.Channel]
+	if !exists || channel.sessionType == nil || m.isDynamic(channel.sessionType) {
+		return types.UNKNOWN
+	}
+
+	return types.Unfold(channel.sessionType, m.labelledTypesEnv).Polarity()
+}
+
+func (re *RuntimeEnvironment) reportViolation(process *Process, err error) {
+	var cast *castFailure
+	if errors.As(err, &cast) {
+		blame := cast.blame
+		if blame == "" {
+			blame = fmt.Sprintf("(%s) %s", process.Position.String(), process.OutlineString())
+		}
+		err = fmt.Errorf("cast failure blaming %s: %s", blame, cast.message)
+	} else {
+		err = fmt.Errorf("(%s) session type violation in %s: %s", process.Position.String(), process.OutlineString(), err)
+	}
+
+	select {
+	case re.errorChan <- err:
+	case <-re.ctx.Done():
+	}
+}
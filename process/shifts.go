@@ -0,0 +1,554 @@
+package process
+
+import (
+	"fmt"
+	"grits/types"
+)
+
+// Shift insertion (enabled by GlobalEnvironment.InsertShifts) adds the shifts and casts needed to move between modes,
+// based on the declared types. A name whose type is a shift, but which is used as the continuation of that type, is
+// shifted first, e.g.
+// >    x : lin /\ aff A used as A      becomes    x1 : A <- new cast x<self>; ...
+// >    x : aff \/ lin A used as A      becomes    x1 <- shift x; ...
+// In the same way, the provider of an upshift is shifted before its first step (s1 <- shift self; ...), the provider of
+// a downshift is cast at its last step (s1 : A <- new ...; cast self<s1>), and a name passed to a function expecting a downshift of its type is cast
+// beforehand (x1 : aff \/ lin A <- new cast self<x>). The modes involved have to follow CanBeUpshiftedTo and
+// CanBeDownshiftedTo. Upshifts are never created from a name, since the new process would depend on a name of a lower
+// mode. The inserted forms are then checked by the typechecker as usual.
+
+type shiftInserter struct {
+	labelledTypesEnv types.LabelledTypesEnv
+	sigma            FunctionTypesEnv
+	// The names which cannot be used by the inserted forms, since they already appear in the process
+	taken map[string]bool
+}
+
+// The types of the names available at some point in a process
+type shiftContext map[string]types.SessionType
+
+func insertShifts(processes []*Process, assumedFreeNames []Name, globalEnv *GlobalEnvironment) {
+	labelledTypesEnv := types.ProduceLabelledSessionTypeEnvironment(*globalEnv.Types)
+	sigma := produceFunctionDefinitionsEnvironment(*globalEnv.FunctionDefinitions, labelledTypesEnv)
+
+	functions := *globalEnv.FunctionDefinitions
+	for i := range functions {
+		e := newShiftInserter(functions[i].Body, labelledTypesEnv, sigma)
+		functions[i].Body = e.walk(functions[i].Body, contextOf(functions[i].Parameters), "", functions[i].Type)
+	}
+
+	for _, p := range processes {
+		e := newShiftInserter(p.Body, labelledTypesEnv, sigma)
+		p.Body = e.walk(p.Body, contextOf(getFreeNameTypes(p, processes, assumedFreeNames)), "", p.Type)
+	}
+}
+
+func newShiftInserter(body Form, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv) *shiftInserter {
+	e := &shiftInserter{labelledTypesEnv: labelledTypesEnv, sigma: sigma, taken: make(map[string]bool)}
+	for _, name := range AllNames(body) {
+		e.taken[name.Ident] = true
+	}
+
+	return e
+}
+
+func contextOf(names []Name) shiftContext {
+	ctx := make(shiftContext)
+	for _, name := range names {
+		if name.Type != nil {
+			ctx[name.Ident] = name.Type
+		}
+	}
+
+	return ctx
+}
+
+func (ctx shiftContext) copy() shiftContext {
+	copied := make(shiftContext)
+	for ident, t := range ctx {
+		copied[ident] = t
+	}
+
+	return copied
+}
+
+// Walks through a process, keeping track of the types of the names (in ctx) and of the provider (known as self, or
+// by its shadow name). Unknown types (nil) are left alone.
+func (e *shiftInserter) walk(form Form, ctx shiftContext, shadow string, provider types.SessionType) Form {
+	isSelf := func(name Name) bool {
+		return name.IsSelf || (shadow != "" && name.Ident == shadow)
+	}
+
+	provider = e.unfold(provider)
+
+	// The provider is shifted or cast before acting as the continuation of its type
+	switch t := provider.(type) {
+	case *types.UpType:
+		// The shift comes before any other step, since those take place in the mode of the continuation
+		if t.From.CanBeUpshiftedTo(t.To) && !e.keepsShift(form, ctx, isSelf, t) {
+			s := e.fresh("s")
+			return insertedShift(Name{Ident: s}, Name{IsSelf: true}, e.walk(form, ctx, s, t.Continuation))
+		}
+	case *types.DownType:
+		if t.From.CanBeDownshiftedTo(t.To) && e.providesContinuation(form, ctx, isSelf, t, t.Continuation) {
+			return e.castProvider(form, ctx, isSelf, t)
+		}
+	}
+
+	// A client used as the continuation of its shift type is shifted (or cast) first
+	if client := e.subject(form, ctx, isSelf, provider); client != nil {
+		if converted := e.unshiftClient(form, *client, ctx); converted != nil {
+			return e.walkConverted(converted, ctx, shadow, provider)
+		}
+	}
+
+	// Names passed to functions expecting downshifts are cast first
+	if call := callOf(form); call != nil {
+		if converted := e.castArguments(form, call, ctx, isSelf); converted != nil {
+			return e.walkConverted(converted, ctx, shadow, provider)
+		}
+	}
+
+	e.continueWalk(form, ctx, shadow, provider, isSelf)
+
+	return form
+}
+
+// The inserted form binds a single name, which is added to the context before continuing with the original form
+func (e *shiftInserter) walkConverted(converted Form, ctx shiftContext, shadow string, provider types.SessionType) Form {
+	switch p := converted.(type) {
+	case *NewForm:
+		delete(ctx, nonSelfName(p.body))
+		ctx[p.new_name_c.Ident] = p.new_name_c.Type
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	case *ShiftForm:
+		shifted := e.unfold(ctx[p.from_c.Ident]).(*types.DownType)
+		delete(ctx, p.from_c.Ident)
+		ctx[p.continuation_c.Ident] = shifted.Continuation
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	}
+
+	return converted
+}
+
+// Updates the context according to the step, and walks through the rest of the process
+func (e *shiftInserter) continueWalk(form Form, ctx shiftContext, shadow string, provider types.SessionType, isSelf func(Name) bool) {
+	switch p := form.(type) {
+	case *ReceiveForm:
+		if isSelf(p.from_c) {
+			var continuation types.SessionType
+			if t, ok := provider.(*types.ReceiveType); ok {
+				ctx[p.payload_c.Ident] = t.Left
+				continuation = t.Right
+			}
+			p.continuation_e = e.walk(p.continuation_e, ctx, p.continuation_c.Ident, continuation)
+		} else {
+			if t, ok := e.unfold(ctx[p.from_c.Ident]).(*types.SendType); ok {
+				ctx[p.payload_c.Ident] = t.Left
+				ctx[p.continuation_c.Ident] = t.Right
+			}
+			delete(ctx, p.from_c.Ident)
+			p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+		}
+	case *CaseForm:
+		var options []types.Option
+		if isSelf(p.from_c) {
+			if t, ok := provider.(*types.BranchCaseType); ok {
+				options = t.Branches
+			}
+		} else if t, ok := e.unfold(ctx[p.from_c.Ident]).(*types.SelectLabelType); ok {
+			options = t.Branches
+		}
+		delete(ctx, p.from_c.Ident)
+
+		for _, branch := range p.branches {
+			var option types.SessionType
+			for _, o := range options {
+				if o.Label == branch.label.L {
+					option = o.SessionType
+				}
+			}
+
+			branchCtx := ctx.copy()
+			if isSelf(p.from_c) {
+				branch.continuation_e = e.walk(branch.continuation_e, branchCtx, branch.payload_c.Ident, option)
+			} else {
+				branchCtx[branch.payload_c.Ident] = option
+				branch.continuation_e = e.walk(branch.continuation_e, branchCtx, shadow, provider)
+			}
+		}
+	case *NewForm:
+		if p.onProvider {
+			// The body of a macro on the provider (e.g. send self<y>; P) carries on as the provider
+			for _, name := range p.continuation_e.FreeNames() {
+				delete(ctx, name.Ident)
+			}
+			p.body = e.walk(p.body, ctx, "", e.macroContinuation(p, provider))
+			return
+		}
+
+		for _, name := range p.body.FreeNames() {
+			delete(ctx, name.Ident)
+		}
+
+		if p.new_name_c.Type != nil {
+			// The modes of the annotation are only filled in by the typechecker
+			annotation := types.CopyType(p.new_name_c.Type)
+			types.AddMissingModalities(&annotation, e.labelledTypesEnv)
+			ctx[p.new_name_c.Ident] = annotation
+		} else if call, ok := p.body.(*CallForm); ok {
+			if function, exists := e.sigma[call.functionName]; exists {
+				ctx[p.new_name_c.Ident] = function.Type
+			}
+		}
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	case *WaitForm:
+		delete(ctx, p.to_c.Ident)
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	case *PrintValueForm:
+		delete(ctx, p.from_c.Ident)
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	case *ShiftForm:
+		if isSelf(p.from_c) {
+			var continuation types.SessionType
+			if t, ok := provider.(*types.UpType); ok {
+				continuation = t.Continuation
+			}
+			p.continuation_e = e.walk(p.continuation_e, ctx, p.continuation_c.Ident, continuation)
+		} else {
+			if t, ok := e.unfold(ctx[p.from_c.Ident]).(*types.DownType); ok {
+				ctx[p.continuation_c.Ident] = t.Continuation
+			}
+			delete(ctx, p.from_c.Ident)
+			p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+		}
+	case *DropForm:
+		delete(ctx, p.client_c.Ident)
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	case *SplitForm:
+		if t, ok := ctx[p.from_c.Ident]; ok {
+			ctx[p.channel_one.Ident] = t
+			ctx[p.channel_two.Ident] = t
+		}
+		delete(ctx, p.from_c.Ident)
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	case *PrintForm:
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	case *LetForm:
+		p.continuation_e = e.walk(p.continuation_e, ctx, shadow, provider)
+	}
+}
+
+// The type provided by the body of a macro on the provider, e.g. B in send self<y>; P for self : A * B
+func (e *shiftInserter) macroContinuation(p *NewForm, provider types.SessionType) types.SessionType {
+	switch t := provider.(type) {
+	case *types.SendType:
+		return t.Right
+	case *types.SelectLabelType:
+		if step, ok := p.continuation_e.(*SelectForm); ok {
+			for _, option := range t.Branches {
+				if option.Label == step.label.L {
+					return option.SessionType
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Whether the form acts on the provider as if it had the type continuation, rather than the shift type
+func (e *shiftInserter) providesContinuation(form Form, ctx shiftContext, isSelf func(Name) bool, shift, continuation types.SessionType) bool {
+	switch p := form.(type) {
+	case *SendForm:
+		return isSelf(p.to_c)
+	case *ReceiveForm:
+		return isSelf(p.from_c)
+	case *SelectForm:
+		return isSelf(p.to_c)
+	case *CaseForm:
+		return isSelf(p.from_c)
+	case *CloseForm, *InputForm:
+		return true
+	case *CastForm:
+		// A cast on the provider (↓R) is part of the downshift itself
+		_, down := shift.(*types.DownType)
+		return isSelf(p.to_c) && !down
+	case *ForwardForm:
+		return e.fits(ctx[p.from_c.Ident], continuation) && !e.fits(ctx[p.from_c.Ident], shift)
+	case *CallForm:
+		if function, exists := e.sigma[p.functionName]; exists {
+			return e.fits(function.Type, continuation) && !e.fits(function.Type, shift)
+		}
+	}
+
+	return false
+}
+
+// Whether the process still provides the upshift itself later on, i.e. it shifts the provider explicitly, forwards
+// or calls a process of the shift type, or leaves a hole for it. Only the steps on clients are looked through.
+func (e *shiftInserter) keepsShift(form Form, ctx shiftContext, isSelf func(Name) bool, shift *types.UpType) bool {
+	switch p := form.(type) {
+	case *HoleForm:
+		return true
+	case *ShiftForm:
+		return isSelf(p.from_c) || e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	case *ForwardForm:
+		return e.fits(ctx[p.from_c.Ident], shift)
+	case *CallForm:
+		function, exists := e.sigma[p.functionName]
+		return exists && e.fits(function.Type, shift)
+	case *ReceiveForm:
+		return !isSelf(p.from_c) && e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	case *CaseForm:
+		if isSelf(p.from_c) {
+			return false
+		}
+		for _, branch := range p.branches {
+			if e.keepsShift(branch.continuation_e, ctx, isSelf, shift) {
+				return true
+			}
+		}
+	case *NewForm:
+		return e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	case *WaitForm:
+		return e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	case *DropForm:
+		return e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	case *SplitForm:
+		return e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	case *PrintForm:
+		return e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	case *PrintValueForm:
+		return e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	case *LetForm:
+		return e.keepsShift(p.continuation_e, ctx, isSelf, shift)
+	}
+
+	return false
+}
+
+// Casts the provider of a downshift, whose continuation is provided by a new process:
+// >    send self<x, y>    becomes    s1 : A <- new send self<x, y>; cast self<s1>
+// Only forms ending a process are moved to the new process, since its body has to be a simple axiomatic rule.
+func (e *shiftInserter) castProvider(form Form, ctx shiftContext, isSelf func(Name) bool, shift *types.DownType) Form {
+	s := Name{Ident: e.fresh("s"), Type: types.CopyType(shift.Continuation)}
+
+	switch p := form.(type) {
+	case *ForwardForm:
+		cast := NewCast(Name{IsSelf: true}, p.from_c)
+		cast.inserted = true
+		return cast
+	case *CallForm:
+		// Within the new process, self is not passed explicitly
+		var parameters []Name
+		for _, parameter := range p.parameters {
+			if !isSelf(parameter) {
+				parameters = append(parameters, parameter)
+			}
+		}
+		return insertedCast(s, NewCall(p.functionName, parameters))
+	case *SendForm, *SelectForm, *CloseForm, *InputForm:
+		for _, name := range AllNames(form) {
+			if isSelf(*name) {
+				*name = Name{IsSelf: true, Position: name.Position}
+			}
+		}
+		return insertedCast(s, form)
+	}
+
+	return form
+}
+
+// The client on which a step acts (e.g. x in case x (...)), if any
+func (e *shiftInserter) subject(form Form, ctx shiftContext, isSelf func(Name) bool, provider types.SessionType) *Name {
+	var client *Name
+
+	switch p := form.(type) {
+	case *SendForm:
+		client = &p.to_c
+	case *ReceiveForm:
+		client = &p.from_c
+	case *SelectForm:
+		client = &p.to_c
+	case *CaseForm:
+		client = &p.from_c
+	case *WaitForm:
+		client = &p.to_c
+	case *PrintValueForm:
+		client = &p.from_c
+	case *ForwardForm:
+		// Only when the client provides the continuation of its type
+		if shifted := e.shifted(ctx[p.from_c.Ident]); shifted != nil && e.fits(shifted, provider) && !e.fits(ctx[p.from_c.Ident], provider) {
+			client = &p.from_c
+		}
+	case *NewForm:
+		switch body := p.body.(type) {
+		case *SendForm:
+			client = &body.to_c
+		case *SelectForm:
+			client = &body.to_c
+		}
+	}
+
+	if client == nil || isSelf(*client) {
+		return nil
+	}
+
+	return client
+}
+
+// Shifts (or casts) a client before the step using it, renaming its uses to the new name:
+// >    case x (...)    becomes    x1 : A <- new cast x<self>; case x1 (...)
+func (e *shiftInserter) unshiftClient(form Form, client Name, ctx shiftContext) Form {
+	x := Name{Ident: client.Ident}
+
+	switch t := e.unfold(ctx[client.Ident]).(type) {
+	case *types.UpType:
+		if !t.From.CanBeUpshiftedTo(t.To) {
+			return nil
+		}
+
+		if forward, ok := form.(*ForwardForm); ok {
+			// fwd self x provides the continuation of x directly
+			cast := NewCast(forward.from_c, forward.to_c)
+			cast.inserted = true
+			return cast
+		}
+
+		x1 := Name{Ident: e.fresh(client.Ident), Type: types.CopyType(t.Continuation)}
+		form.Substitute(x, Name{Ident: x1.Ident})
+		return insertedNew(x1, NewCast(x, Name{IsSelf: true}), form)
+	case *types.DownType:
+		if !t.From.CanBeDownshiftedTo(t.To) {
+			return nil
+		}
+
+		x1 := Name{Ident: e.fresh(client.Ident)}
+		form.Substitute(x, x1)
+		return insertedShift(x1, x, form)
+	}
+
+	return nil
+}
+
+// Casts the first name passed to a function expecting a downshift of its type (further ones are left to the next
+// step, i.e. the continuation of the inserted new)
+func (e *shiftInserter) castArguments(form Form, call *CallForm, ctx shiftContext, isSelf func(Name) bool) Form {
+	function, exists := e.sigma[call.functionName]
+	if !exists {
+		return nil
+	}
+
+	var arguments []*Name
+	for i := range call.parameters {
+		if !isSelf(call.parameters[i]) {
+			arguments = append(arguments, &call.parameters[i])
+		}
+	}
+
+	if len(arguments) != len(function.Parameters) {
+		return nil
+	}
+
+	for i, argument := range arguments {
+		expected, ok := e.unfold(function.Parameters[i].Type).(*types.DownType)
+		argumentType := e.unfold(ctx[argument.Ident])
+		if !ok || argumentType == nil || e.shifted(argumentType) != nil || types.IsDynamic(argumentType) {
+			continue
+		}
+
+		if argumentType.Modality().CanBeDownshiftedTo(expected.To) && e.fits(argumentType, expected.Continuation) {
+			x1 := Name{Ident: e.fresh(argument.Ident), Type: types.CopyType(function.Parameters[i].Type)}
+			x := Name{Ident: argument.Ident}
+			argument.Ident = x1.Ident
+			return insertedNew(x1, NewCast(Name{IsSelf: true}, x), form)
+		}
+	}
+
+	return nil
+}
+
+// The call made by a step, either directly or by a new process
+func callOf(form Form) *CallForm {
+	switch p := form.(type) {
+	case *CallForm:
+		return p
+	case *NewForm:
+		if call, ok := p.body.(*CallForm); ok {
+			return call
+		}
+	}
+
+	return nil
+}
+
+// The continuation of a shift type, or nil for other types
+func (e *shiftInserter) shifted(sessionType types.SessionType) types.SessionType {
+	switch t := e.unfold(sessionType).(type) {
+	case *types.UpType:
+		return t.Continuation
+	case *types.DownType:
+		return t.Continuation
+	}
+
+	return nil
+}
+
+// Whether a name of type found can be used where expected is needed (unknown types never fit)
+func (e *shiftInserter) fits(found, expected types.SessionType) bool {
+	if found == nil || expected == nil {
+		return false
+	}
+
+	return types.ConsistentSubtype(found, expected, e.labelledTypesEnv)
+}
+
+func (e *shiftInserter) unfold(sessionType types.SessionType) types.SessionType {
+	if sessionType == nil {
+		return nil
+	}
+
+	return types.Unfold(sessionType, e.labelledTypesEnv)
+}
+
+// Numbers the new names, e.g. x1, x2, ... (skipping the names already in use)
+func (e *shiftInserter) fresh(ident string) string {
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s%d", ident, i)
+		if !e.taken[name] {
+			e.taken[name] = true
+			return name
+		}
+	}
+}
+
+// The name cast by the body of an inserted new, i.e. x in cast x<self> or cast self<x>
+func nonSelfName(body Form) string {
+	if cast, ok := body.(*CastForm); ok {
+		if cast.to_c.IsSelf {
+			return cast.continuation_c.Ident
+		}
+		return cast.to_c.Ident
+	}
+
+	return ""
+}
+
+func insertedNew(newName Name, body, continuation Form) *NewForm {
+	p := NewNew(newName, body, continuation)
+	p.insertedType = types.CopyType(newName.Type)
+	return p
+}
+
+func insertedShift(continuation, from Name, continuation_e Form) *ShiftForm {
+	p := NewShift(continuation, from, continuation_e)
+	p.inserted = true
+	return p
+}
+
+// s : A <- new body; cast self<s>
+func insertedCast(s Name, body Form) *NewForm {
+	cast := NewCast(Name{IsSelf: true}, Name{Ident: s.Ident})
+	cast.inserted = true
+	return insertedNew(s, body, cast)
+}
@@ -0,0 +1,393 @@
+package process
+
+import (
+	"fmt"
+	"grits/types"
+	"sort"
+	"strings"
+)
+
+// Program synthesis: given a hole (?), search for the forms which may fill it. The search follows the typing rules
+// backwards, starting from the type expected from the provider and the names available at the hole, and is bounded
+// by the number of steps (i.e. the depth of the forms). Smaller forms are found first (iterative deepening).
+//
+// The forms found are well typed as far as the search can tell. They still need to be checked (e.g. recursive calls
+// may not be terminating), so they are meant to be verified by the typechecker before being suggested.
+
+// Bounds the number of forms visited by the search of a single hole, so that it gives up rather than hangs
+const synthesisBudget = 200000
+
+type synthesizer struct {
+	labelledTypesEnv types.LabelledTypesEnv
+	sigma            FunctionTypesEnv
+	// Names which are already taken (e.g. the names at the hole), so fresh names avoid them
+	reserved map[string]bool
+	visited  int
+}
+
+type synthesisStep func(depth, next int, yield func(Form) bool) bool
+
+// SynthesizeHole passes the forms which fill the hole to yield, in order of increasing depth (up to maxDepth), until
+// yield returns false or the search is exhausted
+func SynthesizeHole(hole Hole, maxDepth int, yield func(Form) bool) {
+	s := &synthesizer{labelledTypesEnv: hole.labelledTypesEnv, sigma: hole.sigma, reserved: make(map[string]bool)}
+
+	var gamma []Name
+	for ident, nameType := range hole.gamma {
+		s.reserved[ident] = true
+		if nameType.Type != nil {
+			gamma = append(gamma, Name{Ident: ident, Type: nameType.Type})
+		}
+	}
+	sort.Slice(gamma, func(i, j int) bool { return gamma[i].Ident < gamma[j].Ident })
+	s.reserved[hole.Provider] = true
+
+	// Forms found at a smaller depth are found again at each larger depth, so they are only passed on once
+	seen := make(map[string]bool)
+	for depth := 1; depth <= maxDepth; depth++ {
+		completed := s.search(gamma, hole.ProviderType, depth, 1, "", func(form Form) bool {
+			key := form.String()
+			if seen[key] {
+				return true
+			}
+			seen[key] = true
+			return yield(form)
+		})
+
+		if !completed {
+			return
+		}
+	}
+}
+
+// Passes each form of at most the given depth, providing the provider type using exactly the names in gamma.
+// Returns false once the search has to stop (i.e. yield returned false, or the budget ran out).
+//
+// Fresh names are numbered from next onwards. Since independent drops and waits may be done in any order, they are
+// only tried in alphabetical order (lastIdent is the name dropped or waited on by the previous step, if any).
+func (s *synthesizer) search(gamma []Name, providerType types.SessionType, depth, next int, lastIdent string, yield func(Form) bool) bool {
+	s.visited++
+	if depth <= 0 || s.visited > synthesisBudget {
+		return s.visited <= synthesisBudget
+	}
+
+	self := Name{IsSelf: true}
+	provider := types.Unfold(providerType, s.labelledTypesEnv)
+
+	// Rules which end the process
+	switch t := provider.(type) {
+	case *types.UnitType:
+		if len(gamma) == 0 && !yield(NewClose(self)) {
+			return false
+		}
+	case *types.SendType:
+		if len(gamma) == 2 {
+			for _, pair := range [][2]Name{{gamma[0], gamma[1]}, {gamma[1], gamma[0]}} {
+				if s.subtype(pair[0].Type, t.Left) && s.subtype(pair[1].Type, t.Right) && !yield(NewSend(self, plainName(pair[0]), plainName(pair[1]))) {
+					return false
+				}
+			}
+		}
+	case *types.SelectLabelType:
+		if len(gamma) == 1 {
+			for _, option := range t.Branches {
+				if s.subtype(gamma[0].Type, option.SessionType) && !yield(NewSelect(self, Label{L: option.Label}, plainName(gamma[0]))) {
+					return false
+				}
+			}
+		}
+	}
+
+	if len(gamma) == 1 && s.forwardable(gamma[0].Type, providerType) && !yield(NewForward(self, plainName(gamma[0]))) {
+		return false
+	}
+
+	for _, functionName := range s.functionNames() {
+		function := s.sigma[functionName]
+		if len(function.Parameters) != len(gamma) || !s.subtype(function.Type, providerType) {
+			continue
+		}
+
+		completed := s.assignParameters(function, gamma, func(arguments, _ []Name) bool {
+			return yield(NewCall(functionName, arguments))
+		})
+		if !completed {
+			return false
+		}
+	}
+
+	// Rules with a continuation (or with a body, in case of new)
+	for _, step := range s.continuationSteps(gamma, providerType, provider, lastIdent) {
+		if !step(depth-1, next, yield) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The rules which are followed by some other form, in the order in which they are tried: the invertible ones first,
+// followed by the ones which spawn new processes, and finally drop and split
+func (s *synthesizer) continuationSteps(gamma []Name, providerType, provider types.SessionType, lastIdent string) []synthesisStep {
+	var steps []synthesisStep
+	self := Name{IsSelf: true}
+
+	// Right rules
+	switch t := provider.(type) {
+	case *types.ReceiveType:
+		steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+			x, next := s.fresh(next)
+			y, next := s.fresh(next)
+			extended := withName(gamma, Name{Ident: x.Ident, Type: t.Left})
+			return s.search(extended, t.Right, depth, next, "", func(form Form) bool {
+				return yield(NewReceive(x, y, self, form))
+			})
+		})
+	case *types.BranchCaseType:
+		steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+			x, next := s.fresh(next)
+			return s.searchBranches(t.Branches, func(option types.Option, yieldBranch func(Form) bool) bool {
+				return s.search(gamma, option.SessionType, depth, next, "", yieldBranch)
+			}, func(branches []Form) bool {
+				return yield(NewCase(self, makeBranches(t.Branches, x, branches)))
+			})
+		})
+	}
+
+	// Left rules which are invertible
+	for i, client := range gamma {
+		rest := withoutName(gamma, i)
+		client := client
+
+		switch t := types.Unfold(client.Type, s.labelledTypesEnv).(type) {
+		case *types.SendType:
+			steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+				y, next := s.fresh(next)
+				z, next := s.fresh(next)
+				extended := withName(withName(rest, Name{Ident: y.Ident, Type: t.Left}), Name{Ident: z.Ident, Type: t.Right})
+				return s.search(extended, providerType, depth, next, "", func(form Form) bool {
+					return yield(NewReceive(y, z, plainName(client), form))
+				})
+			})
+		case *types.SelectLabelType:
+			steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+				y, next := s.fresh(next)
+				return s.searchBranches(t.Branches, func(option types.Option, yieldBranch func(Form) bool) bool {
+					return s.search(withName(rest, Name{Ident: y.Ident, Type: option.SessionType}), providerType, depth, next, "", yieldBranch)
+				}, func(branches []Form) bool {
+					return yield(NewCase(plainName(client), makeBranches(t.Branches, y, branches)))
+				})
+			})
+		case *types.UnitType:
+			if client.Ident > lastIdent {
+				steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+					return s.search(rest, providerType, depth, next, client.Ident, func(form Form) bool {
+						return yield(NewWait(plainName(client), form))
+					})
+				})
+			}
+		}
+	}
+
+	// Left rules which spawn a new process: sending to (or selecting from) a client, and function calls
+	for i, client := range gamma {
+		rest := withoutName(gamma, i)
+		client := client
+
+		switch t := types.Unfold(client.Type, s.labelledTypesEnv).(type) {
+		case *types.ReceiveType:
+			for j, payload := range rest {
+				if !s.subtype(payload.Type, t.Left) {
+					continue
+				}
+
+				remaining := withoutName(rest, j)
+				payload := payload
+				steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+					z, next := s.fresh(next)
+					z.Type = sourceType(t.Right)
+					return s.search(withName(remaining, Name{Ident: z.Ident, Type: t.Right}), providerType, depth, next, "", func(form Form) bool {
+						return yield(NewNew(z, NewSend(plainName(client), plainName(payload), self), form))
+					})
+				})
+			}
+		case *types.BranchCaseType:
+			for _, option := range t.Branches {
+				option := option
+				steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+					z, next := s.fresh(next)
+					z.Type = sourceType(option.SessionType)
+					return s.search(withName(rest, Name{Ident: z.Ident, Type: option.SessionType}), providerType, depth, next, "", func(form Form) bool {
+						return yield(NewNew(z, NewSelect(plainName(client), Label{L: option.Label}, self), form))
+					})
+				})
+			}
+		}
+	}
+
+	for _, functionName := range s.functionNames() {
+		function := s.sigma[functionName]
+		functionName := functionName
+		if len(function.Parameters) > len(gamma) {
+			continue
+		}
+
+		steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+			return s.assignParameters(function, gamma, func(arguments, remaining []Name) bool {
+				z, next := s.fresh(next)
+				return s.search(withName(remaining, Name{Ident: z.Ident, Type: function.Type}), providerType, depth, next, "", func(form Form) bool {
+					return yield(NewNew(z, NewCall(functionName, arguments), form))
+				})
+			})
+		})
+	}
+
+	// Selecting a label on self, whose payload is provided by a new process using the names in gamma
+	if t, ok := provider.(*types.SelectLabelType); ok {
+		for _, option := range t.Branches {
+			option := option
+			steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+				z, next := s.fresh(next)
+				z.Type = sourceType(option.SessionType)
+				return s.search(gamma, option.SessionType, depth, next, "", func(body Form) bool {
+					return yield(NewNew(z, body, NewSelect(self, Label{L: option.Label}, plainName(z))))
+				})
+			})
+		}
+	}
+
+	// Structural rules
+	for i, client := range gamma {
+		rest := withoutName(gamma, i)
+		client := client
+
+		if types.IsWeakenable(client.Type) && client.Ident > lastIdent {
+			steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+				return s.search(rest, providerType, depth, next, client.Ident, func(form Form) bool {
+					return yield(NewDrop(plainName(client), form))
+				})
+			})
+		}
+	}
+
+	for i, client := range gamma {
+		rest := withoutName(gamma, i)
+		client := client
+
+		if types.IsContractable(client.Type) {
+			steps = append(steps, func(depth, next int, yield func(Form) bool) bool {
+				x1, next := s.fresh(next)
+				x2, next := s.fresh(next)
+				extended := withName(withName(rest, Name{Ident: x1.Ident, Type: client.Type}), Name{Ident: x2.Ident, Type: client.Type})
+				return s.search(extended, providerType, depth, next, "", func(form Form) bool {
+					return yield(NewSplit(x1, x2, plainName(client), form))
+				})
+			})
+		}
+	}
+
+	return steps
+}
+
+// Combines the forms found for each branch (each branch is searched separately, given its option)
+func (s *synthesizer) searchBranches(options []types.Option, searchBranch func(types.Option, func(Form) bool) bool, yield func([]Form) bool) bool {
+	var combine func(i int, found []Form) bool
+	combine = func(i int, found []Form) bool {
+		if i == len(options) {
+			return yield(append([]Form(nil), found...))
+		}
+
+		return searchBranch(options[i], func(form Form) bool {
+			return combine(i+1, append(found, form))
+		})
+	}
+
+	return combine(0, nil)
+}
+
+// Passes each way of choosing distinct names from gamma as the arguments of the function, along with the names left
+func (s *synthesizer) assignParameters(function FunctionType, gamma []Name, yield func(arguments, remaining []Name) bool) bool {
+	var assign func(i int, arguments, remaining []Name) bool
+	assign = func(i int, arguments, remaining []Name) bool {
+		if i == len(function.Parameters) {
+			return yield(append([]Name(nil), arguments...), remaining)
+		}
+
+		for j, name := range remaining {
+			if s.subtype(name.Type, function.Parameters[i].Type) {
+				if !assign(i+1, append(arguments, plainName(name)), withoutName(remaining, j)) {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	return assign(0, nil, gamma)
+}
+
+func (s *synthesizer) subtype(found, expected types.SessionType) bool {
+	return found != nil && expected != nil && types.ConsistentSubtype(found, expected, s.labelledTypesEnv)
+}
+
+// Same conditions as the ID/FWD rule
+func (s *synthesizer) forwardable(found, expected types.SessionType) bool {
+	if !s.subtype(found, expected) {
+		return false
+	}
+
+	found = types.Unfold(found, s.labelledTypesEnv)
+	expected = types.Unfold(expected, s.labelledTypesEnv)
+	return types.IsDynamic(found) || types.IsDynamic(expected) || found.Polarity() == expected.Polarity()
+}
+
+func (s *synthesizer) functionNames() []string {
+	names := make([]string, 0, len(s.sigma))
+	for name := range s.sigma {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Fresh names are x1, x2, ... (skipping those which are already taken)
+func (s *synthesizer) fresh(next int) (Name, int) {
+	for s.reserved[fmt.Sprintf("x%d", next)] {
+		next++
+	}
+
+	return Name{Ident: fmt.Sprintf("x%d", next)}, next + 1
+}
+
+// Names in gamma carry their types, which are not part of the forms
+func plainName(name Name) Name {
+	return Name{Ident: name.Ident}
+}
+
+func withName(gamma []Name, name Name) []Name {
+	return append(append([]Name(nil), gamma...), name)
+}
+
+func withoutName(gamma []Name, i int) []Name {
+	result := append([]Name(nil), gamma[:i]...)
+	return append(result, gamma[i+1:]...)
+}
+
+func makeBranches(options []types.Option, payload Name, forms []Form) []*BranchForm {
+	branches := make([]*BranchForm, len(options))
+	for i, option := range options {
+		branches[i] = NewBranch(Label{L: option.Label}, payload, forms[i])
+	}
+	return branches
+}
+
+// Prints a synthesized form as it would be written in the source
+func FormatSynthesized(form Form, column int) string {
+	lines := FormatForm(form, column)
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Text
+	}
+	return strings.Join(texts, "\n")
+}
@@ -0,0 +1,340 @@
+package process
+
+import (
+	"fmt"
+	"grits/types"
+	"sort"
+	"strings"
+)
+
+// An optional analysis (enabled by GlobalEnvironment.CheckTermination) to find out whether recursive functions
+// eventually finish, or at least keep producing output. Each function is reported as:
+// -> terminating   every recursive call is a call to the function itself on a smaller name, obtained by receiving
+//                  from (or casing on) the same parameter, e.g. case x (succ<x'> => ... double(x') ...). The type of
+//                  the parameter needs to have finite values (see types.ClassifyTypeDefinitions), otherwise it can
+//                  keep getting smaller forever, e.g. type bits = +{b0 : bits, b1 : bits}
+// -> productive    every recursive call is guarded, i.e. it happens after sending on self (or after receiving on self)
+// -> unknown       neither holds, so a warning is reported
+// The functions called by a terminating (or productive) function need to be terminating (or productive) as well.
+
+type TerminationStatus int
+
+const (
+	TERMINATING TerminationStatus = iota
+	PRODUCTIVE
+	UNKNOWN_TERMINATION
+)
+
+var TerminationStatusMap = map[TerminationStatus]string{
+	TERMINATING:         "terminating",
+	PRODUCTIVE:          "productive",
+	UNKNOWN_TERMINATION: "unknown",
+}
+
+type TerminationResult struct {
+	Function string
+	Status   TerminationStatus
+	// Explains why the function was classified as such, e.g. "structural recursion on x"
+	Reason string
+}
+
+func (r TerminationResult) String() string {
+	return fmt.Sprintf("%s: %s (%s)", r.Function, TerminationStatusMap[r.Status], r.Reason)
+}
+
+// Runs the analysis on all function definitions, storing the results in globalEnv.TerminationResults
+func checkTermination(globalEnv *GlobalEnvironment) {
+	functions := *globalEnv.FunctionDefinitions
+	callGraph := make(map[string][]string)
+	for _, f := range functions {
+		for _, form := range AllForms(f.Body) {
+			if call, ok := form.(*CallForm); ok {
+				callGraph[f.FunctionName] = append(callGraph[f.FunctionName], call.functionName)
+			}
+		}
+	}
+
+	labelledTypesEnv := types.ProduceLabelledSessionTypeEnvironment(*globalEnv.Types)
+	inhabitation := types.ClassifyTypeDefinitions(labelledTypesEnv)
+
+	results := make(map[string]*TerminationResult)
+	for _, f := range functions {
+		results[f.FunctionName] = analyseFunction(f, callGraph, inhabitation, labelledTypesEnv)
+	}
+
+	// A function calling an unknown function is unknown as well, and one calling a productive function is at most
+	// productive. Repeat until nothing changes, since such calls may be chained. This includes the functions calling
+	// each other (i.e. in the same strongly connected component of the call graph), which all end up with the weakest
+	// status among them.
+	for changed := true; changed; {
+		changed = false
+		for _, f := range functions {
+			result := results[f.FunctionName]
+			for _, callee := range callGraph[f.FunctionName] {
+				calleeResult, exists := results[callee]
+				if !exists || calleeResult.Status <= result.Status {
+					continue
+				}
+
+				result.Status = calleeResult.Status
+				if calleeResult.Status == UNKNOWN_TERMINATION {
+					result.Reason = fmt.Sprintf("calls %s, whose termination is unknown", callee)
+				} else {
+					result.Reason = fmt.Sprintf("calls %s, which is %s", callee, TerminationStatusMap[calleeResult.Status])
+				}
+				changed = true
+			}
+		}
+	}
+
+	globalEnv.TerminationResults = nil
+	for _, f := range functions {
+		result := results[f.FunctionName]
+		globalEnv.TerminationResults = append(globalEnv.TerminationResults, *result)
+
+		if result.Status == UNKNOWN_TERMINATION {
+			globalEnv.warnf(NON_TERMINATION, f.Position, "function '%s' might neither terminate nor be productive: %s", f.FunctionName, result.Reason)
+		}
+	}
+}
+
+// A call from f to g is recursive if g (eventually) calls f back
+func reaches(from, to string, callGraph map[string][]string) bool {
+	visited := make(map[string]bool)
+
+	var visit func(current string) bool
+	visit = func(current string) bool {
+		if current == to {
+			return true
+		}
+		if visited[current] {
+			return false
+		}
+		visited[current] = true
+
+		for _, callee := range callGraph[current] {
+			if visit(callee) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return visit(from)
+}
+
+// Size of a name, relative to the parameters of the function being analysed
+type nameSize struct {
+	// Index of the parameter from which the name is obtained
+	parameter int
+	// Set once the name is obtained by deconstructing the parameter
+	smaller bool
+}
+
+// Information about a recursive call found in the body
+type recursiveCall struct {
+	call *CallForm
+	// Parameters for which the call passes a smaller name
+	decreasing map[int]bool
+	guarded    bool
+}
+
+func analyseFunction(f FunctionDefinition, callGraph map[string][]string, inhabitation map[string]types.Inhabitation, labelledTypesEnv types.LabelledTypesEnv) *TerminationResult {
+	sizes := make(map[string]nameSize)
+	for i, param := range f.Parameters {
+		sizes[param.Ident] = nameSize{parameter: i}
+	}
+
+	var calls []recursiveCall
+	collectRecursiveCalls(f.Body, f, sizes, true, false, callGraph, &calls)
+
+	if len(calls) == 0 {
+		return &TerminationResult{Function: f.FunctionName, Status: TERMINATING, Reason: "no recursive calls"}
+	}
+
+	// Structural recursion: some parameter gets smaller in every recursive call, and it cannot do so forever
+	var decreasingParameters, infiniteParameters []int
+	for i, param := range f.Parameters {
+		decreasing := true
+		for _, c := range calls {
+			decreasing = decreasing && c.decreasing[i]
+		}
+
+		switch {
+		case !decreasing:
+		case param.Type != nil && types.IsFinitelyInhabited(param.Type, inhabitation, labelledTypesEnv):
+			decreasingParameters = append(decreasingParameters, i)
+		default:
+			infiniteParameters = append(infiniteParameters, i)
+		}
+	}
+
+	if len(decreasingParameters) > 0 {
+		return &TerminationResult{Function: f.FunctionName, Status: TERMINATING, Reason: fmt.Sprintf("structural recursion on %s", f.Parameters[decreasingParameters[0]].Ident)}
+	}
+
+	var unguarded, unguardedMutual []string
+	for _, c := range calls {
+		if c.guarded {
+			continue
+		}
+		if c.call.functionName == f.FunctionName {
+			unguarded = append(unguarded, c.call.String())
+		} else {
+			unguardedMutual = append(unguardedMutual, c.call.String())
+		}
+	}
+
+	if len(unguarded) == 0 && len(unguardedMutual) == 0 {
+		return &TerminationResult{Function: f.FunctionName, Status: PRODUCTIVE, Reason: "guarded recursion"}
+	}
+
+	if len(unguardedMutual) > 0 {
+		sort.Strings(unguardedMutual)
+		return &TerminationResult{Function: f.FunctionName, Status: UNKNOWN_TERMINATION, Reason: fmt.Sprintf("the mutually recursive call %s is not guarded by a send on self", strings.Join(unguardedMutual, ", "))}
+	}
+
+	sort.Strings(unguarded)
+	if len(infiniteParameters) > 0 {
+		param := f.Parameters[infiniteParameters[0]]
+		return &TerminationResult{Function: f.FunctionName, Status: UNKNOWN_TERMINATION, Reason: fmt.Sprintf("structural recursion on %s, but its type '%s' has no finite values, so it can keep getting smaller forever", param.Ident, param.Type.String())}
+	}
+
+	return &TerminationResult{Function: f.FunctionName, Status: UNKNOWN_TERMINATION, Reason: fmt.Sprintf("the recursive call %s is neither on a smaller argument nor guarded by a send on self", strings.Join(unguarded, ", "))}
+}
+
+// Walks through the body, keeping track of the names that are smaller than the parameters.
+// A recursive call is guarded if the main thread has already received on self (e.g. case self (...)), or if it is
+// spawned (using new) while the main thread goes on to send on self.
+func collectRecursiveCalls(form Form, f FunctionDefinition, sizes map[string]nameSize, mainThread, guarded bool, callGraph map[string][]string, calls *[]recursiveCall) {
+	switch p := form.(type) {
+	case *CallForm:
+		if !reaches(p.functionName, f.FunctionName, callGraph) {
+			return
+		}
+
+		parameters := p.parameters
+		if len(parameters) == len(f.Parameters)+1 {
+			// The first parameter refers to the explicit provider
+			parameters = parameters[1:]
+		}
+
+		// The positions of the parameters only correspond on calls to the function itself, so a call to another
+		// function calling it back is never considered to be on a smaller argument
+		decreasing := make(map[int]bool)
+		if p.functionName == f.FunctionName {
+			for i, param := range parameters {
+				if size, ok := sizes[param.Ident]; ok && !param.IsSelf && size.smaller && size.parameter == i {
+					decreasing[i] = true
+				}
+			}
+		}
+
+		*calls = append(*calls, recursiveCall{call: p, decreasing: decreasing, guarded: guarded})
+	case *ReceiveForm:
+		inner := copySizes(sizes)
+		interacts := mainThread && p.from_c.IsSelf
+		deconstruct(inner, p.from_c, p.payload_c, p.continuation_c)
+		collectRecursiveCalls(p.continuation_e, f, inner, mainThread, guarded || interacts, callGraph, calls)
+	case *CaseForm:
+		interacts := mainThread && p.from_c.IsSelf
+		for _, branch := range p.branches {
+			inner := copySizes(sizes)
+			deconstruct(inner, p.from_c, branch.payload_c)
+			collectRecursiveCalls(branch.continuation_e, f, inner, mainThread, guarded || interacts, callGraph, calls)
+		}
+	case *NewForm:
+		// The new name is fresh, so it is not related to any parameter
+		inner := copySizes(sizes)
+		delete(inner, p.new_name_c.Ident)
+		collectRecursiveCalls(p.body, f, inner, false, guarded || (mainThread && sendsOnSelf(p.continuation_e)), callGraph, calls)
+		collectRecursiveCalls(p.continuation_e, f, inner, mainThread, guarded, callGraph, calls)
+	case *SplitForm:
+		inner := copySizes(sizes)
+		if size, ok := sizes[p.from_c.Ident]; ok {
+			inner[p.channel_one.Ident] = size
+			inner[p.channel_two.Ident] = size
+		}
+		collectRecursiveCalls(p.continuation_e, f, inner, mainThread, guarded, callGraph, calls)
+	case *ShiftForm:
+		inner := copySizes(sizes)
+		if size, ok := sizes[p.from_c.Ident]; ok {
+			inner[p.continuation_c.Ident] = size
+		}
+		collectRecursiveCalls(p.continuation_e, f, inner, mainThread, guarded, callGraph, calls)
+	case *WaitForm:
+		collectRecursiveCalls(p.continuation_e, f, sizes, mainThread, guarded, callGraph, calls)
+	case *DropForm:
+		collectRecursiveCalls(p.continuation_e, f, sizes, mainThread, guarded, callGraph, calls)
+	case *PrintForm:
+		collectRecursiveCalls(p.continuation_e, f, sizes, mainThread, guarded, callGraph, calls)
+	case *PrintValueForm:
+		collectRecursiveCalls(p.continuation_e, f, sizes, mainThread, guarded, callGraph, calls)
+	case *LetForm:
+		collectRecursiveCalls(p.continuation_e, f, sizes, mainThread, guarded, callGraph, calls)
+	}
+}
+
+// The names obtained from a parameter (or a smaller name) are smaller than the parameter itself
+func deconstruct(sizes map[string]nameSize, from Name, obtained ...Name) {
+	size, ok := sizes[from.Ident]
+	for _, name := range obtained {
+		if ok && !from.IsSelf {
+			sizes[name.Ident] = nameSize{parameter: size.parameter, smaller: true}
+		} else {
+			delete(sizes, name.Ident)
+		}
+	}
+}
+
+func copySizes(sizes map[string]nameSize) map[string]nameSize {
+	result := make(map[string]nameSize, len(sizes))
+	for k, v := range sizes {
+		result[k] = v
+	}
+	return result
+}
+
+// Whether the main thread (i.e. ignoring spawned processes) eventually sends on self, in each of its branches
+func sendsOnSelf(form Form) bool {
+	switch p := form.(type) {
+	case *SendForm:
+		return p.to_c.IsSelf
+	case *SelectForm:
+		return p.to_c.IsSelf
+	case *CloseForm:
+		return p.from_c.IsSelf
+	case *InputForm:
+		return p.from_c.IsSelf
+	case *ReceiveForm:
+		return p.from_c.IsSelf || sendsOnSelf(p.continuation_e)
+	case *CaseForm:
+		if p.from_c.IsSelf {
+			return true
+		}
+		for _, branch := range p.branches {
+			if !sendsOnSelf(branch.continuation_e) {
+				return false
+			}
+		}
+		return len(p.branches) > 0
+	case *NewForm:
+		return sendsOnSelf(p.continuation_e)
+	case *SplitForm:
+		return sendsOnSelf(p.continuation_e)
+	case *ShiftForm:
+		return sendsOnSelf(p.continuation_e)
+	case *WaitForm:
+		return sendsOnSelf(p.continuation_e)
+	case *DropForm:
+		return sendsOnSelf(p.continuation_e)
+	case *PrintForm:
+		return sendsOnSelf(p.continuation_e)
+	case *PrintValueForm:
+		return sendsOnSelf(p.continuation_e)
+	case *LetForm:
+		return sendsOnSelf(p.continuation_e)
+	}
+
+	return false
+}
@@ -25,6 +25,8 @@ func (process *Process) SpawnThenTransition(re *RuntimeEnvironment) {
 		re.monitor.MonitorNewProcess(process)
 	}
 
+	re.monitorSpawn(process)
+
 	go process.transitionLoop(re)
 }
 
@@ -47,6 +49,9 @@ func (process *Process) transitionLoop(re *RuntimeEnvironment) {
 //   (c) internally     -> transitions immediately without sending/receiving messages
 
 func TransitionBySending(process *Process, toChan chan Message, continuationFunc func(), sendingMessage Message, re *RuntimeEnvironment) {
+	if len(process.Providers) == 1 && !re.monitorSend(process, toChan, sendingMessage) {
+		return
+	}
 
 	if len(process.Providers) > 1 {
 		// Split process if needed
@@ -69,6 +74,10 @@ func TransitionByReceiving(process *Process, clientChan chan Message, processMes
 		re.error(process, "Channel not initialized (attempting to receive on a dead channel)")
 	}
 
+	if len(process.Providers) == 1 && !re.monitorReceive(process, clientChan) {
+		return
+	}
+
 	if len(process.Providers) > 1 {
 		// Split process if needed
 		process.performDUPrule(re)
@@ -78,6 +87,10 @@ func TransitionByReceiving(process *Process, clientChan chan Message, processMes
 			// Received cancellation request, then stop
 			return
 		case receivedMessage := <-clientChan:
+			if !re.monitorReceived(process, clientChan, receivedMessage) {
+				return
+			}
+
 			// Blocks until a message arrives (may be a FWD request)
 
 			// Process acting as a client by consuming a message from some channel
@@ -295,6 +308,10 @@ func (f *NewForm) Transition(process *Process, re *RuntimeEnvironment) {
 		// Although channels may have an ID, processes (i.e. goroutines) are anonymous
 		newChannelIdent := f.new_name_c.Ident
 		// newChannelIdent := ""
+		if f.onProvider && len(process.Providers) > 0 {
+			// The new process carries on as the provider (e.g. in send self<y>; P), so it is named after it
+			newChannelIdent = process.Providers[0].Ident
+		}
 
 		// First create fresh channel (with fake identity of the continuation_c name) to link both processes
 		newChannel := re.CreateFreshChannel(newChannelIdent)
@@ -340,6 +357,8 @@ func (f *CallForm) Transition(process *Process, re *RuntimeEnvironment) {
 			re.errorf(process, "Function %s does not exist.\n", f.String())
 		}
 
+		re.monitorCall(process, f, functionCall)
+
 		// Function found. Important to copy the body, to keep the original untouched
 		functionCallBody := CopyForm(functionCall.Body)
 
@@ -493,7 +512,7 @@ func (f *CaseForm) Transition(process *Process, re *RuntimeEnvironment) {
 			}
 
 			if !found {
-				re.errorf(process, "no matching labels found for %s\n", message.Label)
+				re.errorf(process, "no matching labels found for %s\n", message.Label.String())
 			}
 
 			process.finishedRule(BRA, "[case, provider]", "(p)", re)
@@ -520,7 +539,7 @@ func (f *CaseForm) Transition(process *Process, re *RuntimeEnvironment) {
 
 		selRule := func(message Message) {
 			re.logProcess(LOGRULE, process, "[case, client] starting SEL rule")
-			re.logProcessf(LOGRULEDETAILS, process, "[case, client] received select label %s on channel %s, containing rule: %s\n", message.Label, f.from_c.String(), RuleString[message.Rule])
+			re.logProcessf(LOGRULEDETAILS, process, "[case, client] received select label %s on channel %s, containing rule: %s\n", message.Label.String(), f.from_c.String(), RuleString[message.Rule])
 
 			if message.Rule != SEL {
 				re.error(process, "expected SEL")
@@ -540,7 +559,7 @@ func (f *CaseForm) Transition(process *Process, re *RuntimeEnvironment) {
 			}
 
 			if !found {
-				re.errorf(process, "no matching labels found for %s\n", message.Label)
+				re.errorf(process, "no matching labels found for %s\n", message.Label.String())
 			}
 
 			process.Body = new_body
@@ -635,6 +654,10 @@ func (f *ForwardForm) Transition(process *Process, re *RuntimeEnvironment) {
 	}
 
 	polarity := f.Polarity(re.Typechecked, re.GlobalEnvironment)
+	if polarity == types.UNKNOWN && re.Typechecked {
+		// Forwarding a name of type ?
+		polarity = re.monitoredPolarity(f.from_c)
+	}
 
 	if polarity == types.NEGATIVE && !f.to_drop {
 		// -ve
@@ -989,7 +1012,7 @@ func (f *PrintForm) Transition(process *Process, re *RuntimeEnvironment) {
 
 	printRule := func() {
 		if !re.Quiet {
-			fmt.Printf("> %s\n", f.label.String())
+			fmt.Fprintf(re.output(), "> %s\n", f.printed())
 		}
 
 		process.finishedRule(PRINT, "[print]", "", re)
@@ -1001,6 +1024,54 @@ func (f *PrintForm) Transition(process *Process, re *RuntimeEnvironment) {
 	TransitionInternally(process, printRule, re)
 }
 
+// The local definitions are lifted beforehand, so only the continuation is left to run
+func (f *LetForm) Transition(process *Process, re *RuntimeEnvironment) {
+	re.logProcessf(LOGRULEDETAILS, process, "transition of let: %s\n", f.StringShort())
+
+	process.Body = f.continuation_e
+	process.transitionLoop(re)
+}
+
+// Print value: receives the next part of the value (printing it once received)
+func (f *PrintValueForm) Transition(process *Process, re *RuntimeEnvironment) {
+	re.logProcessf(LOGRULEDETAILS, process, "transition of print value: %s\n", f.StringShort())
+
+	printValueRule := func() {
+		next, err := f.nextStep(re)
+		if err != nil {
+			re.errorf(process, "%s\n", err)
+			return
+		}
+
+		process.Body = next
+		process.transitionLoop(re)
+	}
+
+	TransitionInternally(process, printValueRule, re)
+}
+
+// INPUT rule: reads a label from the input and selects it on self (or closes self)
+func (f *InputForm) Transition(process *Process, re *RuntimeEnvironment) {
+	re.logProcessf(LOGRULEDETAILS, process, "transition of input: %s\n", f.String())
+
+	inputRule := func() {
+		next, err := f.nextStep(re)
+		if err != nil {
+			re.errorf(process, "%s\n", err)
+			return
+		}
+
+		process.Body = next
+		process.transitionLoop(re)
+	}
+
+	TransitionInternally(process, inputRule, re)
+}
+
+func (f *HoleForm) Transition(process *Process, re *RuntimeEnvironment) {
+	re.errorf(process, "reached a hole (?) at line %d, which has not been filled in\n", f.Position.StartLine)
+}
+
 // To keep the log/monitor update with the currently running processes and the transition rules
 // being performed, there are the following functions:
 //
@@ -31,6 +31,8 @@ func (process *Process) SpawnThenTransitionNP(re *RuntimeEnvironment) {
 		re.monitor.MonitorNewProcess(process)
 	}
 
+	re.monitorSpawn(process)
+
 	go process.transitionLoopNP(re)
 }
 
@@ -56,6 +58,9 @@ func (process *Process) transitionLoopNP(re *RuntimeEnvironment) {
 // A process' control channel is checked for incoming messages. If there are any, the execution of (a-d) may be relegated for later on.
 
 func TransitionBySendingNP(process *Process, toChan chan Message, continuationFunc func(), sendingMessage Message, re *RuntimeEnvironment) {
+	if len(process.Providers) == 1 && !re.monitorSend(process, toChan, sendingMessage) {
+		return
+	}
 
 	if len(process.Providers) > 1 {
 		// Split process if needed
@@ -79,6 +84,10 @@ func TransitionByReceivingNP(process *Process, clientChan chan Message, processM
 		re.error(process, "Channel not initialized (attempting to receive on a dead channel)")
 	}
 
+	if len(process.Providers) == 1 && !re.monitorReceive(process, clientChan) {
+		return
+	}
+
 	if len(process.Providers) > 1 {
 		// Split process if needed
 		process.performDUPruleNP(re)
@@ -90,6 +99,10 @@ func TransitionByReceivingNP(process *Process, clientChan chan Message, processM
 		case cm := <-process.Providers[0].ControlChannel:
 			handleControlMessageNP(process, cm, re)
 		case receivedMessage := <-clientChan:
+			if !re.monitorReceived(process, clientChan, receivedMessage) {
+				return
+			}
+
 			// Acting as a client by consuming a message from some channel
 			processMessageFunc(receivedMessage)
 		}
@@ -267,6 +280,10 @@ func (f *NewForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
 		// Although channels may have an ID, processes (i.e. goroutines) are anonymous
 		newChannelIdent := f.new_name_c.Ident
 		// newChannelIdent := ""
+		if f.onProvider && len(process.Providers) > 0 {
+			// The new process carries on as the provider (e.g. in send self<y>; P), so it is named after it
+			newChannelIdent = process.Providers[0].Ident
+		}
 
 		// First create fresh channel (with fake identity of the continuation_c name) to link both processes
 		newChannel := re.CreateFreshChannel(newChannelIdent)
@@ -368,7 +385,7 @@ func (f *CaseForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
 			}
 
 			if !found {
-				re.errorf(process, "no matching labels found for %s\n", message.Label)
+				re.errorf(process, "no matching labels found for %s\n", message.Label.String())
 			}
 
 			process.finishedRule(BRA, "[case, provider]", "(p)", re)
@@ -389,7 +406,7 @@ func (f *CaseForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
 
 		selRule := func(message Message) {
 			re.logProcess(LOGRULE, process, "[case, client] starting SEL rule")
-			re.logProcessf(LOGRULEDETAILS, process, "[case, client] received select label %s on channel %s, containing rule: %s\n", message.Label, f.from_c.String(), RuleString[message.Rule])
+			re.logProcessf(LOGRULEDETAILS, process, "[case, client] received select label %s on channel %s, containing rule: %s\n", message.Label.String(), f.from_c.String(), RuleString[message.Rule])
 
 			if message.Rule != SEL {
 				re.errorf(process, "expected SEL got %s", RuleString[message.Rule])
@@ -409,7 +426,7 @@ func (f *CaseForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
 			}
 
 			if !found {
-				re.errorf(process, "no matching labels found for %s\n", message.Label)
+				re.errorf(process, "no matching labels found for %s\n", message.Label.String())
 			}
 
 			process.Body = new_body
@@ -435,6 +452,8 @@ func (f *CallForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
 			re.errorf(process, "Function %s does not exist.\n", f.String())
 		}
 
+		re.monitorCall(process, f, functionCall)
+
 		// Function found. Important to copy the body, to keep the original untouched
 		functionCallBody := CopyForm(functionCall.Body)
 
@@ -794,7 +813,7 @@ func (f *PrintForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
 
 	printRule := func() {
 		if !re.Quiet {
-			fmt.Printf("> %s\n", f.label.String())
+			fmt.Fprintf(re.output(), "> %s\n", f.printed())
 		}
 		process.finishedRule(PRINT, "[print]", "", re)
 
@@ -805,6 +824,53 @@ func (f *PrintForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
 	TransitionInternallyNP(process, printRule, re)
 }
 
+func (f *LetForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
+	re.logProcessf(LOGRULEDETAILS, process, "transition of let: %s\n", f.StringShort())
+
+	process.Body = f.continuation_e
+	process.transitionLoopNP(re)
+}
+
+// Print value: receives the next part of the value (printing it once received)
+func (f *PrintValueForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
+	re.logProcessf(LOGRULEDETAILS, process, "transition of print value: %s\n", f.StringShort())
+
+	printValueRule := func() {
+		next, err := f.nextStep(re)
+		if err != nil {
+			re.errorf(process, "%s\n", err)
+			return
+		}
+
+		process.Body = next
+		process.transitionLoopNP(re)
+	}
+
+	TransitionInternallyNP(process, printValueRule, re)
+}
+
+// INPUT rule: reads a label from the input and selects it on self (or closes self)
+func (f *InputForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
+	re.logProcessf(LOGRULEDETAILS, process, "transition of input: %s\n", f.String())
+
+	inputRule := func() {
+		next, err := f.nextStep(re)
+		if err != nil {
+			re.errorf(process, "%s\n", err)
+			return
+		}
+
+		process.Body = next
+		process.transitionLoopNP(re)
+	}
+
+	TransitionInternallyNP(process, inputRule, re)
+}
+
+func (f *HoleForm) TransitionNP(process *Process, re *RuntimeEnvironment) {
+	re.errorf(process, "reached a hole (?) at line %d, which has not been filled in\n", f.Position.StartLine)
+}
+
 // // To keep the log/monitor update with the currently running processes and the transition rules
 // // being performed, there are the following functions:
 // //
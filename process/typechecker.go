@@ -3,13 +3,18 @@ package process
 import (
 	"bytes"
 	"fmt"
+	"grits/position"
 	"grits/types"
+	"sort"
 )
 
 // Entry point to typecheck programs
 func Typecheck(processes []*Process, assumedFreeNames []Name, globalEnv *GlobalEnvironment) error {
 	errorChan := make(chan error)
 	doneChan := make(chan bool)
+	globalEnv.Warnings = nil
+	globalEnv.Holes = nil
+	globalEnv.implicitDrops = nil
 
 	globalEnv.log(LOGINFO, "Initiating typechecking")
 
@@ -21,37 +26,81 @@ func Typecheck(processes []*Process, assumedFreeNames []Name, globalEnv *GlobalE
 	case err := <-errorChan:
 		return err
 	case <-doneChan:
-		globalEnv.log(LOGINFO, "Typecheck successful")
 	}
 
+	// Warnings are listed in the order they appear in the source
+	sort.SliceStable(globalEnv.Warnings, func(i, j int) bool {
+		return globalEnv.Warnings[i].Position.StartLine < globalEnv.Warnings[j].Position.StartLine
+	})
+
+	if err := globalEnv.warningsAsError(); err != nil {
+		return err
+	}
+
+	globalEnv.log(LOGINFO, "Typecheck successful")
+
 	return nil
 }
 
+// Only the first error is reported, after which typechecking stops (so the goroutine is never left blocked)
 func typecheckFunctionsAndProcesses(processes []*Process, assumedFreeNames []Name, globalEnv *GlobalEnvironment, errorChan chan error, doneChan chan bool) {
+	// Unexpected panics are reported as errors, since they would otherwise stop the whole program (e.g. the language
+	// server), rather than just the typechecking
 	defer func() {
-		// No error found, notify parent
-		doneChan <- true
+		if r := recover(); r != nil {
+			errorChan <- fmt.Errorf("internal error while typechecking: %v", r)
+		}
 	}()
 
 	assignTypesToProcessProviders(processes)
 
+	// Names used more than once are split explicitly, before anything else looks at the bodies
+	elaborateSplits(processes, globalEnv)
+
 	// Start with some preliminary check on the labelled types
 	if err := preliminaryTypesDefinitionsChecks(globalEnv); err != nil {
 		errorChan <- err
+		return
 	}
 
+	// Fill in the missing annotations before they are needed by the rest of the checks
+	if globalEnv.InferTypes {
+		if err := inferTypes(processes, assumedFreeNames, globalEnv); err != nil {
+			errorChan <- err
+			return
+		}
+	}
+
+	warnUnfinishedTypes(globalEnv)
+
 	// Check that function definitions are well formed
 	if err := preliminaryFunctionDefinitionsChecks(globalEnv); err != nil {
 		errorChan <- err
+		return
 	}
 
 	// Check that processes are well formed
 	if err := preliminaryProcessesChecks(processes, assumedFreeNames, globalEnv); err != nil {
 		errorChan <- err
+		return
+	}
+
+	// Processes referring to each other in a cycle would wait on each other forever
+	if err := checkDeadlockFreedom(processes); err != nil {
+		errorChan <- err
+		return
 	}
 
 	globalEnv.log(LOGRULEDETAILS, "Preliminary checks ok")
 
+	// Move between modes where the declared types require it
+	if globalEnv.InsertShifts {
+		insertShifts(processes, assumedFreeNames, globalEnv)
+	}
+
+	// Look for unused definitions while the types are still as written by the user
+	warnUnusedDefinitions(processes, assumedFreeNames, globalEnv)
+
 	// At this point, we can assume that all names and functions have a type and such type is well formed
 
 	// So, we can initiate the more heavyweight typechecking on the function's and processes' bodies
@@ -59,16 +108,28 @@ func typecheckFunctionsAndProcesses(processes []*Process, assumedFreeNames []Nam
 	// Typecheck function definitions
 	if err := typecheckFunctionDefinitions(globalEnv); err != nil {
 		errorChan <- err
+		return
 	}
 
 	globalEnv.log(LOGRULEDETAILS, "Function declarations typecheck ok")
 
+	if globalEnv.CheckTermination {
+		checkTermination(globalEnv)
+	}
+
 	// Typecheck process definitions
 	if err := typecheckProcesses(processes, assumedFreeNames, globalEnv); err != nil {
 		errorChan <- err
+		return
 	}
 
 	globalEnv.log(LOGRULEDETAILS, "Process declarations typecheck ok")
+
+	// The names left behind by the processes are dropped explicitly
+	elaborateDrops(processes, globalEnv)
+
+	// No error found, notify parent
+	doneChan <- true
 }
 
 // Sets a common type to all provider names
@@ -99,7 +160,8 @@ func preliminaryFunctionDefinitionsChecks(globalEnv *GlobalEnvironment) error {
 
 	// Analyse the function declarations types (i.e. from 'let f(x : B) : A = ...', check types A & B)
 	unique := make(map[string]bool)
-	for _, f := range *globalEnv.FunctionDefinitions {
+	for i := range *globalEnv.FunctionDefinitions {
+		f := &(*globalEnv.FunctionDefinitions)[i]
 
 		// Check for duplicate function names
 		exists := unique[f.FunctionName]
@@ -109,16 +171,16 @@ func preliminaryFunctionDefinitionsChecks(globalEnv *GlobalEnvironment) error {
 		unique[f.FunctionName] = true
 
 		// Check type of provider
-		if f.Type != nil {
+		if f.Type != nil || globalEnv.dynamicIfMissing(&f.Type) {
 			typesToCheck = append(typesToCheck, f.Type)
 		} else {
 			return fmt.Errorf("(%s) function %s has a missing type of provider", f.Position.String(), f.String())
 		}
 
-		// Check parameters
-		for _, p := range f.Parameters {
-			if p.Type != nil {
-				typesToCheck = append(typesToCheck, p.Type)
+		// Check parameters (the types of the names captured by a local function are set when checking its let block)
+		for j, p := range f.WrittenParameters() {
+			if p.Type != nil || globalEnv.dynamicIfMissing(&f.Parameters[j].Type) {
+				typesToCheck = append(typesToCheck, f.Parameters[j].Type)
 			} else {
 				return fmt.Errorf("(%s) in function definition %s, parameter %s has a missing type", f.Position.String(), f.String(), p.String())
 			}
@@ -142,7 +204,7 @@ func preliminaryFunctionDefinitionsChecks(globalEnv *GlobalEnvironment) error {
 
 		// Ensure that for Γ ⊢ P :: (a : A), the declaration of independence (Γ ≥ A) holds
 		succedentType := f.Type
-		antecedents := f.Parameters
+		antecedents := f.WrittenParameters()
 		if err := declationOfIndependence(antecedents, succedentType); err != nil {
 			return fmt.Errorf("(%s) type error in function definition %s; %s", f.Position.String(), f.String(), err)
 		}
@@ -162,15 +224,15 @@ func preliminaryProcessesChecks(processes []*Process, assumedFreeNames []Name, g
 
 	var typesToCheck []types.SessionType
 	remainingAssumedFreeNames := make(map[string]bool)
-	for _, fn := range assumedFreeNames {
-		if fn.Type == nil {
+	for i, fn := range assumedFreeNames {
+		if fn.Type == nil && !globalEnv.dynamicIfMissing(&assumedFreeNames[i].Type) {
 			return fmt.Errorf("the assumed name %s has no declared type. Use 'assuming %s : T' instead", fn.String(), fn.String())
 		}
 
 		// This will be used to make sure that all declared free names are used (exactly once) by some process
 		remainingAssumedFreeNames[fn.Ident] = true
 
-		typesToCheck = append(typesToCheck, fn.Type)
+		typesToCheck = append(typesToCheck, assumedFreeNames[i].Type)
 	}
 
 	// Modify the types to set their modalities
@@ -212,7 +274,7 @@ func preliminaryProcessesChecks(processes []*Process, assumedFreeNames []Name, g
 
 		// Check the provider type
 		var typesToCheck []types.SessionType
-		if processes[i].Type != nil {
+		if processes[i].Type != nil || globalEnv.dynamicIfMissing(&processes[i].Type) {
 			typesToCheck = append(typesToCheck, processes[i].Type)
 		} else {
 			return fmt.Errorf("(%s) process %s has a missing type of provider", processes[i].Position.String(), processes[i].OutlineString())
@@ -258,15 +320,31 @@ func preliminaryProcessesChecks(processes []*Process, assumedFreeNames []Name, g
 		// todo check for the declaration of independence here as well
 	}
 
-	for name, remainingName := range remainingAssumedFreeNames {
-		if remainingName {
-			return fmt.Errorf("the assume name %s has never been used", name)
+	// Unused assumed names are only allowed if they can be discarded (i.e. affine or replicable)
+	for i, fn := range assumedFreeNames {
+		if remainingAssumedFreeNames[fn.Ident] {
+			if !types.IsWeakenable(typesToCheck[i]) {
+				return fmt.Errorf("the assume name %s has never been used", fn.Ident)
+			}
+
+			globalEnv.warnf(UNUSED_ASSUMPTION, position.Position{}, "the assumed name %s is never used", fn.Ident)
 		}
 	}
 
 	return nil
 }
 
+// With gradual typing, a missing type annotation is set to the dynamic type ? (whose mode is set later on, as for
+// any other type). Returns whether the type has been set.
+func (globalEnv *GlobalEnvironment) dynamicIfMissing(sessionType *types.SessionType) bool {
+	if !globalEnv.Gradual {
+		return false
+	}
+
+	*sessionType = types.NewDynamicType(types.NewUnsetMode())
+	return true
+}
+
 // Ensure that for Γ ⊢ P :: (a : A), Γ ≥ A, where A is the succedentType
 func declationOfIndependence(antecedents []Name, succedentType types.SessionType) error {
 	for _, antecedentName := range antecedents {
@@ -279,8 +357,12 @@ func declationOfIndependence(antecedents []Name, succedentType types.SessionType
 	return nil
 }
 
-// Ensure that left ≥ right
+// Ensure that left ≥ right. The mode of ? is only known at runtime, so it is not checked.
 func declationOfIndependenceOne(left Name, rightType types.SessionType) error {
+	if types.IsDynamic(left.Type) || types.IsDynamic(rightType) {
+		return nil
+	}
+
 	if !left.Type.Modality().CanBeDownshiftedTo(rightType.Modality()) {
 		return fmt.Errorf("declaration of independence error: %s must have a stronger mode than %s", left.Type.StringWithOuterModality(), rightType.StringWithOuterModality())
 	}
@@ -297,10 +379,16 @@ func typecheckFunctionDefinitions(globalEnv *GlobalEnvironment) error {
 	functionDefinitionsEnv := produceFunctionDefinitionsEnvironment(*globalEnv.FunctionDefinitions, labelledTypesEnv)
 
 	for _, funcDef := range *globalEnv.FunctionDefinitions {
+		if funcDef.Local {
+			// Checked within their let block (see LetForm)
+			continue
+		}
+
 		gammaNameTypesCtx := produceNameTypesCtx(funcDef.Parameters)
 		providerType := funcDef.Type
 
 		globalEnv.logf(LOGRULE, "Typechecking function definition %s\n", funcDef.String())
+		globalEnv.startDerivation(funcDef.FunctionName)
 
 		err := funcDef.Body.typecheckForm(gammaNameTypesCtx, nil, providerType, labelledTypesEnv, functionDefinitionsEnv, globalEnv)
 		if err != nil {
@@ -327,6 +415,7 @@ func typecheckProcesses(processes []*Process, assumedFreeNames []Name, globalEnv
 		providerType := processes[i].Type
 
 		globalEnv.logf(LOGRULE, "Typechecking process %s\n", processes[i].OutlineString())
+		globalEnv.startDerivation(processes[i].OutlineString())
 
 		// Run the typechecker
 		// might be a good idea to set the shadowProvider name to processes[i].Providers[0] (when there is only one provider)
@@ -353,16 +442,19 @@ func typecheckProcesses(processes []*Process, assumedFreeNames []Name, globalEnv
 // -> providerShadowName    <- name of the process providing on (nil when name 'self' is used instead)
 // -> providerType    		<- the type of the provider (i.e. type of provider name 'self')
 // -> labelledTypesEnv 		<- [read-only] keeps the mapping of pre-defined types (type A = ...)
-// -> sigma           	 	<- [read-only] ∑: keeps the mapping of pre-defined function definitions (let f() : A = ...)
+// -> sigma           	 	<- [read-only] ∑: keeps the mapping of pre-defined function definitions (let f() : A = ...),
+//                             apart from the types of the names captured by local functions, set by their let block
 // -> globalEnv           	<- [read-only] contains the logging capabilities
 
 // */-*: send w<u, v>
 func (p *SendForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	if isProvider(p.to_c, providerShadowName) {
 		// MulR: *
-		globalEnv.log(LOGRULEDETAILS, "rule ⊗R (MulR)")
+		globalEnv.logRule("⊗R (MulR)")
 
-		providerType = types.Unfold(providerType, labelledTypesEnv)
+		providerType = types.ExpandDynamicSend(types.Unfold(providerType, labelledTypesEnv))
 		// The type of the provider must be SendType
 		providerSendType, sendTypeOk := providerType.(*types.SendType)
 
@@ -387,12 +479,12 @@ func (p *SendForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		}
 
 		// The expected and found types must match
-		if !types.EqualType(expectedLeftType, foundLeftType, labelledTypesEnv) {
-			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead", p.payload_c.String(), expectedLeftType.String(), foundLeftType.String())
+		if !types.ConsistentType(expectedLeftType, foundLeftType, labelledTypesEnv) {
+			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead%s", p.payload_c.String(), expectedLeftType.String(), foundLeftType.String(), explainMismatch(expectedLeftType, foundLeftType, labelledTypesEnv))
 		}
 
-		if !types.EqualType(expectedRightType, foundRightType, labelledTypesEnv) {
-			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead", p.continuation_c.String(), expectedRightType.String(), foundRightType.String())
+		if !types.ConsistentType(expectedRightType, foundRightType, labelledTypesEnv) {
+			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead%s", p.continuation_c.String(), expectedRightType.String(), foundRightType.String(), explainMismatch(expectedRightType, foundRightType, labelledTypesEnv))
 		}
 
 		// Set the types for the names
@@ -402,14 +494,14 @@ func (p *SendForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 
 	} else if isProvider(p.continuation_c, providerShadowName) {
 		// ImpL: -*
-		globalEnv.log(LOGRULEDETAILS, "rule ⊸L (ImpL)")
+		globalEnv.logRule("⊸L (ImpL)")
 
 		clientType, errorClient := consumeName(p.to_c, gammaNameTypesCtx)
 		if errorClient != nil {
 			return TypeErrorf("error in %s; %s", p.String(), errorClient)
 		}
 
-		clientType = types.Unfold(clientType, labelledTypesEnv)
+		clientType = types.ExpandDynamicReceive(types.Unfold(clientType, labelledTypesEnv))
 		// The type of the client must be ReceiveType
 		clientReceiveType, clientTypeOk := clientType.(*types.ReceiveType)
 
@@ -437,12 +529,12 @@ func (p *SendForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		}
 
 		// The expected and found types must match
-		if !types.EqualType(expectedLeftType, foundLeftType, labelledTypesEnv) {
-			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead", p.payload_c.String(), expectedLeftType.String(), foundLeftType.String())
+		if !types.ConsistentType(expectedLeftType, foundLeftType, labelledTypesEnv) {
+			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead%s", p.payload_c.String(), expectedLeftType.String(), foundLeftType.String(), explainMismatch(expectedLeftType, foundLeftType, labelledTypesEnv))
 		}
 
-		if !types.EqualType(expectedRightType, foundRightType, labelledTypesEnv) {
-			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead", p.continuation_c.String(), expectedRightType.String(), foundRightType.String())
+		if !types.ConsistentType(expectedRightType, foundRightType, labelledTypesEnv) {
+			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead%s", p.continuation_c.String(), expectedRightType.String(), foundRightType.String(), explainMismatch(expectedRightType, foundRightType, labelledTypesEnv))
 		}
 
 		// Set the types for the names
@@ -460,7 +552,7 @@ func (p *SendForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 	}
 
 	// make sure that no variables are left in gamma
-	if err := linearGammaContext(gammaNameTypesCtx); err != nil {
+	if err := linearGammaContext(p, gammaNameTypesCtx, globalEnv); err != nil {
 		return TypeErrorE(err)
 	}
 	return nil
@@ -468,11 +560,13 @@ func (p *SendForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 
 // */-*: <x, y> <- recv w; P
 func (p *ReceiveForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	if isProvider(p.from_c, providerShadowName) {
 		// ImpR: -*
-		globalEnv.log(LOGRULEDETAILS, "rule ⊸R (ImpR)")
+		globalEnv.logRule("⊸R (ImpR)")
 
-		providerType = types.Unfold(providerType, labelledTypesEnv)
+		providerType = types.ExpandDynamicReceive(types.Unfold(providerType, labelledTypesEnv))
 		// The type of the provider must be ReceiveType
 		providerReceiveType, receiveTypeOk := providerType.(*types.ReceiveType)
 
@@ -513,14 +607,14 @@ func (p *ReceiveForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerSha
 		return TypeErrorf("you cannot assign self to a new channel (%s)", p.String())
 	} else {
 		// MulL: *
-		globalEnv.log(LOGRULEDETAILS, "rule ⊗L (MulL)")
+		globalEnv.logRule("⊗L (MulL)")
 
 		clientType, errorClient := consumeName(p.from_c, gammaNameTypesCtx)
 		if errorClient != nil {
 			return TypeErrorf("error in %s; %s", p.String(), errorClient)
 		}
 
-		clientType = types.Unfold(clientType, labelledTypesEnv)
+		clientType = types.ExpandDynamicSend(types.Unfold(clientType, labelledTypesEnv))
 		// The type of the client must be SendType
 		clientSendType, clientTypeOk := clientType.(*types.SendType)
 
@@ -565,11 +659,13 @@ func (p *ReceiveForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerSha
 
 // Internal/External Choice: w.l<u>
 func (p *SelectForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	if isProvider(p.to_c, providerShadowName) {
 		// IChoiceR: +{label1: T1, ...}
-		globalEnv.log(LOGRULEDETAILS, "rule ⊕R (IChoiceR)")
+		globalEnv.logRule("⊕R (IChoiceR)")
 
-		providerType = types.Unfold(providerType, labelledTypesEnv)
+		providerType = types.ExpandDynamicSelect(types.Unfold(providerType, labelledTypesEnv), []string{p.label.L})
 		// The type of the provider must be SelectLabelType
 		providerSelectLabelType, selectLabelTypeOk := providerType.(*types.SelectLabelType)
 
@@ -588,26 +684,26 @@ func (p *SelectForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShad
 				return TypeErrorf("error in %s; %s", p.String(), errorContinuationType)
 			}
 
-			if !types.EqualType(continuationType, foundContinuationType, labelledTypesEnv) {
-				return TypeErrorf("type of '%s' is '%s'. Expected type to be '%s'", p.continuation_c.String(), foundContinuationType.StringWithOuterModality(), continuationType.StringWithOuterModality())
+			if !types.ConsistentType(continuationType, foundContinuationType, labelledTypesEnv) {
+				return TypeErrorf("type of '%s' is '%s'. Expected type to be '%s'%s", p.continuation_c.String(), foundContinuationType.StringWithOuterModality(), continuationType.StringWithOuterModality(), explainMismatch(continuationType, foundContinuationType, labelledTypesEnv))
 			}
 
 			p.to_c.Type = providerSelectLabelType
 			continuationType = types.Unfold(continuationType, labelledTypesEnv)
 			p.continuation_c.Type = continuationType
 		} else {
-			return TypeErrorf("could not match label '%s' (from '%s') with the labels from the type '%s'", p.label.String(), p.String(), providerSelectLabelType.String())
+			return TypeErrorf("could not match label '%s' (from '%s') with the labels from the type '%s'; %s", p.label.String(), p.String(), providerSelectLabelType.String(), types.ExpectedLabelsHint(p.label.L, providerSelectLabelType.Branches))
 		}
 	} else if isProvider(p.continuation_c, providerShadowName) {
 		// EChoiceL: &{label1: T1, ...}
-		globalEnv.log(LOGRULEDETAILS, "rule & (EChoiceL)")
+		globalEnv.logRule("& (EChoiceL)")
 
 		clientType, errorClient := consumeName(p.to_c, gammaNameTypesCtx)
 		if errorClient != nil {
 			return TypeErrorf("error in %s; %s", p.String(), errorClient)
 		}
 
-		clientType = types.Unfold(clientType, labelledTypesEnv)
+		clientType = types.ExpandDynamicBranch(types.Unfold(clientType, labelledTypesEnv), []string{p.label.L})
 		// The type of the client must be BranchCaseType
 		clientBranchCaseType, clientTypeOk := clientType.(*types.BranchCaseType)
 
@@ -626,8 +722,8 @@ func (p *SelectForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShad
 				return TypeErrorf("error in %s; %s", p.String(), errorContinuationType)
 			}
 
-			if !types.EqualType(continuationType, foundContinuationType, labelledTypesEnv) {
-				return TypeErrorf("type of '%s' is '%s'. Expected type to be '%s'", p.continuation_c.String(), foundContinuationType.StringWithOuterModality(), continuationType.StringWithOuterModality())
+			if !types.ConsistentType(continuationType, foundContinuationType, labelledTypesEnv) {
+				return TypeErrorf("type of '%s' is '%s'. Expected type to be '%s'%s", p.continuation_c.String(), foundContinuationType.StringWithOuterModality(), continuationType.StringWithOuterModality(), explainMismatch(continuationType, foundContinuationType, labelledTypesEnv))
 			}
 
 			// Type ok
@@ -636,7 +732,7 @@ func (p *SelectForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShad
 			p.to_c.Type = clientBranchCaseType
 			p.continuation_c.Type = continuationType
 		} else {
-			return TypeErrorf("could not match label '%s' (from '%s') with the labels from the type '%s'", p.label.String(), p.String(), clientBranchCaseType.String())
+			return TypeErrorf("could not match label '%s' (from '%s') with the labels from the type '%s'; %s", p.label.String(), p.String(), clientBranchCaseType.String(), types.ExpectedLabelsHint(p.label.L, clientBranchCaseType.Branches))
 		}
 	} else {
 		return TypeErrorf("expected '%s' to either receive or send label on 'self', e.g. self.%s<%s> or %s.%s<self>", p.String(), p.label.String(), p.to_c.String(), p.continuation_c.String(), p.label.String())
@@ -648,7 +744,7 @@ func (p *SelectForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShad
 	}
 
 	// make sure that no variables are left in gamma
-	if err := linearGammaContext(gammaNameTypesCtx); err != nil {
+	if err := linearGammaContext(p, gammaNameTypesCtx, globalEnv); err != nil {
 		return TypeErrorE(err)
 	}
 	return nil
@@ -656,11 +752,13 @@ func (p *SelectForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShad
 
 // Case: case from_c ( branches )
 func (p *CaseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	if isProvider(p.from_c, providerShadowName) {
 		// EChoiceR: &{label1: T1, ...}
-		globalEnv.log(LOGRULEDETAILS, "rule & (EChoiceR)")
+		globalEnv.logRule("& (EChoiceR)")
 
-		providerType = types.Unfold(providerType, labelledTypesEnv)
+		providerType = types.ExpandDynamicBranch(types.Unfold(providerType, labelledTypesEnv), p.labels())
 		// The type of the provider must be BranchCaseType
 		providerBranchCaseType, branchCaseTypeOk := providerType.(*types.BranchCaseType)
 
@@ -684,7 +782,7 @@ func (p *CaseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 			labelsChecked[curBranchForm.label.L] = true
 
 			if !typeFound {
-				return TypeErrorf("branch labelled '%s' does not match the branches of type '%s'", curBranchForm.StringShort(), providerBranchCaseType.String())
+				return TypeErrorf("branch labelled '%s' does not match the branches of type '%s'; %s", curBranchForm.StringShort(), providerBranchCaseType.String(), types.ExpectedLabelsHint(curBranchForm.label.L, providerBranchCaseType.Branches))
 			}
 
 			// Set type
@@ -706,6 +804,10 @@ func (p *CaseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		}
 
 		if len(labelsChecked) < len(providerBranchCaseType.Branches) {
+			if p.patternPath != "" {
+				return TypeErrorf("some patterns (i.e. %s) are not matched in the case construct on %s", p.unmatchedPatterns(providerBranchCaseType.Branches, labelsChecked), p.patternOf.String())
+			}
+
 			labels := extractUnusedLabels(providerBranchCaseType.Branches, labelsChecked)
 
 			return TypeErrorf("some labels (i.e. %s) from the type '%s' are not pattern matched in the case construct: %s", labels, providerBranchCaseType.String(), p.StringShort())
@@ -715,14 +817,14 @@ func (p *CaseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		p.from_c.Type = providerBranchCaseType
 	} else {
 		// IChoiceL: +{label1: T1, ...}
-		globalEnv.log(LOGRULEDETAILS, "rule ⊕L (IChoiceL)")
+		globalEnv.logRule("⊕L (IChoiceL)")
 
 		clientType, errorClient := consumeName(p.from_c, gammaNameTypesCtx)
 		if errorClient != nil {
 			return TypeErrorf("error in %s; %s", p.StringShort(), errorClient)
 		}
 
-		clientType = types.Unfold(clientType, labelledTypesEnv)
+		clientType = types.ExpandDynamicSelect(types.Unfold(clientType, labelledTypesEnv), p.labels())
 		// The type of the client must be SelectLabelType
 		clientSelectLabelType, clientTypeOk := clientType.(*types.SelectLabelType)
 
@@ -746,7 +848,12 @@ func (p *CaseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 			labelsChecked[curBranchForm.label.L] = true
 
 			if !typeFound {
-				return TypeErrorf("case labelled '%s' does not match the branches of type '%s'", curBranchForm.StringShort(), clientSelectLabelType.String())
+				return TypeErrorf("case labelled '%s' does not match the branches of type '%s'; %s", curBranchForm.StringShort(), clientSelectLabelType.String(), types.ExpectedLabelsHint(curBranchForm.label.L, clientSelectLabelType.Branches))
+			}
+
+			// No provider can select a label whose continuation cannot be provided
+			if types.IsEmptyType(expectedBranchType.SessionType, labelledTypesEnv) {
+				globalEnv.warnf(UNREACHABLE_BRANCH, curBranchForm.label.Position, "the branch '%s' in the case on %s is unreachable, since the type '%s' has no values", curBranchForm.label.L, p.from_c.String(), expectedBranchType.SessionType.String())
 			}
 
 			// Copy gamma so that each branch has its own version
@@ -771,6 +878,10 @@ func (p *CaseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		}
 
 		if len(labelsChecked) < len(clientSelectLabelType.Branches) {
+			if p.patternPath != "" {
+				return TypeErrorf("some patterns (i.e. %s) are not matched in the case construct on %s", p.unmatchedPatterns(clientSelectLabelType.Branches, labelsChecked), p.patternOf.String())
+			}
+
 			labels := extractUnusedLabels(clientSelectLabelType.Branches, labelsChecked)
 
 			return TypeErrorf("some labels (i.e. %s) from the type '%s' are not pattern matched in the case construct: %s", labels, clientSelectLabelType.String(), p.StringShort())
@@ -795,8 +906,10 @@ func (p *BranchForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShad
 
 // New: continuation_c <- new (body); continuation_e
 func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	// Cut
-	globalEnv.log(LOGRULEDETAILS, "rule CUT")
+	globalEnv.logRule("CUT")
 
 	//	if isProvider(p.new_name_c, providerShadowName) || nameTypeExists(gammaNameTypesCtx, p.new_name_c.Ident) {
 	//		// Names are not fresh
@@ -812,9 +925,15 @@ func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowN
 		return TypeErrorf("name '%s' is reassigned before being used in the spawned process (%s).", p.new_name_c.String(), p.body.StringShort())
 	}
 
-	// When 'new' is used directly (i.e. not derived from a macro expansion), then we need to ensure
+	// The spawned process refers to its provider as self, so a reassigned name still refers to the client in the body
+	bodyProviderShadowName := &p.new_name_c
+	if new_name_reused {
+		bodyProviderShadowName = &Name{IsSelf: true}
+	}
+
+	// When 'new' is used directly (or derived from a macro on a client, e.g. send x<y>; P), then we need to ensure
 	// that the body is either a function call, or an axiomatic rule (e.g. send)
-	if !p.derivedFromMacro {
+	if !p.onProvider {
 		// check form of body
 		if FormHasContinuation(p.body) {
 			// Difficult to split gamma, so we show it as ill typed for now
@@ -840,12 +959,29 @@ func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowN
 			// Get function signature (incl. its type)
 			functionSignature, exists := sigma[callForm.functionName]
 			if !exists {
-				return TypeErrorf("function '%s' is undefined", p.body.String())
+				return TypeErrorf("function '%s' is undefined%s", p.body.String(), SuggestFunction(callForm.functionName, len(callForm.parameters), *globalEnv.FunctionDefinitions))
 			}
 
 			functionSignatureType := types.CopyType(functionSignature.Type)
 			functionSignatureType = types.Unfold(functionSignatureType, labelledTypesEnv)
 
+			// The new name may be declared with a supertype of the function's type, in which case the continuation
+			// only relies on the declared type (a declared ? keeps the more precise type of the function instead)
+			newNameType := functionSignatureType
+			if p.new_name_c.Type != nil && !types.IsDynamic(p.new_name_c.Type) {
+				types.AddMissingModalities(&p.new_name_c.Type, labelledTypesEnv)
+
+				if err := checkNameType(p.new_name_c, labelledTypesEnv); err != nil {
+					return TypeErrorf("invalid type for %s in %s: %s", p.new_name_c.String(), p.StringShort(), err)
+				}
+
+				if !types.ConsistentSubtype(functionSignatureType, p.new_name_c.Type, labelledTypesEnv) {
+					return TypeErrorf("type error in '%s'. Name '%s' is declared with type '%s', but %s provides '%s'%s", p.StringShort(), p.new_name_c.String(), p.new_name_c.Type.String(), callForm.functionName, functionSignature.Type.String(), explainSubtypeMismatch(functionSignatureType, p.new_name_c.Type, labelledTypesEnv))
+				}
+
+				newNameType = types.Unfold(p.new_name_c.Type, labelledTypesEnv)
+			}
+
 			// Check for declaration of independence: (Γ ⪰ m)
 			// Γ (gammaLeftNameTypesCtx) ⪰ m (type of p.continuation_c)
 			err := declationOfIndependence(gammaLeftNameTypesCtx.getNames(), functionSignatureType)
@@ -854,14 +990,14 @@ func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowN
 			}
 
 			// Typecheck the call function
-			callBodyError := p.body.typecheckForm(gammaLeftNameTypesCtx, &p.new_name_c, functionSignatureType, labelledTypesEnv, sigma, globalEnv)
+			callBodyError := p.body.typecheckForm(gammaLeftNameTypesCtx, bodyProviderShadowName, functionSignatureType, labelledTypesEnv, sigma, globalEnv)
 
 			if callBodyError != nil {
 				return callBodyError
 			}
 
 			// Add new channel name to gamma
-			gammaRightNameTypesCtx[p.new_name_c.Ident] = NamesType{Type: functionSignatureType}
+			gammaRightNameTypesCtx[p.new_name_c.Ident] = NamesType{Type: newNameType}
 
 			// typecheck the continuation body
 			continuationError := p.continuation_e.typecheckForm(gammaRightNameTypesCtx, providerShadowName, providerType, labelledTypesEnv, sigma, globalEnv)
@@ -871,7 +1007,7 @@ func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowN
 			}
 
 			// Set type
-			p.new_name_c.Type = functionSignatureType
+			p.new_name_c.Type = newNameType
 
 			// Check for declaration of independence: (m ⪰ n)
 			// m (type of p.continuation_c) ⪰ p (providerType)
@@ -885,7 +1021,14 @@ func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowN
 				return TypeErrorE(polarityError)
 			}
 		default:
-			// The type of p.continuation_c has to be provided by the user
+			// The type of p.continuation_c has to be provided by the user, unless it follows from a macro
+			if p.derivedFromMacro && p.new_name_c.Type == nil {
+				macroType, macroErr := macroClientType(p, gammaNameTypesCtx, labelledTypesEnv)
+				if macroErr != nil {
+					return macroErr
+				}
+				p.new_name_c.Type = macroType
+			}
 
 			// Split gamma
 			gammaLeftNameTypesCtx, gammaRightNameTypesCtx, gammaErr := splitGammaCtx(gammaNameTypesCtx, p.body.FreeNames(), nil, labelledTypesEnv)
@@ -899,7 +1042,7 @@ func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowN
 				return TypeErrorf("error when splitting variable context in '%s': %s", p.StringShort(), gammaErr)
 			}
 
-			if p.new_name_c.Type == nil {
+			if p.new_name_c.Type == nil && !globalEnv.dynamicIfMissing(&p.new_name_c.Type) {
 				return TypeErrorf("expected '%s' to have an explicit type in %s", p.new_name_c.String(), p.StringShort())
 			}
 
@@ -928,7 +1071,7 @@ func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowN
 			}
 
 			// typecheck the body of the process being spawned
-			bodyError := p.body.typecheckForm(gammaLeftNameTypesCtx, &p.new_name_c, p.new_name_c.Type, labelledTypesEnv, sigma, globalEnv)
+			bodyError := p.body.typecheckForm(gammaLeftNameTypesCtx, bodyProviderShadowName, p.new_name_c.Type, labelledTypesEnv, sigma, globalEnv)
 
 			if bodyError != nil {
 				return bodyError
@@ -952,19 +1095,118 @@ func (p *NewForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowN
 		}
 
 	} else {
-		// Since it is derived from a macro, then we assume that the continuation_e is an axiomatic rule (e.g. send) instead of the body -- no macros are currently being used
-		panic("todo need to handle macros -- not implemented")
+		// Derived from a macro on the provider (e.g. send self<y>; P), so the continuation_e is an axiomatic rule (e.g.
+		// send self<y, z>) and the body provides the rest of the provider's type (through z)
+		continuationType, macroErr := macroProviderType(p, providerType, labelledTypesEnv)
+		if macroErr != nil {
+			return macroErr
+		}
+		p.new_name_c.Type = continuationType
+
+		// The body takes the names it refers to, and the axiomatic rule takes the rest
+		gammaBodyNameTypesCtx, gammaRuleNameTypesCtx, gammaErr := splitGammaCtx(gammaNameTypesCtx, p.body.FreeNames(), providerShadowName, labelledTypesEnv)
+		if gammaErr != nil {
+			return TypeErrorf("error when splitting variable context in '%s': %s", p.StringShort(), gammaErr)
+		}
+
+		// Check for declaration of independence: Γ ⪰ m ⪰ n
+		err := declationOfIndependence(gammaBodyNameTypesCtx.getNames(), continuationType)
+		if err != nil {
+			return TypeErrorE(err)
+		}
+
+		err = declationOfIndependenceOne(p.new_name_c, providerType)
+		if err != nil {
+			return TypeErrorE(err)
+		}
+
+		// typecheck the body, which continues as the provider
+		bodyError := p.body.typecheckForm(gammaBodyNameTypesCtx, &p.new_name_c, continuationType, labelledTypesEnv, sigma, globalEnv)
+		if bodyError != nil {
+			return bodyError
+		}
+
+		gammaRuleNameTypesCtx[p.new_name_c.Ident] = NamesType{Type: continuationType}
+
+		// typecheck the axiomatic rule acting on the provider
+		continuationError := p.continuation_e.typecheckForm(gammaRuleNameTypesCtx, providerShadowName, providerType, labelledTypesEnv, sigma, globalEnv)
+		if continuationError != nil {
+			return continuationError
+		}
 	}
 
 	return nil
 }
 
+// The type of the client after a macro, e.g. B in send x<y>; P for x : A -* B. Clients missing from gamma are left
+// to be reported when splitting the context.
+func macroClientType(p *NewForm, gammaNameTypesCtx NamesTypesCtx, labelledTypesEnv types.LabelledTypesEnv) (types.SessionType, *TypeError) {
+	step, _, _ := p.macro()
+	client, exists := gammaNameTypesCtx[macroSubject(step).Ident]
+	if !exists || client.Type == nil {
+		return nil, nil
+	}
+
+	clientType := types.Unfold(client.Type, labelledTypesEnv)
+
+	switch s := step.(type) {
+	case *SendForm:
+		clientReceiveType, ok := types.ExpandDynamicReceive(clientType).(*types.ReceiveType)
+		if !ok {
+			return nil, TypeErrorf("expected '%s' to have a receive type (A -* B), but found type '%s' instead (in %s)", s.to_c.String(), clientType.String(), p.StringShort())
+		}
+		return types.Unfold(clientReceiveType.Right, labelledTypesEnv), nil
+	case *SelectForm:
+		clientBranchCaseType, ok := types.ExpandDynamicBranch(clientType, []string{s.label.L}).(*types.BranchCaseType)
+		if !ok {
+			return nil, TypeErrorf("expected '%s' to have a branching type (&{...}), but found type '%s' instead (in %s)", s.to_c.String(), clientType.String(), p.StringShort())
+		}
+		return macroOptionType(s.label, p, clientBranchCaseType.Branches, clientBranchCaseType, labelledTypesEnv)
+	}
+
+	return nil, nil
+}
+
+// The type of the provider after a macro, e.g. B in send self<y>; P for self : A * B
+func macroProviderType(p *NewForm, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv) (types.SessionType, *TypeError) {
+	providerType = types.Unfold(providerType, labelledTypesEnv)
+
+	switch s := p.continuation_e.(type) {
+	case *SendForm:
+		providerSendType, ok := types.ExpandDynamicSend(providerType).(*types.SendType)
+		if !ok {
+			return nil, TypeErrorf("expected '%s' to have a send type (A * B), but found type '%s' instead", p.StringShort(), providerType.String())
+		}
+		return types.Unfold(providerSendType.Right, labelledTypesEnv), nil
+	case *SelectForm:
+		providerSelectLabelType, ok := types.ExpandDynamicSelect(providerType, []string{s.label.L}).(*types.SelectLabelType)
+		if !ok {
+			return nil, TypeErrorf("expected '%s' to have a select type (+{...}), but found type '%s' instead", p.StringShort(), providerType.String())
+		}
+		return macroOptionType(s.label, p, providerSelectLabelType.Branches, providerSelectLabelType, labelledTypesEnv)
+	}
+
+	return nil, TypeErrorf("expected '%s' to end with a send or select on the provider", p.StringShort())
+}
+
+func macroOptionType(label Label, p *NewForm, options []types.Option, choiceType types.SessionType, labelledTypesEnv types.LabelledTypesEnv) (types.SessionType, *TypeError) {
+	for _, option := range options {
+		if option.Label == label.L {
+			return types.Unfold(option.SessionType, labelledTypesEnv), nil
+		}
+	}
+
+	return nil, TypeErrorf("could not match label '%s' (from '%s') with the labels from the type '%s'; %s", label.String(), p.StringShort(), choiceType.String(), types.ExpectedLabelsHint(label.L, options))
+}
+
 // 1 : close w
 func (p *CloseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	// EndR: 1
-	globalEnv.log(LOGRULEDETAILS, "rule 1R (EndR)")
+	globalEnv.logRule("1R (EndR)")
 
-	providerType = types.Unfold(providerType, labelledTypesEnv)
+	providerType = types.ExpandDynamicUnit(types.Unfold(providerType, labelledTypesEnv))
 
 	if isProvider(p.from_c, providerShadowName) {
 		providerUnitType, unitTypeOk := providerType.(*types.UnitType)
@@ -991,7 +1233,7 @@ func (p *CloseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShado
 	}
 
 	// make sure that no variables are left in gamma
-	if err := linearGammaContext(gammaNameTypesCtx); err != nil {
+	if err := linearGammaContext(p, gammaNameTypesCtx, globalEnv); err != nil {
 		return TypeErrorE(err)
 	}
 	return nil
@@ -999,8 +1241,10 @@ func (p *CloseForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShado
 
 // 1 : wait w; ...
 func (p *WaitForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	// EndL: 1
-	globalEnv.log(LOGRULEDETAILS, "rule 1L (EndL)")
+	globalEnv.logRule("1L (EndL)")
 
 	// Can only wait for a client (not self)
 	if isProvider(p.to_c, providerShadowName) {
@@ -1020,7 +1264,7 @@ func (p *WaitForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		return TypeErrorf("error in %s; %s", p.String(), errorClient)
 	}
 
-	clientType = types.Unfold(clientType, labelledTypesEnv)
+	clientType = types.ExpandDynamicUnit(types.Unfold(clientType, labelledTypesEnv))
 	// The type of the client must be UnitType
 	clientUnitType, clientTypeOk := clientType.(*types.UnitType)
 
@@ -1044,8 +1288,10 @@ func (p *WaitForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 
 // fwd w u
 func (p *ForwardForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	// ID: 1
-	globalEnv.log(LOGRULEDETAILS, "rule ID/FWD")
+	globalEnv.logRule("ID/FWD")
 
 	if isProvider(p.from_c, providerShadowName) {
 		return TypeErrorf("forwarding to self (%s) is not allowed. Use 'fwd %s %s' instead)", p.String(), p.from_c.String(), p.to_c.String())
@@ -1061,13 +1307,14 @@ func (p *ForwardForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerSha
 		return TypeErrorf("error in %s; %s", p.String(), errorClient)
 	}
 
-	if !types.EqualType(providerType, clientType, labelledTypesEnv) {
-		return TypeErrorf("problem in %s: type of %s (%s) and %s (%s) do not match", p.String(), p.to_c.String(), providerType.String(), p.from_c.String(), clientType.String())
+	// The forwarded name may offer more than what is expected from self (i.e. a subtype)
+	if !types.ConsistentSubtype(clientType, providerType, labelledTypesEnv) {
+		return TypeErrorf("problem in %s: type of %s (%s) and %s (%s) do not match%s", p.String(), p.to_c.String(), providerType.String(), p.from_c.String(), clientType.String(), explainSubtypeMismatch(clientType, providerType, labelledTypesEnv))
 	}
 
-	// Check polarities
+	// Check polarities (unless one of the types is ?, whose polarity is only known at runtime)
 	providerType = types.Unfold(providerType, labelledTypesEnv)
-	if clientType.Polarity() != providerType.Polarity() {
+	if !types.IsDynamic(providerType) && !types.IsDynamic(clientType) && clientType.Polarity() != providerType.Polarity() {
 		// Make sure that the polarities match
 		return TypeErrorf("invalid polarities in %s: name '%s' is %s, while '%s' is %s", p.StringShort(), p.to_c.String(), types.PolarityMap[providerType.Polarity()], p.from_c.String(), types.PolarityMap[clientType.Polarity()])
 	}
@@ -1082,7 +1329,7 @@ func (p *ForwardForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerSha
 	}
 
 	// make sure that no variables are left in gamma
-	if err := linearGammaContext(gammaNameTypesCtx); err != nil {
+	if err := linearGammaContext(p, gammaNameTypesCtx, globalEnv); err != nil {
 		return TypeErrorE(err)
 	}
 	return nil
@@ -1090,8 +1337,10 @@ func (p *ForwardForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerSha
 
 // drop w; ...
 func (p *DropForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	// Drop
-	globalEnv.log(LOGRULEDETAILS, "rule DROP")
+	globalEnv.logRule("DROP")
 
 	// Can only wait for a client (not self)
 	if !isProvider(p.client_c, providerShadowName) {
@@ -1125,12 +1374,14 @@ func (p *DropForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 
 // f(...)
 func (p *CallForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
-	globalEnv.log(LOGRULEDETAILS, "rule CALL")
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
+	globalEnv.logRule("CALL")
 
 	// Check that function exists
 	functionSignature, exists := sigma[p.functionName]
 	if !exists {
-		return TypeErrorf("function '%s' is undefined", p.String())
+		return TypeErrorf("function '%s' is undefined%s", p.String(), SuggestFunction(p.functionName, len(p.parameters), *globalEnv.FunctionDefinitions))
 	}
 
 	// Check that the arity matches
@@ -1141,9 +1392,9 @@ func (p *CallForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 			return TypeErrorf("error in %s; expected first parameter of function call to be 'self', but found '%s'", p.String(), p.parameters[0].String())
 		}
 
-		// Check type of self
-		if !types.EqualType(providerType, functionSignature.Type, labelledTypesEnv) {
-			return TypeErrorf("type error in function call '%s'. Name '%s' has type '%s', but expected '%s'", p.String(), p.parameters[0].String(), providerType.String(), functionSignature.Type.String())
+		// Check type of self (the function may provide a subtype of the expected one)
+		if !types.ConsistentSubtype(functionSignature.Type, providerType, labelledTypesEnv) {
+			return TypeErrorf("type error in function call '%s'. Name '%s' has type '%s', but expected '%s'%s", p.String(), p.parameters[0].String(), providerType.String(), functionSignature.Type.String(), explainSubtypeMismatch(functionSignature.Type, providerType, labelledTypesEnv))
 		}
 
 		// Check types of each parameter
@@ -1157,8 +1408,8 @@ func (p *CallForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 
 			expectedType := functionSignature.Parameters[i-1].Type
 
-			if !types.EqualType(foundParamType, expectedType, labelledTypesEnv) {
-				return TypeErrorf("type error in function call '%s'. Name '%s' has type '%s', but expected '%s'", p.String(), p.parameters[i].String(), foundParamType.String(), expectedType.String())
+			if !types.ConsistentSubtype(foundParamType, expectedType, labelledTypesEnv) {
+				return TypeErrorf("type error in function call '%s'. Name '%s' has type '%s', but expected '%s'%s", p.String(), p.parameters[i].String(), foundParamType.String(), expectedType.String(), explainSubtypeMismatch(foundParamType, expectedType, labelledTypesEnv))
 			}
 
 			// Set types
@@ -1173,14 +1424,14 @@ func (p *CallForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 	} else if len(functionSignature.Parameters) == len(p.parameters) {
 		// 'self' is not included in the parameters
 
-		// Check type of self
-		if !types.EqualType(providerType, functionSignature.Type, labelledTypesEnv) {
+		// Check type of self (the function may provide a subtype of the expected one)
+		if !types.ConsistentSubtype(functionSignature.Type, providerType, labelledTypesEnv) {
 			providerName := "self"
 			if providerShadowName != nil {
 				providerName = providerShadowName.String()
 			}
 
-			return TypeErrorf("type error in function call '%s'. Provider '%s' has type '%s', but %s expects '%s'", p.String(), providerName, providerType.String(), p.functionName, functionSignature.Type.String())
+			return TypeErrorf("type error in function call '%s'. Provider '%s' has type '%s', but %s expects '%s'%s", p.String(), providerName, providerType.String(), p.functionName, functionSignature.Type.String(), explainSubtypeMismatch(functionSignature.Type, providerType, labelledTypesEnv))
 		}
 
 		// Check types of each parameter
@@ -1193,8 +1444,8 @@ func (p *CallForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 
 			expectedType := functionSignature.Parameters[i].Type
 
-			if !types.EqualType(foundParamType, expectedType, labelledTypesEnv) {
-				return TypeErrorf("type error in function call '%s'. Name '%s' has type '%s', but expected '%s'", p.String(), p.parameters[i].String(), foundParamType.String(), expectedType.String())
+			if !types.ConsistentSubtype(foundParamType, expectedType, labelledTypesEnv) {
+				return TypeErrorf("type error in function call '%s'. Name '%s' has type '%s', but expected '%s'%s", p.String(), p.parameters[i].String(), foundParamType.String(), expectedType.String(), explainSubtypeMismatch(foundParamType, expectedType, labelledTypesEnv))
 			}
 
 			// Set types
@@ -1207,14 +1458,15 @@ func (p *CallForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		}
 	} else {
 		// Wrong number of parameters
-		return TypeErrorf("wrong number of parameters in function call '%s'. Expected %d, but found %d parameters", p.String(), len(functionSignature.Parameters), len(p.parameters))
+		_, written := p.written()
+		return TypeErrorf("wrong number of parameters in function call '%s'. Expected %d, but found %d parameters", p.String(), len(functionSignature.Parameters)-p.captured, len(written))
 	}
 
 	// Set type
 	p.ProviderType = functionSignature.Type
 
 	// make sure that no variables are left in gamma
-	if err := linearGammaContext(gammaNameTypesCtx); err != nil {
+	if err := linearGammaContext(p, gammaNameTypesCtx, globalEnv); err != nil {
 		return TypeErrorE(err)
 	}
 	return nil
@@ -1222,7 +1474,9 @@ func (p *CallForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 
 // Split: <channel_one, channel_two> <- recv from_c; P
 func (p *SplitForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
-	globalEnv.log(LOGRULEDETAILS, "rule SPLIT")
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
+	globalEnv.logRule("SPLIT")
 
 	// Can only wait for a client (not self)
 	if isProvider(p.from_c, providerShadowName) {
@@ -1251,6 +1505,9 @@ func (p *SplitForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShado
 	gammaNameTypesCtx[p.channel_two.Ident] = NamesType{Type: foundType} //todo not sure if i need to use CopyType
 
 	if !types.IsContractable(foundType) {
+		if p.contracted != "" {
+			return TypeErrorf("name '%s' is used more than once, but it cannot be split since it is in %s mode", p.contracted, foundType.Modality().FullString())
+		}
 		return TypeErrorf("unable to split %s, which is in %s mode", p.from_c.String(), foundType.Modality().FullString())
 	}
 
@@ -1271,11 +1528,13 @@ func (p *SplitForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShado
 }
 
 func (p *CastForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	if isProvider(p.to_c, providerShadowName) {
 		// Downshift DnSR: \/
-		globalEnv.log(LOGRULEDETAILS, "rule ↓R (DnSR, Cast)")
+		globalEnv.logRule("↓R (DnSR, Cast)")
 
-		providerType = types.Unfold(providerType, labelledTypesEnv)
+		providerType = types.ExpandDynamicDown(types.Unfold(providerType, labelledTypesEnv))
 		// The type of the provider must be DownType
 		providerDownType, downTypeOk := providerType.(*types.DownType)
 
@@ -1300,13 +1559,13 @@ func (p *CastForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		}
 
 		// Expect that the modalities match
-		if !providerDownType.From.Equals(foundContinuationType.Modality()) {
+		if !types.IsDynamic(expectedContinuationType) && !providerDownType.From.Equals(foundContinuationType.Modality()) {
 			return TypeErrorf("expected mode of '%s' to be '%s', but found type '%s' instead", p.continuation_c.String(), providerDownType.From.String(), foundContinuationType.Modality().String())
 		}
 
 		// The expected and found types must match
-		if !types.EqualType(expectedContinuationType, foundContinuationType, labelledTypesEnv) {
-			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead", p.continuation_c.String(), expectedContinuationType.String(), foundContinuationType.String())
+		if !types.ConsistentType(expectedContinuationType, foundContinuationType, labelledTypesEnv) {
+			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead%s", p.continuation_c.String(), expectedContinuationType.String(), foundContinuationType.String(), explainMismatch(expectedContinuationType, foundContinuationType, labelledTypesEnv))
 		}
 
 		// Set the types for the names
@@ -1314,14 +1573,14 @@ func (p *CastForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		p.continuation_c.Type = foundContinuationType
 	} else if isProvider(p.continuation_c, providerShadowName) {
 		// Downshift UpSL: /\
-		globalEnv.log(LOGRULEDETAILS, "rule ↑L (UpSL, Cast)")
+		globalEnv.logRule("↑L (UpSL, Cast)")
 
 		clientType, errorClient := consumeName(p.to_c, gammaNameTypesCtx)
 		if errorClient != nil {
 			return TypeErrorf("error in %s; %s", p.String(), errorClient)
 		}
 
-		clientType = types.Unfold(clientType, labelledTypesEnv)
+		clientType = types.ExpandDynamicUp(types.Unfold(clientType, labelledTypesEnv))
 		// The type of the client must be UpType
 		clientUpType, clientTypeOk := clientType.(*types.UpType)
 
@@ -1347,13 +1606,13 @@ func (p *CastForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 		}
 
 		// Expect that the modalities match
-		if !clientUpType.From.Equals(foundContinuationType.Modality()) {
+		if !types.IsDynamic(expectedContinuationType) && !clientUpType.From.Equals(foundContinuationType.Modality()) {
 			return TypeErrorf("expected mode of '%s' to be '%s', but found type '%s' instead", p.continuation_c.String(), clientUpType.From.String(), foundContinuationType.Modality().String())
 		}
 
 		// The expected and found types must match
-		if !types.EqualType(expectedContinuationType, foundContinuationType, labelledTypesEnv) {
-			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead", p.continuation_c.String(), expectedContinuationType.String(), foundContinuationType.String())
+		if !types.ConsistentType(expectedContinuationType, foundContinuationType, labelledTypesEnv) {
+			return TypeErrorf("expected type of '%s' to be '%s', but found type '%s' instead%s", p.continuation_c.String(), expectedContinuationType.String(), foundContinuationType.String(), explainMismatch(expectedContinuationType, foundContinuationType, labelledTypesEnv))
 		}
 
 		// Set the types for the names
@@ -1368,18 +1627,20 @@ func (p *CastForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadow
 	}
 
 	// make sure that no variables are left in gamma
-	if err := linearGammaContext(gammaNameTypesCtx); err != nil {
+	if err := linearGammaContext(p, gammaNameTypesCtx, globalEnv); err != nil {
 		return TypeErrorE(err)
 	}
 	return nil
 }
 
 func (p *ShiftForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	if isProvider(p.from_c, providerShadowName) {
 		// UpSR: /\
-		globalEnv.log(LOGRULEDETAILS, "rule ↑R (UpSR, Shift)")
+		globalEnv.logRule("↑R (UpSR, Shift)")
 
-		providerType = types.Unfold(providerType, labelledTypesEnv)
+		providerType = types.ExpandDynamicUp(types.Unfold(providerType, labelledTypesEnv))
 		// The type of the provider must be UpType
 		providerUpType, upTypeOk := providerType.(*types.UpType)
 
@@ -1416,14 +1677,14 @@ func (p *ShiftForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShado
 		return TypeErrorf("you cannot assign self to a new channel (%s)", p.String())
 	} else {
 		// DnSL: \/
-		globalEnv.log(LOGRULEDETAILS, "rule ↓L (DnSL, Shift)")
+		globalEnv.logRule("↓L (DnSL, Shift)")
 
 		clientType, errorClient := consumeName(p.from_c, gammaNameTypesCtx)
 		if errorClient != nil {
 			return TypeErrorf("error in %s; %s", p.String(), errorClient)
 		}
 
-		clientType = types.Unfold(clientType, labelledTypesEnv)
+		clientType = types.ExpandDynamicDown(types.Unfold(clientType, labelledTypesEnv))
 		// The type of the client must be DownType
 		clientDownType, clientTypeOk := clientType.(*types.DownType)
 
@@ -1466,14 +1727,154 @@ func (p *ShiftForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShado
 }
 
 func (p *PrintForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
 	// Print
-	globalEnv.log(LOGRULEDETAILS, "rule PRINT")
+	globalEnv.logRule("PRINT")
+
+	// Continue checking the remaining process
+	continuationError := p.continuation_e.typecheckForm(gammaNameTypesCtx, providerShadowName, providerType, labelledTypesEnv, sigma, globalEnv)
+	return continuationError
+}
+
+// print(x); P
+func (p *PrintValueForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
+	globalEnv.logRule("PRINT VALUE")
+
+	// Can only print a client (not self)
+	if isProvider(p.from_c, providerShadowName) {
+		return TypeErrorf("expected '%s' to print a 'non-self' channel instead (%s is acting as self)", p.StringShort(), p.from_c.String())
+	}
+
+	clientType, errorClient := consumeName(p.from_c, gammaNameTypesCtx)
+	if errorClient != nil {
+		return TypeErrorf("error in %s; %s", p.StringShort(), errorClient)
+	}
+
+	if err := checkPrintType(clientType, labelledTypesEnv); err != nil {
+		return TypeErrorf("expected '%s' to have a type made up of choices (+{...}), pairs (*) and 1, but found type '%s' instead; %s", p.from_c.String(), clientType.String(), err)
+	}
+
+	p.from_c.Type = clientType
+	p.valueType = clientType
+
+	polarityError := checkExplicitPolarityValidity(p, p.from_c)
+	if polarityError != nil {
+		return TypeErrorE(polarityError)
+	}
+
+	// Continue checking the remaining process
+	continuationError := p.continuation_e.typecheckForm(gammaNameTypesCtx, providerShadowName, providerType, labelledTypesEnv, sigma, globalEnv)
+	return continuationError
+}
+
+// Let: let <definitions> in P end
+// The local functions are checked here (rather than with the global ones), since the types of the names they capture
+// are only known at this point. Each local function captures names from Γ, without consuming them: the calls pass
+// them on, so the names are consumed by each call.
+func (p *LetForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
+	globalEnv.logRule("LET")
+
+	var localFunctions []*FunctionDefinition
+	for i, lifted := range p.lifted {
+		function := GetFunctionByName(*globalEnv.FunctionDefinitions, lifted.name)
+		if function == nil {
+			return TypeErrorf("local function %s is undefined", p.functions[i].FunctionName)
+		}
+
+		// The captured names take their types from Γ
+		written := len(function.WrittenParameters())
+		for j, captured := range lifted.captured {
+			found, ok := gammaNameTypesCtx[captured.Ident]
+			if !ok {
+				return TypeErrorf("local function %s refers to '%s', which is not available here", p.functions[i].FunctionName, captured.String())
+			}
+
+			function.Parameters[written+j].Type = found.Type
+		}
+
+		// Ensure that the captured names ⪰ the local function
+		if err := declationOfIndependence(function.Parameters[written:], function.Type); err != nil {
+			return TypeErrorf("in local function %s; %s", p.functions[i].FunctionName, err)
+		}
+
+		localFunctions = append(localFunctions, function)
+	}
+
+	// The local functions may call each other, so their bodies are only checked once all of their types are known
+	for i, function := range localFunctions {
+		globalEnv.logf(LOGRULE, "Typechecking local function definition %s\n", function.String())
+
+		err := function.Body.typecheckForm(produceNameTypesCtx(function.Parameters), nil, function.Type, labelledTypesEnv, sigma, globalEnv)
+		if err != nil {
+			return TypeErrorf("typechecking error in local function %s; %s", p.functions[i].String(), err)
+		}
+	}
 
 	// Continue checking the remaining process
 	continuationError := p.continuation_e.typecheckForm(gammaNameTypesCtx, providerShadowName, providerType, labelledTypesEnv, sigma, globalEnv)
 	return continuationError
 }
 
+func (p *HoleForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
+	globalEnv.logRule("HOLE")
+
+	// Record what is expected in place of the hole, rather than failing
+	provider := "self"
+	if providerShadowName != nil {
+		provider = providerShadowName.Ident
+	}
+
+	globalEnv.Holes = append(globalEnv.Holes, Hole{
+		Position:         p.Position,
+		Provider:         provider,
+		ProviderType:     providerType,
+		ProviderPolarity: types.Unfold(providerType, labelledTypesEnv).Polarity(),
+		Context:          stringifyContext(gammaNameTypesCtx),
+		Candidates:       holeCandidates(gammaNameTypesCtx, providerType, labelledTypesEnv, sigma),
+		gamma:            copyContext(gammaNameTypesCtx),
+		labelledTypesEnv: labelledTypesEnv,
+		sigma:            sigma,
+	})
+
+	return nil
+}
+
+// input self
+func (p *InputForm) typecheckForm(gammaNameTypesCtx NamesTypesCtx, providerShadowName *Name, providerType types.SessionType, labelledTypesEnv types.LabelledTypesEnv, sigma FunctionTypesEnv, globalEnv *GlobalEnvironment) *TypeError {
+	defer globalEnv.beginDerivation(p, gammaNameTypesCtx, providerShadowName, providerType)()
+
+	globalEnv.logRule("INPUT")
+
+	if !isProvider(p.from_c, providerShadowName) {
+		return TypeErrorf("expected '%s' to read the input on 'self' instead", p.String())
+	}
+
+	if err := checkInputType(providerType, labelledTypesEnv); err != nil {
+		return TypeErrorf("expected '%s' to have a type made up of choices (+{...}) and 1, but found type '%s' instead; %s", p.String(), providerType.String(), err)
+	}
+
+	p.from_c.Type = providerType
+	p.inputType = providerType
+
+	polarityError := checkExplicitPolarityValidity(p, p.from_c)
+	if polarityError != nil {
+		return TypeErrorE(polarityError)
+	}
+
+	// make sure that no variables are left in gamma
+	if err := linearGammaContext(p, gammaNameTypesCtx, globalEnv); err != nil {
+		return TypeErrorE(err)
+	}
+	return nil
+}
+
 /////////////////////////////////////////////////////
 ///////////////// Fixed Environment /////////////////
 /////////////////////////////////////////////////////
@@ -1639,8 +2040,19 @@ func stringifyContext(gammaNameTypesCtx NamesTypesCtx) string {
 
 	var buffer bytes.Buffer
 
+	// Sorted, so that the output is deterministic
+	names := make([]string, 0, len(gammaNameTypesCtx))
 	for k := range gammaNameTypesCtx {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
 		buffer.WriteString(k)
+		if t := gammaNameTypesCtx[k].Type; t != nil {
+			buffer.WriteString(" : ")
+			buffer.WriteString(t.String())
+		}
 		buffer.WriteString("; ")
 	}
 
@@ -1650,18 +2062,60 @@ func stringifyContext(gammaNameTypesCtx NamesTypesCtx) string {
 }
 
 // Enforce linearity, i.e. ensure that there are no variables left in Gamma
-func linearGammaContext(gammaNameTypesCtx NamesTypesCtx) error {
-	// todo change to allow weakenable variables (although drop prevents this)
-	if len(gammaNameTypesCtx) == 1 {
-		return fmt.Errorf("linearity requires that no names are left behind, however there is one names (%s) left", stringifyContext(gammaNameTypesCtx))
-	} else if len(gammaNameTypesCtx) > 1 {
-		return fmt.Errorf("linearity requires that no names are left behind, however there were %d names (%s) left", len(gammaNameTypesCtx), stringifyContext(gammaNameTypesCtx))
+func linearGammaContext(form Form, gammaNameTypesCtx NamesTypesCtx, globalEnv *GlobalEnvironment) error {
+	// Names which can be weakened are dropped implicitly (see elaborateDrops), so only the rest need to be reported
+	leftover := make(NamesTypesCtx)
+	var dropped []Name
+	for ident, nameType := range gammaNameTypesCtx {
+		if nameType.Type != nil && types.IsWeakenable(nameType.Type) {
+			dropped = append(dropped, Name{Ident: ident, Type: nameType.Type, Position: nameType.Name.Position})
+		} else {
+			leftover[ident] = nameType
+		}
+	}
+
+	if len(leftover) == 1 {
+		return fmt.Errorf("linearity requires that no names are left behind, however there is one names (%s) left", stringifyContext(leftover))
+	} else if len(leftover) > 1 {
+		return fmt.Errorf("linearity requires that no names are left behind, however there were %d names (%s) left", len(leftover), stringifyContext(leftover))
 	}
 
+	globalEnv.recordImplicitDrops(form, dropped)
+
 	// Ok, no unwanted variables left in gamma
 	return nil
 }
 
+// Describes where the expected and found types differ, following the unfolded labels
+func explainMismatch(expected, found types.SessionType, labelledTypesEnv types.LabelledTypesEnv) string {
+	mismatch := types.ExplainTypeInconsistency(expected, found, labelledTypesEnv)
+	if mismatch == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("; difference (expected vs found): %s", mismatch.String())
+}
+
+// Similar to explainMismatch, but for a name of type found which is used where its supertype is expected
+func explainSubtypeMismatch(found, expected types.SessionType, labelledTypesEnv types.LabelledTypesEnv) string {
+	mismatch := types.ExplainSubtypeInconsistency(found, expected, labelledTypesEnv)
+	if mismatch == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("; difference (found vs expected): %s", mismatch.String())
+}
+
+// The labels matched by the branches of a case, in order
+func (p *CaseForm) labels() []string {
+	labels := make([]string, len(p.branches))
+	for i, branch := range p.branches {
+		labels[i] = branch.label.L
+	}
+
+	return labels
+}
+
 // Compares the given labels with the ones offered by the branches. Returns the unused ones
 func extractUnusedLabels(branches []types.Option, labels map[string]bool) string {
 	// One or more branches are not exhausted
@@ -0,0 +1,272 @@
+package process
+
+import (
+	"fmt"
+	"grits/position"
+	"grits/types"
+	"strings"
+)
+
+// Warnings are reported by the typechecker for programs that typecheck, but which are likely to contain a mistake.
+// Each kind of warning can be enabled or disabled separately (see ParseWarningOptions).
+type WarningKind string
+
+const (
+	// A function which is never called
+	UNUSED_FUNCTION WarningKind = "unused-function"
+	// A type definition which is never referred to
+	UNUSED_TYPE WarningKind = "unused-type"
+	// A case branch on a label which can never be selected, since its continuation type is empty
+	UNREACHABLE_BRANCH WarningKind = "unreachable-branch"
+	// An affine or replicable name which is discarded without an explicit drop (a drop is inserted by elaborateDrops)
+	IMPLICIT_DROP WarningKind = "implicit-drop"
+	// An assumed name (assuming x : A) which is never used by any process
+	UNUSED_ASSUMPTION WarningKind = "unused-assumption"
+	// A data type (e.g. +{...}) whose values can never be finished, e.g. type s = +{more : s}
+	UNFINISHED_TYPE WarningKind = "unfinished-type"
+	// A type whose clients can never be done with it, since it has no finite values and its mode does not allow
+	// dropping it, e.g. type s = lin &{next : s}
+	UNUSABLE_TYPE WarningKind = "unusable-type"
+	// A recursive function which is neither terminating nor productive (only when GlobalEnvironment.CheckTermination is set)
+	NON_TERMINATION WarningKind = "non-termination"
+)
+
+var AllWarningKinds = []WarningKind{UNUSED_FUNCTION, UNUSED_TYPE, UNREACHABLE_BRANCH, IMPLICIT_DROP, UNUSED_ASSUMPTION, UNFINISHED_TYPE, UNUSABLE_TYPE, NON_TERMINATION}
+
+// Programs are often collections of definitions which are not all used (e.g. the examples), so unused definitions are
+// only reported when asked for (e.g. -W unused-function or -W all)
+var disabledByDefault = map[WarningKind]bool{UNUSED_FUNCTION: true, UNUSED_TYPE: true}
+
+type Warning struct {
+	Kind     WarningKind
+	Position position.Position
+	Message  string
+}
+
+// E.g. (Line 4) warning: function 'f' is never used [-W unused-function]
+func (w Warning) String() string {
+	if w.Position.StartLine > 0 {
+		return fmt.Sprintf("(%s) warning: %s [-W %s]", w.Position.String(), w.Message, w.Kind)
+	}
+
+	return fmt.Sprintf("warning: %s [-W %s]", w.Message, w.Kind)
+}
+
+// By default (i.e. the zero value), all warnings except those in disabledByDefault are reported, and none of them are
+// treated as errors. Disabled only holds the kinds which have been explicitly enabled (false) or disabled (true).
+type WarningOptions struct {
+	Disabled map[WarningKind]bool
+	AsErrors bool
+}
+
+func (o WarningOptions) isEnabled(kind WarningKind) bool {
+	if disabled, ok := o.Disabled[kind]; ok {
+		return !disabled
+	}
+
+	return !disabledByDefault[kind]
+}
+
+// ParseWarningOptions interprets the -W options, which are applied in order:
+// -> all           enable all warnings
+// -> none          disable all warnings
+// -> error         treat warnings as errors (no-error reverts this)
+// -> <kind>        enable a kind of warning, e.g. unused-function
+// -> no-<kind>     disable a kind of warning, e.g. no-unused-function
+func ParseWarningOptions(options []string) (WarningOptions, error) {
+	result := WarningOptions{Disabled: make(map[WarningKind]bool)}
+
+	for _, option := range options {
+		switch option {
+		case "all":
+			for _, kind := range AllWarningKinds {
+				result.Disabled[kind] = false
+			}
+		case "none":
+			for _, kind := range AllWarningKinds {
+				result.Disabled[kind] = true
+			}
+		case "error":
+			result.AsErrors = true
+		case "no-error":
+			result.AsErrors = false
+		default:
+			kind, disable := WarningKind(option), false
+			if strings.HasPrefix(option, "no-") {
+				kind, disable = WarningKind(strings.TrimPrefix(option, "no-")), true
+			}
+
+			if !isWarningKind(kind) {
+				return result, fmt.Errorf("unknown warning option '%s' (expected all, none, error or one of: %s)", option, warningKindsString())
+			}
+
+			result.Disabled[kind] = disable
+		}
+	}
+
+	return result, nil
+}
+
+func isWarningKind(kind WarningKind) bool {
+	for _, k := range AllWarningKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func warningKindsString() string {
+	kinds := make([]string, len(AllWarningKinds))
+	for i, kind := range AllWarningKinds {
+		kinds[i] = string(kind)
+	}
+
+	return strings.Join(kinds, ", ")
+}
+
+// Records a warning, unless its kind is disabled
+func (globalEnv *GlobalEnvironment) warnf(kind WarningKind, pos position.Position, message string, args ...interface{}) {
+	if !globalEnv.WarningOptions.isEnabled(kind) {
+		return
+	}
+
+	globalEnv.Warnings = append(globalEnv.Warnings, Warning{Kind: kind, Position: pos, Message: fmt.Sprintf(message, args...)})
+}
+
+// When warnings are treated as errors, they are all combined into a single error
+func (globalEnv *GlobalEnvironment) warningsAsError() error {
+	if !globalEnv.WarningOptions.AsErrors || len(globalEnv.Warnings) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(globalEnv.Warnings))
+	for i, warning := range globalEnv.Warnings {
+		messages[i] = warning.String()
+	}
+
+	return fmt.Errorf("warnings treated as errors:\n%s", strings.Join(messages, "\n"))
+}
+
+// Data types are built by their providers, so a data type without finite values is likely missing a base case.
+// Infinite types in modes which cannot be dropped (e.g. linear) are reported whether or not they are data types.
+func warnUnfinishedTypes(globalEnv *GlobalEnvironment) {
+	labelledTypesEnv := types.ProduceLabelledSessionTypeEnvironment(*globalEnv.Types)
+	inhabitation := types.ClassifyTypeDefinitions(labelledTypesEnv)
+
+	for _, def := range *globalEnv.Types {
+		if types.IsUnusableType(def.Name, inhabitation, labelledTypesEnv) {
+			globalEnv.warnf(UNUSABLE_TYPE, def.Position, "type '%s' has no finite values and its mode (%s) does not allow dropping it, so its clients can never be done with it", def.Name, def.SessionType.Modality().FullString())
+			continue
+		}
+
+		if !types.IsDataType(def.SessionType, labelledTypesEnv) {
+			continue
+		}
+
+		switch inhabitation[def.Name] {
+		case types.INFINITE:
+			globalEnv.warnf(UNFINISHED_TYPE, def.Position, "type '%s' has no finite values, so a process providing it can never terminate", def.Name)
+		case types.EMPTY:
+			globalEnv.warnf(UNFINISHED_TYPE, def.Position, "type '%s' has no values", def.Name)
+		}
+	}
+}
+
+///////////////////////////////////////////////////////////
+///////////////// Unused definitions //////////////////////
+///////////////////////////////////////////////////////////
+
+// Finds the functions and types which are never referred to. This has to be done before typechecking the bodies,
+// since the typechecker unfolds the types set in the names.
+// Recursive references (e.g. a function calling itself) do not count as uses.
+func warnUnusedDefinitions(processes []*Process, assumedFreeNames []Name, globalEnv *GlobalEnvironment) {
+	calledFunctions := make(map[string]bool)
+	usedTypes := make(map[string]bool)
+
+	useTypesOfForm := func(form Form, owner string) {
+		for _, f := range AllForms(form) {
+			switch q := f.(type) {
+			case *CallForm:
+				if q.functionName != owner {
+					calledFunctions[q.functionName] = true
+				}
+			case *NewForm:
+				if q.new_name_c.Type != nil {
+					addTypeLabels(q.new_name_c.Type, "", usedTypes)
+				}
+			}
+		}
+	}
+
+	for _, p := range processes {
+		if p.Type != nil {
+			addTypeLabels(p.Type, "", usedTypes)
+		}
+		useTypesOfForm(p.Body, "")
+	}
+
+	for _, name := range assumedFreeNames {
+		if name.Type != nil {
+			addTypeLabels(name.Type, "", usedTypes)
+		}
+	}
+
+	for _, f := range *globalEnv.FunctionDefinitions {
+		for _, param := range f.Parameters {
+			if param.Type != nil {
+				addTypeLabels(param.Type, "", usedTypes)
+			}
+		}
+		if f.Type != nil {
+			addTypeLabels(f.Type, "", usedTypes)
+		}
+		useTypesOfForm(f.Body, f.FunctionName)
+	}
+
+	for _, def := range *globalEnv.Types {
+		addTypeLabels(def.SessionType, def.Name, usedTypes)
+	}
+
+	for _, f := range *globalEnv.FunctionDefinitions {
+		if !calledFunctions[f.FunctionName] {
+			globalEnv.warnf(UNUSED_FUNCTION, f.Position, "function '%s' is never used", f.FunctionName)
+		}
+	}
+
+	for _, def := range *globalEnv.Types {
+		// Not every pair of roles in a global type needs to interact, so its projections are not reported
+		if !usedTypes[def.Name] && def.ProjectedFrom == "" {
+			globalEnv.warnf(UNUSED_TYPE, def.Position, "type '%s' is never used", def.Name)
+		}
+	}
+}
+
+// Marks the labelled types referred to by t as used, except for the one being defined (i.e. owner)
+func addTypeLabels(t types.SessionType, owner string, usedTypes map[string]bool) {
+	switch q := t.(type) {
+	case *types.LabelType:
+		if q.Label != owner {
+			usedTypes[q.Label] = true
+		}
+	case *types.SendType:
+		addTypeLabels(q.Left, owner, usedTypes)
+		addTypeLabels(q.Right, owner, usedTypes)
+	case *types.ReceiveType:
+		addTypeLabels(q.Left, owner, usedTypes)
+		addTypeLabels(q.Right, owner, usedTypes)
+	case *types.SelectLabelType:
+		for _, option := range q.Branches {
+			addTypeLabels(option.SessionType, owner, usedTypes)
+		}
+	case *types.BranchCaseType:
+		for _, option := range q.Branches {
+			addTypeLabels(option.SessionType, owner, usedTypes)
+		}
+	case *types.UpType:
+		addTypeLabels(q.Continuation, owner, usedTypes)
+	case *types.DownType:
+		addTypeLabels(q.Continuation, owner, usedTypes)
+	}
+}
@@ -0,0 +1,116 @@
+package types
+
+import "bytes"
+
+// FormatType prints a type as written in the source, so that it can be parsed back into the same type.
+// Unlike String(), brackets are added where needed (e.g. (A * B) * C) and the outer modality is kept.
+// It is meant for types as produced by the parser, i.e. before their modalities are inferred.
+func FormatType(t SessionType) string {
+	var buffer bytes.Buffer
+	bracketed := false
+
+	switch t.(type) {
+	case *UpType, *DownType:
+		// Shifts define their own modalities
+	default:
+		if mode := t.Modality(); mode != nil {
+			if _, unset := mode.(*UnsetMode); !unset {
+				buffer.WriteString(formatMode(mode))
+				buffer.WriteString(" ")
+				// Not needed, but clearer, e.g. lin (A -* B)
+				bracketed = isBinaryType(t)
+			}
+		}
+	}
+
+	formatInnerType(t, bracketed, &buffer)
+	return buffer.String()
+}
+
+// Send, receive and shift types extend as far to the right as possible, so they are bracketed when used on the left
+func formatInnerType(t SessionType, bracketed bool, buffer *bytes.Buffer) {
+	switch q := t.(type) {
+	case *LabelType:
+		buffer.WriteString(q.Label)
+	case *UnitType:
+		buffer.WriteString("1")
+	case *DynamicType:
+		buffer.WriteString("?")
+	case *SendType:
+		formatBinaryType(q.Left, " * ", q.Right, bracketed, buffer)
+	case *ReceiveType:
+		formatBinaryType(q.Left, " -* ", q.Right, bracketed, buffer)
+	case *SelectLabelType:
+		buffer.WriteString("+{")
+		formatOptions(q.Branches, buffer)
+		buffer.WriteString("}")
+	case *BranchCaseType:
+		buffer.WriteString("&{")
+		formatOptions(q.Branches, buffer)
+		buffer.WriteString("}")
+	case *UpType:
+		formatShiftType(q.From, " /\\ ", q.To, q.Continuation, bracketed, buffer)
+	case *DownType:
+		formatShiftType(q.From, " \\/ ", q.To, q.Continuation, bracketed, buffer)
+	}
+}
+
+func formatBinaryType(left SessionType, operator string, right SessionType, bracketed bool, buffer *bytes.Buffer) {
+	if bracketed {
+		buffer.WriteString("(")
+	}
+
+	formatInnerType(left, true, buffer)
+	buffer.WriteString(operator)
+	formatInnerType(right, false, buffer)
+
+	if bracketed {
+		buffer.WriteString(")")
+	}
+}
+
+func formatShiftType(from Modality, operator string, to Modality, continuation SessionType, bracketed bool, buffer *bytes.Buffer) {
+	if bracketed {
+		buffer.WriteString("(")
+	}
+
+	buffer.WriteString(formatMode(from))
+	buffer.WriteString(operator)
+	buffer.WriteString(formatMode(to))
+	buffer.WriteString(" ")
+	formatInnerType(continuation, isBinaryType(continuation), buffer)
+
+	if bracketed {
+		buffer.WriteString(")")
+	}
+}
+
+func formatOptions(options []Option, buffer *bytes.Buffer) {
+	for i, option := range options {
+		buffer.WriteString(option.Label)
+		buffer.WriteString(" : ")
+		formatInnerType(option.SessionType, false, buffer)
+
+		if i < len(options)-1 {
+			buffer.WriteString(", ")
+		}
+	}
+}
+
+// Invalid modes are kept as written by the user (they are reported by the typechecker)
+func formatMode(mode Modality) string {
+	if invalid, ok := mode.(*InvalidMode); ok {
+		return invalid.mode
+	}
+
+	return mode.String()
+}
+
+func isBinaryType(t SessionType) bool {
+	switch t.(type) {
+	case *SendType, *ReceiveType:
+		return true
+	}
+
+	return false
+}
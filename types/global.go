@@ -0,0 +1,469 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"grits/position"
+	"strings"
+)
+
+// Global types describe the interaction between several participants (roles) as a whole, e.g.
+//
+//	global trade = buyer -> seller {request(item) : seller -> buyer {quote : end}}
+//
+// Each message is sent from one role to another, and consists of a label chosen from the branches, optionally
+// carrying a name of some (binary) session type. A global type is projected onto each pair of roles, producing the
+// binary session type of the channel between them (named <global>_<role1>_<role2>, see ProjectionName).
+//
+// Roles are ordered by their first appearance in the global type, and the later role of each pair provides the
+// channel. Hence, a role is only a client of the roles appearing after it, so the processes implementing the roles
+// never depend on each other in a cycle.
+type GlobalTypeDefinition struct {
+	Name       string
+	GlobalType GlobalType
+	Position   position.Position
+}
+
+type GlobalType interface {
+	String() string
+}
+
+// Interaction: from -> to {label1(T1) : G1, label2 : G2, ...}
+type GlobalInteraction struct {
+	From     string
+	To       string
+	Branches []GlobalBranch
+}
+
+type GlobalBranch struct {
+	Label string
+	// Type of the name sent along with the label (nil if only the label is sent)
+	Payload      SessionType
+	Continuation GlobalType
+}
+
+// End of the interaction: end
+type GlobalEnd struct{}
+
+// Reference to a global type (e.g. for recursion)
+type GlobalReference struct {
+	Name string
+}
+
+func NewGlobalInteraction(from, to string, branches []GlobalBranch) *GlobalInteraction {
+	return &GlobalInteraction{From: from, To: to, Branches: branches}
+}
+
+func NewGlobalBranch(label string, payload SessionType, continuation GlobalType) *GlobalBranch {
+	return &GlobalBranch{Label: label, Payload: payload, Continuation: continuation}
+}
+
+func NewGlobalEnd() *GlobalEnd {
+	return &GlobalEnd{}
+}
+
+func NewGlobalReference(name string) *GlobalReference {
+	return &GlobalReference{Name: name}
+}
+
+func (q *GlobalInteraction) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(q.From)
+	buffer.WriteString(" -> ")
+	buffer.WriteString(q.To)
+	buffer.WriteString(" {")
+	for i, branch := range q.Branches {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(branch.String())
+	}
+	buffer.WriteString("}")
+
+	return buffer.String()
+}
+
+// E.g. request(item) : G
+func (q *GlobalBranch) String() string {
+	return q.Header() + " : " + q.Continuation.String()
+}
+
+// The label along with its payload type, e.g. request(item)
+func (q *GlobalBranch) Header() string {
+	if q.Payload == nil {
+		return q.Label
+	}
+
+	return fmt.Sprintf("%s(%s)", q.Label, FormatType(q.Payload))
+}
+
+func (q *GlobalEnd) String() string {
+	return "end"
+}
+
+func (q *GlobalReference) String() string {
+	return q.Name
+}
+
+// Name of the binary session type projected onto a pair of roles, given in order of appearance
+func ProjectionName(global, role1, role2 string) string {
+	return fmt.Sprintf("%s_%s_%s", global, role1, role2)
+}
+
+// The roles taking part in a global type, in order of their first appearance (referenced global types aside)
+func (def *GlobalTypeDefinition) Roles() []string {
+	var roles []string
+	seen := make(map[string]bool)
+
+	var visit func(g GlobalType)
+	visit = func(g GlobalType) {
+		interaction, ok := g.(*GlobalInteraction)
+		if !ok {
+			return
+		}
+
+		for _, role := range []string{interaction.From, interaction.To} {
+			if !seen[role] {
+				seen[role] = true
+				roles = append(roles, role)
+			}
+		}
+
+		for _, branch := range interaction.Branches {
+			visit(branch.Continuation)
+		}
+	}
+
+	visit(def.GlobalType)
+	return roles
+}
+
+/////////////////////////////////////////////////////
+//////////////////// Projection /////////////////////
+/////////////////////////////////////////////////////
+
+// ProjectGlobalTypes checks that the global types are well formed, and projects each of them onto every pair of its
+// roles. The resulting type definitions are returned, to be added to the other type definitions (typeDefs).
+//
+// An interaction between the pair projects to a choice: +{...} if sent by the provider, or &{...} if sent by the
+// client (where a payload of type T continues as T * A or T -* A respectively). Any other interaction is invisible
+// to the pair, so its branches have to agree on what happens between them. Branches may only differ if one of the
+// pair takes part in the interaction, and it makes the difference known by sending (or receiving) distinct labels
+// (i.e. the choices are merged).
+func ProjectGlobalTypes(globals []GlobalTypeDefinition, typeDefs []SessionTypeDefinition) ([]SessionTypeDefinition, error) {
+	definedTypes := make(map[string]bool)
+	for _, def := range typeDefs {
+		definedTypes[def.Name] = true
+	}
+
+	globalsByName := make(map[string]*GlobalTypeDefinition)
+	roles := make(map[string][]string)
+	for i := range globals {
+		def := &globals[i]
+		if _, exists := globalsByName[def.Name]; exists {
+			return nil, fmt.Errorf("(%s) global type '%s' is defined more than once", def.Position.String(), def.Name)
+		}
+
+		globalsByName[def.Name] = def
+		roles[def.Name] = def.Roles()
+	}
+
+	var projections []SessionTypeDefinition
+	var obligations []projectionObligation
+
+	for i := range globals {
+		def := &globals[i]
+		if err := checkGlobalType(def.GlobalType, globalsByName); err != nil {
+			return nil, fmt.Errorf("(%s) in global type '%s', %s", def.Position.String(), def.Name, err)
+		}
+
+		if len(roles[def.Name]) < 2 {
+			return nil, fmt.Errorf("(%s) global type '%s' needs at least two roles", def.Position.String(), def.Name)
+		}
+
+		defRoles := roles[def.Name]
+		for c := range defRoles {
+			for p := c + 1; p < len(defRoles); p++ {
+				name := ProjectionName(def.Name, defRoles[c], defRoles[p])
+				if definedTypes[name] {
+					return nil, fmt.Errorf("(%s) the projection of global type '%s' onto %s and %s clashes with the type '%s'", def.Position.String(), def.Name, defRoles[c], defRoles[p], name)
+				}
+				definedTypes[name] = true
+
+				projector := &projector{def: def, client: defRoles[c], provider: defRoles[p], roles: roles}
+				sessionType, err := projector.project(def.GlobalType)
+				if err != nil {
+					return nil, fmt.Errorf("(%s) global type '%s' cannot be projected onto %s and %s: %s", def.Position.String(), def.Name, defRoles[c], defRoles[p], err)
+				}
+
+				projections = append(projections, SessionTypeDefinition{Name: name, SessionType: sessionType, Position: def.Position, ProjectedFrom: def.Name})
+				obligations = append(obligations, projector.obligations...)
+			}
+		}
+	}
+
+	if err := checkProjectionObligations(obligations, append(append([]SessionTypeDefinition{}, typeDefs...), projections...)); err != nil {
+		return nil, err
+	}
+
+	return projections, nil
+}
+
+// Roles may not send messages to themselves, labels have to be unique and references have to be defined
+func checkGlobalType(g GlobalType, globalsByName map[string]*GlobalTypeDefinition) error {
+	switch q := g.(type) {
+	case *GlobalReference:
+		if _, exists := globalsByName[q.Name]; !exists {
+			return fmt.Errorf("the global type '%s' is not defined", q.Name)
+		}
+	case *GlobalInteraction:
+		if q.From == q.To {
+			return fmt.Errorf("role '%s' cannot send a message to itself (%s -> %s)", q.From, q.From, q.To)
+		}
+
+		labels := make(map[string]bool)
+		for _, branch := range q.Branches {
+			if labels[branch.Label] {
+				return fmt.Errorf("the label '%s' is used more than once in %s -> %s", branch.Label, q.From, q.To)
+			}
+			labels[branch.Label] = true
+
+			if err := checkGlobalType(branch.Continuation, globalsByName); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Branches of an interaction which are invisible to a pair of roles have to project to equal types. These are
+// only compared once all projections are known, since they may refer to each other.
+type projectionObligation struct {
+	def          *GlobalTypeDefinition
+	client       string
+	provider     string
+	type1, type2 SessionType
+	reason       string
+}
+
+type projector struct {
+	def              *GlobalTypeDefinition
+	client, provider string
+	roles            map[string][]string
+	obligations      []projectionObligation
+	// Whether the pair has interacted since the start of the global type (on the current path)
+	guarded bool
+}
+
+func (p *projector) project(g GlobalType) (SessionType, error) {
+	switch q := g.(type) {
+	case *GlobalEnd:
+		return NewUnitType(NewUnsetMode()), nil
+	case *GlobalReference:
+		return p.projectReference(q)
+	case *GlobalInteraction:
+		if (q.From == p.client && q.To == p.provider) || (q.From == p.provider && q.To == p.client) {
+			return p.projectMessage(q)
+		}
+
+		// The interaction is not visible to the pair, so the branches are merged
+		knower := ""
+		if q.From == p.client || q.To == p.client {
+			knower = p.client
+		} else if q.From == p.provider || q.To == p.provider {
+			knower = p.provider
+		}
+
+		var result SessionType
+		for _, branch := range q.Branches {
+			sessionType, err := p.project(branch.Continuation)
+			if err != nil {
+				return nil, err
+			}
+
+			if result == nil {
+				result = sessionType
+			} else {
+				result = p.merge(result, sessionType, knower, q)
+			}
+		}
+
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("unknown global type '%s'", g.String())
+}
+
+// A message between the pair of roles, seen from the provider's side
+func (p *projector) projectMessage(q *GlobalInteraction) (SessionType, error) {
+	sentByProvider := q.From == p.provider
+
+	guarded := p.guarded
+	p.guarded = true
+	defer func() { p.guarded = guarded }()
+
+	options := make([]Option, len(q.Branches))
+	for i, branch := range q.Branches {
+		continuation, err := p.project(branch.Continuation)
+		if err != nil {
+			return nil, err
+		}
+
+		if branch.Payload != nil && sentByProvider {
+			continuation = NewSendType(CopyType(branch.Payload), continuation, NewUnsetMode())
+		} else if branch.Payload != nil {
+			continuation = NewReceiveType(CopyType(branch.Payload), continuation, NewUnsetMode())
+		}
+
+		options[i] = *NewOption(branch.Label, continuation)
+	}
+
+	if sentByProvider {
+		return NewSelectLabelType(options, NewUnsetMode()), nil
+	}
+
+	return NewBranchCaseType(options, NewUnsetMode()), nil
+}
+
+// A reference continues as the projection of the referenced global type onto the same pair. If one of the roles is
+// not part of it (or the global type recurses without any interaction between the pair), the pair does not interact
+// anymore.
+func (p *projector) projectReference(q *GlobalReference) (SessionType, error) {
+	if q.Name == p.def.Name && !p.guarded {
+		return NewUnitType(NewUnsetMode()), nil
+	}
+
+	clientIndex, providerIndex := -1, -1
+	for i, role := range p.roles[q.Name] {
+		if role == p.client {
+			clientIndex = i
+		} else if role == p.provider {
+			providerIndex = i
+		}
+	}
+
+	if clientIndex == -1 || providerIndex == -1 {
+		return NewUnitType(NewUnsetMode()), nil
+	}
+
+	if clientIndex > providerIndex {
+		return nil, fmt.Errorf("the roles appear in the opposite order in the global type '%s', so the provider of their channel would change", q.Name)
+	}
+
+	return NewLabelType(ProjectionName(q.Name, p.client, p.provider), NewUnsetMode()), nil
+}
+
+// Merges the projections of two branches of an interaction (q) which the pair does not take part in. The knower is
+// the role of the pair taking part in q (if any): the choices made by this role may differ between the branches.
+func (p *projector) merge(type1, type2 SessionType, knower string, q *GlobalInteraction) SessionType {
+	if knower != "" {
+		choiceByKnower := false
+		var options1, options2 []Option
+
+		switch t1 := type1.(type) {
+		case *SelectLabelType:
+			if t2, ok := type2.(*SelectLabelType); ok && knower == p.provider {
+				choiceByKnower, options1, options2 = true, t1.Branches, t2.Branches
+			}
+		case *BranchCaseType:
+			if t2, ok := type2.(*BranchCaseType); ok && knower == p.client {
+				choiceByKnower, options1, options2 = true, t1.Branches, t2.Branches
+			}
+		case *SendType:
+			if t2, ok := type2.(*SendType); ok {
+				p.mustEqual(t1.Left, t2.Left, knower, q)
+				return NewSendType(t1.Left, p.merge(t1.Right, t2.Right, knower, q), t1.Mode)
+			}
+		case *ReceiveType:
+			if t2, ok := type2.(*ReceiveType); ok {
+				p.mustEqual(t1.Left, t2.Left, knower, q)
+				return NewReceiveType(t1.Left, p.merge(t1.Right, t2.Right, knower, q), t1.Mode)
+			}
+		}
+
+		if choiceByKnower {
+			merged := append([]Option{}, options1...)
+			for _, option := range options2 {
+				index := -1
+				for i := range merged {
+					if merged[i].Label == option.Label {
+						index = i
+					}
+				}
+
+				if index == -1 {
+					merged = append(merged, option)
+				} else {
+					merged[index] = *NewOption(option.Label, p.merge(merged[index].SessionType, option.SessionType, knower, q))
+				}
+			}
+
+			if knower == p.provider {
+				return NewSelectLabelType(merged, NewUnsetMode())
+			}
+			return NewBranchCaseType(merged, NewUnsetMode())
+		}
+	}
+
+	p.mustEqual(type1, type2, knower, q)
+	return type1
+}
+
+func (p *projector) mustEqual(type1, type2 SessionType, knower string, q *GlobalInteraction) {
+	var reason string
+	if knower == "" {
+		reason = fmt.Sprintf("neither of them takes part in %s -> %s, so their interaction cannot depend on which branch is taken", q.From, q.To)
+	} else {
+		other := p.client
+		if knower == p.client {
+			other = p.provider
+		}
+		reason = fmt.Sprintf("only %s knows which branch of %s -> %s is taken, so it has to pass on a different label to %s before their interaction differs", knower, q.From, q.To, other)
+	}
+
+	p.obligations = append(p.obligations, projectionObligation{def: p.def, client: p.client, provider: p.provider, type1: type1, type2: type2, reason: reason})
+}
+
+// The types are compared after setting their modalities (on copies, so the type definitions remain untouched)
+func checkProjectionObligations(obligations []projectionObligation, typeDefs []SessionTypeDefinition) error {
+	copies := make([]SessionTypeDefinition, len(typeDefs))
+	for i, def := range typeDefs {
+		copies[i] = SessionTypeDefinition{Name: def.Name, SessionType: CopyType(def.SessionType), Position: def.Position, ProjectedFrom: def.ProjectedFrom}
+	}
+
+	SetModalityTypeDef(copies)
+	labelledTypesEnv := ProduceLabelledSessionTypeEnvironment(copies)
+
+	for _, def := range copies {
+		if CheckTypeWellFormedness(def.SessionType, labelledTypesEnv) != nil {
+			// Errors in the types themselves are reported by the typechecker
+			return nil
+		}
+	}
+
+	// A role which keeps on going without ever interacting with the other one leaves their projection undefined
+	for _, def := range copies {
+		if def.ProjectedFrom != "" && !def.SessionType.isContractive(labelledTypesEnv, make(map[string]bool)) {
+			return fmt.Errorf("(%s) global type '%s' cannot be projected onto the pair of roles of '%s', since the projection keeps unfolding to other labels: %s", def.Position.String(), def.ProjectedFrom, def.Name, strings.Join(labelCycle(def.Name, labelledTypesEnv), " → "))
+		}
+	}
+
+	for _, def := range copies {
+		if !def.SessionType.isContractive(labelledTypesEnv, make(map[string]bool)) {
+			return nil
+		}
+	}
+
+	for _, o := range obligations {
+		type1, type2 := CopyType(o.type1), CopyType(o.type2)
+		AddMissingModalities(&type1, labelledTypesEnv)
+		AddMissingModalities(&type2, labelledTypesEnv)
+
+		if mismatch := ExplainTypeMismatch(type1, type2, labelledTypesEnv); mismatch != nil {
+			return fmt.Errorf("(%s) global type '%s' cannot be projected onto %s and %s: %s (difference: %s)", o.def.Position.String(), o.def.Name, o.client, o.provider, o.reason, mismatch.String())
+		}
+	}
+
+	return nil
+}
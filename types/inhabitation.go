@@ -0,0 +1,184 @@
+package types
+
+// IsEmptyType returns true if no process can provide the given type, i.e. every way of providing it eventually
+// requires a choice from an empty internal choice (+{}).
+// Recursive types are conservatively considered to be inhabited.
+func IsEmptyType(t SessionType, labelledTypesEnv LabelledTypesEnv) bool {
+	return isEmptyType(t, make(map[string]bool), labelledTypesEnv)
+}
+
+func isEmptyType(t SessionType, visitedLabels map[string]bool, labelledTypesEnv LabelledTypesEnv) bool {
+	switch q := t.(type) {
+	case *LabelType:
+		if visitedLabels[q.Label] {
+			return false
+		}
+
+		def, exists := labelledTypesEnv[q.Label]
+		if !exists {
+			return false
+		}
+
+		visitedLabels[q.Label] = true
+		empty := isEmptyType(def.Type, visitedLabels, labelledTypesEnv)
+		delete(visitedLabels, q.Label)
+		return empty
+	case *UnitType:
+		return false
+	case *SendType:
+		// Both the payload and the continuation have to be provided
+		return isEmptyType(q.Left, visitedLabels, labelledTypesEnv) || isEmptyType(q.Right, visitedLabels, labelledTypesEnv)
+	case *ReceiveType:
+		// Receiving a name of an empty type is impossible, so the continuation is never reached
+		return isEmptyType(q.Right, visitedLabels, labelledTypesEnv) && !isEmptyType(q.Left, visitedLabels, labelledTypesEnv)
+	case *SelectLabelType:
+		// One of the options has to be selected
+		for _, option := range q.Branches {
+			if !isEmptyType(option.SessionType, visitedLabels, labelledTypesEnv) {
+				return false
+			}
+		}
+		return true
+	case *BranchCaseType:
+		// All options have to be offered, so a single empty one is enough
+		for _, option := range q.Branches {
+			if isEmptyType(option.SessionType, visitedLabels, labelledTypesEnv) {
+				return true
+			}
+		}
+		return false
+	case *UpType:
+		return isEmptyType(q.Continuation, visitedLabels, labelledTypesEnv)
+	case *DownType:
+		return isEmptyType(q.Continuation, visitedLabels, labelledTypesEnv)
+	}
+
+	return false
+}
+
+// Inhabitation classifies the values of a type
+type Inhabitation int
+
+const (
+	// Some value can be provided by a process which eventually terminates, e.g. zero for +{zero : 1, succ : nat}
+	FINITE Inhabitation = iota
+	// All values are infinite, e.g. type stream = &{next : stream}
+	INFINITE
+	// No values at all, e.g. +{}
+	EMPTY
+)
+
+var InhabitationMap = map[Inhabitation]string{
+	FINITE:   "finite",
+	INFINITE: "infinite",
+	EMPTY:    "empty",
+}
+
+// ClassifyTypeDefinitions determines whether each labelled type is finitely inhabited, only infinitely inhabited (i.e.
+// a stream) or empty.
+// The finite labelled types are found as a least fixed point: initially none are known to be finite, and each pass
+// marks the types which can be finished using the ones found so far.
+func ClassifyTypeDefinitions(labelledTypesEnv LabelledTypesEnv) map[string]Inhabitation {
+	finiteLabels := make(map[string]bool)
+
+	for changed := true; changed; {
+		changed = false
+		for label, def := range labelledTypesEnv {
+			if !finiteLabels[label] && isFiniteType(def.Type, finiteLabels, labelledTypesEnv) {
+				finiteLabels[label] = true
+				changed = true
+			}
+		}
+	}
+
+	result := make(map[string]Inhabitation)
+	for label, def := range labelledTypesEnv {
+		switch {
+		case finiteLabels[label]:
+			result[label] = FINITE
+		case IsEmptyType(def.Type, labelledTypesEnv):
+			result[label] = EMPTY
+		default:
+			result[label] = INFINITE
+		}
+	}
+
+	return result
+}
+
+// IsFinitelyInhabited returns true if some value of the type can be provided in a finite number of steps, given the
+// classification of the labelled types (see ClassifyTypeDefinitions)
+func IsFinitelyInhabited(t SessionType, inhabitation map[string]Inhabitation, labelledTypesEnv LabelledTypesEnv) bool {
+	finiteLabels := make(map[string]bool)
+	for label, i := range inhabitation {
+		finiteLabels[label] = i == FINITE
+	}
+
+	return isFiniteType(t, finiteLabels, labelledTypesEnv)
+}
+
+// IsUnusableType returns true if the clients of a labelled type can never be done with it: its values are all
+// infinite (or it has none), so they cannot be consumed fully, and its mode does not allow weakening (e.g. linear or
+// multicast), so they cannot be dropped either. For example, in type s = lin &{next : s}, each client has to keep
+// selecting next forever.
+// Shifts between modes which can neither be provided nor consumed (e.g. lin /\ aff A, see CanBeUpshiftedTo and
+// CanBeDownshiftedTo) are already rejected by CheckTypeWellFormedness.
+func IsUnusableType(label string, inhabitation map[string]Inhabitation, labelledTypesEnv LabelledTypesEnv) bool {
+	def, exists := labelledTypesEnv[label]
+	if !exists || inhabitation[label] == FINITE {
+		return false
+	}
+
+	mode := def.Type.Modality()
+	if _, unset := mode.(*UnsetMode); unset || mode == nil {
+		return false
+	}
+
+	return !mode.AllowsWeakening()
+}
+
+// Whether the type has a value which can be provided in a finite number of steps, given the labelled types which are
+// already known to be finite
+func isFiniteType(t SessionType, finiteLabels map[string]bool, labelledTypesEnv LabelledTypesEnv) bool {
+	switch q := t.(type) {
+	case *LabelType:
+		return finiteLabels[q.Label]
+	case *UnitType, *DynamicType:
+		// The values of ? are only known at runtime, so it is assumed to have finite ones
+		return true
+	case *SendType:
+		return isFiniteType(q.Left, finiteLabels, labelledTypesEnv) && isFiniteType(q.Right, finiteLabels, labelledTypesEnv)
+	case *ReceiveType:
+		// An empty received name can be pattern matched using an empty case
+		return isFiniteType(q.Right, finiteLabels, labelledTypesEnv) || IsEmptyType(q.Left, labelledTypesEnv)
+	case *SelectLabelType:
+		for _, option := range q.Branches {
+			if isFiniteType(option.SessionType, finiteLabels, labelledTypesEnv) {
+				return true
+			}
+		}
+		return false
+	case *BranchCaseType:
+		// The client may pick any of the options, so each one has to be finished
+		for _, option := range q.Branches {
+			if !isFiniteType(option.SessionType, finiteLabels, labelledTypesEnv) {
+				return false
+			}
+		}
+		return true
+	case *UpType:
+		return isFiniteType(q.Continuation, finiteLabels, labelledTypesEnv)
+	case *DownType:
+		return isFiniteType(q.Continuation, finiteLabels, labelledTypesEnv)
+	}
+
+	return false
+}
+
+// A definition is considered to be data if its (unfolded) type is positive, e.g. +{...} or A * B, since then a
+// value is built by its provider. Negative types such as &{...} are driven by their clients, so infinite ones are
+// expected (e.g. streams).
+func IsDataType(t SessionType, labelledTypesEnv LabelledTypesEnv) bool {
+	unfolded := Unfold(t, labelledTypesEnv)
+	return unfolded != nil && unfolded.Polarity() == POSITIVE
+}
@@ -477,6 +477,10 @@ func (q *UnitType) inferModality(labelledTypesEnv LabelledTypesEnv, usedLabels m
 	return q.Mode
 }
 
+func (q *DynamicType) inferModality(labelledTypesEnv LabelledTypesEnv, usedLabels map[string]bool) Modality {
+	return q.Mode
+}
+
 func (q *SendType) inferModality(labelledTypesEnv LabelledTypesEnv, usedLabels map[string]bool) Modality {
 	_, unset := q.Mode.(*UnsetMode)
 	if !unset {
@@ -593,6 +597,12 @@ func (q *UnitType) assignUnsetModalities(labelledTypesEnv LabelledTypesEnv, curr
 	q.Mode = currentMode
 }
 
+func (q *DynamicType) assignUnsetModalities(labelledTypesEnv LabelledTypesEnv, currentMode Modality) {
+	if _, unset := q.Mode.(*UnsetMode); unset {
+		q.Mode = currentMode
+	}
+}
+
 func (q *SendType) assignUnsetModalities(labelledTypesEnv LabelledTypesEnv, currentMode Modality) {
 	_, unset := q.Mode.(*UnsetMode)
 	if unset {
@@ -682,8 +692,12 @@ func copyMap(orig map[string]bool) map[string]bool {
 }
 
 // Takes a list of modalities, and returns the first non UnsetMode that there is.
-// If all modes are Unset, then it returns Unset
+// If all modes are Unset (or there are none, e.g. for +{}), then it returns Unset
 func commonMode(modes ...Modality) Modality {
+	if len(modes) == 0 {
+		return NewUnsetMode()
+	}
+
 	commonMode := modes[0]
 
 	for _, mode := range modes {
@@ -753,6 +767,25 @@ func (q *UnitType) checkTypeModalities(labelledTypesEnv LabelledTypesEnv, curren
 	return nil
 }
 
+func (q *DynamicType) checkTypeModalities(labelledTypesEnv LabelledTypesEnv, currentMode Modality) error {
+	_, unset := q.Mode.(*UnsetMode)
+	invalidMode, invalid := q.Mode.(*InvalidMode)
+
+	if unset || q.Mode == nil {
+		return fmt.Errorf("type '%s' has no modality defined", q.String())
+	}
+
+	if invalid {
+		return fmt.Errorf("type '%s' has an unknown modality '%s'", q.String(), invalidMode.mode)
+	}
+
+	if !q.Mode.Equals(currentMode) {
+		return fmt.Errorf("mode of dynamic type '%s' (%s) does not match the expected mode '%s'", q.String(), q.Mode.String(), currentMode.String())
+	}
+
+	return nil
+}
+
 func (q *SendType) checkTypeModalities(labelledTypesEnv LabelledTypesEnv, currentMode Modality) error {
 	_, unset := q.Mode.(*UnsetMode)
 	invalidMode, invalid := q.Mode.(*InvalidMode)
@@ -16,6 +16,7 @@ var PolarityMap = map[Polarity]string{
 
 // Positive types: 1, *, +{...}, \/ (downshift)
 // Negative types:   -*, &{...}, /\ (upshift)
+// The dynamic type ? has an unknown polarity
 
 func (q *LabelType) Polarity() Polarity {
 	// todo change to pass labelled environments
@@ -50,3 +51,8 @@ func (q *UpType) Polarity() Polarity {
 func (q *DownType) Polarity() Polarity {
 	return POSITIVE
 }
+
+// The polarity of ? is only known at runtime
+func (q *DynamicType) Polarity() Polarity {
+	return UNKNOWN
+}
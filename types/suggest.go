@@ -0,0 +1,117 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EditDistance computes the (optimal string alignment) distance between two strings, i.e. the least number of
+// single character insertions, deletions, substitutions and transpositions of adjacent characters needed to change a
+// into b
+func EditDistance(a, b string) int {
+	first, second := []rune(a), []rune(b)
+
+	distance := make([][]int, len(first)+1)
+	for i := range distance {
+		distance[i] = make([]int, len(second)+1)
+		distance[i][0] = i
+	}
+	for j := range distance[0] {
+		distance[0][j] = j
+	}
+
+	for i := 1; i <= len(first); i++ {
+		for j := 1; j <= len(second); j++ {
+			cost := 1
+			if first[i-1] == second[j-1] {
+				cost = 0
+			}
+
+			distance[i][j] = min(distance[i-1][j]+1, distance[i][j-1]+1, distance[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && first[i-1] == second[j-2] && first[i-2] == second[j-1] {
+				distance[i][j] = min(distance[i][j], distance[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return distance[len(first)][len(second)]
+}
+
+// ClosestMatches returns the candidates which are close enough to name to be a likely misspelling, closest first.
+// Candidates with the same distance keep their original order.
+func ClosestMatches(name string, candidates []string) []string {
+	// Short names allow for fewer mistakes, and a name is never entirely replaced (e.g. 'a' should not suggest 'b')
+	length := len([]rune(name))
+	threshold := max(1, length/3)
+
+	type match struct {
+		candidate string
+		distance  int
+	}
+
+	var matches []match
+	for _, candidate := range candidates {
+		if candidate == name {
+			continue
+		}
+
+		if distance := EditDistance(name, candidate); distance <= threshold && distance < length {
+			matches = append(matches, match{candidate, distance})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+
+	result := make([]string, len(matches))
+	for i, m := range matches {
+		result[i] = m.candidate
+	}
+
+	return result
+}
+
+// DidYouMean produces a hint such as "; did you mean 'succ'?", or an empty string if there are no close candidates
+func DidYouMean(name string, candidates []string) string {
+	matches := ClosestMatches(name, candidates)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("; did you mean '%s'?", matches[0])
+}
+
+// Lists the labels of the branches, e.g. zero, succ
+func BranchLabels(branches []Option) []string {
+	labels := make([]string, len(branches))
+	for i, branch := range branches {
+		labels[i] = branch.Label
+	}
+
+	return labels
+}
+
+// Explains which labels are allowed, possibly suggesting one which is similar to the given label, e.g.
+// "expected one of the labels zero, succ; did you mean 'succ'?"
+func ExpectedLabelsHint(label string, branches []Option) string {
+	labels := BranchLabels(branches)
+	if len(labels) == 0 {
+		return "the type has no labels"
+	}
+
+	return fmt.Sprintf("expected one of the labels %s%s", strings.Join(labels, ", "), DidYouMean(label, labels))
+}
+
+// Names of the labelled types, sorted to produce deterministic suggestions
+func labelledTypeNames(labelledTypesEnv LabelledTypesEnv) []string {
+	names := make([]string, 0, len(labelledTypesEnv))
+	for name := range labelledTypesEnv {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -2,8 +2,10 @@ package types
 
 import (
 	"bytes"
+	"fmt"
 	"grits/position"
 	"reflect"
+	"strings"
 
 	"golang.org/x/exp/slices"
 )
@@ -13,6 +15,8 @@ type SessionTypeDefinition struct {
 	Name        string
 	Position    position.Position
 	Modality    Modality
+	// Name of the global type this definition was projected from (empty for user defined types)
+	ProjectedFrom string
 }
 
 type SessionType interface {
@@ -397,6 +401,43 @@ func (q *DownType) Modality() Modality {
 	return q.To
 }
 
+// Dynamic: ? (gradual typing)
+// Stands for a type which is only known at runtime, so it is consistent with any other type
+type DynamicType struct {
+	Mode Modality
+}
+
+func NewDynamicType(mode Modality) *DynamicType {
+	return &DynamicType{
+		Mode: mode,
+	}
+}
+
+func (q *DynamicType) String() string {
+	return "?"
+}
+
+func (q *DynamicType) StringWithModality() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	buffer.WriteString(q.Mode.String())
+	buffer.WriteString("]?")
+
+	return buffer.String()
+}
+
+func (q *DynamicType) StringWithOuterModality() string {
+	var buffer bytes.Buffer
+	buffer.WriteString("? [")
+	buffer.WriteString(q.Mode.String())
+	buffer.WriteString("]")
+	return buffer.String()
+}
+
+func (q *DynamicType) Modality() Modality {
+	return q.Mode
+}
+
 // Branch/Case option
 type Option struct {
 	Label       string
@@ -452,20 +493,137 @@ func NewOption(label string, sessionType SessionType) *Option {
 
 // Check for equality
 func EqualType(type1, type2 SessionType, labelledTypesEnv LabelledTypesEnv) bool {
-	return innerEqualType(type1, type2, make(map[string]bool), labelledTypesEnv)
+	return innerEqualType(type1, type2, make(map[string]bool), labelledTypesEnv, false, false, nil)
+}
+
+// TypeMismatch explains why two types are not equal
+type TypeMismatch struct {
+	// Steps leading to the first mismatch, including the labelled types that were unfolded along the way
+	Path []string
+	// What differs at the end of the path
+	Reason string
+}
+
+// E.g. unfold 'nat' → branch 'succ' → right of '*' → mode 'aff' vs 'lin'
+func (m *TypeMismatch) String() string {
+	return strings.Join(append(append([]string{}, m.Path...), m.Reason), " → ")
+}
+
+// ExplainTypeMismatch compares two types like EqualType, but also returns the path to the first mismatch
+// (or nil if the types are equal)
+func ExplainTypeMismatch(type1, type2 SessionType, labelledTypesEnv LabelledTypesEnv) *TypeMismatch {
+	mismatch := &TypeMismatch{}
+	if innerEqualType(type1, type2, make(map[string]bool), labelledTypesEnv, false, false, mismatch) {
+		return nil
+	}
+
+	return mismatch
+}
+
+// ConsistentType is the gradual counterpart of EqualType: the dynamic type ? is consistent with any type, e.g.
+// nat * ? is consistent with ? * 1, but not with 1 -* 1. Otherwise, the types have to match as in EqualType.
+func ConsistentType(type1, type2 SessionType, labelledTypesEnv LabelledTypesEnv) bool {
+	return innerEqualType(type1, type2, make(map[string]bool), labelledTypesEnv, true, false, nil)
+}
+
+// ExplainTypeInconsistency is similar to ExplainTypeMismatch, but compares the types using ConsistentType
+func ExplainTypeInconsistency(type1, type2 SessionType, labelledTypesEnv LabelledTypesEnv) *TypeMismatch {
+	mismatch := &TypeMismatch{}
+	if innerEqualType(type1, type2, make(map[string]bool), labelledTypesEnv, true, false, mismatch) {
+		return nil
+	}
+
+	return mismatch
+}
+
+// Subtype checks whether a name of type1 can be used wherever type2 is expected, e.g. &{a : 1, b : 1} ≤ &{a : 1}
+// (offering more branches) and +{a : 1} ≤ +{a : 1, b : 1} (selecting from fewer labels). Names received
+// (A -* B) are compared contravariantly, and a stronger mode may be used in place of a weaker one. As in EqualType,
+// recursive types are compared coinductively, so a pair of types that is being compared is assumed to be related.
+func Subtype(type1, type2 SessionType, labelledTypesEnv LabelledTypesEnv) bool {
+	return innerEqualType(type1, type2, make(map[string]bool), labelledTypesEnv, false, true, nil)
+}
+
+// ConsistentSubtype combines Subtype with ConsistentType, i.e. ? is related to any type
+func ConsistentSubtype(type1, type2 SessionType, labelledTypesEnv LabelledTypesEnv) bool {
+	return innerEqualType(type1, type2, make(map[string]bool), labelledTypesEnv, true, true, nil)
+}
+
+// ExplainSubtypeInconsistency is similar to ExplainTypeInconsistency, but compares the types using ConsistentSubtype
+func ExplainSubtypeInconsistency(type1, type2 SessionType, labelledTypesEnv LabelledTypesEnv) *TypeMismatch {
+	mismatch := &TypeMismatch{}
+	if innerEqualType(type1, type2, make(map[string]bool), labelledTypesEnv, true, true, mismatch) {
+		return nil
+	}
+
+	return mismatch
+}
+
+// Sets the reason for a mismatch (if an explanation is requested)
+func (m *TypeMismatch) fail(format string, args ...interface{}) bool {
+	if m != nil {
+		m.Reason = fmt.Sprintf(format, args...)
+	}
+
+	return false
+}
+
+// The path is built as the recursion unwinds, so steps are added at the front
+func (m *TypeMismatch) step(equal bool, format string, args ...interface{}) bool {
+	if !equal && m != nil {
+		m.Path = append([]string{fmt.Sprintf(format, args...)}, m.Path...)
+	}
+
+	return equal
+}
+
+func (m *TypeMismatch) equalModes(mode1, mode2 Modality) bool {
+	if mode1.Equals(mode2) {
+		return true
+	}
+
+	return m.fail("mode '%s' vs '%s'", mode1.String(), mode2.String())
+}
+
+// When subtyping, a stronger mode can be used in place of a weaker one (e.g. rep in place of lin), since it allows
+// (at least) the same structural rules
+func (m *TypeMismatch) subModes(mode1, mode2 Modality, subtype bool) bool {
+	if subtype && isProperMode(mode1) && isProperMode(mode2) &&
+		(mode1.AllowsWeakening() || !mode2.AllowsWeakening()) &&
+		(mode1.AllowsContraction() || !mode2.AllowsContraction()) {
+		return true
+	}
+
+	return m.equalModes(mode1, mode2)
+}
+
+func isProperMode(mode Modality) bool {
+	switch mode.(type) {
+	case *UnsetMode, *InvalidMode:
+		return false
+	}
+
+	return true
 }
 
 // The snapshots maps keeps a snapshot of both types in case the types are unfolded. This ensures that the types do not keep unfolding infinitely.
-func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelledTypesEnv LabelledTypesEnv) bool {
+// If consistent is set, the dynamic type ? matches any type (i.e. the types are compared for consistency instead).
+// If subtype is set, type1 only has to be a subtype of type2 (see Subtype).
+// If mismatch is not nil, it is filled with the reason why the types are not equal.
+func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelledTypesEnv LabelledTypesEnv, consistent, subtype bool, mismatch *TypeMismatch) bool {
 	a := reflect.TypeOf(type1)
 	b := reflect.TypeOf(type2)
 
+	if consistent && (IsDynamic(type1) || IsDynamic(type2)) {
+		return true
+	}
+
 	f1, isLabel1 := type1.(*LabelType)
 	f2, isLabel2 := type2.(*LabelType)
 
 	// If neither is a label and neither type's match
 	if a != b && !isLabel1 && !isLabel2 {
-		return false
+		return mismatch.fail("'%s' vs '%s'", type1.String(), type2.String())
 	}
 
 	if isLabel1 || isLabel2 {
@@ -481,19 +639,23 @@ func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelle
 		if exists {
 			return true
 		}
+		snapshots[presentSnapshot.String()] = true
 
 		if isLabel1 && isLabel2 && f1.Label == f2.Label {
-			return f1.Modality().Equals(f2.Modality())
+			return mismatch.subModes(f1.Modality(), f2.Modality(), subtype)
 		}
 
+		var unfolded []string
+
 		// Expand label/s
 		// This fetch operation (from the map) should succeed since we already check that all labels used are defined
 		if isLabel1 {
 			labelledType, ok1 := labelledTypesEnv[f1.Label]
 			if ok1 {
 				type1 = labelledType.Type
+				unfolded = append(unfolded, f1.Label)
 			} else {
-				return false
+				return mismatch.fail("type '%s' is undefined", f1.Label)
 			}
 		}
 
@@ -501,8 +663,9 @@ func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelle
 			labelledType, ok2 := labelledTypesEnv[f2.Label]
 			if ok2 {
 				type2 = labelledType.Type
+				unfolded = append(unfolded, f2.Label)
 			} else {
-				return false
+				return mismatch.fail("type '%s' is undefined", f2.Label)
 			}
 		}
 
@@ -515,12 +678,12 @@ func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelle
 		newSnapshot.WriteString(type2.Modality().String())
 		snapshots[newSnapshot.String()] = true
 
-		return innerEqualType(type1, type2, snapshots, labelledTypesEnv)
+		return mismatch.step(innerEqualType(type1, type2, snapshots, labelledTypesEnv, consistent, subtype, mismatch), "unfold '%s'", strings.Join(unfolded, "' and '"))
 	}
 
 	// At this point, neither type1 nor type2 can be of LabelType
 	if a != b {
-		return false
+		return mismatch.fail("'%s' vs '%s'", type1.String(), type2.String())
 	}
 
 	switch interface{}(type1).(type) {
@@ -532,14 +695,21 @@ func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelle
 	case *UnitType:
 		f1, ok1 := type1.(*UnitType)
 		f2, ok2 := type2.(*UnitType)
-		return ok1 && ok2 && f1.Modality().Equals(f2.Modality())
+		return ok1 && ok2 && mismatch.subModes(f1.Modality(), f2.Modality(), subtype)
+
+	case *DynamicType:
+		f1, ok1 := type1.(*DynamicType)
+		f2, ok2 := type2.(*DynamicType)
+		return ok1 && ok2 && mismatch.subModes(f1.Modality(), f2.Modality(), subtype)
 
 	case *SendType:
 		f1, ok1 := type1.(*SendType)
 		f2, ok2 := type2.(*SendType)
 
 		if ok1 && ok2 {
-			return f1.Modality().Equals(f2.Modality()) && innerEqualType(f1.Left, f2.Left, snapshots, labelledTypesEnv) && innerEqualType(f1.Right, f2.Right, snapshots, labelledTypesEnv)
+			return mismatch.subModes(f1.Modality(), f2.Modality(), subtype) &&
+				mismatch.step(innerEqualType(f1.Left, f2.Left, snapshots, labelledTypesEnv, consistent, subtype, mismatch), "left of '*'") &&
+				mismatch.step(innerEqualType(f1.Right, f2.Right, snapshots, labelledTypesEnv, consistent, subtype, mismatch), "right of '*'")
 		}
 
 	case *ReceiveType:
@@ -547,24 +717,32 @@ func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelle
 		f2, ok2 := type2.(*ReceiveType)
 
 		if ok1 && ok2 {
-			return f1.Modality().Equals(f2.Modality()) && innerEqualType(f1.Left, f2.Left, snapshots, labelledTypesEnv) && innerEqualType(f1.Right, f2.Right, snapshots, labelledTypesEnv)
+			// The received names flow in the opposite direction, so they are compared the other way round
+			left1, left2 := f1.Left, f2.Left
+			if subtype {
+				left1, left2 = f2.Left, f1.Left
+			}
+
+			return mismatch.subModes(f1.Modality(), f2.Modality(), subtype) &&
+				mismatch.step(innerEqualType(left1, left2, snapshots, labelledTypesEnv, consistent, subtype, mismatch), "left of '-*'") &&
+				mismatch.step(innerEqualType(f1.Right, f2.Right, snapshots, labelledTypesEnv, consistent, subtype, mismatch), "right of '-*'")
 		}
 
 	case *SelectLabelType:
 		f1, ok1 := type1.(*SelectLabelType)
 		f2, ok2 := type2.(*SelectLabelType)
 
-		if ok1 && ok2 && len(f1.Branches) == len(f2.Branches) {
-			return f1.Modality().Equals(f2.Modality()) && equalTypeBranch(f1.Branches, f2.Branches, snapshots, labelledTypesEnv)
+		if ok1 && ok2 {
+			return mismatch.subModes(f1.Modality(), f2.Modality(), subtype) && equalTypeBranch(f1.Branches, f2.Branches, false, snapshots, labelledTypesEnv, consistent, subtype, mismatch)
 		}
 
 	case *BranchCaseType:
 		f1, ok1 := type1.(*BranchCaseType)
 		f2, ok2 := type2.(*BranchCaseType)
 
-		if ok1 && ok2 && len(f1.Branches) == len(f2.Branches) {
+		if ok1 && ok2 {
 			// order doesn't matters
-			return f1.Modality().Equals(f2.Modality()) && equalTypeBranch(f1.Branches, f2.Branches, snapshots, labelledTypesEnv)
+			return mismatch.subModes(f1.Modality(), f2.Modality(), subtype) && equalTypeBranch(f1.Branches, f2.Branches, true, snapshots, labelledTypesEnv, consistent, subtype, mismatch)
 		}
 
 	case *UpType:
@@ -572,7 +750,8 @@ func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelle
 		f2, ok2 := type2.(*UpType)
 
 		if ok1 && ok2 {
-			return f1.To.Equals(f2.To) && f1.From.Equals(f2.From) && innerEqualType(f1.Continuation, f2.Continuation, snapshots, labelledTypesEnv)
+			return mismatch.equalModes(f1.From, f2.From) && mismatch.equalModes(f1.To, f2.To) &&
+				mismatch.step(innerEqualType(f1.Continuation, f2.Continuation, snapshots, labelledTypesEnv, consistent, subtype, mismatch), "after '/\\'")
 		}
 
 	case *DownType:
@@ -580,30 +759,35 @@ func innerEqualType(type1, type2 SessionType, snapshots map[string]bool, labelle
 		f2, ok2 := type2.(*DownType)
 
 		if ok1 && ok2 {
-			return f1.To.Equals(f2.To) && f1.From.Equals(f2.From) && innerEqualType(f1.Continuation, f2.Continuation, snapshots, labelledTypesEnv)
+			return mismatch.equalModes(f1.From, f2.From) && mismatch.equalModes(f1.To, f2.To) &&
+				mismatch.step(innerEqualType(f1.Continuation, f2.Continuation, snapshots, labelledTypesEnv, consistent, subtype, mismatch), "after '\\/'")
 		}
 	}
 
 	// fmt.Printf("issue in EqualType for type %s\n", a)
-	return false
+	return mismatch.fail("'%s' vs '%s'", type1.String(), type2.String())
 }
 
-// Compare branches in an unordered way. Here we are assuming that both branches contain unique labels
-func equalTypeBranch(options1, options2 []Option, snapshots map[string]bool, labelledTypesEnv LabelledTypesEnv) bool {
-	if len(options1) != len(options2) {
-		return false
-	}
-
+// Compare branches in an unordered way. Here we are assuming that both branches contain unique labels.
+// When subtyping, an external choice (&) may offer more labels than expected, whereas an internal choice (+) may
+// select from fewer labels than expected.
+func equalTypeBranch(options1, options2 []Option, external bool, snapshots map[string]bool, labelledTypesEnv LabelledTypesEnv, consistent, subtype bool, mismatch *TypeMismatch) bool {
 	// Match each label to the other set
 	for _, b := range options1 {
 		matchingBranch, foundMatchingBranch := LookupBranchByLabel(options2, b.Label)
 		if foundMatchingBranch {
-			if !innerEqualType(b.SessionType, matchingBranch.SessionType, snapshots, labelledTypesEnv) {
+			if !mismatch.step(innerEqualType(b.SessionType, matchingBranch.SessionType, snapshots, labelledTypesEnv, consistent, subtype, mismatch), "branch '%s'", b.Label) {
 				// If inner types do not match, then stop checking
 				return false
 			}
-		} else {
-			return false
+		} else if !subtype || !external {
+			return mismatch.fail("label '%s' vs no such label", b.Label)
+		}
+	}
+
+	for _, b := range options2 {
+		if _, found := LookupBranchByLabel(options1, b.Label); !found && (!subtype || external) {
+			return mismatch.fail("no such label vs label '%s'", b.Label)
 		}
 	}
 
@@ -695,12 +879,52 @@ func CopyType(orig SessionType) SessionType {
 		if ok {
 			return NewDownType(p.From.Copy(), p.To.Copy(), CopyType(p.Continuation))
 		}
+	case *DynamicType:
+		p, ok := orig.(*DynamicType)
+		if ok {
+			return NewDynamicType(p.Mode.Copy())
+		}
 	}
 
 	panic("Should not happen (type)")
 	// return nil
 }
 
+// Takes a type and returns a clone in which the labels found in renames are replaced, e.g. by the unique names given
+// to local type definitions
+func RenameLabels(orig SessionType, renames map[string]string) SessionType {
+	renamed := CopyType(orig)
+	renameLabels(renamed, renames)
+	return renamed
+}
+
+func renameLabels(t SessionType, renames map[string]string) {
+	switch p := t.(type) {
+	case *LabelType:
+		if label, ok := renames[p.Label]; ok {
+			p.Label = label
+		}
+	case *SendType:
+		renameLabels(p.Left, renames)
+		renameLabels(p.Right, renames)
+	case *ReceiveType:
+		renameLabels(p.Left, renames)
+		renameLabels(p.Right, renames)
+	case *SelectLabelType:
+		for _, option := range p.Branches {
+			renameLabels(option.SessionType, renames)
+		}
+	case *BranchCaseType:
+		for _, option := range p.Branches {
+			renameLabels(option.SessionType, renames)
+		}
+	case *UpType:
+		renameLabels(p.Continuation, renames)
+	case *DownType:
+		renameLabels(p.Continuation, renames)
+	}
+}
+
 // The labelled types environment is constant and set once at the beginning. The information is obtained from the 'type A = ...' definitions.
 // labelledTypesEnv: map of labels to their session type (wrapped in a LabelledType struct)
 
@@ -734,6 +958,108 @@ func IsWeakenable(sessionType SessionType) bool {
 	return sessionType.Modality().AllowsWeakening()
 }
 
+// Whether the type is the dynamic type ?, which is only checked at runtime
+func IsDynamic(sessionType SessionType) bool {
+	_, dynamic := sessionType.(*DynamicType)
+	return dynamic
+}
+
+// Whether ? occurs anywhere within the type (without unfolding labelled types)
+func ContainsDynamicType(sessionType SessionType) bool {
+	switch q := sessionType.(type) {
+	case *DynamicType:
+		return true
+	case *SendType:
+		return ContainsDynamicType(q.Left) || ContainsDynamicType(q.Right)
+	case *ReceiveType:
+		return ContainsDynamicType(q.Left) || ContainsDynamicType(q.Right)
+	case *SelectLabelType:
+		return optionsContainDynamicType(q.Branches)
+	case *BranchCaseType:
+		return optionsContainDynamicType(q.Branches)
+	case *UpType:
+		return ContainsDynamicType(q.Continuation)
+	case *DownType:
+		return ContainsDynamicType(q.Continuation)
+	}
+
+	return false
+}
+
+func optionsContainDynamicType(options []Option) bool {
+	for _, option := range options {
+		if ContainsDynamicType(option.SessionType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// When a name of type ? is used by a specific construct (e.g. sending on it), ? is taken to be the type expected by
+// that construct, with ? in place of its components, e.g. ? * ? when sending. Other types are returned as is.
+
+func ExpandDynamicUnit(sessionType SessionType) SessionType {
+	return expandDynamic(sessionType, func(mode Modality) SessionType {
+		return NewUnitType(mode)
+	})
+}
+
+func ExpandDynamicSend(sessionType SessionType) SessionType {
+	return expandDynamic(sessionType, func(mode Modality) SessionType {
+		return NewSendType(NewDynamicType(mode.Copy()), NewDynamicType(mode.Copy()), mode)
+	})
+}
+
+func ExpandDynamicReceive(sessionType SessionType) SessionType {
+	return expandDynamic(sessionType, func(mode Modality) SessionType {
+		return NewReceiveType(NewDynamicType(mode.Copy()), NewDynamicType(mode.Copy()), mode)
+	})
+}
+
+// The labels are the ones being selected (or matched), each leading to ?
+func ExpandDynamicSelect(sessionType SessionType, labels []string) SessionType {
+	return expandDynamic(sessionType, func(mode Modality) SessionType {
+		return NewSelectLabelType(dynamicOptions(labels, mode), mode)
+	})
+}
+
+func ExpandDynamicBranch(sessionType SessionType, labels []string) SessionType {
+	return expandDynamic(sessionType, func(mode Modality) SessionType {
+		return NewBranchCaseType(dynamicOptions(labels, mode), mode)
+	})
+}
+
+// The mode of the continuation is unknown, so the shift is taken to keep the same mode
+func ExpandDynamicUp(sessionType SessionType) SessionType {
+	return expandDynamic(sessionType, func(mode Modality) SessionType {
+		return NewUpType(mode.Copy(), mode, NewDynamicType(mode.Copy()))
+	})
+}
+
+func ExpandDynamicDown(sessionType SessionType) SessionType {
+	return expandDynamic(sessionType, func(mode Modality) SessionType {
+		return NewDownType(mode.Copy(), mode, NewDynamicType(mode.Copy()))
+	})
+}
+
+func expandDynamic(sessionType SessionType, expand func(mode Modality) SessionType) SessionType {
+	if dynamicType, ok := sessionType.(*DynamicType); ok {
+		return expand(dynamicType.Mode)
+	}
+
+	return sessionType
+}
+
+func dynamicOptions(labels []string, mode Modality) []Option {
+	options := make([]Option, len(labels))
+	for i, label := range labels {
+		options[i] = *NewOption(label, NewDynamicType(mode.Copy()))
+	}
+
+	return options
+}
+
 // Contraction types allow for channels to be copied/splits
 func IsContractable(sessionType SessionType) bool {
 	return sessionType.Modality().AllowsContraction()
@@ -964,6 +1290,17 @@ func (q *DownTypeInitial) toSessionType(mode Modality) SessionType {
 	return NewDownType(q.From, q.To, q.Continuation.toSessionType(q.From))
 }
 
+// Dynamic: ?
+type DynamicTypeInitial struct{}
+
+func NewDynamicTypeInitial() *DynamicTypeInitial {
+	return &DynamicTypeInitial{}
+}
+
+func (q *DynamicTypeInitial) toSessionType(mode Modality) SessionType {
+	return NewDynamicType(mode)
+}
+
 // Branch/Case option
 type OptionInitial struct {
 	Label        string
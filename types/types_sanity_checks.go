@@ -1,6 +1,9 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 func SanityChecksTypeDefinitions(typesDefs []SessionTypeDefinition) error {
 	// Check for redeclaration of the same name
@@ -37,7 +40,7 @@ func SanityChecksTypeDefinitions(typesDefs []SessionTypeDefinition) error {
 		ok := j.SessionType.isContractive(labelledTypesEnv, make(map[string]bool))
 
 		if !ok {
-			return fmt.Errorf("session type definition for %s (= %s) is not contractive", j.Name, j.SessionType.String())
+			return fmt.Errorf("session type definition for %s (= %s) is not contractive, since it keeps unfolding to other labels: %s", j.Name, j.SessionType.String(), strings.Join(labelCycle(j.Name, labelledTypesEnv), " → "))
 		}
 
 		err := CheckTypeWellFormedness(j.SessionType, labelledTypesEnv)
@@ -91,7 +94,7 @@ func CheckTypeWellFormedness(t SessionType, labelledTypesEnv LabelledTypesEnv) e
 // Ensures also the branches are made up of unique labels
 func (q *LabelType) checkTypeLabels(labelledTypesEnv LabelledTypesEnv) error {
 	if !LabelledTypedExists(labelledTypesEnv, q.Label) {
-		return fmt.Errorf("type '%s' is undefined", q.String())
+		return fmt.Errorf("type '%s' is undefined%s", q.String(), DidYouMean(q.Label, labelledTypeNames(labelledTypesEnv)))
 	}
 
 	return nil
@@ -99,6 +102,9 @@ func (q *LabelType) checkTypeLabels(labelledTypesEnv LabelledTypesEnv) error {
 func (q *UnitType) checkTypeLabels(labelledTypesEnv LabelledTypesEnv) error {
 	return nil
 }
+func (q *DynamicType) checkTypeLabels(labelledTypesEnv LabelledTypesEnv) error {
+	return nil
+}
 func (q *SendType) checkTypeLabels(labelledTypesEnv LabelledTypesEnv) error {
 	err := q.Left.checkTypeLabels(labelledTypesEnv)
 
@@ -205,10 +211,35 @@ func (q *LabelType) isContractive(labelledTypesEnv LabelledTypesEnv, snapshots m
 	return unfoldedType.isContractive(labelledTypesEnv, snapshots)
 }
 
+// Follows the definitions which are made up of a single label, until a label is repeated, e.g. [C, D, E, C]
+func labelCycle(name string, labelledTypesEnv LabelledTypesEnv) []string {
+	cycle := []string{name}
+	visited := map[string]bool{name: true}
+
+	for {
+		label, isLabel := labelledTypesEnv[name].Type.(*LabelType)
+		if !isLabel {
+			return cycle
+		}
+
+		name = label.Label
+		cycle = append(cycle, name)
+
+		if visited[name] {
+			return cycle
+		}
+		visited[name] = true
+	}
+}
+
 func (q *UnitType) isContractive(labelledTypesEnv LabelledTypesEnv, snapshots map[string]bool) bool {
 	return true
 }
 
+func (q *DynamicType) isContractive(labelledTypesEnv LabelledTypesEnv, snapshots map[string]bool) bool {
+	return true
+}
+
 func (q *SendType) isContractive(labelledTypesEnv LabelledTypesEnv, snapshots map[string]bool) bool {
 	return true
 }
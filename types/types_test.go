@@ -1,6 +1,7 @@
 package types
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -142,3 +143,242 @@ func TestCopy(t *testing.T) {
 		}
 	}
 }
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"succ", "succ", 0},
+		{"sucx", "succ", 1},
+		{"nta", "nat", 1},
+		{"zero", "", 4},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, c := range cases {
+		if output := EditDistance(c.a, c.b); output != c.expected {
+			t.Errorf("distance between '%s' and '%s': got %d, expected %d", c.a, c.b, output, c.expected)
+		}
+	}
+}
+
+func TestClosestMatches(t *testing.T) {
+	candidates := []string{"zero", "succ", "suc", "a"}
+
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"sucx", "succ, suc"},
+		{"zer", "zero"},
+		{"b", ""},
+		{"double", ""},
+	}
+
+	for _, c := range cases {
+		if output := strings.Join(ClosestMatches(c.name, candidates), ", "); output != c.expected {
+			t.Errorf("closest matches for '%s': got '%s', expected '%s'", c.name, output, c.expected)
+		}
+	}
+
+	if hint := ExpectedLabelsHint("sucx", []Option{{Label: "zero"}, {Label: "succ"}}); hint != "expected one of the labels zero, succ; did you mean 'succ'?" {
+		t.Errorf("unexpected hint: %s", hint)
+	}
+}
+
+func TestExplainTypeMismatch(t *testing.T) {
+	typeDefs := []SessionTypeDefinition{
+		{Name: "nat", SessionType: NewSelectLabelType([]Option{{Label: "zero", SessionType: NewUnitType(NewUnsetMode())}, {Label: "succ", SessionType: NewLabelType("nat", NewUnsetMode())}}, NewUnsetMode())},
+		{Name: "pair", SessionType: NewSendType(NewUnitType(NewUnsetMode()), NewUnitType(NewAffineMode()), NewUnsetMode())},
+	}
+	SetModalityTypeDef(typeDefs)
+	labelledTypesEnv := ProduceLabelledSessionTypeEnvironment(typeDefs)
+
+	nat := NewLabelType("nat", NewReplicableMode())
+	onlyZero := NewSelectLabelType([]Option{{Label: "zero", SessionType: NewUnitType(NewReplicableMode())}}, NewReplicableMode())
+	natOfPairs := NewSelectLabelType([]Option{{Label: "zero", SessionType: NewUnitType(NewReplicableMode())}, {Label: "succ", SessionType: NewLabelType("pair", NewReplicableMode())}}, NewReplicableMode())
+
+	cases := []struct {
+		type1, type2 SessionType
+		expected     string
+	}{
+		{nat, onlyZero, "unfold 'nat' → label 'succ' vs no such label"},
+		{nat, natOfPairs, "unfold 'nat' → branch 'succ' → unfold 'nat' and 'pair' → '+{zero : 1, succ : nat}' vs '1 * 1'"},
+		{NewLabelType("pair", NewReplicableMode()), NewSendType(NewUnitType(NewAffineMode()), NewUnitType(NewLinearMode()), NewAffineMode()), "unfold 'pair' → right of '*' → mode 'aff' vs 'lin'"},
+	}
+
+	for i, c := range cases {
+		mismatch := ExplainTypeMismatch(c.type1, c.type2, labelledTypesEnv)
+		if mismatch == nil {
+			t.Errorf("case #%d: expected a mismatch", i)
+		} else if mismatch.String() != c.expected {
+			t.Errorf("case #%d: got '%s', expected '%s'", i, mismatch.String(), c.expected)
+		}
+	}
+
+	if mismatch := ExplainTypeMismatch(nat, CopyType(nat), labelledTypesEnv); mismatch != nil {
+		t.Errorf("expected no mismatch, but found '%s'", mismatch.String())
+	}
+}
+
+func TestConsistentType(t *testing.T) {
+	typeDefs := []SessionTypeDefinition{
+		{Name: "nat", SessionType: NewSelectLabelType([]Option{{Label: "zero", SessionType: NewUnitType(NewUnsetMode())}, {Label: "succ", SessionType: NewLabelType("nat", NewUnsetMode())}}, NewUnsetMode())},
+	}
+	SetModalityTypeDef(typeDefs)
+	labelledTypesEnv := ProduceLabelledSessionTypeEnvironment(typeDefs)
+
+	mode := NewReplicableMode()
+	dynamic := NewDynamicType(mode)
+	nat := NewLabelType("nat", mode)
+	unit := NewUnitType(mode)
+
+	consistent := []struct {
+		type1, type2 SessionType
+	}{
+		{dynamic, nat},
+		{unit, dynamic},
+		{NewSendType(nat, dynamic, mode), NewSendType(dynamic, unit, mode)},
+		{nat, NewSelectLabelType([]Option{{Label: "zero", SessionType: dynamic}, {Label: "succ", SessionType: dynamic}}, mode)},
+	}
+
+	for i, c := range consistent {
+		if !ConsistentType(c.type1, c.type2, labelledTypesEnv) {
+			t.Errorf("case #%d: expected '%s' to be consistent with '%s'", i, c.type1.String(), c.type2.String())
+		}
+	}
+
+	inconsistent := []struct {
+		type1, type2 SessionType
+		expected     string
+	}{
+		{NewSendType(dynamic, unit, mode), NewReceiveType(dynamic, unit, mode), "'? * 1' vs '? -* 1'"},
+		{NewSendType(dynamic, unit, mode), NewSendType(unit, nat, mode), "right of '*' → unfold 'nat' → '1' vs '+{zero : 1, succ : nat}'"},
+		{nat, NewSelectLabelType([]Option{{Label: "zero", SessionType: dynamic}}, mode), "unfold 'nat' → label 'succ' vs no such label"},
+	}
+
+	for i, c := range inconsistent {
+		mismatch := ExplainTypeInconsistency(c.type1, c.type2, labelledTypesEnv)
+		if mismatch == nil {
+			t.Errorf("case #%d: expected '%s' to be inconsistent with '%s'", i, c.type1.String(), c.type2.String())
+		} else if mismatch.String() != c.expected {
+			t.Errorf("case #%d: got '%s', expected '%s'", i, mismatch.String(), c.expected)
+		}
+	}
+
+	// Consistency is not transitive, so ? is only equal to itself
+	if EqualType(dynamic, nat, labelledTypesEnv) || !EqualType(dynamic, CopyType(dynamic), labelledTypesEnv) {
+		t.Errorf("expected ? to only be equal to itself")
+	}
+}
+
+func TestClassifyTypeDefinitions(t *testing.T) {
+	unit := NewUnitType(NewReplicableMode())
+	label := func(l string) SessionType { return NewLabelType(l, NewReplicableMode()) }
+	option := func(l string, t SessionType) Option { return Option{Label: l, SessionType: t} }
+
+	typeDefs := []SessionTypeDefinition{
+		{Name: "nat", SessionType: NewSelectLabelType([]Option{option("zero", unit), option("succ", label("nat"))}, NewReplicableMode())},
+		{Name: "bits", SessionType: NewSelectLabelType([]Option{option("b0", label("bits")), option("b1", label("bits"))}, NewReplicableMode())},
+		{Name: "stream", SessionType: NewBranchCaseType([]Option{option("head", label("nat")), option("tail", label("stream"))}, NewReplicableMode())},
+		{Name: "empty", SessionType: NewSelectLabelType(nil, NewReplicableMode())},
+		{Name: "pairs", SessionType: NewSendType(label("empty"), label("nat"), NewReplicableMode())},
+		{Name: "fun", SessionType: NewReceiveType(label("empty"), label("empty"), NewReplicableMode())},
+		{Name: "later", SessionType: NewSelectLabelType([]Option{option("a", label("nat2")), option("b", label("later"))}, NewReplicableMode())},
+		{Name: "nat2", SessionType: label("nat")},
+	}
+
+	expected := map[string]Inhabitation{
+		"nat":    FINITE,
+		"bits":   INFINITE,
+		"stream": INFINITE,
+		"empty":  EMPTY,
+		"pairs":  EMPTY,
+		"fun":    FINITE,
+		"later":  FINITE,
+		"nat2":   FINITE,
+	}
+
+	result := ClassifyTypeDefinitions(ProduceLabelledSessionTypeEnvironment(typeDefs))
+	for name, inhabitation := range expected {
+		if result[name] != inhabitation {
+			t.Errorf("expected %s to be %s, but found %s", name, InhabitationMap[inhabitation], InhabitationMap[result[name]])
+		}
+	}
+
+	// Infinite types can only be used if they can be dropped
+	linearTypeDefs := append(typeDefs,
+		SessionTypeDefinition{Name: "linStream", SessionType: NewBranchCaseType([]Option{option("next", NewLabelType("linStream", NewLinearMode()))}, NewLinearMode())},
+		SessionTypeDefinition{Name: "affStream", SessionType: NewBranchCaseType([]Option{option("next", NewLabelType("affStream", NewAffineMode()))}, NewAffineMode())},
+		SessionTypeDefinition{Name: "linNat", SessionType: NewSelectLabelType([]Option{option("zero", NewUnitType(NewLinearMode())), option("succ", NewLabelType("linNat", NewLinearMode()))}, NewLinearMode())},
+	)
+	labelledTypesEnv := ProduceLabelledSessionTypeEnvironment(linearTypeDefs)
+	result = ClassifyTypeDefinitions(labelledTypesEnv)
+	for name, unusable := range map[string]bool{"linStream": true, "affStream": false, "linNat": false, "stream": false} {
+		if IsUnusableType(name, result, labelledTypesEnv) != unusable {
+			t.Errorf("expected %s to be unusable: %t", name, unusable)
+		}
+	}
+}
+
+func TestSubtype(t *testing.T) {
+	zero := Option{Label: "zero", SessionType: NewUnitType(NewUnsetMode())}
+	typeDefs := []SessionTypeDefinition{
+		{Name: "nat", SessionType: NewSelectLabelType([]Option{zero, {Label: "succ", SessionType: NewLabelType("nat", NewUnsetMode())}}, NewUnsetMode())},
+		// Even numbers (only select succ twice)
+		{Name: "even", SessionType: NewSelectLabelType([]Option{zero, {Label: "succ", SessionType: NewSelectLabelType([]Option{{Label: "succ", SessionType: NewLabelType("even", NewUnsetMode())}}, NewUnsetMode())}}, NewUnsetMode())},
+		// Streams offering more operations than others
+		{Name: "stream", SessionType: NewBranchCaseType([]Option{{Label: "next", SessionType: NewSendType(NewLabelType("nat", NewUnsetMode()), NewLabelType("stream", NewUnsetMode()), NewUnsetMode())}}, NewUnsetMode())},
+		{Name: "resettable", SessionType: NewBranchCaseType([]Option{{Label: "next", SessionType: NewSendType(NewLabelType("even", NewUnsetMode()), NewLabelType("resettable", NewUnsetMode()), NewUnsetMode())}, {Label: "reset", SessionType: NewLabelType("resettable", NewUnsetMode())}}, NewUnsetMode())},
+	}
+	SetModalityTypeDef(typeDefs)
+	labelledTypesEnv := ProduceLabelledSessionTypeEnvironment(typeDefs)
+
+	mode := NewReplicableMode()
+	nat := NewLabelType("nat", mode)
+	even := NewLabelType("even", mode)
+	stream := NewLabelType("stream", mode)
+	resettable := NewLabelType("resettable", mode)
+	unit := NewUnitType(mode)
+
+	subtypes := []struct {
+		type1, type2 SessionType
+	}{
+		{even, nat},
+		{resettable, stream},
+		{NewReceiveType(nat, unit, mode), NewReceiveType(even, unit, mode)},
+		{NewSendType(even, unit, mode), NewSendType(nat, unit, mode)},
+		{NewUnitType(NewReplicableMode()), NewUnitType(NewAffineMode())},
+		{NewUnitType(NewMulticastMode()), NewUnitType(NewLinearMode())},
+	}
+
+	for i, c := range subtypes {
+		if !Subtype(c.type1, c.type2, labelledTypesEnv) {
+			t.Errorf("case #%d: expected '%s' to be a subtype of '%s'", i, c.type1.StringWithModality(), c.type2.StringWithModality())
+		}
+	}
+
+	notSubtypes := []struct {
+		type1, type2 SessionType
+	}{
+		{nat, even},
+		{stream, resettable},
+		{NewReceiveType(even, unit, mode), NewReceiveType(nat, unit, mode)},
+		{NewUnitType(NewAffineMode()), NewUnitType(NewMulticastMode())},
+		{NewUnitType(NewLinearMode()), NewUnitType(NewReplicableMode())},
+		{NewUpType(NewAffineMode(), NewLinearMode(), unit), NewUpType(NewReplicableMode(), NewLinearMode(), unit)},
+	}
+
+	for i, c := range notSubtypes {
+		if Subtype(c.type1, c.type2, labelledTypesEnv) {
+			t.Errorf("case #%d: expected '%s' not to be a subtype of '%s'", i, c.type1.StringWithModality(), c.type2.StringWithModality())
+		}
+	}
+
+	// Subtyping is reflexive, and equal types remain equal
+	if !Subtype(nat, CopyType(nat), labelledTypesEnv) || EqualType(even, nat, labelledTypesEnv) {
+		t.Errorf("expected subtyping to extend type equality")
+	}
+}